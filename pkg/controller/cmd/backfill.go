@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/firestore"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func backfillCommand() *cli.Command {
+	var (
+		bq       config.BigQuery
+		policy   config.Policy
+		metadata config.Metadata
+
+		listConcurrency    int
+		resolveConcurrency int
+		loadConcurrency    int
+		batchSize          int
+		queueSize          int
+		glob               string
+		regex              string
+		failuresFile       string
+		countOnly          bool
+		pauseFile          string
+		progressID         string
+		metricsExporter    string
+		statsAddr          string
+
+		firestoreProject  string
+		firestoreDatabase string
+	)
+
+	return &cli.Command{
+		Name:      "backfill",
+		Usage:     "Load all objects under Cloud Storage prefixes by a pipeline of listing, resolving and loading",
+		ArgsUsage: "[prefix URL ...]",
+		Flags: mergeFlags([]cli.Flag{
+			&cli.IntFlag{
+				Name:        "list-concurrency",
+				EnvVars:     []string{"SWARM_BACKFILL_LIST_CONCURRENCY"},
+				Usage:       "Number of workers to list objects in parallel by sub-prefix split by '/'",
+				Destination: &listConcurrency,
+				Value:       1,
+			},
+			&cli.IntFlag{
+				Name:        "resolve-concurrency",
+				EnvVars:     []string{"SWARM_BACKFILL_RESOLVE_CONCURRENCY"},
+				Usage:       "Number of workers to evaluate event policy for listed objects",
+				Destination: &resolveConcurrency,
+				Value:       4,
+			},
+			&cli.IntFlag{
+				Name:        "load-concurrency",
+				EnvVars:     []string{"SWARM_BACKFILL_LOAD_CONCURRENCY"},
+				Usage:       "Number of workers to load batches of objects",
+				Destination: &loadConcurrency,
+				Value:       4,
+			},
+			&cli.IntFlag{
+				Name:        "batch-size",
+				EnvVars:     []string{"SWARM_BACKFILL_BATCH_SIZE"},
+				Usage:       "Number of objects loaded together under one load ID",
+				Destination: &batchSize,
+				Value:       1,
+			},
+			&cli.IntFlag{
+				Name:        "queue-size",
+				EnvVars:     []string{"SWARM_BACKFILL_QUEUE_SIZE"},
+				Usage:       "Capacity of queues between stages. A stage waits for the next stage if the queue is full",
+				Destination: &queueSize,
+				Value:       256,
+			},
+			&cli.StringFlag{
+				Name:        "glob",
+				EnvVars:     []string{"SWARM_BACKFILL_GLOB"},
+				Usage:       "Load only objects of which name matches the glob pattern (e.g. logs/**/*.json)",
+				Destination: &glob,
+			},
+			&cli.StringFlag{
+				Name:        "regex",
+				EnvVars:     []string{"SWARM_BACKFILL_REGEX"},
+				Usage:       "Load only objects of which name matches the regular expression",
+				Destination: &regex,
+			},
+			&cli.StringFlag{
+				Name:        "failures-file",
+				EnvVars:     []string{"SWARM_FAILURES_FILE"},
+				Usage:       "File path to append URL and error of each failed object as tab separated lines while loading, to retry them later",
+				Destination: &failuresFile,
+			},
+			&cli.BoolFlag{
+				Name:        "count-only",
+				EnvVars:     []string{"SWARM_BACKFILL_COUNT_ONLY"},
+				Usage:       "Only list matching objects and print their count and total bytes without downloading or loading them. Options to load objects, such as BigQuery and policy, are not used",
+				Destination: &countOnly,
+			},
+			&cli.StringFlag{
+				Name:        "pause-file",
+				EnvVars:     []string{"SWARM_BACKFILL_PAUSE_FILE"},
+				Usage:       "File path to control pause. Backfill is paused while the file exists. It can be also paused and resumed by SIGUSR1",
+				Destination: &pauseFile,
+			},
+			&cli.StringFlag{
+				Name:        "progress-id",
+				EnvVars:     []string{"SWARM_BACKFILL_PROGRESS_ID"},
+				Usage:       "ID to save progress when paused or finished. Objects completed in the saved progress are skipped, so that backfill can be resumed after restart",
+				Destination: &progressID,
+			},
+			&cli.StringFlag{
+				Name:        "stats-addr",
+				EnvVars:     []string{"SWARM_STATS_ADDR"},
+				Usage:       "Address to expose progress metrics in Prometheus format at /stats (e.g. localhost:9090). Disabled if empty",
+				Destination: &statsAddr,
+			},
+			&cli.StringFlag{
+				Name:        "metrics-exporter",
+				EnvVars:     []string{"SWARM_METRICS_EXPORTER"},
+				Usage:       "Exporter of metrics: prometheus (exposed by --stats-addr) or otlp (sent to OTLP/HTTP endpoint configured by OTEL_EXPORTER_OTLP_* environment variables)",
+				Value:       metricsExporterPrometheus,
+				Destination: &metricsExporter,
+			},
+			&cli.StringFlag{
+				Name:        "firestore-project-id",
+				EnvVars:     []string{"SWARM_FIRESTORE_PROJECT_ID"},
+				Usage:       "Project ID of Firestore (To save progress). If not set, progress is kept only in memory",
+				Destination: &firestoreProject,
+			},
+			&cli.StringFlag{
+				Name:        "firestore-database-id",
+				EnvVars:     []string{"SWARM_FIRESTORE_DATABASE_ID"},
+				Usage:       "Database ID of Firestore (To save progress)",
+				Destination: &firestoreDatabase,
+			},
+		}, bq.Flags(), policy.Flags(), metadata.Flags()),
+
+		Action: func(c *cli.Context) error {
+			ctx := c.Context
+			if c.Args().Len() == 0 {
+				return goerr.Wrap(types.ErrInvalidOption, "at least one prefix URL is required (e.g. gs://bucket/logs/)")
+			}
+
+			req := &model.BackfillRequest{
+				ListConcurrency:    listConcurrency,
+				ResolveConcurrency: resolveConcurrency,
+				LoadConcurrency:    loadConcurrency,
+				BatchSize:          batchSize,
+				QueueSize:          queueSize,
+				CountOnly:          countOnly,
+				Pause:              &model.PauseSwitch{},
+				ProgressID:         progressID,
+			}
+			for _, arg := range c.Args().Slice() {
+				req.URLs = append(req.URLs, types.ObjectURL(arg))
+			}
+
+			var err error
+			switch {
+			case glob != "" && regex != "":
+				return goerr.Wrap(types.ErrInvalidOption, "--glob and --regex can not be specified together")
+			case glob != "":
+				if req.Filter, err = types.NewGlobMatcher(glob); err != nil {
+					return err
+				}
+			case regex != "":
+				if req.Filter, err = types.NewRegexMatcher(regex); err != nil {
+					return err
+				}
+			}
+
+			utils.Logger().Info("starting backfill",
+				slog.Group("config",
+					"urls", req.URLs,
+					"list-concurrency", listConcurrency,
+					"resolve-concurrency", resolveConcurrency,
+					"load-concurrency", loadConcurrency,
+					"batch-size", batchSize,
+					"queue-size", queueSize,
+					"glob", glob,
+					"regex", regex,
+					"failures-file", failuresFile,
+					"count-only", countOnly,
+					"pause-file", pauseFile,
+					"progress-id", progressID,
+					"stats-addr", statsAddr,
+					"metrics-exporter", metricsExporter,
+					"firestore-project-id", firestoreProject,
+					"firestore-database-id", firestoreDatabase,
+
+					"bigquery", &bq,
+					"policy", &policy,
+					"metadata", &metadata,
+				),
+			)
+
+			csClient, err := cs.New(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure CloudStorage client")
+			}
+			infraOptions := []infra.Option{
+				infra.WithCloudStorage(csClient),
+			}
+			var ucOptions []usecase.Option
+
+			// Counting only lists objects, then clients and options to load them are not configured
+			if !countOnly {
+				policyClient, err := policy.Configure()
+				if err != nil {
+					return goerr.Wrap(err, "failed to configure policy client")
+				}
+				bqClient, err := bq.Configure(ctx)
+				if err != nil {
+					return goerr.Wrap(err, "failed to configure BigQuery client")
+				}
+				infraOptions = append(infraOptions,
+					infra.WithPolicy(policyClient),
+					infra.WithBigQuery(bqClient),
+				)
+
+				md, err := metadata.Configure()
+				if err != nil {
+					return goerr.Wrap(err, "failed to configure metadata")
+				}
+				ucOptions = append(ucOptions,
+					usecase.WithMetadata(md),
+					usecase.WithLoadLogSink(metadata.Sinks(csClient)...),
+					usecase.WithMetadataStrict(metadata.Strict()),
+				)
+				if failuresFile != "" {
+					f, err := os.OpenFile(filepath.Clean(failuresFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+					if err != nil {
+						return goerr.Wrap(err, "failed to open failures file").With("path", failuresFile)
+					}
+					defer f.Close()
+					ucOptions = append(ucOptions, usecase.WithFailureWriter(f))
+				}
+
+				if firestoreProject != "" && firestoreDatabase != "" {
+					dbClient, err := firestore.New(ctx, firestoreProject, firestoreDatabase)
+					if err != nil {
+						return goerr.Wrap(err, "failed to configure Firestore client")
+					}
+					infraOptions = append(infraOptions, infra.WithDatabase(dbClient))
+				} else if firestoreProject != "" || firestoreDatabase != "" {
+					return goerr.New("both firestore-project-id and firestore-database-id are required")
+				} else if progressID != "" {
+					utils.Logger().Warn("Firestore is not configured, backfill progress is kept only in memory")
+				}
+			}
+
+			metricsOption, shutdownMetrics, err := configureMetrics(ctx, metricsExporter, statsAddr)
+			if err != nil {
+				return err
+			}
+			defer shutdownMetrics()
+			if metricsOption != nil {
+				ucOptions = append(ucOptions, metricsOption)
+			}
+
+			uc := usecase.New(infra.New(infraOptions...), ucOptions...)
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go watchBackfillPause(ctx, req.Pause, pauseFile)
+
+			resp, err := uc.Backfill(ctx, req)
+			if resp != nil && countOnly {
+				fmt.Fprintf(c.App.Writer, "%d objects, %d bytes (%s)\n", resp.Listed, resp.Size, humanize.IBytes(uint64(resp.Size)))
+			} else if resp != nil {
+				utils.Logger().Info("backfill done",
+					"elapsed", resp.Elapsed.String(),
+					"listed", resp.Listed,
+					"size", resp.Size,
+					"loaded", resp.Loaded,
+					"failed", resp.Failed,
+					"skipped", resp.Skipped,
+				)
+			}
+			return err
+		},
+	}
+}
+
+const backfillPauseFileInterval = time.Second
+
+// watchBackfillPause toggles pause by SIGUSR1, and pauses while pauseFile exists if pauseFile is not empty, until ctx is canceled. Pause by the file is applied only when existence of the file changes, so that SIGUSR1 can still resume or pause the backfill.
+func watchBackfillPause(ctx context.Context, pause *model.PauseSwitch, pauseFile string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(backfillPauseFileInterval)
+	defer ticker.Stop()
+
+	var fileExists bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-sigCh:
+			if pause.Toggle() {
+				utils.Logger().Info("backfill paused by signal")
+			} else {
+				utils.Logger().Info("backfill resumed by signal")
+			}
+
+		case <-ticker.C:
+			if pauseFile == "" {
+				continue
+			}
+			_, err := os.Stat(pauseFile)
+			exists := err == nil
+			if exists == fileExists {
+				continue
+			}
+			fileExists = exists
+			if exists {
+				pause.Pause()
+				utils.Logger().Info("backfill paused by pause file", "path", pauseFile)
+			} else {
+				pause.Resume()
+				utils.Logger().Info("backfill resumed by removal of pause file", "path", pauseFile)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/urfave/cli/v2"
+)
+
+func cleanupCommand() *cli.Command {
+	var (
+		bq      config.BigQuery
+		dataset types.BQDatasetID
+		prefix  string
+		labels  cli.StringSlice
+		dryRun  bool
+		yes     bool
+	)
+
+	return &cli.Command{
+		Name:  "cleanup",
+		Usage: "Delete tables in a dataset matching prefix or labels, e.g. scratch tables created in policy development",
+		Flags: mergeFlags([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "dataset",
+				Aliases:     []string{"d"},
+				Usage:       "BigQuery dataset ID of tables to be deleted",
+				EnvVars:     []string{"SWARM_CLEANUP_DATASET"},
+				Destination: (*string)(&dataset),
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "prefix",
+				Aliases:     []string{"p"},
+				Usage:       "Delete tables of which ID starts with the prefix",
+				EnvVars:     []string{"SWARM_CLEANUP_PREFIX"},
+				Destination: &prefix,
+			},
+			&cli.StringSliceFlag{
+				Name:        "label",
+				Aliases:     []string{"l"},
+				Usage:       "Delete tables having the label (key=value). All labels must match if specified multiple times",
+				EnvVars:     []string{"SWARM_CLEANUP_LABEL"},
+				Destination: &labels,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Only print tables to be deleted",
+				EnvVars:     []string{"SWARM_CLEANUP_DRY_RUN"},
+				Destination: &dryRun,
+			},
+			&cli.BoolFlag{
+				Name:        "yes",
+				Aliases:     []string{"y"},
+				Usage:       "Delete tables without confirmation",
+				EnvVars:     []string{"SWARM_CLEANUP_YES"},
+				Destination: &yes,
+			},
+		}, bq.Flags()),
+
+		Action: func(c *cli.Context) error {
+			filter := model.CleanupFilter{
+				Prefix: prefix,
+				Labels: map[string]string{},
+			}
+			for _, label := range labels.Value() {
+				k, v, ok := strings.Cut(label, "=")
+				if !ok || k == "" {
+					return goerr.Wrap(types.ErrInvalidOption, "label must be key=value").With("label", label)
+				}
+				filter.Labels[k] = v
+			}
+
+			bqClient, err := bq.Configure(c.Context)
+			if err != nil {
+				return err
+			}
+			uc := usecase.New(infra.New(infra.WithBigQuery(bqClient)))
+
+			tables, err := uc.ListCleanupTables(c.Context, dataset, filter)
+			if err != nil {
+				return err
+			}
+
+			for _, table := range tables {
+				fmt.Fprintf(c.App.Writer, "%s.%s\n", dataset, table)
+			}
+			if len(tables) == 0 || dryRun {
+				fmt.Fprintf(c.App.Writer, "%d tables to be deleted\n", len(tables))
+				return nil
+			}
+
+			if !yes {
+				fmt.Fprintf(c.App.Writer, "Delete %d tables? [y/N]: ", len(tables))
+				answer, _ := bufio.NewReader(c.App.Reader).ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					fmt.Fprintln(c.App.Writer, "Canceled")
+					return nil
+				}
+			}
+
+			return uc.DeleteTables(c.Context, dataset, tables)
+		},
+	}
+}
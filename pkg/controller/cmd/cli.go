@@ -33,7 +33,14 @@ func Run(argv []string) error {
 			clientCommand(),
 			schemaCommand(),
 			enqueueCommand(),
+			subscribeCommand(),
+			watchCommand(),
+			backfillCommand(),
 			migrateCommand(),
+			partitionCommand(),
+			cleanupCommand(),
+			replayDeadLetterCommand(),
+			policyCommand(),
 		},
 	}
 
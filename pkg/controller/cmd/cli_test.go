@@ -1,6 +1,7 @@
 package cmd_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/m-mizutani/gt"
@@ -14,12 +15,15 @@ func TestFlags(t *testing.T) {
 	}{
 		{"ingest"},
 		{"serve"},
+		{"subscribe"},
 		{"client"},
+		{"policy eval"},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.subCommand, func(t *testing.T) {
-			gt.NoError(t, cmd.Run([]string{"swarm", tc.subCommand, "--help"}))
+			argv := append([]string{"swarm"}, strings.Fields(tc.subCommand)...)
+			gt.NoError(t, cmd.Run(append(argv, "--help")))
 		})
 	}
 }
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
+	"github.com/urfave/cli/v2"
+)
+
+// DeadLetter is configuration of Pub/Sub topic to republish objects of failed load.
+type DeadLetter struct {
+	projectID types.GoogleProjectID
+	topicID   types.PubSubTopicID
+}
+
+func (x *DeadLetter) Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dead-letter-pubsub-project-id",
+			Usage:       "Google Cloud Project ID of Pub/Sub topic to republish failed objects",
+			EnvVars:     []string{"SWARM_DEAD_LETTER_PUBSUB_PROJECT_ID"},
+			Destination: (*string)(&x.projectID),
+		},
+		&cli.StringFlag{
+			Name:        "dead-letter-pubsub-topic-id",
+			Usage:       "Pub/Sub topic ID to republish objects of failed load classified as dead letter",
+			EnvVars:     []string{"SWARM_DEAD_LETTER_PUBSUB_TOPIC_ID"},
+			Destination: (*string)(&x.topicID),
+		},
+	}
+}
+
+// Configure returns Pub/Sub client for dead letter. It returns nil if dead letter is not configured.
+func (x *DeadLetter) Configure(ctx context.Context) (*pubsub.Client, error) {
+	if x.projectID == "" && x.topicID == "" {
+		return nil, nil
+	}
+	if x.projectID == "" || x.topicID == "" {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "both dead-letter-pubsub-project-id and dead-letter-pubsub-topic-id are required")
+	}
+
+	client, err := pubsub.New(ctx, x.projectID, x.topicID)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to create Pub/Sub client for dead letter")
+	}
+
+	return client, nil
+}
+
+func (x *DeadLetter) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("projectID", string(x.projectID)),
+		slog.String("topicID", string(x.topicID)),
+	)
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/lineage"
+	"github.com/urfave/cli/v2"
+)
+
+// Lineage is configuration of Data Lineage (Dataplex) to record lineage from source objects to BigQuery tables.
+type Lineage struct {
+	location string
+}
+
+func (x *Lineage) Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "lineage-location",
+			Usage:       "Location of Data Lineage (e.g. us) to record lineage from objects to BigQuery tables. Lineage is not recorded if empty",
+			EnvVars:     []string{"SWARM_LINEAGE_LOCATION"},
+			Destination: &x.location,
+		},
+	}
+}
+
+// Configure returns Data Lineage client to record lineage in the project of BigQuery tables. It returns nil if lineage is not configured.
+func (x *Lineage) Configure(ctx context.Context, projectID types.GoogleProjectID) (*lineage.Client, error) {
+	if x.location == "" {
+		return nil, nil
+	}
+	if projectID == "" {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "bigquery-project-id is required for lineage")
+	}
+
+	return lineage.New(ctx, projectID, x.location)
+}
+
+func (x *Lineage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("location", x.location),
+	)
+}
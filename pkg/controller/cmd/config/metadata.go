@@ -2,16 +2,33 @@ package config
 
 import (
 	"log/slog"
+	"os"
+	"time"
 
 	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/sink"
 	"github.com/urfave/cli/v2"
 )
 
 type Metadata struct {
 	dataset types.BQDatasetID
 	table   types.BQTableID
+
+	partitionField string
+	partitionType  string
+	clustering     cli.StringSlice
+	expiration     time.Duration
+
+	gcsBucket types.CSBucket
+	gcsPrefix string
+	stdout    bool
+
+	stdoutCloudLogging bool
+
+	strict bool
 }
 
 func (x *Metadata) Flags() []cli.Flag {
@@ -28,6 +45,62 @@ func (x *Metadata) Flags() []cli.Flag {
 			EnvVars:     []string{"SWARM_META_BQ_TABLE_ID"},
 			Destination: (*string)(&x.table),
 		},
+		&cli.StringFlag{
+			Name:        "meta-bq-partition-field",
+			Usage:       "Time partitioning field of metadata table (only applied when creating the table)",
+			EnvVars:     []string{"SWARM_META_BQ_PARTITION_FIELD"},
+			Destination: &x.partitionField,
+			Value:       "started_at",
+		},
+		&cli.StringFlag{
+			Name:        "meta-bq-partition-type",
+			Usage:       "Time partitioning type of metadata table [hour|day|month|year|none] (only applied when creating the table)",
+			EnvVars:     []string{"SWARM_META_BQ_PARTITION_TYPE"},
+			Destination: &x.partitionType,
+			Value:       "month",
+		},
+		&cli.StringSliceFlag{
+			Name:        "meta-bq-clustering",
+			Usage:       "Clustering fields of metadata table (only applied when creating the table)",
+			EnvVars:     []string{"SWARM_META_BQ_CLUSTERING"},
+			Destination: &x.clustering,
+		},
+		&cli.DurationFlag{
+			Name:        "meta-bq-partition-expiration",
+			Usage:       "Partition expiration of metadata table, 0 means no expiration (only applied when creating the table)",
+			EnvVars:     []string{"SWARM_META_BQ_PARTITION_EXPIRATION"},
+			Destination: &x.expiration,
+		},
+		&cli.StringFlag{
+			Name:        "meta-gcs-bucket",
+			Usage:       "Cloud Storage bucket to write metadata as JSON",
+			EnvVars:     []string{"SWARM_META_GCS_BUCKET"},
+			Destination: (*string)(&x.gcsBucket),
+		},
+		&cli.StringFlag{
+			Name:        "meta-gcs-prefix",
+			Usage:       "Object name prefix of metadata in Cloud Storage",
+			EnvVars:     []string{"SWARM_META_GCS_PREFIX"},
+			Destination: &x.gcsPrefix,
+		},
+		&cli.BoolFlag{
+			Name:        "meta-stdout",
+			Usage:       "Write metadata to stdout as JSON",
+			EnvVars:     []string{"SWARM_META_STDOUT"},
+			Destination: &x.stdout,
+		},
+		&cli.BoolFlag{
+			Name:        "meta-stdout-cloud-logging",
+			Usage:       "Add severity and message fields of Cloud Logging structured log to metadata written to stdout",
+			EnvVars:     []string{"SWARM_META_STDOUT_CLOUD_LOGGING"},
+			Destination: &x.stdoutCloudLogging,
+		},
+		&cli.BoolFlag{
+			Name:        "meta-strict",
+			Usage:       "Fail the load if metadata can not be written, instead of only logging the error",
+			EnvVars:     []string{"SWARM_META_STRICT"},
+			Destination: &x.strict,
+		},
 	}
 }
 
@@ -42,12 +115,65 @@ func (x *Metadata) Configure() (*model.MetadataConfig, error) {
 		return nil, goerr.Wrap(types.ErrInvalidOption, "bq-table is required")
 	}
 
-	return model.NewMetadataConfig(x.dataset, x.table), nil
+	partition := types.BQPartition(x.partitionType)
+	if partition == "none" {
+		partition = types.BQPartitionNone
+	}
+	if partition != types.BQPartitionNone && partition.Type() == "" {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "invalid meta-bq-partition-type").With("type", x.partitionType)
+	}
+
+	options := []model.MetadataOption{
+		model.WithMetadataPartition(x.partitionField, partition),
+	}
+	if clustering := x.clustering.Value(); len(clustering) > 0 {
+		options = append(options, model.WithMetadataClustering(clustering...))
+	}
+	if x.expiration > 0 {
+		options = append(options, model.WithMetadataExpiration(x.expiration))
+	}
+
+	return model.NewMetadataConfig(x.dataset, x.table, options...), nil
+}
+
+// Sinks returns destinations of metadata other than BigQuery.
+func (x *Metadata) Sinks(csClient interfaces.CloudStorage) []interfaces.LoadLogSink {
+	var sinks []interfaces.LoadLogSink
+	if x.gcsBucket != "" {
+		sinks = append(sinks, sink.NewCloudStorage(csClient, x.gcsBucket, x.gcsPrefix))
+	}
+	if x.stdout {
+		var options []sink.WriterOption
+		if x.stdoutCloudLogging {
+			options = append(options, sink.WithCloudLogging())
+		}
+		sinks = append(sinks, sink.NewWriter(os.Stdout, options...))
+	}
+	return sinks
+}
+
+// Strict returns true if a load should fail when metadata can not be written.
+func (x *Metadata) Strict() bool {
+	return x.strict
+}
+
+// CloudStorage returns bucket and prefix of LoadLog objects in Cloud Storage. Bucket is empty if it is not configured.
+func (x *Metadata) CloudStorage() (types.CSBucket, string) {
+	return x.gcsBucket, x.gcsPrefix
 }
 
 func (x *Metadata) LogValue() slog.Value {
 	return slog.GroupValue(
 		slog.String("dataset", string(x.dataset)),
 		slog.String("table", string(x.table)),
+		slog.String("partitionField", x.partitionField),
+		slog.String("partitionType", x.partitionType),
+		slog.Any("clustering", x.clustering.Value()),
+		slog.String("expiration", x.expiration.String()),
+		slog.String("gcsBucket", string(x.gcsBucket)),
+		slog.String("gcsPrefix", x.gcsPrefix),
+		slog.Bool("stdout", x.stdout),
+		slog.Bool("stdoutCloudLogging", x.stdoutCloudLogging),
+		slog.Bool("strict", x.strict),
 	)
 }
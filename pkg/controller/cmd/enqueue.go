@@ -1,14 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
 	"log/slog"
 
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
 	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
 	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
 	"github.com/m-mizutani/swarm/pkg/usecase"
 	"github.com/m-mizutani/swarm/pkg/utils"
@@ -17,10 +20,27 @@ import (
 
 func enqueueCommand() *cli.Command {
 	var (
-		pubsubCfg  config.PubSub
-		countLimit int
-		sizeLimit  int
-		outDir     string
+		pubsubCfg       config.PubSub
+		deadLetter      config.DeadLetter
+		countLimit      int
+		sizeLimit       int
+		outDir          string
+		dumpGzip        bool
+		dryRun          bool
+		metricsExporter string
+		statsAddr       string
+		glob            string
+		regex           string
+
+		listConcurrency int
+
+		policyDirs     cli.StringSlice
+		skipUnroutable bool
+		unroutable     string
+
+		dateLayout string
+		dateStart  string
+		dateEnd    string
 	)
 
 	return &cli.Command{
@@ -35,6 +55,18 @@ func enqueueCommand() *cli.Command {
 				Usage:       "Output directory path",
 				Destination: &outDir,
 			},
+			&cli.BoolFlag{
+				Name:        "dump-gzip",
+				Usage:       "Compress message files written to output directory with gzip",
+				Destination: &dumpGzip,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Aliases:     []string{"d"},
+				EnvVars:     []string{"SWARM_DRY_RUN"},
+				Usage:       "Print messages to be published as JSON without publishing them",
+				Destination: &dryRun,
+			},
 			&cli.IntFlag{
 				Name:        "count-limit",
 				EnvVars:     []string{"SWARM_ENQUEUE_COUNT_LIMIT"},
@@ -49,15 +81,91 @@ func enqueueCommand() *cli.Command {
 				Destination: &sizeLimit,
 				Value:       4,
 			},
-		}, pubsubCfg.Flags()),
+			&cli.StringFlag{
+				Name:        "stats-addr",
+				EnvVars:     []string{"SWARM_STATS_ADDR"},
+				Usage:       "Address to expose progress metrics in Prometheus format at /stats (e.g. localhost:9090). Disabled if empty",
+				Destination: &statsAddr,
+			},
+			&cli.StringFlag{
+				Name:        "metrics-exporter",
+				EnvVars:     []string{"SWARM_METRICS_EXPORTER"},
+				Usage:       "Exporter of metrics: prometheus (exposed by --stats-addr) or otlp (sent to OTLP/HTTP endpoint configured by OTEL_EXPORTER_OTLP_* environment variables)",
+				Value:       metricsExporterPrometheus,
+				Destination: &metricsExporter,
+			},
+			&cli.StringFlag{
+				Name:        "glob",
+				EnvVars:     []string{"SWARM_ENQUEUE_GLOB"},
+				Usage:       "Enqueue only objects of which name matches the glob pattern (e.g. logs/**/*.json)",
+				Destination: &glob,
+			},
+			&cli.StringFlag{
+				Name:        "regex",
+				EnvVars:     []string{"SWARM_ENQUEUE_REGEX"},
+				Usage:       "Enqueue only objects of which name matches the regular expression",
+				Destination: &regex,
+			},
+			&cli.IntFlag{
+				Name:        "list-concurrency",
+				EnvVars:     []string{"SWARM_ENQUEUE_LIST_CONCURRENCY"},
+				Usage:       "Number of workers to list objects in parallel by sub-prefix split by '/'",
+				Destination: &listConcurrency,
+				Value:       1,
+			},
+			&cli.StringSliceFlag{
+				Name:        "policy-dir",
+				Aliases:     []string{"p"},
+				EnvVars:     []string{"SWARM_POLICY_DIR"},
+				Usage:       "Directory path of policy files to check objects by event policy before enqueue. Objects are not checked if empty",
+				Destination: &policyDirs,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-unroutable",
+				EnvVars:     []string{"SWARM_SKIP_UNROUTABLE"},
+				Usage:       "Skip objects for which event policy selects no source, otherwise enqueue fails (requires --policy-dir)",
+				Destination: &skipUnroutable,
+			},
+			&cli.StringFlag{
+				Name:        "unroutable",
+				EnvVars:     []string{"SWARM_ENQUEUE_UNROUTABLE"},
+				Usage:       "Action for objects for which event policy selects no source: skip or dead_letter. They are reported after enqueue (requires --policy-dir, and --dead-letter-pubsub-* for dead_letter)",
+				Destination: &unroutable,
+			},
+			&cli.StringFlag{
+				Name:        "date-layout",
+				EnvVars:     []string{"SWARM_ENQUEUE_DATE_LAYOUT"},
+				Usage:       "Go time layout of date path in UTC following the URL prefix (e.g. 2006/01/02/). Only date sub-prefixes between --date-start and --date-end are listed",
+				Destination: &dateLayout,
+			},
+			&cli.StringFlag{
+				Name:        "date-start",
+				EnvVars:     []string{"SWARM_ENQUEUE_DATE_START"},
+				Usage:       "Start of date path range, inclusive (RFC3339 or YYYY-MM-DD)",
+				Destination: &dateStart,
+			},
+			&cli.StringFlag{
+				Name:        "date-end",
+				EnvVars:     []string{"SWARM_ENQUEUE_DATE_END"},
+				Usage:       "End of date path range, exclusive (RFC3339 or YYYY-MM-DD)",
+				Destination: &dateEnd,
+			},
+		}, pubsubCfg.Flags(), deadLetter.Flags()),
 		Action: func(ctx *cli.Context) error {
 			var pubsubClient interfaces.PubSub
 
 			utils.Logger().Info("Start enqueue command", "output", outDir)
 
-			if outDir != "" {
-				pubsubClient = pubsub.NewDumper(outDir)
-			} else {
+			switch {
+			case dryRun:
+				utils.Logger().Info("dry run mode, nothing is published")
+			case outDir != "":
+				var dumpOptions []pubsub.DumperOption
+				if dumpGzip {
+					dumpOptions = append(dumpOptions, pubsub.WithDumpGzip())
+				}
+				pubsubClient = pubsub.NewDumper(outDir, dumpOptions...)
+			default:
 				client, err := pubsubCfg.Configure(ctx.Context)
 				if err != nil {
 					return err
@@ -70,11 +178,42 @@ func enqueueCommand() *cli.Command {
 				return err
 			}
 
-			clients := infra.New(
+			clientOptions := []infra.Option{
 				infra.WithPubSub(pubsubClient),
 				infra.WithCloudStorage(csClient),
-			)
-			uc := usecase.New(clients)
+			}
+			if dirs := policyDirs.Value(); len(dirs) > 0 {
+				var policyOptions []policy.Option
+				for _, dir := range dirs {
+					policyOptions = append(policyOptions, policy.WithDir(dir))
+				}
+				policyClient, err := policy.New(policyOptions...)
+				if err != nil {
+					return goerr.Wrap(err, "failed to configure policy client")
+				}
+				clientOptions = append(clientOptions, infra.WithPolicy(policyClient))
+			}
+			clients := infra.New(clientOptions...)
+
+			ucOptions := []usecase.Option{
+				usecase.WithEnqueueListConcurrency(listConcurrency),
+				usecase.WithSkipUnroutable(skipUnroutable),
+			}
+			if dlClient, err := deadLetter.Configure(ctx.Context); err != nil {
+				return err
+			} else if dlClient != nil {
+				ucOptions = append(ucOptions, usecase.WithDeadLetterPubSub(dlClient))
+			}
+			metricsOption, shutdownMetrics, err := configureMetrics(ctx.Context, metricsExporter, statsAddr)
+			if err != nil {
+				return err
+			}
+			defer shutdownMetrics()
+			if metricsOption != nil {
+				ucOptions = append(ucOptions, metricsOption, usecase.WithoutIngestMetrics())
+			}
+
+			uc := usecase.New(clients, ucOptions...)
 
 			var urls []types.ObjectURL
 			for _, arg := range ctx.Args().Slice() {
@@ -82,16 +221,56 @@ func enqueueCommand() *cli.Command {
 			}
 
 			req := &model.EnqueueRequest{
-				URLs: urls,
+				URLs:       urls,
+				DryRun:     dryRun,
+				Unroutable: types.UnroutableAction(unroutable),
 			}
+			switch {
+			case glob != "" && regex != "":
+				return goerr.Wrap(types.ErrInvalidOption, "--glob and --regex can not be specified together")
+			case glob != "":
+				if req.Filter, err = types.NewGlobMatcher(glob); err != nil {
+					return err
+				}
+			case regex != "":
+				if req.Filter, err = types.NewRegexMatcher(regex); err != nil {
+					return err
+				}
+			}
+			if dateLayout != "" || dateStart != "" || dateEnd != "" {
+				if dateLayout == "" || dateStart == "" || dateEnd == "" {
+					return goerr.Wrap(types.ErrInvalidOption, "--date-layout, --date-start and --date-end must be specified together")
+				}
+				req.DatePath = &model.DatePathRange{Layout: dateLayout}
+				if req.DatePath.Start, err = parseRangeTime(dateStart); err != nil {
+					return err
+				}
+				if req.DatePath.End, err = parseRangeTime(dateEnd); err != nil {
+					return err
+				}
+			}
+
 			resp, err := uc.Enqueue(ctx.Context, req)
 			if err != nil {
 				return err
 			}
 
+			if resp.Plan != nil {
+				encoder := json.NewEncoder(ctx.App.Writer)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(resp.Plan); err != nil {
+					return goerr.Wrap(err, "failed to print enqueue plan")
+				}
+			}
+
+			for _, obj := range resp.Unroutable {
+				utils.Logger().Warn("Unroutable object is not enqueued", slog.Any("object", obj), slog.String("action", unroutable))
+			}
+
 			utils.Logger().Info("Enqueue request is completed",
 				slog.Int64("object_count", resp.Count),
 				slog.Int64("object_size", resp.Size),
+				slog.Int("unroutable_count", len(resp.Unroutable)),
 				slog.Any("elapsed", resp.Elapsed.String()),
 			)
 
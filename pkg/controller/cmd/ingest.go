@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
@@ -16,10 +21,55 @@ import (
 func ingestCommand() *cli.Command {
 	var (
 		dryRun   bool
+		truncate bool
 		output   string
 		bigquery config.BigQuery
 		policy   config.Policy
 		metadata config.Metadata
+		lineage  config.Lineage
+
+		schemaSampleHead   int
+		schemaSampleRandom int
+		ingestTimeFallback bool
+		timestampFallback  bool
+		defaultPartition   string
+		lowerCaseDest      bool
+		policyBatchSize    int
+		failOnMissing      bool
+		skipUnroutable     bool
+		logObjectVersion   bool
+		verifyChecksum     bool
+		minObjectSize      string
+		maxObjectSize      string
+		parallelObjects    int
+		ingestTimeout      time.Duration
+		dedupWindow        time.Duration
+		query              string
+		failuresFile       string
+
+		schemaSidecarBucket string
+		schemaSidecarPrefix string
+		schemaPin           bool
+		sortByTimestamp     bool
+		loadLabels          bool
+		retryUnknownField   bool
+		fixedSchemas        cli.StringSlice
+		fixedSchemaMode     string
+
+		schemaRegistryDataset string
+		schemaRegistryTable   string
+
+		schemaOverrides     cli.StringSlice
+		compressMetadataKey string
+
+		recordRequestID bool
+
+		skipInvalidRows     bool
+		insertErrorTable    bool
+		ignoreUnknownValues bool
+
+		enrichments   cli.StringSlice
+		enrichRefresh time.Duration
 	)
 	return &cli.Command{
 		Name:      "ingest",
@@ -34,6 +84,232 @@ func ingestCommand() *cli.Command {
 				EnvVars:     []string{"SWARM_DRY_RUN"},
 				Destination: &dryRun,
 			},
+			&cli.BoolFlag{
+				Name:        "truncate-partition",
+				Usage:       "Delete existing rows in partitions affected by ingested data before inserting, to reprocess objects without duplicated rows",
+				EnvVars:     []string{"SWARM_TRUNCATE_PARTITION"},
+				Destination: &truncate,
+			},
+			&cli.IntFlag{
+				Name:        "schema-sample-head",
+				Usage:       "Number of first records to infer schema. If both schema-sample-head and schema-sample-random are 0, all records are used",
+				EnvVars:     []string{"SWARM_SCHEMA_SAMPLE_HEAD"},
+				Destination: &schemaSampleHead,
+			},
+			&cli.IntFlag{
+				Name:        "schema-sample-random",
+				Usage:       "Number of randomly picked records to infer schema in addition to schema-sample-head",
+				EnvVars:     []string{"SWARM_SCHEMA_SAMPLE_RANDOM"},
+				Destination: &schemaSampleRandom,
+			},
+			&cli.BoolFlag{
+				Name:        "timestamp-fallback",
+				Usage:       "Use last modified time of the object as log timestamp if schema policy returns no timestamp",
+				EnvVars:     []string{"SWARM_TIMESTAMP_FALLBACK"},
+				Destination: &timestampFallback,
+			},
+			&cli.BoolFlag{
+				Name:        "ingest-time-fallback",
+				Usage:       "Use ingested time as log timestamp if schema policy returns no timestamp, and create timestamp column as REQUIRED",
+				EnvVars:     []string{"SWARM_INGEST_TIME_FALLBACK"},
+				Destination: &ingestTimeFallback,
+			},
+			&cli.StringFlag{
+				Name:        "default-partition",
+				Usage:       "Time partitioning [hour|day|month|year] of table when schema policy does not specify partition",
+				EnvVars:     []string{"SWARM_DEFAULT_PARTITION"},
+				Destination: &defaultPartition,
+			},
+			&cli.IntFlag{
+				Name:        "policy-batch-size",
+				Usage:       "Number of rows evaluated by schema policy with a prepared query in a batch. 1 or less means row by row",
+				EnvVars:     []string{"SWARM_POLICY_BATCH_SIZE"},
+				Destination: &policyBatchSize,
+			},
+			&cli.BoolFlag{
+				Name:        "lowercase-dest",
+				Usage:       "Normalize dataset and table name returned by schema policy to lower case",
+				EnvVars:     []string{"SWARM_LOWERCASE_DEST"},
+				Destination: &lowerCaseDest,
+			},
+			&cli.BoolFlag{
+				Name:        "fail-on-missing",
+				Usage:       "Fail if any object does not exist, otherwise missing object is skipped",
+				EnvVars:     []string{"SWARM_FAIL_ON_MISSING"},
+				Destination: &failOnMissing,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-unroutable",
+				Usage:       "Skip objects for which event policy selects no source, otherwise they fail",
+				EnvVars:     []string{"SWARM_SKIP_UNROUTABLE"},
+				Destination: &skipUnroutable,
+			},
+			&cli.BoolFlag{
+				Name:        "log-object-version",
+				Usage:       "Record generation and CRC32C of objects in load log",
+				EnvVars:     []string{"SWARM_LOG_OBJECT_VERSION"},
+				Destination: &logObjectVersion,
+			},
+			&cli.BoolFlag{
+				Name:        "verify-checksum",
+				Usage:       "Verify CRC32C of downloaded objects before parsing, and fail the load if it does not match",
+				EnvVars:     []string{"SWARM_VERIFY_CHECKSUM"},
+				Destination: &verifyChecksum,
+			},
+			&cli.StringFlag{
+				Name:        "min-object-size",
+				Usage:       "Skip object smaller than the size (e.g. 1KiB) as incomplete write. No threshold if empty",
+				EnvVars:     []string{"SWARM_MIN_OBJECT_SIZE"},
+				Destination: &minObjectSize,
+			},
+			&cli.StringFlag{
+				Name:        "max-object-size",
+				Usage:       "Max size of object after decompression (e.g. 1GiB). Loading larger object fails. No limit if empty",
+				EnvVars:     []string{"SWARM_MAX_OBJECT_SIZE"},
+				Destination: &maxObjectSize,
+			},
+			&cli.IntFlag{
+				Name:        "parallel-objects",
+				Usage:       "Number of objects loaded concurrently",
+				EnvVars:     []string{"SWARM_PARALLEL_OBJECTS"},
+				Value:       4,
+				Destination: &parallelObjects,
+			},
+			&cli.DurationFlag{
+				Name:        "ingest-timeout",
+				Usage:       "Timeout duration of ingesting records into each destination table. A destination exceeding it fails without blocking others. No timeout if 0",
+				EnvVars:     []string{"SWARM_INGEST_TIMEOUT"},
+				Destination: &ingestTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "dedup-window",
+				Usage:       "Warn records older than the duration because insert ID does not deduplicate them. No warning if 0",
+				EnvVars:     []string{"SWARM_DEDUP_WINDOW"},
+				Destination: &dedupWindow,
+			},
+			&cli.StringFlag{
+				Name:        "schema-sidecar-bucket",
+				Usage:       "Cloud Storage bucket to write table schema as JSON when the schema is changed",
+				EnvVars:     []string{"SWARM_SCHEMA_SIDECAR_BUCKET"},
+				Destination: &schemaSidecarBucket,
+			},
+			&cli.StringFlag{
+				Name:        "schema-sidecar-prefix",
+				Usage:       "Object name prefix of table schema JSON in schema-sidecar-bucket",
+				EnvVars:     []string{"SWARM_SCHEMA_SIDECAR_PREFIX"},
+				Destination: &schemaSidecarPrefix,
+			},
+			&cli.StringFlag{
+				Name:        "schema-registry-dataset",
+				Usage:       "BigQuery dataset ID of schema registry table to which table schema is inserted when the schema is changed",
+				EnvVars:     []string{"SWARM_SCHEMA_REGISTRY_DATASET"},
+				Destination: &schemaRegistryDataset,
+			},
+			&cli.StringFlag{
+				Name:        "schema-registry-table",
+				Usage:       "BigQuery table ID of schema registry table",
+				EnvVars:     []string{"SWARM_SCHEMA_REGISTRY_TABLE"},
+				Destination: &schemaRegistryTable,
+			},
+			&cli.StringSliceFlag{
+				Name:        "schema-override",
+				Usage:       "Allow object to override schema of the source by \"swarm-schema\" custom metadata if the schema is one of specified. Can be specified multiple times",
+				EnvVars:     []string{"SWARM_SCHEMA_OVERRIDE"},
+				Destination: &schemaOverrides,
+			},
+			&cli.StringFlag{
+				Name:        "compress-metadata-key",
+				Usage:       "Key of custom metadata of object to specify its compression type (gzip, zstd or none) instead of compress of the source, e.g. content-codec. Disabled if empty",
+				EnvVars:     []string{"SWARM_COMPRESS_METADATA_KEY"},
+				Destination: &compressMetadataKey,
+			},
+			&cli.BoolFlag{
+				Name:        "schema-pin",
+				Usage:       "Use table schema JSON in schema-sidecar-bucket as the base of inferred schema, and never change types of existing fields",
+				EnvVars:     []string{"SWARM_SCHEMA_PIN"},
+				Destination: &schemaPin,
+			},
+			&cli.BoolFlag{
+				Name:        "sort-by-timestamp",
+				Usage:       "Sort records by timestamp before inserting into BigQuery. Insert order is not guaranteed by BigQuery, but it helps clustering by time",
+				EnvVars:     []string{"SWARM_SORT_BY_TIMESTAMP"},
+				Destination: &sortByTimestamp,
+			},
+			&cli.BoolFlag{
+				Name:        "record-request-id",
+				Usage:       "Record IDs of insert requests to BigQuery (write stream name, trace ID of the writer and offset if available) in ingest logs of metadata",
+				EnvVars:     []string{"SWARM_RECORD_REQUEST_ID"},
+				Destination: &recordRequestID,
+			},
+			&cli.BoolFlag{
+				Name:        "load-labels",
+				Usage:       "Set ID and time of the last successful load as labels (swarm_last_load_id, swarm_last_load_at) of destination tables",
+				EnvVars:     []string{"SWARM_LOAD_LABELS"},
+				Destination: &loadLabels,
+			},
+			&cli.BoolFlag{
+				Name:        "retry-unknown-field",
+				Usage:       "Widen table schema and retry insert once if BigQuery rejects rows by unknown field",
+				EnvVars:     []string{"SWARM_RETRY_UNKNOWN_FIELD"},
+				Destination: &retryUnknownField,
+			},
+			&cli.BoolFlag{
+				Name:        "insert-error-table",
+				Usage:       "Write rows rejected by BigQuery into <table>_errors table with the reason and insert the other rows of the chunk, instead of failing the chunk. The rows can be replayed by replay-dead-letter",
+				EnvVars:     []string{"SWARM_INSERT_ERROR_TABLE"},
+				Destination: &insertErrorTable,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-invalid-rows",
+				Usage:       "Insert valid rows and drop invalid rows of a chunk instead of failing the chunk. Dropped rows are lost with only warning logs",
+				EnvVars:     []string{"SWARM_SKIP_INVALID_ROWS"},
+				Destination: &skipInvalidRows,
+			},
+			&cli.BoolFlag{
+				Name:        "ignore-unknown-values",
+				Usage:       "Drop values of fields not in the table schema instead of rejecting rows. Dropped values are lost and do not widen the table schema",
+				EnvVars:     []string{"SWARM_IGNORE_UNKNOWN_VALUES"},
+				Destination: &ignoreUnknownValues,
+			},
+			&cli.StringSliceFlag{
+				Name:        "enrich",
+				Usage:       "Add columns of a BigQuery dimension table to records of which value at the field matches the key column (field=dataset.table.key). Can be specified multiple times",
+				EnvVars:     []string{"SWARM_ENRICH"},
+				Destination: &enrichments,
+			},
+			&cli.DurationFlag{
+				Name:        "enrich-refresh",
+				Usage:       "Interval to reload dimension tables of --enrich. 0 means loading them only at startup",
+				EnvVars:     []string{"SWARM_ENRICH_REFRESH"},
+				Destination: &enrichRefresh,
+				Value:       10 * time.Minute,
+			},
+			&cli.StringSliceFlag{
+				Name:        "fixed-schema",
+				Usage:       "Use BigQuery schema JSON file for the table instead of inferring it (dataset.table=path). Can be specified multiple times",
+				EnvVars:     []string{"SWARM_FIXED_SCHEMA"},
+				Destination: &fixedSchemas,
+			},
+			&cli.StringFlag{
+				Name:        "fixed-schema-mode",
+				Usage:       "Handling of records having fields not declared in fixed schema or values not matching column types: strict (reject ingestion) or loose (remove the fields and convert the values)",
+				EnvVars:     []string{"SWARM_FIXED_SCHEMA_MODE"},
+				Value:       string(types.FixedSchemaStrict),
+				Destination: &fixedSchemaMode,
+			},
+			&cli.StringFlag{
+				Name:        "query",
+				Aliases:     []string{"q"},
+				Usage:       "BigQuery query returning object URLs in url column to be ingested instead of arguments",
+				EnvVars:     []string{"SWARM_INGEST_QUERY"},
+				Destination: &query,
+			},
+			&cli.StringFlag{
+				Name:        "failures-file",
+				Usage:       "File path to append URL and error of each failed object as tab separated lines while loading, to retry them later",
+				EnvVars:     []string{"SWARM_FAILURES_FILE"},
+				Destination: &failuresFile,
+			},
 			&cli.StringFlag{
 				Name:        "output",
 				Aliases:     []string{"o"},
@@ -42,7 +318,7 @@ func ingestCommand() *cli.Command {
 				Value:       ".",
 				Destination: &output,
 			},
-		}, bigquery.Flags(), policy.Flags(), metadata.Flags()),
+		}, bigquery.Flags(), policy.Flags(), metadata.Flags(), lineage.Flags()),
 
 		Action: func(c *cli.Context) error {
 			ctx := c.Context
@@ -74,21 +350,160 @@ func ingestCommand() *cli.Command {
 				return goerr.Wrap(err, "failed to configure metadata")
 			}
 
+			ucOptions := []usecase.Option{
+				usecase.WithMetadata(md),
+				usecase.WithLoadLogSink(metadata.Sinks(csClient)...),
+				usecase.WithMetadataStrict(metadata.Strict()),
+				usecase.WithFailOnMissing(failOnMissing),
+				usecase.WithSkipUnroutable(skipUnroutable),
+				usecase.WithIngestTimeout(ingestTimeout),
+				usecase.WithDedupWindow(dedupWindow),
+			}
+			if !dryRun {
+				if lineageClient, err := lineage.Configure(ctx, bigquery.ProjectID()); err != nil {
+					return err
+				} else if lineageClient != nil {
+					ucOptions = append(ucOptions, usecase.WithLineageSink(lineageClient))
+				}
+			}
+			if query != "" && dryRun {
+				return goerr.Wrap(types.ErrInvalidOption, "--query can not be used with --dry-run")
+			}
+			if query != "" && c.Args().Len() > 0 {
+				return goerr.Wrap(types.ErrInvalidOption, "object path arguments can not be used with --query")
+			}
+			if truncate {
+				ucOptions = append(ucOptions, usecase.WithTruncatePartition())
+			}
+			if schemaSampleHead > 0 || schemaSampleRandom > 0 {
+				ucOptions = append(ucOptions, usecase.WithSchemaSample(schemaSampleHead, schemaSampleRandom))
+			}
+			if timestampFallback {
+				ucOptions = append(ucOptions, usecase.WithObjectTimeFallback())
+			}
+			if ingestTimeFallback {
+				ucOptions = append(ucOptions, usecase.WithIngestTimeFallback())
+			}
+			if logObjectVersion {
+				ucOptions = append(ucOptions, usecase.WithObjectVersion())
+			}
+			if verifyChecksum {
+				ucOptions = append(ucOptions, usecase.WithVerifyChecksum())
+			}
+			if lowerCaseDest {
+				ucOptions = append(ucOptions, usecase.WithLowerCaseDest())
+			}
+			if policyBatchSize > 1 {
+				ucOptions = append(ucOptions, usecase.WithPolicyBatchSize(policyBatchSize))
+			}
+			if minObjectSize != "" {
+				threshold, err := humanize.ParseBytes(minObjectSize)
+				if err != nil {
+					return goerr.Wrap(err, "invalid min object size option")
+				}
+				ucOptions = append(ucOptions, usecase.WithMinObjectSize(int64(threshold)))
+			}
+			if maxObjectSize != "" {
+				limit, err := humanize.ParseBytes(maxObjectSize)
+				if err != nil {
+					return goerr.Wrap(err, "invalid max object size option")
+				}
+				ucOptions = append(ucOptions, usecase.WithMaxObjectSize(int64(limit)))
+			}
+			if defaultPartition != "" {
+				pt, err := parseDefaultPartition(defaultPartition)
+				if err != nil {
+					return err
+				}
+				ucOptions = append(ucOptions, usecase.WithDefaultPartition(pt))
+			}
+
+			if schemaSidecarBucket != "" {
+				ucOptions = append(ucOptions, usecase.WithSchemaSidecar(types.CSBucket(schemaSidecarBucket), schemaSidecarPrefix))
+			}
+			if schemaRegistryDataset != "" || schemaRegistryTable != "" {
+				if schemaRegistryDataset == "" || schemaRegistryTable == "" {
+					return goerr.Wrap(types.ErrInvalidOption, "--schema-registry-dataset and --schema-registry-table must be specified together")
+				}
+				ucOptions = append(ucOptions, usecase.WithSchemaRegistry(types.BQDatasetID(schemaRegistryDataset), types.BQTableID(schemaRegistryTable)))
+			}
+			if len(schemaOverrides.Value()) > 0 {
+				var allowed []types.ObjectSchema
+				for _, schema := range schemaOverrides.Value() {
+					allowed = append(allowed, types.ObjectSchema(schema))
+				}
+				ucOptions = append(ucOptions, usecase.WithSchemaOverride(allowed...))
+			}
+			if compressMetadataKey != "" {
+				ucOptions = append(ucOptions, usecase.WithCompressMetadataKey(compressMetadataKey))
+			}
+			if schemaPin {
+				if schemaSidecarBucket == "" {
+					return goerr.Wrap(types.ErrInvalidOption, "--schema-pin requires --schema-sidecar-bucket")
+				}
+				ucOptions = append(ucOptions, usecase.WithSchemaPin())
+			}
+			if sortByTimestamp {
+				ucOptions = append(ucOptions, usecase.WithSortByTimestamp())
+			}
+			if loadLabels {
+				ucOptions = append(ucOptions, usecase.WithLoadLabels())
+			}
+			if recordRequestID {
+				ucOptions = append(ucOptions, usecase.WithIngestRequestID())
+			}
+			if retryUnknownField {
+				ucOptions = append(ucOptions, usecase.WithRetryUnknownField())
+			}
+			if insertErrorTable {
+				ucOptions = append(ucOptions, usecase.WithInsertErrorTable())
+			}
+			if skipInvalidRows {
+				ucOptions = append(ucOptions, usecase.WithSkipInvalidRows())
+			}
+			if ignoreUnknownValues {
+				ucOptions = append(ucOptions, usecase.WithIgnoreUnknownValues())
+			}
+			fixedSchemaOptions, err := parseFixedSchemas(fixedSchemas.Value(), fixedSchemaMode)
+			if err != nil {
+				return err
+			}
+			ucOptions = append(ucOptions, fixedSchemaOptions...)
+			enrichOptions, err := parseEnrichments(enrichments.Value(), enrichRefresh)
+			if err != nil {
+				return err
+			}
+			ucOptions = append(ucOptions, enrichOptions...)
+			if failuresFile != "" {
+				f, err := os.OpenFile(filepath.Clean(failuresFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+				if err != nil {
+					return goerr.Wrap(err, "failed to open failures file").With("path", failuresFile)
+				}
+				defer f.Close()
+				ucOptions = append(ucOptions, usecase.WithFailureWriter(f))
+			}
+
 			uc := usecase.New(
 				infra.New(
 					infra.WithPolicy(policyClient),
 					infra.WithCloudStorage(csClient),
 					infra.WithBigQuery(bqClient),
 				),
-				usecase.WithMetadata(md),
+				ucOptions...,
 			)
+			if err := uc.LoadDimensions(ctx); err != nil {
+				return err
+			}
 
+			if query != "" {
+				return uc.LoadObjectsByQuery(ctx, query, parallelObjects)
+			}
+
+			var urls []types.CSUrl
 			for _, url := range c.Args().Slice() {
-				if err := uc.LoadDataByObject(ctx, types.CSUrl(url)); err != nil {
-					return goerr.Wrap(err, "failed to load data").With("url", url)
-				}
+				urls = append(urls, types.CSUrl(url))
 			}
-			return nil
+			return uc.LoadDataByObjects(ctx, urls, parallelObjects)
 		},
 	}
 }
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func partitionCommand() *cli.Command {
+	var (
+		start     string
+		end       string
+		partition types.BQPartition
+		warnOnly  bool
+	)
+
+	return &cli.Command{
+		Name:  "partition",
+		Usage: "Estimate number of time partitions for a time range to avoid exceeding the partition limit of BigQuery",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "start",
+				Aliases:     []string{"s"},
+				Usage:       "Start of time range (RFC3339 or YYYY-MM-DD)",
+				EnvVars:     []string{"SWARM_PARTITION_START"},
+				Destination: &start,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "end",
+				Aliases:     []string{"e"},
+				Usage:       "End of time range (RFC3339 or YYYY-MM-DD), default is now",
+				EnvVars:     []string{"SWARM_PARTITION_END"},
+				Destination: &end,
+			},
+			&cli.StringFlag{
+				Name:        "partition-type",
+				Aliases:     []string{"p"},
+				Usage:       "Time partition type of table [hour|day|month|year]",
+				EnvVars:     []string{"SWARM_PARTITION_TYPE"},
+				Destination: (*string)(&partition),
+				Value:       string(types.BQPartitionDay),
+			},
+			&cli.BoolFlag{
+				Name:        "warn-only",
+				Usage:       "Only warn instead of failing if number of partitions exceeds the limit",
+				EnvVars:     []string{"SWARM_PARTITION_WARN_ONLY"},
+				Destination: &warnOnly,
+			},
+		},
+
+		Action: func(c *cli.Context) error {
+			startAt, err := parseRangeTime(start)
+			if err != nil {
+				return err
+			}
+			endAt := time.Now()
+			if end != "" {
+				if endAt, err = parseRangeTime(end); err != nil {
+					return err
+				}
+			}
+
+			estimate, estErr := usecase.EstimatePartitions(startAt, endAt, partition)
+			if estErr != nil && !errors.Is(estErr, types.ErrTooManyPartitions) {
+				return estErr
+			}
+
+			encoder := json.NewEncoder(c.App.Writer)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(estimate); err != nil {
+				return goerr.Wrap(err, "failed to encode partition estimate")
+			}
+
+			if estErr != nil {
+				if warnOnly {
+					utils.Logger().Warn("number of partitions exceeds the limit",
+						"count", estimate.Count,
+						"limit", usecase.MaxPartitions,
+						"suggested", estimate.Suggested,
+					)
+					return nil
+				}
+				return estErr
+			}
+
+			return nil
+		},
+	}
+}
+
+// parseRangeTime parses time of --start and --end option. Date without time is regarded as midnight in UTC.
+func parseRangeTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, goerr.Wrap(types.ErrInvalidOption, "invalid time format").With("time", v)
+	}
+	return t, nil
+}
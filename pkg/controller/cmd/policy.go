@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/urfave/cli/v2"
+)
+
+func policyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "policy",
+		Usage: "Utilities for developing policies",
+		Subcommands: []*cli.Command{
+			policyEvalCommand(),
+		},
+	}
+}
+
+func policyEvalCommand() *cli.Command {
+	var (
+		policy config.Policy
+
+		schema string
+		input  string
+	)
+
+	return &cli.Command{
+		Name:  "eval",
+		Usage: "Evaluate schema policy with a JSON record from stdin (or --input file) and print the output as JSON. Cloud Storage and BigQuery are not used",
+		Flags: mergeFlags([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "schema",
+				Aliases:     []string{"s"},
+				Usage:       "Schema name of the policy to evaluate, i.e. package schema.{name}",
+				EnvVars:     []string{"SWARM_POLICY_EVAL_SCHEMA"},
+				Destination: &schema,
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "input",
+				Aliases:     []string{"i"},
+				Usage:       "File path of a JSON record. Read from stdin if not specified",
+				EnvVars:     []string{"SWARM_POLICY_EVAL_INPUT"},
+				Destination: &input,
+			},
+		}, policy.Flags()),
+
+		Action: func(c *cli.Context) error {
+			policyClient, err := policy.Configure()
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure policy client")
+			}
+			uc := usecase.New(infra.New(infra.WithPolicy(policyClient)))
+
+			var r io.Reader = c.App.Reader
+			if input != "" {
+				f, err := os.Open(filepath.Clean(input))
+				if err != nil {
+					return goerr.Wrap(err, "failed to open input file").With("path", input)
+				}
+				defer f.Close()
+				r = f
+			}
+
+			output, err := uc.EvalSchemaPolicy(c.Context, types.ObjectSchema(schema), r)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(c.App.Writer)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(output); err != nil {
+				return goerr.Wrap(err, "failed to write policy output")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/urfave/cli/v2"
+)
+
+func replayDeadLetterCommand() *cli.Command {
+	var (
+		bigquery config.BigQuery
+		policy   config.Policy
+		metadata config.Metadata
+
+		start           string
+		end             string
+		errorFilter     string
+		dryRun          bool
+		parallelObjects int
+
+		errorDataset types.BQDatasetID
+		errorTable   types.BQTableID
+
+		subProjectID types.GoogleProjectID
+		subID        types.PubSubSubscriptionID
+		idleTimeout  time.Duration
+
+		dumpDir string
+	)
+
+	return &cli.Command{
+		Name:  "replay-dead-letter",
+		Usage: "Reload objects of failed loads recorded in metadata (BigQuery table or Cloud Storage) or dead letter topic, or rows in insert error table, after fixing the cause",
+		Flags: mergeFlags([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "start",
+				Aliases:     []string{"s"},
+				Usage:       "Replay loads started at or after the time (RFC3339 or YYYY-MM-DD)",
+				EnvVars:     []string{"SWARM_REPLAY_START"},
+				Destination: &start,
+			},
+			&cli.StringFlag{
+				Name:        "end",
+				Aliases:     []string{"e"},
+				Usage:       "Replay loads started before the time (RFC3339 or YYYY-MM-DD)",
+				EnvVars:     []string{"SWARM_REPLAY_END"},
+				Destination: &end,
+			},
+			&cli.StringFlag{
+				Name:        "error",
+				Usage:       "Replay only loads of which error message contains the string",
+				EnvVars:     []string{"SWARM_REPLAY_ERROR"},
+				Destination: &errorFilter,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Only print URLs of objects to be replayed",
+				EnvVars:     []string{"SWARM_REPLAY_DRY_RUN"},
+				Destination: &dryRun,
+			},
+			&cli.IntFlag{
+				Name:        "parallel-objects",
+				Usage:       "Number of objects loaded concurrently",
+				EnvVars:     []string{"SWARM_PARALLEL_OBJECTS"},
+				Value:       4,
+				Destination: &parallelObjects,
+			},
+			&cli.StringFlag{
+				Name:        "insert-error-dataset-id",
+				Usage:       "Dataset ID of the table of which rows in insert error table (<table>_errors) are replayed instead of objects",
+				EnvVars:     []string{"SWARM_REPLAY_INSERT_ERROR_DATASET_ID"},
+				Destination: (*string)(&errorDataset),
+			},
+			&cli.StringFlag{
+				Name:        "insert-error-table-id",
+				Usage:       "Table ID of the table of which rows in insert error table (<table>_errors) are replayed instead of objects",
+				EnvVars:     []string{"SWARM_REPLAY_INSERT_ERROR_TABLE_ID"},
+				Destination: (*string)(&errorTable),
+			},
+			&cli.StringFlag{
+				Name:        "dead-letter-subscription-project-id",
+				Usage:       "Google Cloud Project ID of Pub/Sub subscription of dead letter topic",
+				EnvVars:     []string{"SWARM_REPLAY_DEAD_LETTER_SUBSCRIPTION_PROJECT_ID"},
+				Destination: (*string)(&subProjectID),
+			},
+			&cli.StringFlag{
+				Name:        "dead-letter-subscription-id",
+				Usage:       "Pub/Sub subscription ID of dead letter topic to replay objects in the messages instead of metadata",
+				EnvVars:     []string{"SWARM_REPLAY_DEAD_LETTER_SUBSCRIPTION_ID"},
+				Destination: (*string)(&subID),
+			},
+			&cli.DurationFlag{
+				Name:        "idle-timeout",
+				Usage:       "Stop pulling dead letter messages when no message is replayed for the duration",
+				EnvVars:     []string{"SWARM_REPLAY_IDLE_TIMEOUT"},
+				Value:       30 * time.Second,
+				Destination: &idleTimeout,
+			},
+			&cli.StringFlag{
+				Name:        "dump-dir",
+				Usage:       "Directory of messages dumped by enqueue --output to replay objects in the messages instead of metadata. Gzip compressed files are also read",
+				EnvVars:     []string{"SWARM_REPLAY_DUMP_DIR"},
+				Destination: &dumpDir,
+			},
+		}, bigquery.Flags(), policy.Flags(), metadata.Flags()),
+
+		Action: func(c *cli.Context) error {
+			ctx := c.Context
+
+			var filter model.ReplayFilter
+			if start != "" {
+				t, err := parseRangeTime(start)
+				if err != nil {
+					return err
+				}
+				filter.Start = t
+			}
+			if end != "" {
+				t, err := parseRangeTime(end)
+				if err != nil {
+					return err
+				}
+				filter.End = t
+			}
+			filter.Error = errorFilter
+
+			if (errorDataset == "") != (errorTable == "") {
+				return goerr.Wrap(types.ErrInvalidOption, "both of --insert-error-dataset-id and --insert-error-table-id are required")
+			}
+			if (subProjectID == "") != (subID == "") {
+				return goerr.Wrap(types.ErrInvalidOption, "both of --dead-letter-subscription-project-id and --dead-letter-subscription-id are required")
+			}
+			if errorTable != "" && subID != "" {
+				return goerr.Wrap(types.ErrInvalidOption, "insert error table and dead letter subscription can not be replayed at once")
+			}
+			if dumpDir != "" && (errorTable != "" || subID != "") {
+				return goerr.Wrap(types.ErrInvalidOption, "dumped messages can not be replayed with insert error table or dead letter subscription")
+			}
+			if dumpDir != "" && (start != "" || end != "" || errorFilter != "") {
+				return goerr.Wrap(types.ErrInvalidOption, "--start, --end and --error are not available for dumped messages because they have no failure")
+			}
+			if subID != "" && dryRun {
+				return goerr.Wrap(types.ErrInvalidOption, "--dry-run is not available for dead letter subscription because messages are consumed")
+			}
+
+			md, err := metadata.Configure()
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure metadata")
+			}
+			bucket, prefix := metadata.CloudStorage()
+			if errorTable == "" && subID == "" && dumpDir == "" && md == nil && bucket == "" {
+				return goerr.Wrap(types.ErrInvalidOption, "--meta-bq-dataset-id and --meta-bq-table-id, or --meta-gcs-bucket is required to read failed loads")
+			}
+
+			policyClient, err := policy.Configure()
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure policy client")
+			}
+			bqClient, err := bigquery.Configure(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure BigQuery client")
+			}
+			csClient, err := cs.New(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure CloudStorage client")
+			}
+
+			infraOptions := []infra.Option{
+				infra.WithPolicy(policyClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithBigQuery(bqClient),
+			}
+			if subID != "" {
+				sub, err := pubsub.NewSubscription(ctx, subProjectID, subID, parallelObjects)
+				if err != nil {
+					return err
+				}
+				infraOptions = append(infraOptions, infra.WithPubSubSubscription(sub))
+			}
+
+			uc := usecase.New(
+				infra.New(infraOptions...),
+				usecase.WithMetadata(md),
+				usecase.WithLoadLogSink(metadata.Sinks(csClient)...),
+				usecase.WithMetadataStrict(metadata.Strict()),
+			)
+
+			if errorTable != "" {
+				dst := model.BigQueryDest{Dataset: errorDataset, Table: errorTable}
+				records, err := uc.ListInsertErrors(ctx, dst, filter)
+				if err != nil {
+					return err
+				}
+
+				if dryRun {
+					for _, record := range records {
+						fmt.Fprintln(c.App.Writer, record.ID)
+					}
+					fmt.Fprintf(c.App.Writer, "%d rows to be replayed\n", len(records))
+					return nil
+				}
+
+				return uc.ReplayInsertErrors(ctx, dst, records)
+			}
+
+			if subID != "" {
+				count, err := uc.ReplayDeadLetterSubscription(ctx, filter, idleTimeout)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(c.App.Writer, "%d dead letter messages replayed\n", count)
+				return nil
+			}
+
+			// The metadata table is preferred because filtering is done by BigQuery
+			var urls []types.CSUrl
+			switch {
+			case dumpDir != "":
+				var messages [][]byte
+				if messages, err = pubsub.ReadDump(dumpDir); err != nil {
+					return err
+				}
+				urls, err = uc.ListDumpedObjects(ctx, messages)
+			case md != nil:
+				urls, err = uc.ListFailedObjectsByTable(ctx, md.Dataset(), md.Table(), filter)
+			default:
+				urls, err = uc.ListFailedObjectsByCloudStorage(ctx, bucket, prefix, filter)
+			}
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				for _, url := range urls {
+					fmt.Fprintln(c.App.Writer, url)
+				}
+				fmt.Fprintf(c.App.Writer, "%d objects to be replayed\n", len(urls))
+				return nil
+			}
+
+			return uc.LoadDataByObjects(ctx, urls, parallelObjects)
+		},
+	}
+}
@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
+
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
 	"github.com/m-mizutani/swarm/pkg/infra/cs"
@@ -20,6 +25,9 @@ func schemaCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "schema",
 		Usage: "Infer schema from Cloud Storage object, and apply it to BigQuery table",
+		Subcommands: []*cli.Command{
+			schemaDiffCommand(),
+		},
 		Flags: mergeFlags([]cli.Flag{
 			&cli.StringFlag{
 				Name:        "output-dir",
@@ -68,3 +76,93 @@ func schemaCommand() *cli.Command {
 		},
 	}
 }
+
+func schemaDiffCommand() *cli.Command {
+	var (
+		bq     config.BigQuery
+		policy config.Policy
+	)
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "Show difference between schema inferred from Cloud Storage object and live BigQuery table. Exit with error if the change is incompatible",
+		ArgsUsage: "[gs://bucket/prefix...]",
+		Flags:     mergeFlags([]cli.Flag{}, bq.Flags(), policy.Flags()),
+
+		Action: func(c *cli.Context) error {
+			bqClient, err := bq.Configure(c.Context)
+			if err != nil {
+				return err
+			}
+
+			policyClient, err := policy.Configure()
+			if err != nil {
+				return err
+			}
+
+			csClient, err := cs.New(c.Context)
+			if err != nil {
+				return err
+			}
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(policyClient),
+			))
+
+			var urls []types.CSUrl
+			for i := 0; i < c.Args().Len(); i++ {
+				urls = append(urls, types.CSUrl(c.Args().Get(i)))
+			}
+
+			diffs, err := uc.DiffInferredSchema(c.Context, urls)
+			if err != nil {
+				return err
+			}
+
+			return printSchemaDiffs(c.App.Writer, diffs)
+		},
+	}
+}
+
+func printSchemaDiffs(w io.Writer, diffs []*model.SchemaDiff) error {
+	var conflicts []string
+	for _, diff := range diffs {
+		table := fmt.Sprintf("%s.%s", diff.Dest.Dataset, diff.Dest.Table)
+		switch {
+		case !diff.TableExists:
+			fmt.Fprintf(w, "%s: (new table)\n", table)
+		case !diff.HasChanges():
+			fmt.Fprintf(w, "%s: no change\n", table)
+			continue
+		default:
+			fmt.Fprintf(w, "%s:\n", table)
+		}
+
+		for _, f := range diff.Added {
+			fmt.Fprintf(w, "  + %s %s\n", f.Name, fieldTypeString(*f))
+		}
+		for _, f := range diff.Removed {
+			fmt.Fprintf(w, "  - %s %s\n", f.Name, fieldTypeString(*f))
+		}
+		for _, f := range diff.Retyped {
+			fmt.Fprintf(w, "  ~ %s %s -> %s\n", f.Name, fieldTypeString(f.Old), fieldTypeString(f.New))
+		}
+
+		if diff.Incompatible() {
+			conflicts = append(conflicts, table)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return goerr.Wrap(types.ErrSchemaConflict, "incompatible schema change").With("tables", conflicts)
+	}
+	return nil
+}
+
+func fieldTypeString(f model.SchemaField) string {
+	if f.Repeated {
+		return fmt.Sprintf("ARRAY<%s>", f.Type)
+	}
+	return string(f.Type)
+}
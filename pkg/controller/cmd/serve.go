@@ -12,6 +12,7 @@ import (
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
 	"github.com/m-mizutani/swarm/pkg/controller/server"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
 	"github.com/m-mizutani/swarm/pkg/infra/cs"
 	"github.com/m-mizutani/swarm/pkg/infra/firestore"
@@ -26,18 +27,66 @@ func serveCommand() *cli.Command {
 		readConcurrency         int
 		ingestTableConcurrency  int
 		ingestRecordConcurrency int
+		maxConcurrentInserts    int
+		schemaSampleHead        int
+		schemaSampleRandom      int
+		ingestTimeFallback      bool
+		timestampFallback       bool
+		defaultPartition        string
+		lowerCaseDest           bool
+		policyBatchSize         int
+		failOnMissing           bool
+		skipUnroutable          bool
+		logObjectVersion        bool
+		verifyChecksum          bool
+		minObjectSize           string
+		maxObjectSize           string
 		stateTimeout            time.Duration
 		stateTTL                time.Duration
+		ingestTimeout           time.Duration
+		dedupWindow             time.Duration
+		insertTrackingTTL       time.Duration
+		force                   bool
 
 		bq       config.BigQuery
 		policy   config.Policy
 		metadata config.Metadata
 		sentry   config.Sentry
 
+		deadLetter config.DeadLetter
+		lineage    config.Lineage
+
 		firestoreProject  string
 		firestoreDatabase string
 
-		memoryLimit string
+		memoryLimit     string
+		maxRequests     int
+		metricsExporter string
+		statsAddr       string
+
+		schemaSidecarBucket string
+		schemaSidecarPrefix string
+		schemaPin           bool
+		sortByTimestamp     bool
+		loadLabels          bool
+		retryUnknownField   bool
+		fixedSchemas        cli.StringSlice
+		fixedSchemaMode     string
+
+		schemaRegistryDataset string
+		schemaRegistryTable   string
+
+		schemaOverrides     cli.StringSlice
+		compressMetadataKey string
+
+		recordRequestID bool
+
+		skipInvalidRows     bool
+		insertErrorTable    bool
+		ignoreUnknownValues bool
+
+		enrichments   cli.StringSlice
+		enrichRefresh time.Duration
 	)
 
 	return &cli.Command{
@@ -73,6 +122,90 @@ func serveCommand() *cli.Command {
 				Destination: &ingestRecordConcurrency,
 				Value:       16,
 			},
+			&cli.IntFlag{
+				Name:        "max-concurrent-inserts",
+				EnvVars:     []string{"SWARM_MAX_CONCURRENT_INSERTS"},
+				Usage:       "Max number of concurrent inserts to BigQuery in the whole process, 0 means no limit",
+				Destination: &maxConcurrentInserts,
+			},
+			&cli.IntFlag{
+				Name:        "schema-sample-head",
+				EnvVars:     []string{"SWARM_SCHEMA_SAMPLE_HEAD"},
+				Usage:       "Number of first records to infer schema. If both schema-sample-head and schema-sample-random are 0, all records are used",
+				Destination: &schemaSampleHead,
+			},
+			&cli.IntFlag{
+				Name:        "schema-sample-random",
+				EnvVars:     []string{"SWARM_SCHEMA_SAMPLE_RANDOM"},
+				Usage:       "Number of randomly picked records to infer schema in addition to schema-sample-head",
+				Destination: &schemaSampleRandom,
+			},
+			&cli.BoolFlag{
+				Name:        "timestamp-fallback",
+				EnvVars:     []string{"SWARM_TIMESTAMP_FALLBACK"},
+				Usage:       "Use last modified time of the object as log timestamp if schema policy returns no timestamp",
+				Destination: &timestampFallback,
+			},
+			&cli.BoolFlag{
+				Name:        "ingest-time-fallback",
+				EnvVars:     []string{"SWARM_INGEST_TIME_FALLBACK"},
+				Usage:       "Use ingested time as log timestamp if schema policy returns no timestamp, and create timestamp column as REQUIRED",
+				Destination: &ingestTimeFallback,
+			},
+			&cli.StringFlag{
+				Name:        "default-partition",
+				EnvVars:     []string{"SWARM_DEFAULT_PARTITION"},
+				Usage:       "Time partitioning [hour|day|month|year] of table when schema policy does not specify partition",
+				Destination: &defaultPartition,
+			},
+			&cli.IntFlag{
+				Name:        "policy-batch-size",
+				EnvVars:     []string{"SWARM_POLICY_BATCH_SIZE"},
+				Usage:       "Number of rows evaluated by schema policy with a prepared query in a batch. 1 or less means row by row",
+				Destination: &policyBatchSize,
+			},
+			&cli.BoolFlag{
+				Name:        "lowercase-dest",
+				EnvVars:     []string{"SWARM_LOWERCASE_DEST"},
+				Usage:       "Normalize dataset and table name returned by schema policy to lower case",
+				Destination: &lowerCaseDest,
+			},
+			&cli.BoolFlag{
+				Name:        "fail-on-missing",
+				EnvVars:     []string{"SWARM_FAIL_ON_MISSING"},
+				Usage:       "Fail if any object does not exist, otherwise missing object is skipped",
+				Destination: &failOnMissing,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-unroutable",
+				EnvVars:     []string{"SWARM_SKIP_UNROUTABLE"},
+				Usage:       "Skip objects for which event policy selects no source, otherwise they fail",
+				Destination: &skipUnroutable,
+			},
+			&cli.BoolFlag{
+				Name:        "log-object-version",
+				EnvVars:     []string{"SWARM_LOG_OBJECT_VERSION"},
+				Usage:       "Record generation and CRC32C of objects in load log",
+				Destination: &logObjectVersion,
+			},
+			&cli.BoolFlag{
+				Name:        "verify-checksum",
+				EnvVars:     []string{"SWARM_VERIFY_CHECKSUM"},
+				Usage:       "Verify CRC32C of downloaded objects before parsing, and fail the load if it does not match",
+				Destination: &verifyChecksum,
+			},
+			&cli.StringFlag{
+				Name:        "min-object-size",
+				EnvVars:     []string{"SWARM_MIN_OBJECT_SIZE"},
+				Usage:       "Skip object smaller than the size (e.g. 1KiB) as incomplete write. No threshold if empty",
+				Destination: &minObjectSize,
+			},
+			&cli.StringFlag{
+				Name:        "max-object-size",
+				EnvVars:     []string{"SWARM_MAX_OBJECT_SIZE"},
+				Usage:       "Max size of object after decompression (e.g. 1GiB). Loading larger object fails. No limit if empty",
+				Destination: &maxObjectSize,
+			},
 			&cli.DurationFlag{
 				Name:        "state-timeout",
 				EnvVars:     []string{"SWARM_STATE_TIMEOUT"},
@@ -87,6 +220,30 @@ func serveCommand() *cli.Command {
 				Destination: &stateTTL,
 				Value:       7 * 24 * time.Hour,
 			},
+			&cli.DurationFlag{
+				Name:        "ingest-timeout",
+				EnvVars:     []string{"SWARM_INGEST_TIMEOUT"},
+				Usage:       "Timeout duration of ingesting records into each destination table. A destination exceeding it fails without blocking others. No timeout if 0",
+				Destination: &ingestTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "dedup-window",
+				EnvVars:     []string{"SWARM_DEDUP_WINDOW"},
+				Usage:       "Warn records older than the duration because insert ID does not deduplicate them. No warning if 0",
+				Destination: &dedupWindow,
+			},
+			&cli.DurationFlag{
+				Name:        "insert-tracking-ttl",
+				EnvVars:     []string{"SWARM_INSERT_TRACKING_TTL"},
+				Usage:       "Keep IDs of inserted records in memory for the duration, and insert only records not inserted yet when a partially failed load is retried. Disabled if 0",
+				Destination: &insertTrackingTTL,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				EnvVars:     []string{"SWARM_FORCE"},
+				Usage:       "Re-ingest data bypassing state checks even if the message has been already processed or is being processed by other process, and insert tracking of --insert-tracking-ttl",
+				Destination: &force,
+			},
 			&cli.StringFlag{
 				Name:        "firestore-project-id",
 				EnvVars:     []string{"SWARM_FIRESTORE_PROJECT_ID"},
@@ -99,13 +256,142 @@ func serveCommand() *cli.Command {
 				Usage:       "Database ID of Firestore (To manage state)",
 				Destination: &firestoreDatabase,
 			},
+			&cli.StringFlag{
+				Name:        "schema-sidecar-bucket",
+				EnvVars:     []string{"SWARM_SCHEMA_SIDECAR_BUCKET"},
+				Usage:       "Cloud Storage bucket to write table schema as JSON when the schema is changed",
+				Destination: &schemaSidecarBucket,
+			},
+			&cli.StringFlag{
+				Name:        "schema-sidecar-prefix",
+				EnvVars:     []string{"SWARM_SCHEMA_SIDECAR_PREFIX"},
+				Usage:       "Object name prefix of table schema JSON in schema-sidecar-bucket",
+				Destination: &schemaSidecarPrefix,
+			},
+			&cli.StringFlag{
+				Name:        "schema-registry-dataset",
+				EnvVars:     []string{"SWARM_SCHEMA_REGISTRY_DATASET"},
+				Usage:       "BigQuery dataset ID of schema registry table to which table schema is inserted when the schema is changed",
+				Destination: &schemaRegistryDataset,
+			},
+			&cli.StringFlag{
+				Name:        "schema-registry-table",
+				EnvVars:     []string{"SWARM_SCHEMA_REGISTRY_TABLE"},
+				Usage:       "BigQuery table ID of schema registry table",
+				Destination: &schemaRegistryTable,
+			},
+			&cli.StringSliceFlag{
+				Name:        "schema-override",
+				EnvVars:     []string{"SWARM_SCHEMA_OVERRIDE"},
+				Usage:       "Allow object to override schema of the source by \"swarm-schema\" custom metadata if the schema is one of specified. Can be specified multiple times",
+				Destination: &schemaOverrides,
+			},
+			&cli.StringFlag{
+				Name:        "compress-metadata-key",
+				EnvVars:     []string{"SWARM_COMPRESS_METADATA_KEY"},
+				Usage:       "Key of custom metadata of object to specify its compression type (gzip, zstd or none) instead of compress of the source, e.g. content-codec. Disabled if empty",
+				Destination: &compressMetadataKey,
+			},
+			&cli.BoolFlag{
+				Name:        "schema-pin",
+				EnvVars:     []string{"SWARM_SCHEMA_PIN"},
+				Usage:       "Use table schema JSON in schema-sidecar-bucket as the base of inferred schema, and never change types of existing fields",
+				Destination: &schemaPin,
+			},
+			&cli.BoolFlag{
+				Name:        "sort-by-timestamp",
+				EnvVars:     []string{"SWARM_SORT_BY_TIMESTAMP"},
+				Usage:       "Sort records by timestamp before inserting into BigQuery. Insert order is not guaranteed by BigQuery, but it helps clustering by time",
+				Destination: &sortByTimestamp,
+			},
+			&cli.BoolFlag{
+				Name:        "record-request-id",
+				EnvVars:     []string{"SWARM_RECORD_REQUEST_ID"},
+				Usage:       "Record IDs of insert requests to BigQuery (write stream name, trace ID of the writer and offset if available) in ingest logs of metadata",
+				Destination: &recordRequestID,
+			},
+			&cli.BoolFlag{
+				Name:        "load-labels",
+				EnvVars:     []string{"SWARM_LOAD_LABELS"},
+				Usage:       "Set ID and time of the last successful load as labels (swarm_last_load_id, swarm_last_load_at) of destination tables",
+				Destination: &loadLabels,
+			},
+			&cli.BoolFlag{
+				Name:        "retry-unknown-field",
+				EnvVars:     []string{"SWARM_RETRY_UNKNOWN_FIELD"},
+				Usage:       "Widen table schema and retry insert once if BigQuery rejects rows by unknown field",
+				Destination: &retryUnknownField,
+			},
+			&cli.BoolFlag{
+				Name:        "insert-error-table",
+				EnvVars:     []string{"SWARM_INSERT_ERROR_TABLE"},
+				Usage:       "Write rows rejected by BigQuery into <table>_errors table with the reason and insert the other rows of the chunk, instead of failing the chunk. The rows can be replayed by replay-dead-letter",
+				Destination: &insertErrorTable,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-invalid-rows",
+				EnvVars:     []string{"SWARM_SKIP_INVALID_ROWS"},
+				Usage:       "Insert valid rows and drop invalid rows of a chunk instead of failing the chunk. Dropped rows are lost with only warning logs",
+				Destination: &skipInvalidRows,
+			},
+			&cli.BoolFlag{
+				Name:        "ignore-unknown-values",
+				EnvVars:     []string{"SWARM_IGNORE_UNKNOWN_VALUES"},
+				Usage:       "Drop values of fields not in the table schema instead of rejecting rows. Dropped values are lost and do not widen the table schema",
+				Destination: &ignoreUnknownValues,
+			},
+			&cli.StringSliceFlag{
+				Name:        "enrich",
+				EnvVars:     []string{"SWARM_ENRICH"},
+				Usage:       "Add columns of a BigQuery dimension table to records of which value at the field matches the key column (field=dataset.table.key). Can be specified multiple times",
+				Destination: &enrichments,
+			},
+			&cli.DurationFlag{
+				Name:        "enrich-refresh",
+				EnvVars:     []string{"SWARM_ENRICH_REFRESH"},
+				Usage:       "Interval to reload dimension tables of --enrich. 0 means loading them only at startup",
+				Destination: &enrichRefresh,
+				Value:       10 * time.Minute,
+			},
+			&cli.StringSliceFlag{
+				Name:        "fixed-schema",
+				EnvVars:     []string{"SWARM_FIXED_SCHEMA"},
+				Usage:       "Use BigQuery schema JSON file for the table instead of inferring it (dataset.table=path). Can be specified multiple times",
+				Destination: &fixedSchemas,
+			},
+			&cli.StringFlag{
+				Name:        "fixed-schema-mode",
+				EnvVars:     []string{"SWARM_FIXED_SCHEMA_MODE"},
+				Usage:       "Handling of records having fields not declared in fixed schema or values not matching column types: strict (reject ingestion) or loose (remove the fields and convert the values)",
+				Value:       string(types.FixedSchemaStrict),
+				Destination: &fixedSchemaMode,
+			},
 			&cli.StringFlag{
 				Name:        "memory-limit",
 				EnvVars:     []string{"SWARM_MEMORY_LIMIT"},
 				Usage:       "Memory limit for each process. If it exceeds the limit, the process return 429 too many requests error. (e.g. 1GiB)",
 				Destination: &memoryLimit,
 			},
-		}, bq.Flags(), policy.Flags(), metadata.Flags(), sentry.Flags()),
+			&cli.IntFlag{
+				Name:        "max-concurrent-requests",
+				EnvVars:     []string{"SWARM_MAX_CONCURRENT_REQUESTS"},
+				Usage:       "Max number of Pub/Sub push requests processed concurrently. Exceeding requests get 429 too many requests error to make Pub/Sub back off. 0 means no limit",
+				Destination: &maxRequests,
+			},
+			&cli.StringFlag{
+				Name:        "stats-addr",
+				EnvVars:     []string{"SWARM_STATS_ADDR"},
+				Usage:       "Address to expose metrics, such as ingestion results of each table, in Prometheus format at /stats (e.g. localhost:9090). Disabled if empty",
+				Destination: &statsAddr,
+			},
+			&cli.StringFlag{
+				Name:        "metrics-exporter",
+				EnvVars:     []string{"SWARM_METRICS_EXPORTER"},
+				Usage:       "Exporter of metrics: prometheus (exposed by --stats-addr) or otlp (sent to OTLP/HTTP endpoint configured by OTEL_EXPORTER_OTLP_* environment variables)",
+				Value:       metricsExporterPrometheus,
+				Destination: &metricsExporter,
+			},
+		}, bq.Flags(), policy.Flags(), metadata.Flags(), sentry.Flags(), deadLetter.Flags(), lineage.Flags()),
 		Action: func(c *cli.Context) error {
 			ctx := c.Context
 
@@ -115,16 +401,57 @@ func serveCommand() *cli.Command {
 					"read-concurrency", readConcurrency,
 					"ingest-table-concurrency", ingestTableConcurrency,
 					"ingest-record-concurrency", ingestRecordConcurrency,
+					"max-concurrent-inserts", maxConcurrentInserts,
+					"schema-sample-head", schemaSampleHead,
+					"schema-sample-random", schemaSampleRandom,
+					"timestamp-fallback", timestampFallback,
+					"ingest-time-fallback", ingestTimeFallback,
+					"default-partition", defaultPartition,
+					"lowercase-dest", lowerCaseDest,
+					"policy-batch-size", policyBatchSize,
+					"fail-on-missing", failOnMissing,
+					"skip-unroutable", skipUnroutable,
+					"log-object-version", logObjectVersion,
+					"verify-checksum", verifyChecksum,
+					"min-object-size", minObjectSize,
+					"max-object-size", maxObjectSize,
 					"state-timeout", stateTimeout.String(),
 					"state-ttl", stateTTL.String(),
+					"ingest-timeout", ingestTimeout.String(),
+					"dedup-window", dedupWindow.String(),
+					"insert-tracking-ttl", insertTrackingTTL.String(),
+					"force", force,
 					"firestore-project-id", firestoreProject,
 					"firestore-database-id", firestoreDatabase,
 					"memory-limit", memoryLimit,
+					"max-concurrent-requests", maxRequests,
+					"stats-addr", statsAddr,
+					"metrics-exporter", metricsExporter,
+					"schema-sidecar-bucket", schemaSidecarBucket,
+					"schema-sidecar-prefix", schemaSidecarPrefix,
+					"schema-registry-dataset", schemaRegistryDataset,
+					"schema-registry-table", schemaRegistryTable,
+					"schema-override", schemaOverrides.Value(),
+					"compress-metadata-key", compressMetadataKey,
+					"schema-pin", schemaPin,
+					"sort-by-timestamp", sortByTimestamp,
+					"load-labels", loadLabels,
+					"record-request-id", recordRequestID,
+					"retry-unknown-field", retryUnknownField,
+					"insert-error-table", insertErrorTable,
+					"skip-invalid-rows", skipInvalidRows,
+					"ignore-unknown-values", ignoreUnknownValues,
+					"enrich", enrichments.Value(),
+					"enrich-refresh", enrichRefresh.String(),
+					"fixed-schema", fixedSchemas.Value(),
+					"fixed-schema-mode", fixedSchemaMode,
 
 					"bigquery", &bq,
 					"policy", &policy,
 					"metadata", &metadata,
 					"sentry", &sentry,
+					"dead-letter", &deadLetter,
+					"lineage", &lineage,
 				),
 			)
 
@@ -167,8 +494,14 @@ func serveCommand() *cli.Command {
 			ucOptions := []usecase.Option{
 				usecase.WithIngestTableConcurrency(ingestTableConcurrency),
 				usecase.WithIngestRecordConcurrency(ingestRecordConcurrency),
+				usecase.WithMaxConcurrentInserts(maxConcurrentInserts),
+				usecase.WithFailOnMissing(failOnMissing),
+				usecase.WithSkipUnroutable(skipUnroutable),
 				usecase.WithStateTimeout(stateTimeout),
 				usecase.WithStateTTL(stateTTL),
+				usecase.WithIngestTimeout(ingestTimeout),
+				usecase.WithDedupWindow(dedupWindow),
+				usecase.WithInsertTracking(insertTrackingTTL),
 			}
 
 			if meta, err := metadata.Configure(); err != nil {
@@ -176,12 +509,149 @@ func serveCommand() *cli.Command {
 			} else if meta != nil {
 				ucOptions = append(ucOptions, usecase.WithMetadata(meta))
 			}
+			if sinks := metadata.Sinks(csClient); len(sinks) > 0 {
+				ucOptions = append(ucOptions, usecase.WithLoadLogSink(sinks...))
+			}
+			ucOptions = append(ucOptions, usecase.WithMetadataStrict(metadata.Strict()))
+
+			if schemaSampleHead > 0 || schemaSampleRandom > 0 {
+				ucOptions = append(ucOptions, usecase.WithSchemaSample(schemaSampleHead, schemaSampleRandom))
+			}
+
+			if timestampFallback {
+				ucOptions = append(ucOptions, usecase.WithObjectTimeFallback())
+			}
+			if ingestTimeFallback {
+				ucOptions = append(ucOptions, usecase.WithIngestTimeFallback())
+			}
+			if logObjectVersion {
+				ucOptions = append(ucOptions, usecase.WithObjectVersion())
+			}
+			if verifyChecksum {
+				ucOptions = append(ucOptions, usecase.WithVerifyChecksum())
+			}
+
+			if defaultPartition != "" {
+				pt, err := parseDefaultPartition(defaultPartition)
+				if err != nil {
+					return err
+				}
+				ucOptions = append(ucOptions, usecase.WithDefaultPartition(pt))
+			}
+
+			if dlClient, err := deadLetter.Configure(ctx); err != nil {
+				return err
+			} else if dlClient != nil {
+				ucOptions = append(ucOptions, usecase.WithDeadLetterPubSub(dlClient))
+			}
+
+			if lineageClient, err := lineage.Configure(ctx, bq.ProjectID()); err != nil {
+				return err
+			} else if lineageClient != nil {
+				ucOptions = append(ucOptions, usecase.WithLineageSink(lineageClient))
+			}
+
+			if lowerCaseDest {
+				ucOptions = append(ucOptions, usecase.WithLowerCaseDest())
+			}
+
+			if policyBatchSize > 1 {
+				ucOptions = append(ucOptions, usecase.WithPolicyBatchSize(policyBatchSize))
+			}
+
+			if minObjectSize != "" {
+				threshold, err := humanize.ParseBytes(minObjectSize)
+				if err != nil {
+					return goerr.Wrap(err, "invalid min object size option")
+				}
+				ucOptions = append(ucOptions, usecase.WithMinObjectSize(int64(threshold)))
+			}
+
+			if maxObjectSize != "" {
+				limit, err := humanize.ParseBytes(maxObjectSize)
+				if err != nil {
+					return goerr.Wrap(err, "invalid max object size option")
+				}
+				ucOptions = append(ucOptions, usecase.WithMaxObjectSize(int64(limit)))
+			}
+
+			if schemaSidecarBucket != "" {
+				ucOptions = append(ucOptions, usecase.WithSchemaSidecar(types.CSBucket(schemaSidecarBucket), schemaSidecarPrefix))
+			}
+			if schemaRegistryDataset != "" || schemaRegistryTable != "" {
+				if schemaRegistryDataset == "" || schemaRegistryTable == "" {
+					return goerr.Wrap(types.ErrInvalidOption, "--schema-registry-dataset and --schema-registry-table must be specified together")
+				}
+				ucOptions = append(ucOptions, usecase.WithSchemaRegistry(types.BQDatasetID(schemaRegistryDataset), types.BQTableID(schemaRegistryTable)))
+			}
+			if len(schemaOverrides.Value()) > 0 {
+				var allowed []types.ObjectSchema
+				for _, schema := range schemaOverrides.Value() {
+					allowed = append(allowed, types.ObjectSchema(schema))
+				}
+				ucOptions = append(ucOptions, usecase.WithSchemaOverride(allowed...))
+			}
+			if compressMetadataKey != "" {
+				ucOptions = append(ucOptions, usecase.WithCompressMetadataKey(compressMetadataKey))
+			}
+			if schemaPin {
+				if schemaSidecarBucket == "" {
+					return goerr.Wrap(types.ErrInvalidOption, "--schema-pin requires --schema-sidecar-bucket")
+				}
+				ucOptions = append(ucOptions, usecase.WithSchemaPin())
+			}
+			if sortByTimestamp {
+				ucOptions = append(ucOptions, usecase.WithSortByTimestamp())
+			}
+			if loadLabels {
+				ucOptions = append(ucOptions, usecase.WithLoadLabels())
+			}
+			if recordRequestID {
+				ucOptions = append(ucOptions, usecase.WithIngestRequestID())
+			}
+			if retryUnknownField {
+				ucOptions = append(ucOptions, usecase.WithRetryUnknownField())
+			}
+			if insertErrorTable {
+				ucOptions = append(ucOptions, usecase.WithInsertErrorTable())
+			}
+			if skipInvalidRows {
+				ucOptions = append(ucOptions, usecase.WithSkipInvalidRows())
+			}
+			if ignoreUnknownValues {
+				ucOptions = append(ucOptions, usecase.WithIgnoreUnknownValues())
+			}
+			fixedSchemaOptions, err := parseFixedSchemas(fixedSchemas.Value(), fixedSchemaMode)
+			if err != nil {
+				return err
+			}
+			ucOptions = append(ucOptions, fixedSchemaOptions...)
+			enrichOptions, err := parseEnrichments(enrichments.Value(), enrichRefresh)
+			if err != nil {
+				return err
+			}
+			ucOptions = append(ucOptions, enrichOptions...)
 
 			if readConcurrency > 0 {
 				ucOptions = append(ucOptions, usecase.WithReadObjectConcurrency(readConcurrency))
 			}
+			if force {
+				utils.Logger().Warn("force mode, state checks are bypassed")
+				ucOptions = append(ucOptions, usecase.WithForce())
+			}
+			metricsOption, shutdownMetrics, err := configureMetrics(c.Context, metricsExporter, statsAddr)
+			if err != nil {
+				return err
+			}
+			defer shutdownMetrics()
+			if metricsOption != nil {
+				ucOptions = append(ucOptions, metricsOption)
+			}
 
 			uc := usecase.New(infra.New(infraOptions...), ucOptions...)
+			if err := uc.LoadDimensions(c.Context); err != nil {
+				return err
+			}
 
 			var serverOptions []server.Option
 			if memoryLimit != "" {
@@ -191,6 +661,9 @@ func serveCommand() *cli.Command {
 				}
 				serverOptions = append(serverOptions, server.WithMemoryLimit(limit))
 			}
+			if maxRequests > 0 {
+				serverOptions = append(serverOptions, server.WithMaxConcurrency(maxRequests))
+			}
 
 			srv := server.New(uc, serverOptions...)
 
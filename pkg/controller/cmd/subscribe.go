@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/firestore"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func subscribeCommand() *cli.Command {
+	var (
+		projectID      types.GoogleProjectID
+		subscriptionID types.PubSubSubscriptionID
+		concurrency    int
+		force          bool
+
+		bq       config.BigQuery
+		policy   config.Policy
+		metadata config.Metadata
+
+		firestoreProject  string
+		firestoreDatabase string
+	)
+
+	return &cli.Command{
+		Name:  "subscribe",
+		Usage: "Pull Cloud Storage notifications from Pub/Sub subscription and ingest notified objects",
+		Flags: mergeFlags([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "subscription-project-id",
+				EnvVars:     []string{"SWARM_SUBSCRIPTION_PROJECT_ID"},
+				Usage:       "Google Cloud Project ID of Pub/Sub subscription",
+				Destination: (*string)(&projectID),
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "subscription-id",
+				EnvVars:     []string{"SWARM_SUBSCRIPTION_ID"},
+				Usage:       "Pub/Sub subscription ID to pull Cloud Storage notifications",
+				Destination: (*string)(&subscriptionID),
+				Required:    true,
+			},
+			&cli.IntFlag{
+				Name:        "concurrency",
+				EnvVars:     []string{"SWARM_SUBSCRIPTION_CONCURRENCY"},
+				Usage:       "Max number of messages handled at the same time",
+				Destination: &concurrency,
+				Value:       8,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				EnvVars:     []string{"SWARM_FORCE"},
+				Usage:       "Re-ingest data bypassing state checks even if the message has been already processed or is being processed by other process",
+				Destination: &force,
+			},
+			&cli.StringFlag{
+				Name:        "firestore-project-id",
+				EnvVars:     []string{"SWARM_FIRESTORE_PROJECT_ID"},
+				Usage:       "Project ID of Firestore (To manage state)",
+				Destination: &firestoreProject,
+			},
+			&cli.StringFlag{
+				Name:        "firestore-database-id",
+				EnvVars:     []string{"SWARM_FIRESTORE_DATABASE_ID"},
+				Usage:       "Database ID of Firestore (To manage state)",
+				Destination: &firestoreDatabase,
+			},
+		}, bq.Flags(), policy.Flags(), metadata.Flags()),
+		Action: func(c *cli.Context) error {
+			ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			utils.Logger().Info("starting subscriber",
+				slog.Group("config",
+					"subscription-project-id", projectID,
+					"subscription-id", subscriptionID,
+					"concurrency", concurrency,
+					"force", force,
+					"firestore-project-id", firestoreProject,
+					"firestore-database-id", firestoreDatabase,
+
+					"bigquery", &bq,
+					"policy", &policy,
+					"metadata", &metadata,
+				),
+			)
+
+			var infraOptions []infra.Option
+
+			policyClient, err := policy.Configure()
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure policy client")
+			}
+			infraOptions = append(infraOptions, infra.WithPolicy(policyClient))
+
+			bqClient, err := bq.Configure(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure BigQuery client")
+			}
+			infraOptions = append(infraOptions, infra.WithBigQuery(bqClient))
+
+			csClient, err := cs.New(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure CloudStorage client")
+			}
+			infraOptions = append(infraOptions, infra.WithCloudStorage(csClient))
+
+			sub, err := pubsub.NewSubscription(ctx, projectID, subscriptionID, concurrency)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure Pub/Sub subscription")
+			}
+			infraOptions = append(infraOptions, infra.WithPubSubSubscription(sub))
+
+			if firestoreProject != "" && firestoreDatabase != "" {
+				dbClient, err := firestore.New(ctx, firestoreProject, firestoreDatabase)
+				if err != nil {
+					return goerr.Wrap(err, "failed to configure Firestore client")
+				}
+				infraOptions = append(infraOptions, infra.WithDatabase(dbClient))
+			} else if firestoreProject != "" || firestoreDatabase != "" {
+				return goerr.New("both firestore-project-id and firestore-database-id are required")
+			}
+
+			var ucOptions []usecase.Option
+			if meta, err := metadata.Configure(); err != nil {
+				return goerr.Wrap(err, "failed to configure metadata")
+			} else if meta != nil {
+				ucOptions = append(ucOptions, usecase.WithMetadata(meta))
+			}
+			if sinks := metadata.Sinks(csClient); len(sinks) > 0 {
+				ucOptions = append(ucOptions, usecase.WithLoadLogSink(sinks...))
+			}
+			ucOptions = append(ucOptions, usecase.WithMetadataStrict(metadata.Strict()))
+			if force {
+				utils.Logger().Warn("force mode, state checks are bypassed")
+				ucOptions = append(ucOptions, usecase.WithForce())
+			}
+
+			uc := usecase.New(infra.New(infraOptions...), ucOptions...)
+			if err := uc.Subscribe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+
+			utils.Logger().Info("subscriber stopped")
+			return nil
+		},
+	}
+}
@@ -1,6 +1,22 @@
 package cmd
 
-import "github.com/urfave/cli/v2"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/metrics"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
 
 func mergeFlags(flags ...[]cli.Flag) []cli.Flag {
 	var merged []cli.Flag
@@ -9,3 +25,121 @@ func mergeFlags(flags ...[]cli.Flag) []cli.Flag {
 	}
 	return merged
 }
+
+// parseDefaultPartition validates value of --default-partition option. Empty string means no default partition.
+func parseDefaultPartition(v string) (types.BQPartition, error) {
+	pt := types.BQPartition(v)
+	if pt != types.BQPartitionNone && pt.Type() == "" {
+		return "", goerr.Wrap(types.ErrInvalidOption, "invalid default-partition").With("partition", v)
+	}
+	return pt, nil
+}
+
+// parseFixedSchemas builds WithFixedSchema options from values of --fixed-schema option in "dataset.table=path" format. path is a local file of BigQuery schema JSON.
+func parseFixedSchemas(specs []string, mode string) ([]usecase.Option, error) {
+	fixedMode := types.FixedSchemaMode(mode)
+	if fixedMode != types.FixedSchemaStrict && fixedMode != types.FixedSchemaLoose {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "fixed-schema-mode must be strict or loose").With("mode", mode)
+	}
+
+	var options []usecase.Option
+	for _, spec := range specs {
+		dst, path, ok := strings.Cut(spec, "=")
+		dataset, table, found := strings.Cut(dst, ".")
+		if !ok || !found || dataset == "" || table == "" || path == "" {
+			return nil, goerr.Wrap(types.ErrInvalidOption, "fixed-schema must be dataset.table=path").With("fixed-schema", spec)
+		}
+
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to open fixed schema file").With("path", path)
+		}
+		schema, err := usecase.ReadFixedSchema(f)
+		f.Close()
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to read fixed schema file").With("path", path)
+		}
+
+		options = append(options, usecase.WithFixedSchema(types.BQDatasetID(dataset), types.BQTableID(table), schema, fixedMode))
+	}
+	return options, nil
+}
+
+// parseEnrichments parses enrichment specs in "field=dataset.table.key" format into usecase options. refresh is applied to all dimension tables.
+func parseEnrichments(specs []string, refresh time.Duration) ([]usecase.Option, error) {
+	var options []usecase.Option
+	for _, spec := range specs {
+		field, dim, ok := strings.Cut(spec, "=")
+		parts := strings.Split(dim, ".")
+		if !ok || field == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, goerr.Wrap(types.ErrInvalidOption, "enrich must be field=dataset.table.key").With("enrich", spec)
+		}
+
+		options = append(options, usecase.WithEnrichment(model.EnrichmentConfig{
+			Field:   field,
+			Dataset: types.BQDatasetID(parts[0]),
+			Table:   types.BQTableID(parts[1]),
+			Key:     parts[2],
+			Refresh: refresh,
+		}))
+	}
+	return options, nil
+}
+
+const (
+	metricsExporterPrometheus = "prometheus"
+	metricsExporterOTLP       = "otlp"
+)
+
+// configureMetrics returns usecase option to emit metrics by the exporter of --metrics-exporter option. prometheus exposes metrics at /stats of statsAddr, and is disabled if statsAddr is empty. otlp exports metrics to OTLP/HTTP endpoint configured by OTEL_EXPORTER_OTLP_* environment variables. The option is nil if metrics are disabled. The returned function stops the exporter, and must be called before exit.
+func configureMetrics(ctx context.Context, exporter, statsAddr string) (usecase.Option, func(), error) {
+	switch exporter {
+	case metricsExporterPrometheus, "":
+		if statsAddr == "" {
+			return nil, func() {}, nil
+		}
+		reg := metrics.New()
+		return usecase.WithMetrics(reg), startStatsServer(ctx, statsAddr, reg), nil
+
+	case metricsExporterOTLP:
+		emitter, shutdown, err := metrics.NewOTLP(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		utils.Logger().Info("exporting metrics by OTLP")
+		return usecase.WithMetricsEmitter(emitter), func() {
+			// Flush remaining metrics even if ctx is canceled by signal
+			if err := shutdown(context.WithoutCancel(ctx)); err != nil {
+				utils.HandleError(ctx, "failed to shutdown OTLP metric exporter", err)
+			}
+		}, nil
+
+	default:
+		return nil, nil, goerr.Wrap(types.ErrInvalidOption, "metrics-exporter must be prometheus or otlp").With("exporter", exporter)
+	}
+}
+
+// startStatsServer starts HTTP server to expose metrics at /stats in background. The returned function shuts down the server.
+func startStatsServer(ctx context.Context, addr string, reg *metrics.Registry) func() {
+	mux := http.NewServeMux()
+	mux.Handle("/stats", reg)
+
+	srv := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 3 * time.Second,
+		Handler:           mux,
+	}
+
+	go func() {
+		utils.Logger().Info("starting stats server", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.HandleError(ctx, "failed to run stats server", err)
+		}
+	}()
+
+	return func() {
+		if err := srv.Shutdown(ctx); err != nil {
+			utils.HandleError(ctx, "failed to shutdown stats server", err)
+		}
+	}
+}
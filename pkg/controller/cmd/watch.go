@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/controller/cmd/config"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/firestore"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func watchCommand() *cli.Command {
+	var (
+		interval    time.Duration
+		concurrency int
+
+		bq       config.BigQuery
+		policy   config.Policy
+		metadata config.Metadata
+
+		firestoreProject  string
+		firestoreDatabase string
+	)
+
+	return &cli.Command{
+		Name:      "watch",
+		Usage:     "Poll Cloud Storage prefix and ingest newly appeared objects",
+		ArgsUsage: "[prefix URL]",
+		Flags: mergeFlags([]cli.Flag{
+			&cli.DurationFlag{
+				Name:        "interval",
+				EnvVars:     []string{"SWARM_WATCH_INTERVAL"},
+				Usage:       "Interval to poll the prefix",
+				Destination: &interval,
+				Value:       time.Minute,
+			},
+			&cli.IntFlag{
+				Name:        "concurrency",
+				EnvVars:     []string{"SWARM_WATCH_CONCURRENCY"},
+				Usage:       "Max number of objects ingested at the same time",
+				Destination: &concurrency,
+				Value:       8,
+			},
+			&cli.StringFlag{
+				Name:        "firestore-project-id",
+				EnvVars:     []string{"SWARM_FIRESTORE_PROJECT_ID"},
+				Usage:       "Project ID of Firestore (To save watermark). If not set, watermark is kept only in memory",
+				Destination: &firestoreProject,
+			},
+			&cli.StringFlag{
+				Name:        "firestore-database-id",
+				EnvVars:     []string{"SWARM_FIRESTORE_DATABASE_ID"},
+				Usage:       "Database ID of Firestore (To save watermark)",
+				Destination: &firestoreDatabase,
+			},
+		}, bq.Flags(), policy.Flags(), metadata.Flags()),
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 1 {
+				return goerr.Wrap(types.ErrInvalidOption, "one prefix URL is required (e.g. gs://bucket/logs/)")
+			}
+			req := &model.WatchRequest{
+				URL:         types.ObjectURL(c.Args().First()),
+				Interval:    interval,
+				Concurrency: concurrency,
+			}
+
+			ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			utils.Logger().Info("starting watcher",
+				slog.Group("config",
+					"url", req.URL,
+					"interval", interval.String(),
+					"concurrency", concurrency,
+					"firestore-project-id", firestoreProject,
+					"firestore-database-id", firestoreDatabase,
+
+					"bigquery", &bq,
+					"policy", &policy,
+					"metadata", &metadata,
+				),
+			)
+
+			var infraOptions []infra.Option
+
+			policyClient, err := policy.Configure()
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure policy client")
+			}
+			infraOptions = append(infraOptions, infra.WithPolicy(policyClient))
+
+			bqClient, err := bq.Configure(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure BigQuery client")
+			}
+			infraOptions = append(infraOptions, infra.WithBigQuery(bqClient))
+
+			csClient, err := cs.New(ctx)
+			if err != nil {
+				return goerr.Wrap(err, "failed to configure CloudStorage client")
+			}
+			infraOptions = append(infraOptions, infra.WithCloudStorage(csClient))
+
+			if firestoreProject != "" && firestoreDatabase != "" {
+				dbClient, err := firestore.New(ctx, firestoreProject, firestoreDatabase)
+				if err != nil {
+					return goerr.Wrap(err, "failed to configure Firestore client")
+				}
+				infraOptions = append(infraOptions, infra.WithDatabase(dbClient))
+			} else if firestoreProject != "" || firestoreDatabase != "" {
+				return goerr.New("both firestore-project-id and firestore-database-id are required")
+			} else {
+				utils.Logger().Warn("Firestore is not configured, watermark is kept only in memory")
+			}
+
+			var ucOptions []usecase.Option
+			if meta, err := metadata.Configure(); err != nil {
+				return goerr.Wrap(err, "failed to configure metadata")
+			} else if meta != nil {
+				ucOptions = append(ucOptions, usecase.WithMetadata(meta))
+			}
+			if sinks := metadata.Sinks(csClient); len(sinks) > 0 {
+				ucOptions = append(ucOptions, usecase.WithLoadLogSink(sinks...))
+			}
+			ucOptions = append(ucOptions, usecase.WithMetadataStrict(metadata.Strict()))
+
+			uc := usecase.New(infra.New(infraOptions...), ucOptions...)
+			if err := uc.Watch(ctx, req); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+
+			utils.Logger().Info("watcher stopped")
+			return nil
+		},
+	}
+}
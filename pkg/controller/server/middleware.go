@@ -106,3 +106,23 @@ func MemoryLimit(limit uint64, read ReadMemStatsFn) func(next http.Handler) http
 		})
 	}
 }
+
+// ConcurrencyLimit is a middleware to bound number of requests in process. If limit requests are already in process, it returns 429 too many requests immediately without waiting, then Pub/Sub push subscription backs off and redelivers the message.
+func ConcurrencyLimit(limit int) func(next http.Handler) http.Handler {
+	sem := make(chan struct{}, limit)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			default:
+				utils.CtxLogger(r.Context()).Warn("Concurrency limit exceeded", "limit", limit)
+				http.Error(w, "Concurrency limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -79,3 +79,49 @@ func TestMemoryLimit(t *testing.T) {
 		gt.Equal(t, w.Code, http.StatusTooManyRequests)
 	})
 }
+
+func TestConcurrencyLimit(t *testing.T) {
+	const limit = 2
+	started := make(chan struct{}, limit)
+	release := make(chan struct{})
+	mock := &usecase.Mock{
+		MockObjectToSources: func(ctx context.Context, obj model.Object) ([]*model.Source, error) {
+			return []*model.Source{{}}, nil
+		},
+		MockLoadData: func(ctx context.Context, req []*model.LoadRequest) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		},
+	}
+	srv := server.New(mock, server.WithMaxConcurrency(limit))
+
+	send := func() int {
+		r := httptest.NewRequest("POST", "/event/pubsub/cs", bytes.NewReader(pubsubBody))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Fill all slots with requests blocked in Load
+	codes := make(chan int, limit)
+	for i := 0; i < limit; i++ {
+		go func() { codes <- send() }()
+	}
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+
+	// Exceeding requests are rejected without calling Load
+	for i := 0; i < 3; i++ {
+		gt.Equal(t, send(), http.StatusTooManyRequests)
+	}
+
+	close(release)
+	for i := 0; i < limit; i++ {
+		gt.Equal(t, <-codes, http.StatusOK)
+	}
+
+	// Slots are released after the requests finished
+	gt.Equal(t, send(), http.StatusOK)
+}
@@ -22,8 +22,9 @@ type Server struct {
 }
 
 type serverCfg struct {
-	memoryLimit uint64
-	readMem     ReadMemStatsFn
+	memoryLimit    uint64
+	readMem        ReadMemStatsFn
+	maxConcurrency int
 }
 
 type requestHandler func(uc interfaces.UseCase, r *http.Request) error
@@ -42,6 +43,13 @@ func WithReadMemStats(fn ReadMemStatsFn) Option {
 	}
 }
 
+// WithMaxConcurrency bounds number of event requests processed concurrently. Requests exceeding the limit are rejected with 429 too many requests. 0 means no limit.
+func WithMaxConcurrency(limit int) Option {
+	return func(cfg *serverCfg) {
+		cfg.maxConcurrency = limit
+	}
+}
+
 func New(uc interfaces.UseCase, options ...Option) *Server {
 	cfg := &serverCfg{
 		memoryLimit: 0,
@@ -75,6 +83,14 @@ func New(uc interfaces.UseCase, options ...Option) *Server {
 					return
 				}
 
+				// Acknowledge the message not to be redelivered if the error is not worth retry
+				if decision := uc.ClassifyError(err); decision != types.RetryDecisionRetry {
+					utils.HandleError(r.Context(), "failed handle event, not retried", goerr.Wrap(err).With("decision", decision))
+					w.WriteHeader(http.StatusOK)
+					utils.SafeWrite(w, []byte("OK"))
+					return
+				}
+
 				utils.HandleError(r.Context(), "failed handle event", err)
 				http.Error(w, err.Error(), http.StatusBadRequest)
 
@@ -90,6 +106,9 @@ func New(uc interfaces.UseCase, options ...Option) *Server {
 		if cfg.memoryLimit > 0 {
 			r.Use(MemoryLimit(cfg.memoryLimit, cfg.readMem))
 		}
+		if cfg.maxConcurrency > 0 {
+			r.Use(ConcurrencyLimit(cfg.maxConcurrency))
+		}
 
 		r.Route("/pubsub", func(r chi.Router) {
 			r.Post("/cs", api(handlePubSubMessage(handleCloudStorageEvent)))
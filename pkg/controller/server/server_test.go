@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/gt"
 	"github.com/m-mizutani/swarm/pkg/controller/server"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
@@ -248,3 +249,62 @@ func TestPubSubMessageBlock(t *testing.T) {
 		})
 	}
 }
+
+func TestPubSubErrorClassifier(t *testing.T) {
+	testCases := map[string]struct {
+		loadError  error
+		classify   func(err error) types.RetryDecision
+		expectCode int
+	}{
+		"retryable error is nacked": {
+			loadError:  errors.New("service unavailable"),
+			expectCode: http.StatusBadRequest,
+		},
+		"custom classifier forces retryable error to dead letter": {
+			loadError: errors.New("service unavailable"),
+			classify: func(err error) types.RetryDecision {
+				return types.RetryDecisionDeadLetter
+			},
+			expectCode: http.StatusOK,
+		},
+		"invalid policy result is acked by default": {
+			loadError:  goerr.Wrap(types.ErrInvalidPolicyResult, "log.table is required"),
+			expectCode: http.StatusOK,
+		},
+		"dropped error is acked": {
+			loadError: errors.New("service unavailable"),
+			classify: func(err error) types.RetryDecision {
+				return types.RetryDecisionDrop
+			},
+			expectCode: http.StatusOK,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			var updated types.MsgState
+			mock := &usecase.Mock{
+				MockObjectToSources: func(ctx context.Context, obj model.Object) ([]*model.Source, error) {
+					return []*model.Source{{}}, nil
+				},
+				MockLoadData: func(ctx context.Context, req []*model.LoadRequest) error {
+					return tc.loadError
+				},
+				MockUpdateState: func(ctx context.Context, msgType types.MsgType, id string, state types.MsgState) error {
+					updated = state
+					return nil
+				},
+				MockClassifyError: tc.classify,
+			}
+
+			srv := server.New(mock)
+			r := httptest.NewRequest("POST", "/event/pubsub/cs", bytes.NewReader(pubsubBody))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			gt.Equal(t, w.Code, tc.expectCode)
+			// The message is recorded as failed even if it is acked
+			gt.Equal(t, updated, types.MsgFailed)
+		})
+	}
+}
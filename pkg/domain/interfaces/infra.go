@@ -16,23 +16,37 @@ type BigQueryIterator interface {
 }
 
 type BigQuery interface {
-	Query(ctx context.Context, query string) (BigQueryIterator, error)
+	// Query runs query with named parameters, e.g. @start in query for bigquery.QueryParameter{Name: "start"}.
+	Query(ctx context.Context, query string, params ...bigquery.QueryParameter) (BigQueryIterator, error)
 	NewStream(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, schema bigquery.Schema) (BigQueryStream, error)
 
 	GetMetadata(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) (*bigquery.TableMetadata, error)
 	UpdateTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md bigquery.TableMetadataToUpdate, eTag string) error
 	CreateTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error
+	ListTables(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error)
+	DeleteTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error
 }
 
 type BigQueryStream interface {
-	Insert(ctx context.Context, data []any) error
+	// Insert inserts data with opts and returns ID of the insert request to BigQuery API. The ID is returned also with error if the request has been issued.
+	Insert(ctx context.Context, data []any, opts model.InsertOptions) (types.BQRequestID, error)
 	Close() error
 }
 
 type PubSub interface {
-	Publish(ctx context.Context, data []byte) (types.PubSubMessageID, error)
+	// Publish sends data with attributes to the topic. attrs can be nil.
+	Publish(ctx context.Context, data []byte, attrs map[string]string) (types.PubSubMessageID, error)
 }
 
+// PubSubSubscription receives messages from Pub/Sub subscription by pull.
+type PubSubSubscription interface {
+	// Receive calls handler for each message until ctx is canceled. The message is acked if handler returns nil, otherwise nacked to be redelivered.
+	Receive(ctx context.Context, handler PubSubHandler) error
+}
+
+// PubSubHandler handles a message of data with attributes. attrs can be nil.
+type PubSubHandler func(ctx context.Context, msgID types.PubSubMessageID, data []byte, attrs map[string]string) error
+
 type CSObjectIterator interface {
 	Next() (*storage.ObjectAttrs, error)
 }
@@ -41,10 +55,38 @@ type CloudStorage interface {
 	Open(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error)
 	Attrs(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error)
 	List(ctx context.Context, bucket types.CSBucket, query *storage.Query) CSObjectIterator
+	NewWriter(ctx context.Context, obj model.CloudStorageObject) io.WriteCloser
 }
 
 type Database interface {
 	GetOrCreateState(ctx context.Context, msgType types.MsgType, input *model.State) (*model.State, bool, error)
 	GetState(ctx context.Context, msgType types.MsgType, id string) (*model.State, error)
 	UpdateState(ctx context.Context, msgType types.MsgType, id string, state types.MsgState, now time.Time) error
+
+	// GetWatermark returns the watermark of id. It returns nil without error if the watermark is not found.
+	GetWatermark(ctx context.Context, id string) (*model.Watermark, error)
+	PutWatermark(ctx context.Context, wm *model.Watermark) error
+}
+
+// LoadLogSink is a destination of LoadLog that is recorded for each Load request.
+type LoadLogSink interface {
+	Write(ctx context.Context, log *model.LoadLog) error
+}
+
+// LineageSink is a destination of Lineage that is recorded for each ingestion into a table.
+type LineageSink interface {
+	Write(ctx context.Context, lineage *model.Lineage) error
+}
+
+// Metrics creates instruments to emit metrics. It is implemented by metrics.NewPrometheus exposing metrics.Registry in Prometheus text format, and metrics.NewOTel emitting via OpenTelemetry SDK.
+type Metrics interface {
+	// Counter returns a monotonically increasing metric with the name. labels are keys of label values given to MetricCounter.Add.
+	Counter(name, help string, labels ...string) MetricCounter
+	// GaugeFunc registers a gauge metric of which value is calculated by fn when metrics are collected.
+	GaugeFunc(name, help string, fn func() float64)
+}
+
+// MetricCounter is a counter created by Metrics. values must be given in the same order as labels of the counter.
+type MetricCounter interface {
+	Add(n int64, values ...string)
 }
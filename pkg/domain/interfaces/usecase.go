@@ -17,4 +17,7 @@ type UseCase interface {
 	GetOrCreateState(ctx context.Context, msgType types.MsgType, id string) (*model.State, bool, error)
 	UpdateState(ctx context.Context, msgType types.MsgType, id string, state types.MsgState) error
 	WaitState(ctx context.Context, msgType types.MsgType, id string, expiresAt time.Time) error
+
+	// ClassifyError decides whether a message failed by err should be retried, dead-lettered or dropped.
+	ClassifyError(err error) types.RetryDecision
 }
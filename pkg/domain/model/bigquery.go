@@ -1,6 +1,9 @@
 package model
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/m-mizutani/swarm/pkg/domain/types"
@@ -23,6 +26,27 @@ type SourceLog struct {
 	StartedAt  time.Time           `json:"started_at" bigquery:"started_at"`
 	FinishedAt time.Time           `json:"finished_at" bigquery:"finished_at"`
 	Success    bool                `json:"success" bigquery:"success"`
+
+	// DropCount is number of records dropped by Drop filters of the source. They are not counted in RowCount.
+	DropCount int `json:"drop_count" bigquery:"drop_count"`
+
+	// SampledOutCount is number of logs skipped by SampleRate of the source.
+	SampledOutCount int `json:"sampled_out_count" bigquery:"sampled_out_count"`
+
+	// Missing is true if the object does not exist and it is skipped.
+	Missing bool `json:"missing" bigquery:"missing"`
+
+	// Manifest is the manifest object that listed the object, if any.
+	Manifest *CloudStorageObject `json:"manifest" bigquery:"manifest"`
+
+	// Generation and CRC32C identify version of the object that is processed. CRC32C is base64 encoded big-endian checksum as same as Cloud Storage API. They are set only if recording object version is enabled.
+	Generation int64  `json:"generation" bigquery:"generation"`
+	CRC32C     string `json:"crc32c" bigquery:"crc32c"`
+
+	// Error is a message of error in importing the source.
+	Error string `json:"error" bigquery:"error"`
+	// PolicyError is set if schema policy evaluation failed, to know which rule and line failed.
+	PolicyError *PolicyError `json:"policy_error" bigquery:"policy_error"`
 }
 
 type IngestLog struct {
@@ -36,6 +60,9 @@ type IngestLog struct {
 	LogCount     int                `json:"log_count" bigquery:"log_count"`
 	Success      bool               `json:"success" bigquery:"success"`
 	Error        string             `json:"error" bigquery:"error"`
+
+	// RequestIDs are IDs of insert requests to BigQuery API of the ingestion, consisting of the write stream name, trace ID of the writer and offset if available. They are recorded only if enabled by option of UseCase.
+	RequestIDs []types.BQRequestID `json:"request_ids,omitempty" bigquery:"request_ids"`
 }
 
 type LoadLogRaw struct {
@@ -102,9 +129,27 @@ type LogRecord struct {
 	Timestamp  time.Time      `json:"timestamp" bigquery:"timestamp"`
 	IngestedAt time.Time      `json:"ingested_at" bigquery:"ingested_at"`
 	Data       any            `json:"data" bigquery:"data"`
+
+	// Fields is FieldSpec of Data declared by schema policy. Key is dot separated path in Data.
+	Fields map[string]FieldSpec `json:"-" bigquery:"-"`
+
+	// Description is table description declared by schema policy for the destination.
+	Description string `json:"-" bigquery:"-"`
+
+	// PartitionKey is a value of RangePartitionColumn if the destination has range partitioning. It is excluded from schema inference not to add the column to other tables.
+	PartitionKey *int64 `json:"partition_key,omitempty" bigquery:"-"`
 }
 
 func (x LogRecord) Raw() *LogRecordRaw {
+	// time.Time in Data (e.g. converted by FieldSpec) also needs to be int64 for Storage Write API
+	if data, ok := timeToMicro(x.Data); ok {
+		x.Data = data
+	}
+	// Values of declared numeric fields are validated in conversion of the fields. If encoding fails, the value is kept and BigQuery rejects the row as schema mismatch.
+	if data, err := encodeTypedFields(x.Data, x.Fields); err == nil {
+		x.Data = data
+	}
+
 	return &LogRecordRaw{
 		LogRecord:  x,
 		Timestamp:  x.Timestamp.UnixMicro(),
@@ -112,6 +157,54 @@ func (x LogRecord) Raw() *LogRecordRaw {
 	}
 }
 
+// timeToMicro returns copy of v that time.Time values are replaced with Unix time in microsecond. The second return value is false if v has no time.Time value, and v is not copied.
+func timeToMicro(v any) (any, bool) {
+	switch value := v.(type) {
+	case time.Time:
+		return value.UnixMicro(), true
+
+	case map[string]any:
+		var copied map[string]any
+		for key, elem := range value {
+			converted, ok := timeToMicro(elem)
+			if !ok {
+				continue
+			}
+			if copied == nil {
+				copied = make(map[string]any, len(value))
+				for k, e := range value {
+					copied[k] = e
+				}
+			}
+			copied[key] = converted
+		}
+		if copied == nil {
+			return v, false
+		}
+		return copied, true
+
+	case []any:
+		var copied []any
+		for i, elem := range value {
+			converted, ok := timeToMicro(elem)
+			if !ok {
+				continue
+			}
+			if copied == nil {
+				copied = make([]any, len(value))
+				copy(copied, value)
+			}
+			copied[i] = converted
+		}
+		if copied == nil {
+			return v, false
+		}
+		return copied, true
+	}
+
+	return v, false
+}
+
 // LogRecordRaw is replaced LogRecord with Timestamp from time.Time to int64. BigQuery Storage Write API requires converting data to protocol buffer. But adapt.StorageSchemaToProto2Descriptor is not supported for time.Time. It uses int64 for timestamp instead of time.Time. So, LogRecordRaw is used for only insertion by BigQuery Storage Write API.
 type LogRecordRaw struct {
 	LogRecord
@@ -126,3 +219,194 @@ func (x LogRecordSet) Merge(src LogRecordSet) {
 		x[srcKey] = append(x[srcKey], srcRecords...)
 	}
 }
+
+// Dests returns destinations of the set in deterministic order: by dataset, table and then other attributes of the destination.
+func (x LogRecordSet) Dests() []BigQueryDest {
+	dests := make([]BigQueryDest, 0, len(x))
+	for dst := range x {
+		dests = append(dests, dst)
+	}
+	sort.Slice(dests, func(i, j int) bool {
+		if dests[i].Dataset != dests[j].Dataset {
+			return dests[i].Dataset < dests[j].Dataset
+		}
+		if dests[i].Table != dests[j].Table {
+			return dests[i].Table < dests[j].Table
+		}
+		// Same table with different attributes is rare, but it is ordered for determinism
+		return fmt.Sprintf("%+v", dests[i]) < fmt.Sprintf("%+v", dests[j])
+	})
+	return dests
+}
+
+// TableDescription returns the first non-empty description of records. Description of the table is declared per log, then it is taken from records of the destination.
+func TableDescription(records []*LogRecord) string {
+	for _, record := range records {
+		if record.Description != "" {
+			return record.Description
+		}
+	}
+	return ""
+}
+
+// LogRecordConflicts is a set of log IDs that collide in the same destination, keyed by the destination.
+type LogRecordConflicts map[BigQueryDest][]types.LogID
+
+// MergeWithConflicts returns a new LogRecordSet that has records of a followed by records of b for each destination, and IDs of records in b that collide with records in a of the same destination. All records are kept as Merge does, and the caller decides how to handle the collisions, e.g. deduplication. A colliding ID is reported once even if it appears multiple times. a and b are not modified.
+func MergeWithConflicts(a, b LogRecordSet) (LogRecordSet, LogRecordConflicts) {
+	merged := LogRecordSet{}
+	conflicts := LogRecordConflicts{}
+
+	for dst, records := range a {
+		merged[dst] = append([]*LogRecord{}, records...)
+	}
+
+	for dst, records := range b {
+		if existing := a[dst]; len(existing) > 0 {
+			ids := make(map[types.LogID]struct{}, len(existing))
+			for _, record := range existing {
+				ids[record.ID] = struct{}{}
+			}
+
+			reported := map[types.LogID]struct{}{}
+			for _, record := range records {
+				if _, ok := ids[record.ID]; !ok {
+					continue
+				}
+				if _, ok := reported[record.ID]; ok {
+					continue
+				}
+				reported[record.ID] = struct{}{}
+				conflicts[dst] = append(conflicts[dst], record.ID)
+			}
+		}
+
+		merged[dst] = append(merged[dst], records...)
+	}
+
+	return merged, conflicts
+}
+
+// InsertOptions are options of BigQueryStream.Insert that correspond to options of insertAll API of BigQuery. Both options trade data quality for availability of ingestion, and the zero value rejects the whole request by any invalid row or unknown value.
+type InsertOptions struct {
+	// SkipInvalidRows inserts valid rows and drops invalid rows of the request instead of rejecting all rows. Dropped rows are only logged and not routed to the insert error table.
+	SkipInvalidRows bool
+	// IgnoreUnknownValues drops values of fields that are not in the table schema instead of rejecting the row. The dropped values are lost and the table schema is not widened by them.
+	IgnoreUnknownValues bool
+}
+
+// InsertRowErrorCode classifies why BigQuery rejects a row.
+type InsertRowErrorCode int
+
+const (
+	// InsertRowErrorUnspecified is a rejection without classification.
+	InsertRowErrorUnspecified InsertRowErrorCode = iota
+	// InsertRowErrorFields is a rejection by invalid values of fields, e.g. a value of wrong type.
+	InsertRowErrorFields
+	// InsertRowErrorUnknownField is a rejection because the table does not have a field of the row.
+	InsertRowErrorUnknownField
+)
+
+// InsertRowError is an error of a row rejected by BigQuery. Index is position of the row in the inserted data. Code classifies the rejection and Reason is the message for humans, which should not be parsed.
+type InsertRowError struct {
+	Index  int
+	Code   InsertRowErrorCode
+	Reason string
+}
+
+// InsertRowErrors is returned by BigQueryStream.Insert when some rows are rejected. All rows in the request are not inserted, including rows without error.
+type InsertRowErrors struct {
+	Errors []*InsertRowError
+}
+
+func (x *InsertRowErrors) Error() string {
+	reasons := make([]string, len(x.Errors))
+	for i, e := range x.Errors {
+		reasons[i] = fmt.Sprintf("row %d: %s", e.Index, e.Reason)
+	}
+	return "rows are rejected: " + strings.Join(reasons, ", ")
+}
+
+// Reasons returns map of row index and error reason.
+func (x *InsertRowErrors) Reasons() map[int]string {
+	resp := make(map[int]string, len(x.Errors))
+	for _, e := range x.Errors {
+		resp[e.Index] = e.Reason
+	}
+	return resp
+}
+
+// UnknownField returns true if any row is rejected because the table does not have a field of the row, e.g. the table schema has not been widened yet.
+func (x *InsertRowErrors) UnknownField() bool {
+	for _, e := range x.Errors {
+		if e.Code == InsertRowErrorUnknownField {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertErrorLog is a record of error table. It keeps a row rejected by BigQuery with the reason.
+type InsertErrorLog struct {
+	ID        types.LogID    `json:"id" bigquery:"id"`
+	IngestID  types.IngestID `json:"ingest_id" bigquery:"ingest_id"`
+	Timestamp time.Time      `json:"timestamp" bigquery:"timestamp"`
+	FailedAt  time.Time      `json:"failed_at" bigquery:"failed_at"`
+	Reason    string         `json:"reason" bigquery:"reason"`
+	Record    string         `json:"record" bigquery:"record"`
+}
+
+type InsertErrorLogRaw struct {
+	InsertErrorLog
+	Timestamp int64 `json:"timestamp" bigquery:"timestamp"`
+	FailedAt  int64 `json:"failed_at" bigquery:"failed_at"`
+}
+
+func (x *InsertErrorLog) Raw() *InsertErrorLogRaw {
+	return &InsertErrorLogRaw{
+		InsertErrorLog: *x,
+		Timestamp:      x.Timestamp.UnixMicro(),
+		FailedAt:       x.FailedAt.UnixMicro(),
+	}
+}
+
+// SchemaRegistryLog is a record of schema registry table. It is a version of table schema written when the schema is changed.
+type SchemaRegistryLog struct {
+	Dataset    types.BQDatasetID `json:"dataset" bigquery:"dataset"`
+	Table      types.BQTableID   `json:"table" bigquery:"table"`
+	SchemaJSON string            `json:"schema_json" bigquery:"schema_json"`
+	ChangedAt  time.Time         `json:"changed_at" bigquery:"changed_at"`
+	LoadID     types.RequestID   `json:"load_id" bigquery:"load_id"`
+}
+
+type SchemaRegistryLogRaw struct {
+	SchemaRegistryLog
+	ChangedAt int64 `json:"changed_at" bigquery:"changed_at"`
+}
+
+func (x *SchemaRegistryLog) Raw() *SchemaRegistryLogRaw {
+	return &SchemaRegistryLogRaw{
+		SchemaRegistryLog: *x,
+		ChangedAt:         x.ChangedAt.UnixMicro(),
+	}
+}
+
+// PartitionEstimate is a result of estimating number of time partitions of a table for a time range.
+type PartitionEstimate struct {
+	Partition types.BQPartition `json:"partition"`
+	Count     int               `json:"count"`
+	// Suggested is the finest partition granularity that keeps number of partitions within the limit. It is empty if Count is within the limit or no granularity can keep it.
+	Suggested types.BQPartition `json:"suggested,omitempty"`
+}
+
+// Lineage is a data flow from Cloud Storage objects to a BigQuery table by an ingestion. It is recorded for data governance.
+type Lineage struct {
+	RequestID  types.RequestID       `json:"request_id"`
+	IngestID   types.IngestID        `json:"ingest_id"`
+	Sources    []*CloudStorageObject `json:"sources"`
+	Dataset    types.BQDatasetID     `json:"dataset"`
+	Table      types.BQTableID       `json:"table"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at"`
+	Success    bool                  `json:"success"`
+}
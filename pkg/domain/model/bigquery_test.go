@@ -0,0 +1,48 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+func TestMergeWithConflicts(t *testing.T) {
+	dstA := model.BigQueryDest{Dataset: "my_dataset", Table: "a"}
+	dstB := model.BigQueryDest{Dataset: "my_dataset", Table: "b"}
+	dstC := model.BigQueryDest{Dataset: "my_dataset", Table: "c"}
+
+	a := model.LogRecordSet{
+		dstA: {{ID: "1"}, {ID: "2"}},
+		dstB: {{ID: "1"}},
+	}
+	b := model.LogRecordSet{
+		// "2" collides twice but reported once
+		dstA: {{ID: "2"}, {ID: "3"}, {ID: "2"}},
+		// Same ID in other destination is not a collision
+		dstC: {{ID: "1"}},
+	}
+
+	merged, conflicts := model.MergeWithConflicts(a, b)
+
+	ids := func(records []*model.LogRecord) []types.LogID {
+		var resp []types.LogID
+		for _, r := range records {
+			resp = append(resp, r.ID)
+		}
+		return resp
+	}
+	gt.Equal(t, ids(merged[dstA]), []types.LogID{"1", "2", "2", "3", "2"})
+	gt.Equal(t, ids(merged[dstB]), []types.LogID{"1"})
+	gt.Equal(t, ids(merged[dstC]), []types.LogID{"1"})
+
+	gt.Equal(t, conflicts, model.LogRecordConflicts{
+		dstA: {"2"},
+	})
+
+	// Inputs are not modified
+	gt.A(t, a[dstA]).Length(2)
+	gt.A(t, b[dstA]).Length(3)
+	gt.V(t, a[dstC]).Nil()
+}
@@ -1,14 +1,82 @@
 package model
 
-import "github.com/m-mizutani/swarm/pkg/domain/types"
+import (
+	"time"
+
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
 
 type MetadataConfig struct {
 	dataset types.BQDatasetID
 	table   types.BQTableID
+
+	partitionField string
+	partitionType  types.BQPartition
+	clustering     []string
+	expiration     time.Duration
+}
+
+const (
+	defaultMetadataPartitionField = "started_at"
+	defaultMetadataPartitionType  = types.BQPartitionMonth
+)
+
+type MetadataOption func(*MetadataConfig)
+
+// WithMetadataPartition specifies the time partitioning of LoadLog table. If pt is types.BQPartitionNone, the table is created without time partitioning.
+func WithMetadataPartition(field string, pt types.BQPartition) MetadataOption {
+	return func(x *MetadataConfig) {
+		x.partitionField = field
+		x.partitionType = pt
+	}
+}
+
+// WithMetadataClustering specifies clustering fields of LoadLog table.
+func WithMetadataClustering(fields ...string) MetadataOption {
+	return func(x *MetadataConfig) {
+		x.clustering = fields
+	}
 }
 
-func NewMetadataConfig(dataset types.BQDatasetID, table types.BQTableID) *MetadataConfig {
-	return &MetadataConfig{dataset: dataset, table: table}
+// WithMetadataExpiration specifies the partition expiration of LoadLog table. Zero means no expiration.
+func WithMetadataExpiration(d time.Duration) MetadataOption {
+	return func(x *MetadataConfig) {
+		x.expiration = d
+	}
+}
+
+func NewMetadataConfig(dataset types.BQDatasetID, table types.BQTableID, options ...MetadataOption) *MetadataConfig {
+	cfg := &MetadataConfig{
+		dataset:        dataset,
+		table:          table,
+		partitionField: defaultMetadataPartitionField,
+		partitionType:  defaultMetadataPartitionType,
+	}
+	for _, opt := range options {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func (x *MetadataConfig) Dataset() types.BQDatasetID       { return x.dataset }
+func (x *MetadataConfig) Table() types.BQTableID           { return x.table }
+func (x *MetadataConfig) PartitionField() string           { return x.partitionField }
+func (x *MetadataConfig) PartitionType() types.BQPartition { return x.partitionType }
+func (x *MetadataConfig) Clustering() []string             { return x.clustering }
+func (x *MetadataConfig) Expiration() time.Duration        { return x.expiration }
+
+// EnrichmentConfig is configuration to enrich records with columns of a dimension table of BigQuery, e.g. account name by account ID. A record of which value at Field equals to value of Key column of a row in the dimension table gets other columns of the row as top-level fields of data.
+type EnrichmentConfig struct {
+	// Field is dot separated path of the lookup key in data of a record, e.g. "recipientAccountId".
+	Field string
+
+	// Dataset and Table are the dimension table. The whole table is loaded into memory, so it should be small.
+	Dataset types.BQDatasetID
+	Table   types.BQTableID
+
+	// Key is column name of the dimension table matched with the value at Field. Values are compared as strings.
+	Key string
+
+	// Refresh is interval to reload the dimension table. 0 means that the table is loaded only once.
+	Refresh time.Duration
 }
-func (x *MetadataConfig) Dataset() types.BQDatasetID { return x.dataset }
-func (x *MetadataConfig) Table() types.BQTableID     { return x.table }
@@ -42,23 +42,24 @@ type PubSubMessage struct {
 }
 
 type CloudStorageEvent struct {
-	Bucket                  types.CSBucket   `json:"bucket"`
-	ContentType             string           `json:"contentType"`
-	Crc32c                  string           `json:"crc32c"`
-	Etag                    string           `json:"etag"`
-	Generation              string           `json:"generation"`
-	ID                      string           `json:"id"`
-	Kind                    string           `json:"kind"`
-	Md5Hash                 string           `json:"md5Hash"`
-	MediaLink               string           `json:"mediaLink"`
-	Metageneration          string           `json:"metageneration"`
-	Name                    types.CSObjectID `json:"name"`
-	SelfLink                string           `json:"selfLink"`
-	Size                    string           `json:"size"`
-	StorageClass            string           `json:"storageClass"`
-	TimeCreated             string           `json:"timeCreated"`
-	TimeStorageClassUpdated string           `json:"timeStorageClassUpdated"`
-	Updated                 string           `json:"updated"`
+	Bucket                  types.CSBucket    `json:"bucket"`
+	ContentType             string            `json:"contentType"`
+	Crc32c                  string            `json:"crc32c"`
+	Etag                    string            `json:"etag"`
+	Generation              string            `json:"generation"`
+	ID                      string            `json:"id"`
+	Kind                    string            `json:"kind"`
+	Md5Hash                 string            `json:"md5Hash"`
+	MediaLink               string            `json:"mediaLink"`
+	Metadata                map[string]string `json:"metadata"`
+	Metageneration          string            `json:"metageneration"`
+	Name                    types.CSObjectID  `json:"name"`
+	SelfLink                string            `json:"selfLink"`
+	Size                    string            `json:"size"`
+	StorageClass            string            `json:"storageClass"`
+	TimeCreated             string            `json:"timeCreated"`
+	TimeStorageClassUpdated string            `json:"timeStorageClassUpdated"`
+	Updated                 string            `json:"updated"`
 }
 
 func (x CloudStorageEvent) ToObject() Object {
@@ -95,9 +96,11 @@ func (x CloudStorageEvent) ToObject() Object {
 			Bucket: x.Bucket,
 			Name:   x.Name,
 		},
-		Size:      size,
-		CreatedAt: createdAt,
-		Digests:   digests,
+		Size:        size,
+		CreatedAt:   createdAt,
+		Digests:     digests,
+		ContentType: x.ContentType,
+		Metadata:    x.Metadata,
 
 		Data: x,
 	}
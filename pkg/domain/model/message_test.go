@@ -21,6 +21,8 @@ func TestCloudStorageEvent(t *testing.T) {
 	gt.Equal(t, obj.CS.Name, "mydir/GA1ZivRbQAAAyXs.jpg")
 	gt.Equal(t, *obj.Size, int64(434358))
 	gt.Equal(t, *obj.CreatedAt, int64(1708130907))
+	gt.Equal(t, obj.ContentType, "image/jpeg")
+	gt.Equal(t, obj.Metadata["log-type"], "access_log")
 	gt.A(t, obj.Digests).Must().Length(1).At(0, func(t testing.TB, v model.Digest) {
 		gt.Equal(t, v.Alg, "md5")
 		gt.Equal(t, v.Value, "eb9b8a4296628acbbd90ff20065fb9d1")
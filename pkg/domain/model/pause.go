@@ -0,0 +1,74 @@
+package model
+
+import (
+	"context"
+	"sync"
+)
+
+// PauseSwitch pauses and resumes a long running process such as Backfill from another goroutine, e.g. a signal handler. The zero value is running and it is safe for concurrent use. Methods of nil PauseSwitch behave as never paused.
+type PauseSwitch struct {
+	mutex  sync.Mutex
+	resume chan struct{}
+}
+
+// Pause pauses the process. It does nothing if already paused.
+func (x *PauseSwitch) Pause() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.resume == nil {
+		x.resume = make(chan struct{})
+	}
+}
+
+// Resume resumes the process and releases all waiters. It does nothing if not paused.
+func (x *PauseSwitch) Resume() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.resume != nil {
+		close(x.resume)
+		x.resume = nil
+	}
+}
+
+// Toggle pauses the process if running, or resumes it if paused. It returns true if the process is paused after toggle.
+func (x *PauseSwitch) Toggle() bool {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.resume != nil {
+		close(x.resume)
+		x.resume = nil
+		return false
+	}
+	x.resume = make(chan struct{})
+	return true
+}
+
+// Paused returns true if the process is paused.
+func (x *PauseSwitch) Paused() bool {
+	if x == nil {
+		return false
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.resume != nil
+}
+
+// Wait blocks while the process is paused. It returns ctx.Err() if ctx is canceled before resumed.
+func (x *PauseSwitch) Wait(ctx context.Context) error {
+	if x == nil {
+		return nil
+	}
+	x.mutex.Lock()
+	resume := x.resume
+	x.mutex.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,6 +1,13 @@
 package model
 
 import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 )
@@ -27,12 +34,108 @@ type Source struct {
 	Parser   types.ObjectParser   `json:"parser" bigquery:"parser"`
 	Schema   types.ObjectSchema   `json:"schema" bigquery:"schema"`
 	Compress types.ObjectCompress `json:"compress" bigquery:"compress"`
+
+	// SingleObject is a flag that the object has exactly one JSON value (e.g. pretty-printed JSON) and it is treated as one record. If the object has more values, it fails.
+	SingleObject bool `json:"single_object" bigquery:"single_object"`
+
+	// Delimiter is a separator of JSON records in the object, e.g. "\u001e" (record separator of RFC 7464 JSON text sequences) or "\u0000". Whitespace around each record, such as the trailing newline of JSON text sequences, is ignored. Empty or "\n" means newline delimited (or concatenated) JSON values. It is ignored for other parsers.
+	Delimiter string `json:"delimiter" bigquery:"delimiter"`
+
+	// RecordsPath is a dot separated path to an array of records in the parsed object, e.g. "Records". If empty, each parsed value is treated as a record.
+	RecordsPath string `json:"records_path" bigquery:"records_path"`
+
+	// EnvelopePath is a dot separated path to an object in the parsed object of which fields are merged into each record extracted by RecordsPath, e.g. "metadata". A field of the record takes precedence over the same name field of the envelope.
+	EnvelopePath string `json:"envelope_path" bigquery:"envelope_path"`
+
+	// MaxFields is the max number of top-level fields of data per destination table in the source. It protects the table from schema explosion by a buggy policy, e.g. leaking unique values into field names. 0 means no limit.
+	MaxFields int `json:"max_fields" bigquery:"max_fields"`
+
+	// MaxRecordDepth is the max nesting depth of objects and arrays in each JSON value of the object. It is checked while decoding to reject a malicious value before it exhausts memory. With RecordsPath, it is applied to each extracted record, and the parsed value is limited to the depth of records in it. 0 means no limit.
+	MaxRecordDepth int `json:"max_record_depth" bigquery:"max_record_depth"`
+
+	// MaxRecordSize is the max byte size of each JSON value of the object, checked while decoding as well as MaxRecordDepth. With RecordsPath, it is applied to each extracted record as compact JSON after decoding, because the parsed value contains all records of the object. 0 means no limit.
+	MaxRecordSize int64 `json:"max_record_size" bigquery:"max_record_size"`
+
+	// FailOnEmpty is a flag to fail the load if schema policy returns no log for a record. By default, such record is skipped with warning.
+	FailOnEmpty bool `json:"fail_on_empty" bigquery:"fail_on_empty"`
+
+	// Drop is a list of filters to drop records before schema policy evaluation. A record is dropped if any filter matches it. It is cheaper than dropping records in schema policy, e.g. health check logs.
+	Drop RecordFilters `json:"drop" bigquery:"drop"`
+
+	// PathFields is a list of keys of Hive-style "key=value" segments in the object name, e.g. "region" of "logs/region=us/service=ec2/1.log". Values of the keys are added to data of each log as string fields. A key that is not in the object name is ignored.
+	PathFields []string `json:"path_fields" bigquery:"path_fields"`
+
+	// SampleRate is a fraction of logs to be ingested, e.g. 0.1 keeps about 10% of logs. A log is kept or skipped deterministically by hash of the log ID, so that the same log is consistently sampled across reprocessing. 0 means no sampling, all logs are ingested.
+	SampleRate float64 `json:"sample_rate" bigquery:"sample_rate"`
+
+	// ObjectPolicy is a flag to evaluate "batch_log" rule of schema policy once with all records of the object as input array, instead of evaluating "log" rule for each record. It is faster for a policy that is expensive per evaluation. If "batch_log" is not defined, records are evaluated one by one as usual.
+	ObjectPolicy bool `json:"object_policy" bigquery:"object_policy"`
+
+	// CSVEmpty specifies conversion of an empty field of CSV: "string" keeps it as "" (default), "null" converts it to null (then the field is removed from data of the log), and "sentinel" replaces it with CSVEmptySentinel. It is ignored for other parsers.
+	CSVEmpty types.CSVEmptyPolicy `json:"csv_empty" bigquery:"csv_empty"`
+	// CSVEmptySentinel is a string that replaces an empty field of CSV when CSVEmpty is "sentinel", e.g. "N/A".
+	CSVEmptySentinel string `json:"csv_empty_sentinel" bigquery:"csv_empty_sentinel"`
+
+	// MaxAge is retention of the source in seconds. An object of which last update (Updated attribute of Cloud Storage) is older than it is rejected by ExpiredAction in loading. 0 means no limit.
+	MaxAge float64 `json:"max_age" bigquery:"max_age"`
+	// ExpiredAction is handling of an object older than MaxAge: "skip" (default) or "dead_letter".
+	ExpiredAction types.ExpiredAction `json:"expired_action" bigquery:"expired_action"`
+
+	// Manifest is a flag that the object is a manifest listing data objects to be loaded with this source as one batch, instead of data itself. Each line of the manifest is a URL of a data object (gs://bucket/name) or an object name in the same bucket as the manifest.
+	Manifest bool `json:"manifest" bigquery:"manifest"`
+}
+
+// RecordFilter matches a record of which field has the value.
+type RecordFilter struct {
+	// Field is a dot separated path to a field of the record, e.g. "request.path".
+	Field string `json:"field" bigquery:"field"`
+	// Value is compared with the field value as string. Number and boolean value are formatted, e.g. 200 as "200" and true as "true".
+	Value string `json:"value" bigquery:"value"`
+}
+
+type RecordFilters []RecordFilter
+
+// Match returns true if any filter matches the record.
+func (x RecordFilters) Match(record any) bool {
+	for _, filter := range x {
+		if filter.Match(record) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the field of the record exists and has the value. Object, array and null value never match.
+func (x RecordFilter) Match(record any) bool {
+	v := record
+	for _, key := range strings.Split(x.Field, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		if v, ok = obj[key]; !ok {
+			return false
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		return value == x.Value
+	case json.Number:
+		return value.String() == x.Value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64) == x.Value
+	case bool:
+		return strconv.FormatBool(value) == x.Value
+	default:
+		return false
+	}
 }
 
 func (x Source) Validate() error {
 	switch x.Parser {
-	case types.JSONParser:
-		// OK
+	case types.JSONParser, types.CSVParser, "":
+		// OK, empty parser is selected automatically by the object
 	default:
 		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.format is invalid").With("format", x.Parser)
 	}
@@ -41,8 +144,53 @@ func (x Source) Validate() error {
 		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.record is required")
 	}
 
+	if x.EnvelopePath != "" && x.RecordsPath == "" {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.envelope_path requires src.records_path").With("envelope_path", x.EnvelopePath)
+	}
+
+	if x.MaxFields < 0 {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.max_fields must not be negative").With("max_fields", x.MaxFields)
+	}
+
+	if x.MaxRecordDepth < 0 {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.max_record_depth must not be negative").With("max_record_depth", x.MaxRecordDepth)
+	}
+	if x.MaxRecordSize < 0 {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.max_record_size must not be negative").With("max_record_size", x.MaxRecordSize)
+	}
+
+	for _, filter := range x.Drop {
+		if filter.Field == "" {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "src.drop.field is required").With("drop", x.Drop)
+		}
+	}
+
+	if x.SampleRate < 0 || x.SampleRate > 1 {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.sample_rate must be between 0 and 1").With("sample_rate", x.SampleRate)
+	}
+
+	for _, key := range x.PathFields {
+		if key == "" || strings.ContainsAny(key, "/=") {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "src.path_fields has invalid key").With("path_fields", x.PathFields)
+		}
+	}
+
+	if !x.CSVEmpty.IsValid() {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.csv_empty is invalid").With("csv_empty", x.CSVEmpty)
+	}
+	if x.CSVEmpty == types.CSVEmptySentinel && x.CSVEmptySentinel == "" {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.csv_empty_sentinel is required for sentinel of src.csv_empty")
+	}
+
+	if x.MaxAge < 0 {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.max_age must not be negative").With("max_age", x.MaxAge)
+	}
+	if !x.ExpiredAction.IsValid() {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.expired_action is invalid").With("expired_action", x.ExpiredAction)
+	}
+
 	switch x.Compress {
-	case types.GZIPComp, "":
+	case types.GZIPComp, types.ZstdComp, "":
 		// OK
 	default:
 		return goerr.Wrap(types.ErrInvalidPolicyResult, "src.comp is invalid").With("comp", x.Compress)
@@ -59,8 +207,58 @@ type BigQueryDest struct {
 	Dataset   types.BQDatasetID `json:"dataset"`
 	Table     types.BQTableID   `json:"table"`
 	Partition types.BQPartition `json:"partition"`
+
+	// RangePartition is integer range partitioning of the table applied when the table is created. It can not be used with Partition.
+	RangePartition RangePartition `json:"range_partition"`
 }
 
+// RangePartitionColumn is a top-level INTEGER column of which value is copied from RangePartition.Field in data. BigQuery can partition a table only by a top-level column.
+const RangePartitionColumn = "partition_key"
+
+// maxRangePartitions is the max number of partitions of a table in BigQuery.
+const maxRangePartitions = 10000
+
+// RangePartition declares integer range partitioning of BigQuery table. Partitions are [Start, Start+Interval), [Start+Interval, Start+2*Interval), ... until End (exclusive).
+type RangePartition struct {
+	// Field is a dot separated path of an integer field in data, e.g. "customer.id".
+	Field    string `json:"field"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Interval int64  `json:"interval"`
+}
+
+// Enabled returns true if the range partitioning is declared.
+func (x RangePartition) Enabled() bool {
+	return x.Field != ""
+}
+
+func (x RangePartition) Validate() error {
+	if !x.Enabled() {
+		if x != (RangePartition{}) {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "log.range_partition.field is required")
+		}
+		return nil
+	}
+
+	if slices.Contains(strings.Split(x.Field, "."), "") {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.range_partition.field must not have empty field name").With("field", x.Field)
+	}
+	if x.Interval <= 0 {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.range_partition.interval must be positive").With("interval", x.Interval)
+	}
+	if x.End <= x.Start {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.range_partition.end must be greater than start").With("start", x.Start).With("end", x.End)
+	}
+	// Difference of int64 values always fits in uint64
+	if n := (uint64(x.End-x.Start)-1)/uint64(x.Interval) + 1; n > maxRangePartitions {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.range_partition has too many partitions").With("count", n).With("limit", maxRangePartitions)
+	}
+	return nil
+}
+
+// maxLogTimestamp is 10000-01-01T00:00:00Z in Unix time (second). BigQuery TIMESTAMP does not support time after it.
+const maxLogTimestamp = 253402300800
+
 type Log struct {
 	// Destination BigQuery table information
 	BigQueryDest
@@ -68,6 +266,28 @@ type Log struct {
 	ID        types.LogID    `json:"id"`
 	Timestamp float64        `json:"timestamp"`
 	Data      map[string]any `json:"data"`
+
+	// Description is set to the table description when the table is created. If it differs from description of existing table, the table is updated. Empty description does not change the table. It is not a part of BigQueryDest, then logs with different descriptions are still ingested together.
+	Description string `json:"description"`
+
+	// Fields declares types of fields in Data. Key is field name, and nested field can be specified by dot separated path. The field value is converted according to FieldSpec before schema inference.
+	Fields map[string]FieldSpec `json:"fields"`
+
+	// InsertIDField is a field name in Data of which value is used as insert ID (stored as id column) instead of ID. Nested field can be specified by dot separated path, e.g. "detail.eventId".
+	InsertIDField string `json:"insert_id_field"`
+
+	// Include is a list of fields in Data to be kept. Other fields are removed before schema inference to keep the table narrow. Nested field can be specified by dot separated path. Empty means all fields.
+	Include []string `json:"include"`
+	// Exclude is a list of fields in Data to be removed after Include is applied. Nested field can be specified by dot separated path.
+	Exclude []string `json:"exclude"`
+
+	// RenameFields renames fields in Data before other field options are applied, e.g. to keep column name when upstream renames a field. Key is a field name (nested field can be specified by dot separated path), and value is new name of the field in the same object.
+	RenameFields map[string]string `json:"rename_fields"`
+
+	// Shard appends a date suffix of the granularity to Table for date-sharded tables, e.g. "events_20240501" for "day". It can not be used with Partition and RangePartition.
+	Shard types.BQPartition `json:"shard"`
+	// ShardBy is time source of the suffix, "timestamp" of the log (default) or created time of the "object".
+	ShardBy types.BQShardBy `json:"shard_by"`
 }
 
 func (x *Log) Validate() error {
@@ -81,9 +301,146 @@ func (x *Log) Validate() error {
 	if x.Timestamp == 0 {
 		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.timestamp is required, or must be more than 0")
 	}
+	if x.Timestamp < 0 || math.IsNaN(x.Timestamp) || math.IsInf(x.Timestamp, 0) {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.timestamp must be positive Unix time").With("timestamp", x.Timestamp)
+	}
+	// Unix timestamp in second must be before 10000-01-01. A larger value is likely in millisecond or microsecond by mistake.
+	if x.Timestamp >= maxLogTimestamp {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.timestamp is too large, it must be Unix time in second").With("timestamp", x.Timestamp)
+	}
 	if x.Data == nil {
 		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.data is required")
 	}
+	if _, err := x.InsertID(); err != nil {
+		return err
+	}
+	if err := x.RangePartition.Validate(); err != nil {
+		return err
+	}
+	if x.RangePartition.Enabled() && x.Partition != types.BQPartitionNone {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.partition and log.range_partition can not be used together").With("partition", x.Partition)
+	}
+	if x.Shard != types.BQPartitionNone {
+		if x.Shard.Type() == "" {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "log.shard must be hour, day, month or year").With("shard", x.Shard)
+		}
+		if x.Partition != types.BQPartitionNone || x.RangePartition.Enabled() {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "log.shard can not be used with partitioning").With("shard", x.Shard)
+		}
+	}
+	switch x.ShardBy {
+	case "", types.BQShardByTimestamp, types.BQShardByObject:
+	default:
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "log.shard_by must be timestamp or object").With("shard_by", x.ShardBy)
+	}
+	for _, field := range append(x.Include, x.Exclude...) {
+		if field == "" || slices.Contains(strings.Split(field, "."), "") {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "log.include and log.exclude must not have empty field name").With("field", field)
+		}
+	}
+	for from, to := range x.RenameFields {
+		if from == "" || slices.Contains(strings.Split(from, "."), "") {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "log.rename_fields must not have empty field name").With("field", from)
+		}
+		if to == "" || strings.Contains(to, ".") {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "new name of log.rename_fields must be a non-empty name without dot").With("field", from).With("to", to)
+		}
+	}
+	for name, spec := range x.Fields {
+		if err := spec.Validate(); err != nil {
+			return goerr.Wrap(err, "invalid log.fields").With("field", name)
+		}
+	}
 
 	return nil
 }
+
+// InsertID returns value of the field specified by InsertIDField. It returns empty ID if InsertIDField is not set. The field must exist in Data and have string or number value.
+func (x *Log) InsertID() (types.LogID, error) {
+	if x.InsertIDField == "" {
+		return "", nil
+	}
+
+	var v any = x.Data
+	for _, key := range strings.Split(x.InsertIDField, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", goerr.Wrap(types.ErrInvalidPolicyResult, "log.insert_id_field is not found in log.data").With("field", x.InsertIDField)
+		}
+		if v, ok = obj[key]; !ok {
+			return "", goerr.Wrap(types.ErrInvalidPolicyResult, "log.insert_id_field is not found in log.data").With("field", x.InsertIDField)
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		if value == "" {
+			return "", goerr.Wrap(types.ErrInvalidPolicyResult, "value of log.insert_id_field is empty").With("field", x.InsertIDField)
+		}
+		return types.LogID(value), nil
+	case float64:
+		return types.LogID(strconv.FormatFloat(value, 'f', -1, 64)), nil
+	case json.Number:
+		return types.LogID(value.String()), nil
+	default:
+		return "", goerr.Wrap(types.ErrInvalidPolicyResult, "value of log.insert_id_field must be string or number").With("field", x.InsertIDField).With("value", v)
+	}
+}
+
+// Timestamp formats of FieldSpec. Other format is used as layout of time.Parse.
+const (
+	TimestampFormatRFC3339   = "rfc3339"
+	TimestampFormatUnix      = "unix"
+	TimestampFormatUnixMilli = "unix_milli"
+)
+
+// FieldSpec is a declaration of field type in log data.
+type FieldSpec struct {
+	// Type is a type to convert the field value. If empty, the value is not converted.
+	Type types.FieldType `json:"type"`
+
+	// Format is a format of original value. For timestamp, "rfc3339" (default), "unix", "unix_milli" or layout of Go time.Parse (e.g. "2006-01-02 15:04:05") is available.
+	Format string `json:"format"`
+
+	// PolicyTags is a list of policy tag resource names for column-level access control, e.g. "projects/my-project/locations/us/taxonomies/123/policyTags/456".
+	PolicyTags []string `json:"policy_tags"`
+}
+
+var policyTagPattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/taxonomies/[^/]+/policyTags/[^/]+$`)
+
+func (x FieldSpec) Validate() error {
+	switch x.Type {
+	case types.FieldTimestamp, types.FieldString, types.FieldNumeric, types.FieldBigNumeric, types.FieldBytes, types.FieldGeography, "":
+		// OK
+	default:
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "field type is invalid").With("type", x.Type)
+	}
+
+	for _, tag := range x.PolicyTags {
+		if !policyTagPattern.MatchString(tag) {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "policy tag must be projects/{project}/locations/{location}/taxonomies/{taxonomy}/policyTags/{tag}").With("tag", tag)
+		}
+	}
+	return nil
+}
+
+// PolicyError is a structured error of policy evaluation reported by OPA, e.g. conflict of rule values or runtime error of built-in function.
+type PolicyError struct {
+	Code    string `json:"code" bigquery:"code"`
+	Message string `json:"message" bigquery:"message"`
+	// Location is "{file}:{row}" of the expression that failed.
+	Location string `json:"location" bigquery:"location"`
+	// Rule is full path of the rule containing Location, e.g. "data.schema.cloudtrail.log". Empty if the rule can not be identified.
+	Rule string `json:"rule" bigquery:"rule"`
+}
+
+func (x *PolicyError) Error() string {
+	msg := x.Code + ": " + x.Message
+	if x.Location != "" {
+		msg += " at " + x.Location
+	}
+	if x.Rule != "" {
+		msg += " in " + x.Rule
+	}
+	return msg
+}
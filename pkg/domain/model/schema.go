@@ -0,0 +1,103 @@
+package model
+
+import (
+	"sort"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// SchemaDiff is difference between schema of live BigQuery table and inferred schema from objects.
+type SchemaDiff struct {
+	Dest BigQueryDest `json:"dest"`
+
+	// TableExists is false if the table does not exist yet. Then all fields are in Added.
+	TableExists bool `json:"table_exists"`
+
+	Added   []*SchemaField       `json:"added"`
+	Removed []*SchemaField       `json:"removed"`
+	Retyped []*SchemaFieldChange `json:"retyped"`
+}
+
+type SchemaField struct {
+	Name     string             `json:"name"`
+	Type     bigquery.FieldType `json:"type"`
+	Repeated bool               `json:"repeated"`
+}
+
+type SchemaFieldChange struct {
+	Name string      `json:"name"`
+	Old  SchemaField `json:"old"`
+	New  SchemaField `json:"new"`
+}
+
+// HasChanges returns true if the inferred schema adds, removes or retypes any column.
+func (x *SchemaDiff) HasChanges() bool {
+	return len(x.Added) > 0 || len(x.Removed) > 0 || len(x.Retyped) > 0
+}
+
+// Incompatible returns true if the inferred schema can not be applied to the live table, i.e. type of a column or whether it is repeated is changed. NULLABLE and REQUIRED modes are not compared because mode of existing columns is kept when the table is updated. Removed columns are compatible because they are kept in the table.
+func (x *SchemaDiff) Incompatible() bool {
+	return len(x.Retyped) > 0
+}
+
+// DiffSchema compares current (live table) schema and inferred schema. Nested fields are named with dot separated path, such as "data.user.name". current can be nil if the table does not exist.
+func DiffSchema(dst BigQueryDest, current, inferred bigquery.Schema) *SchemaDiff {
+	diff := &SchemaDiff{
+		Dest:        dst,
+		TableExists: current != nil,
+	}
+	diffSchema(diff, "", current, inferred)
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Name < diff.Added[j].Name })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Name < diff.Removed[j].Name })
+	sort.Slice(diff.Retyped, func(i, j int) bool { return diff.Retyped[i].Name < diff.Retyped[j].Name })
+
+	return diff
+}
+
+func diffSchema(diff *SchemaDiff, prefix string, current, inferred bigquery.Schema) {
+	currentMap := map[string]*bigquery.FieldSchema{}
+	for _, f := range current {
+		currentMap[f.Name] = f
+	}
+	inferredMap := map[string]*bigquery.FieldSchema{}
+	for _, f := range inferred {
+		inferredMap[f.Name] = f
+	}
+
+	for _, f := range inferred {
+		name := prefix + f.Name
+		c, ok := currentMap[f.Name]
+		if !ok {
+			diff.Added = append(diff.Added, toSchemaField(name, f))
+			continue
+		}
+
+		if c.Type != f.Type || c.Repeated != f.Repeated {
+			diff.Retyped = append(diff.Retyped, &SchemaFieldChange{
+				Name: name,
+				Old:  *toSchemaField(name, c),
+				New:  *toSchemaField(name, f),
+			})
+			continue
+		}
+
+		if f.Type == bigquery.RecordFieldType {
+			diffSchema(diff, name+".", c.Schema, f.Schema)
+		}
+	}
+
+	for _, f := range current {
+		if _, ok := inferredMap[f.Name]; !ok {
+			diff.Removed = append(diff.Removed, toSchemaField(prefix+f.Name, f))
+		}
+	}
+}
+
+func toSchemaField(name string, f *bigquery.FieldSchema) *SchemaField {
+	return &SchemaField{
+		Name:     name,
+		Type:     f.Type,
+		Repeated: f.Repeated,
+	}
+}
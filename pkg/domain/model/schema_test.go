@@ -0,0 +1,76 @@
+package model_test
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+)
+
+func TestDiffSchema(t *testing.T) {
+	dst := model.BigQueryDest{Dataset: "ds", Table: "tbl"}
+	current := bigquery.Schema{
+		{Name: "id", Type: bigquery.StringFieldType},
+		{Name: "count", Type: bigquery.IntegerFieldType},
+		{Name: "old", Type: bigquery.StringFieldType},
+		{Name: "data", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		}},
+	}
+
+	t.Run("compatible change", func(t *testing.T) {
+		inferred := bigquery.Schema{
+			{Name: "id", Type: bigquery.StringFieldType},
+			{Name: "count", Type: bigquery.IntegerFieldType},
+			{Name: "data", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType},
+				{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+				{Name: "color", Type: bigquery.StringFieldType},
+			}},
+		}
+
+		diff := model.DiffSchema(dst, current, inferred)
+		gt.True(t, diff.TableExists)
+		gt.True(t, diff.HasChanges())
+		gt.False(t, diff.Incompatible())
+		gt.A(t, diff.Added).Length(1).At(0, func(t testing.TB, v *model.SchemaField) {
+			gt.Equal(t, v.Name, "data.color")
+			gt.Equal(t, v.Type, bigquery.StringFieldType)
+		})
+		gt.A(t, diff.Removed).Length(1).At(0, func(t testing.TB, v *model.SchemaField) {
+			gt.Equal(t, v.Name, "old")
+		})
+		gt.A(t, diff.Retyped).Length(0)
+	})
+
+	t.Run("incompatible change", func(t *testing.T) {
+		inferred := bigquery.Schema{
+			{Name: "id", Type: bigquery.StringFieldType},
+			{Name: "count", Type: bigquery.StringFieldType},
+			{Name: "old", Type: bigquery.StringFieldType},
+			{Name: "data", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType},
+				{Name: "tags", Type: bigquery.StringFieldType},
+			}},
+		}
+
+		diff := model.DiffSchema(dst, current, inferred)
+		gt.True(t, diff.Incompatible())
+		gt.A(t, diff.Retyped).Must().Length(2)
+		gt.Equal(t, diff.Retyped[0].Name, "count")
+		gt.Equal(t, diff.Retyped[0].Old.Type, bigquery.IntegerFieldType)
+		gt.Equal(t, diff.Retyped[0].New.Type, bigquery.StringFieldType)
+		gt.Equal(t, diff.Retyped[1].Name, "data.tags")
+		gt.True(t, diff.Retyped[1].Old.Repeated)
+		gt.False(t, diff.Retyped[1].New.Repeated)
+	})
+
+	t.Run("new table", func(t *testing.T) {
+		diff := model.DiffSchema(dst, nil, current)
+		gt.False(t, diff.TableExists)
+		gt.False(t, diff.Incompatible())
+		gt.A(t, diff.Added).Length(4)
+	})
+}
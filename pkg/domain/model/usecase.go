@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/hex"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -15,16 +16,120 @@ type LoadDataRequest struct {
 type LoadRequest struct {
 	Source Source
 	Object Object
+
+	// Manifest is the manifest object that listed Object. It is nil if Object is not listed by a manifest.
+	Manifest *CloudStorageObject
 }
 
 type EnqueueRequest struct {
 	URLs []types.ObjectURL
+
+	// Filter selects objects to enqueue by object name. If nil, all listed objects are enqueued.
+	Filter *types.ObjectMatcher
+
+	// DryRun resolves objects and messages by filter and limits without publishing them. The result is returned as EnqueueResponse.Plan.
+	DryRun bool
+
+	// DatePath lists only date sub-prefixes in the range under each URL instead of listing the whole prefix. If nil, the whole prefix is listed.
+	DatePath *DatePathRange
+
+	// Unroutable is handling of an object for which event policy selects no source: skip it, or publish it to dead letter topic. It requires policy client. If empty, Enqueue fails by such object unless WithSkipUnroutable is enabled.
+	Unroutable types.UnroutableAction
+}
+
+// DatePathRange is a time range of objects organized by date path under a prefix, e.g. "logs/2024/03/01/".
+type DatePathRange struct {
+	// Layout is Go time layout of the date path following the prefix, e.g. "2006/01/02/" or "dt=2006-01-02/15/". Each segment split by "/" must be finer than the previous one. Time is formatted in UTC.
+	Layout string
+
+	// Start (inclusive) and End (exclusive) of the range
+	Start time.Time
+	End   time.Time
+}
+
+// WatchRequest is a request to poll a Cloud Storage prefix and load new objects.
+type WatchRequest struct {
+	// URL is a Cloud Storage prefix to watch, e.g. gs://bucket/logs/
+	URL types.ObjectURL
+
+	// Interval is a duration between polling
+	Interval time.Duration
+
+	// Concurrency is number of objects loaded at the same time in a polling cycle
+	Concurrency int
+}
+
+// BackfillRequest is a request to load all objects under Cloud Storage prefixes. Objects are processed by a pipeline of list, resolve (event policy evaluation) and load stages that run concurrently with bounded queues between them.
+type BackfillRequest struct {
+	// URLs are Cloud Storage prefixes to backfill, e.g. gs://bucket/logs/
+	URLs []types.ObjectURL
+
+	// Filter selects objects to load by object name. If nil, all listed objects are loaded.
+	Filter *types.ObjectMatcher
+
+	// ListConcurrency is number of workers listing sub-prefixes split by "/" in parallel. 1 or less means sequential listing.
+	ListConcurrency int
+
+	// ResolveConcurrency is number of workers evaluating event policy for listed objects. 1 or less means one worker.
+	ResolveConcurrency int
+
+	// LoadConcurrency is number of workers loading batches of objects. 1 or less means one worker.
+	LoadConcurrency int
+
+	// BatchSize is number of objects loaded together by one Load, i.e. under one load ID. 1 or less means one object per load.
+	BatchSize int
+
+	// QueueSize is capacity of queues between stages. A stage waits for the next stage if the queue is full, so that a slow stage does not let objects pile up in memory. 0 or less means default size.
+	QueueSize int
+
+	// CountOnly only lists objects and reports their count and total size in BackfillResponse without downloading or loading them.
+	CountOnly bool
+
+	// Pause pauses and resumes Backfill while running. While paused, objects in flight are finished but no new object is taken from the listing or the queues. If nil, Backfill is never paused.
+	Pause *PauseSwitch
+
+	// ProgressID is ID of progress saved into Database when Backfill is paused or finished. Objects completed in the saved progress are skipped, so that a restarted Backfill resumes where it stopped. If empty, progress is not saved.
+	ProgressID string
+}
+
+// BackfillResponse is a result of Backfill.
+type BackfillResponse struct {
+	Elapsed time.Duration
+
+	// Listed is number of objects listed and matched the filter
+	Listed int64
+	// Size is total bytes of listed objects
+	Size int64
+	// Loaded is number of objects loaded successfully, including skipped objects
+	Loaded int64
+	// Failed is number of objects failed to be resolved or loaded
+	Failed int64
+	// Skipped is number of objects skipped because they are already completed in the saved progress. They are not counted in Listed.
+	Skipped int64
 }
 
 type EnqueueResponse struct {
 	Elapsed time.Duration
 	Count   int64
 	Size    int64
+
+	// Plan is set only in dry run.
+	Plan *EnqueuePlan
+
+	// Unroutable are objects not enqueued because event policy selects no source for them. They are published to dead letter topic by EnqueueRequest.Unroutable "dead_letter" unless dry run.
+	Unroutable []*Object
+}
+
+// EnqueuePlan is a list of messages that Enqueue would publish.
+type EnqueuePlan struct {
+	// Messages are objects of each message split by count and size limits.
+	Messages [][]*Object `json:"messages"`
+
+	// Filtered are objects skipped because they do not match EnqueueRequest.Filter.
+	Filtered []*Object `json:"filtered"`
+
+	// Unroutable are objects skipped because event policy selects no source for them.
+	Unroutable []*Object `json:"unroutable"`
 }
 
 type Object struct {
@@ -33,6 +138,12 @@ type Object struct {
 	CreatedAt *int64              `json:"created_at" bigquery:"created_at"`
 	Digests   []Digest            `json:"digests" bigquery:"digests"`
 
+	// ContentType is MIME type of the object, such as "application/json". It is used to select parser when source does not specify it.
+	ContentType string `json:"content_type,omitempty" bigquery:"content_type"`
+
+	// Metadata is custom metadata of the object, such as a label of log type. Event policy can select source by it instead of object name.
+	Metadata map[string]string `json:"metadata,omitempty" bigquery:"-"`
+
 	// Data is original notification data, such as CloudStorageEvent
 	Data any `json:"data" bigquery:"-"`
 }
@@ -40,6 +151,9 @@ type Object struct {
 type CloudStorageObject struct {
 	Bucket types.CSBucket   `json:"bucket" bigquery:"bucket"`
 	Name   types.CSObjectID `json:"name" bigquery:"name"`
+
+	// Generation pins version of the object to be read. 0 means the latest version. It is set only while loading the object, then not serialized.
+	Generation int64 `json:"-" bigquery:"-"`
 }
 
 type Digest struct {
@@ -53,8 +167,10 @@ func NewObjectFromCloudStorageAttrs(attrs *storage.ObjectAttrs) Object {
 			Bucket: types.CSBucket(attrs.Bucket),
 			Name:   types.CSObjectID(attrs.Name),
 		},
-		Size:      &attrs.Size,
-		CreatedAt: toPtr(attrs.Created.Unix()),
+		Size:        &attrs.Size,
+		CreatedAt:   toPtr(attrs.Created.Unix()),
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
 		Digests: []Digest{
 			{
 				Alg:   "md5",
@@ -67,3 +183,39 @@ func NewObjectFromCloudStorageAttrs(attrs *storage.ObjectAttrs) Object {
 func toPtr[T any](v T) *T {
 	return &v
 }
+
+// CleanupFilter selects tables in a dataset to be deleted by cleanup. A table must match both Prefix and all Labels.
+type CleanupFilter struct {
+	// Prefix is a prefix of table ID. Empty means any table ID.
+	Prefix string
+	// Labels are key and value pairs that must be set to labels of the table. Empty means any labels.
+	Labels map[string]string
+}
+
+// ReplayFilter selects failed loads recorded in LoadLog to be replayed. A load must match all conditions.
+type ReplayFilter struct {
+	// Start and End are time range of started_at of the load. Start is inclusive and End is exclusive. Zero value means no limit.
+	Start time.Time
+	End   time.Time
+	// Error is a substring of error message of the load or the source, e.g. "schema policy". Empty means any error.
+	Error string
+}
+
+// Match returns true if the load started at startedAt with the error messages matches the filter.
+func (x ReplayFilter) Match(startedAt time.Time, errors ...string) bool {
+	if !x.Start.IsZero() && startedAt.Before(x.Start) {
+		return false
+	}
+	if !x.End.IsZero() && !startedAt.Before(x.End) {
+		return false
+	}
+	if x.Error == "" {
+		return true
+	}
+	for _, msg := range errors {
+		if strings.Contains(msg, x.Error) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// decimalSpec is scale (number of digits after the decimal point) and byte size of encoded value of NUMERIC and BIGNUMERIC in BigQuery.
+type decimalSpec struct {
+	scale int
+	size  int
+	// max is the max absolute value of scaled integer
+	max *big.Int
+}
+
+var decimalSpecs = map[types.FieldType]decimalSpec{
+	// NUMERIC has 38 digits of precision including 9 digits of scale
+	types.FieldNumeric: {
+		scale: 9,
+		size:  16,
+		max:   new(big.Int).Sub(new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil), big.NewInt(1)),
+	},
+	// BIGNUMERIC has 38 digits of scale, and the scaled value is 256 bit signed integer
+	types.FieldBigNumeric: {
+		scale: 38,
+		size:  32,
+		max:   new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1)),
+	},
+}
+
+// ParseDecimal converts value (number or numeric string) to a decimal string for NUMERIC or BIGNUMERIC field type t. The value is rounded to the scale of the type, and a value out of range of the type fails.
+func ParseDecimal(value any, t types.FieldType) (string, error) {
+	spec, ok := decimalSpecs[t]
+	if !ok {
+		return "", goerr.Wrap(types.ErrAssertion, "field type is not decimal").With("type", t)
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case int:
+		s = strconv.Itoa(v)
+	default:
+		return "", goerr.New("decimal field must be number or string").With("value", value)
+	}
+
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return "", goerr.New("failed to parse decimal field").With("value", value)
+	}
+
+	// FloatString rounds the last digit with halves away from zero as BigQuery does
+	rounded := r.FloatString(spec.scale)
+	scaled, _ := new(big.Int).SetString(strings.Replace(rounded, ".", "", 1), 10)
+	if new(big.Int).Abs(scaled).Cmp(spec.max) > 0 {
+		return "", goerr.New("decimal field is out of range").With("value", value).With("type", t)
+	}
+
+	if strings.Contains(rounded, ".") {
+		rounded = strings.TrimRight(strings.TrimRight(rounded, "0"), ".")
+	}
+	if rounded == "-0" {
+		rounded = "0"
+	}
+	return rounded, nil
+}
+
+// encodeDecimal encodes decimal string s parsed by ParseDecimal into bytes for BigQuery Storage Write API. The scaled integer is encoded as little-endian two's complement.
+func encodeDecimal(s string, t types.FieldType) ([]byte, error) {
+	spec, ok := decimalSpecs[t]
+	if !ok {
+		return nil, goerr.Wrap(types.ErrAssertion, "field type is not decimal").With("type", t)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, goerr.New("failed to parse decimal value").With("value", s)
+	}
+	n := new(big.Int).Mul(r.Num(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(spec.scale)), nil))
+	n.Quo(n, r.Denom())
+	if new(big.Int).Abs(n).Cmp(spec.max) > 0 {
+		return nil, goerr.New("decimal value is out of range").With("value", s).With("type", t)
+	}
+
+	if n.Sign() < 0 {
+		n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(spec.size*8)))
+	}
+	encoded := n.FillBytes(make([]byte, spec.size))
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return encoded, nil
+}
+
+// typedFieldTypes is field types of which values are stored as string in data and serialized by the declared type when inserting.
+var typedFieldTypes = map[types.FieldType]bigquery.FieldType{
+	types.FieldNumeric:    bigquery.NumericFieldType,
+	types.FieldBigNumeric: bigquery.BigNumericFieldType,
+	types.FieldBytes:      bigquery.BytesFieldType,
+	types.FieldGeography:  bigquery.GeographyFieldType,
+}
+
+// BigQueryFieldType returns BigQuery column type declared by the field type. The second return value is false if the column type is inferred from the value, e.g. timestamp and string.
+func BigQueryFieldType(t types.FieldType) (bigquery.FieldType, bool) {
+	ft, ok := typedFieldTypes[t]
+	return ft, ok
+}
+
+// encodeTypedFields returns copy of data of which numeric and bignumeric fields are encoded for BigQuery Storage Write API. Bytes fields are kept as base64 string because protojson decodes it into bytes, and geography fields as WKT or GeoJSON string that the API accepts.
+func encodeTypedFields(data any, fields map[string]FieldSpec) (any, error) {
+	return mapTypedFields(data, fields, func(value string, t types.FieldType) (any, error) {
+		switch t {
+		case types.FieldNumeric, types.FieldBigNumeric:
+			return encodeDecimal(value, t)
+		default:
+			return value, nil
+		}
+	})
+}
+
+// mapTypedFields returns copy of data of which values of fields declared as typedFieldTypes are replaced by fn. Only maps and arrays on the path of the fields are copied, and data is not modified. A field that does not exist or is not string is kept as it is.
+func mapTypedFields(data any, fields map[string]FieldSpec, fn func(value string, t types.FieldType) (any, error)) (any, error) {
+	for name, spec := range fields {
+		if _, ok := typedFieldTypes[spec.Type]; !ok {
+			continue
+		}
+
+		var err error
+		if data, err = mapTypedField(data, strings.Split(name, "."), spec.Type, fn); err != nil {
+			return nil, goerr.Wrap(err).With("field", name)
+		}
+	}
+	return data, nil
+}
+
+func mapTypedField(data any, path []string, t types.FieldType, fn func(value string, t types.FieldType) (any, error)) (any, error) {
+	switch v := data.(type) {
+	case []any:
+		copied := make([]any, len(v))
+		for i, elem := range v {
+			converted, err := mapTypedField(elem, path, t, fn)
+			if err != nil {
+				return nil, err
+			}
+			copied[i] = converted
+		}
+		return copied, nil
+
+	case map[string]any:
+		if len(path) == 0 {
+			return data, nil
+		}
+		value, ok := v[path[0]]
+		if !ok {
+			return data, nil
+		}
+		converted, err := mapTypedField(value, path[1:], t, fn)
+		if err != nil {
+			return nil, err
+		}
+		copied := make(map[string]any, len(v))
+		for key, elem := range v {
+			copied[key] = elem
+		}
+		copied[path[0]] = converted
+		return copied, nil
+
+	case string:
+		if len(path) > 0 {
+			return data, nil
+		}
+		return fn(v, t)
+	}
+
+	return data, nil
+}
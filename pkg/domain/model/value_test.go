@@ -0,0 +1,119 @@
+package model_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+func TestParseDecimal(t *testing.T) {
+	testCases := map[string]struct {
+		value     any
+		fieldType types.FieldType
+		expect    string
+		isErr     bool
+	}{
+		"string": {
+			value:     "12.50",
+			fieldType: types.FieldNumeric,
+			expect:    "12.5",
+		},
+		"json number": {
+			value:     json.Number("1234567890123456789012345678"),
+			fieldType: types.FieldNumeric,
+			expect:    "1234567890123456789012345678",
+		},
+		"float": {
+			value:     0.1,
+			fieldType: types.FieldNumeric,
+			expect:    "0.1",
+		},
+		"round to scale": {
+			value:     "1.0000000005",
+			fieldType: types.FieldNumeric,
+			expect:    "1.000000001",
+		},
+		"negative zero": {
+			value:     "-0.0000000001",
+			fieldType: types.FieldNumeric,
+			expect:    "0",
+		},
+		"max numeric": {
+			value:     "99999999999999999999999999999.999999999",
+			fieldType: types.FieldNumeric,
+			expect:    "99999999999999999999999999999.999999999",
+		},
+		"out of numeric range": {
+			value:     "100000000000000000000000000000",
+			fieldType: types.FieldNumeric,
+			isErr:     true,
+		},
+		"bignumeric keeps more scale": {
+			value:     "0.00000000000000000001",
+			fieldType: types.FieldBigNumeric,
+			expect:    "0.00000000000000000001",
+		},
+		"invalid string": {
+			value:     "abc",
+			fieldType: types.FieldNumeric,
+			isErr:     true,
+		},
+		"boolean": {
+			value:     true,
+			fieldType: types.FieldNumeric,
+			isErr:     true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			actual, err := model.ParseDecimal(tc.value, tc.fieldType)
+			if tc.isErr {
+				gt.Error(t, err)
+				return
+			}
+			gt.NoError(t, err)
+			gt.Equal(t, actual, tc.expect)
+		})
+	}
+}
+
+func TestLogRecordTypedFields(t *testing.T) {
+	record := model.LogRecord{
+		ID:         "log-1",
+		Timestamp:  time.Unix(1708130907, 0),
+		IngestedAt: time.Unix(1708130908, 0),
+		Data: map[string]any{
+			"price":  "1.5",
+			"refund": "-1",
+			"blob":   "AQID",
+			"name":   "blue",
+			"place":  "POINT(1 2)",
+		},
+		Fields: map[string]model.FieldSpec{
+			"price":  {Type: types.FieldNumeric},
+			"refund": {Type: types.FieldNumeric},
+			"blob":   {Type: types.FieldBytes},
+			"place":  {Type: types.FieldGeography},
+		},
+	}
+
+	t.Run("serialize for Storage Write API", func(t *testing.T) {
+		raw := record.Raw()
+		data := gt.Cast[map[string]any](t, raw.Data)
+		// 1.5 * 10^9 = 0x59682F00 in little-endian
+		gt.Equal(t, data["price"], any([]byte{0x00, 0x2F, 0x68, 0x59, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+		// -1 * 10^9 in two's complement
+		gt.Equal(t, data["refund"], any([]byte{0x00, 0x36, 0x65, 0xC4, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}))
+		gt.Equal(t, data["blob"], any("AQID"))
+		gt.Equal(t, data["name"], any("blue"))
+		gt.Equal(t, data["place"], any("POINT(1 2)"))
+
+		// Original data is not modified
+		gt.Equal(t, record.Data.(map[string]any)["price"], any("1.5"))
+	})
+}
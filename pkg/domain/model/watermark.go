@@ -0,0 +1,27 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// Watermark is progress of watching a Cloud Storage prefix. Objects created before Timestamp are already loaded. Objects created at or after Timestamp are loaded only if they are in Names, because multiple objects can have the same created time and objects after a failed object can be loaded before it.
+//
+// Watermark is also used to save progress of Backfill. In this case, Names are URLs of completed objects split into multiple Watermarks not to exceed size limit of a Firestore document, and Timestamp is not used.
+type Watermark struct {
+	ID        string    `firestore:"id"`
+	Timestamp time.Time `firestore:"timestamp"`
+	Names     []string  `firestore:"names"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+// Loaded returns true if the object created at createdAt with name is already covered by the watermark. It is nil-safe and nil watermark covers nothing.
+func (x *Watermark) Loaded(name string, createdAt time.Time) bool {
+	if x == nil {
+		return false
+	}
+	if createdAt.Before(x.Timestamp) {
+		return true
+	}
+	return slices.Contains(x.Names, name)
+}
@@ -20,6 +20,51 @@ var (
 	ErrStateNotFound       = goerr.New("state not found")
 	ErrTableNotFound       = goerr.New("table not found")
 
+	// ErrDestinationNotTable is returned when destination of logs is not a table but e.g. a view, to which logs can not be inserted.
+	ErrDestinationNotTable = goerr.New("destination is not a table")
+
+	// ErrTableAlreadyExists is returned when creating a table that has been already created, e.g. by another concurrent ingestion.
+	ErrTableAlreadyExists = goerr.New("table already exists")
+
+	// ErrRecordSchemaMismatch is returned when a record can not be converted with the table schema, e.g. it has an unknown field.
+	ErrRecordSchemaMismatch = goerr.New("record does not match table schema")
+
+	// ErrObjectTooLarge is returned when size of the object after decompression exceeds the limit.
+	ErrObjectTooLarge = goerr.New("object is too large")
+
+	// ErrCorruptObject is returned when CRC32C of downloaded object does not match the checksum of Cloud Storage.
+	ErrCorruptObject = goerr.New("object is corrupted")
+
+	// ErrRecordTooLarge is returned when byte size of a JSON value in the object exceeds max_record_size of the source.
+	ErrRecordTooLarge = goerr.New("record is too large")
+
+	// ErrRecordTooDeep is returned when nesting depth of a JSON value in the object exceeds max_record_depth of the source.
+	ErrRecordTooDeep = goerr.New("record is too deeply nested")
+
+	// ErrTooManyFields is returned when number of top-level fields of data exceeds the limit of the source.
+	ErrTooManyFields = goerr.New("too many fields")
+
+	// ErrSchemaConflict is returned when a schema can not be applied to existing table, e.g. a column type is changed.
+	ErrSchemaConflict = goerr.New("schema conflict")
+
+	// ErrUnexpectedField is returned when a record has a field that is not declared in fixed schema of the destination table.
+	ErrUnexpectedField = goerr.New("unexpected field")
+
+	// ErrFieldTypeMismatch is returned when a value of a record can not be inserted into the column because of its type, e.g. an object for STRING column.
+	ErrFieldTypeMismatch = goerr.New("field type mismatch")
+
+	// ErrObjectExpired is returned when an object is older than max_age of the source and expired_action is dead_letter.
+	ErrObjectExpired = goerr.New("object is expired")
+
+	// ErrUnsupportedArray is returned when an array in data can not be a REPEATED column of BigQuery, e.g. it has elements of different types.
+	ErrUnsupportedArray = goerr.New("unsupported array")
+
+	// ErrUnsupportedCompress is returned when compression type given by object metadata is not supported.
+	ErrUnsupportedCompress = goerr.New("unsupported compression type")
+
+	// ErrTooManyPartitions is returned when number of time partitions of a table exceeds the limit of BigQuery.
+	ErrTooManyPartitions = goerr.New("too many partitions")
+
 	// Assertion error
 	ErrAssertion = goerr.New("assertion error")
 
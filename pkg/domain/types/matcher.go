@@ -0,0 +1,122 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/m-mizutani/goerr"
+)
+
+// ObjectMatcher matches object names with glob or regular expression pattern.
+type ObjectMatcher struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// NewGlobMatcher creates ObjectMatcher with glob pattern. The pattern supports following syntax:
+//   - `*` matches any sequence of characters except `/`
+//   - `**` matches any sequence of characters including `/`. `**/` also matches empty, e.g. `logs/**/*.json` matches `logs/a.json`
+//   - `?` matches any single character except `/`
+//   - `[abc]`, `[a-z]` and `[!a-z]` (or `[^a-z]`) match a character class
+func NewGlobMatcher(pattern string) (*ObjectMatcher, error) {
+	expr, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, goerr.Wrap(ErrInvalidOption, "invalid glob pattern").With("pattern", pattern).With("cause", err.Error())
+	}
+
+	return &ObjectMatcher{pattern: pattern, re: re}, nil
+}
+
+// NewRegexMatcher creates ObjectMatcher with regular expression. The expression is not anchored, use `^` and `$` to match whole name.
+func NewRegexMatcher(pattern string) (*ObjectMatcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, goerr.Wrap(ErrInvalidOption, "invalid regex pattern").With("pattern", pattern).With("cause", err.Error())
+	}
+
+	return &ObjectMatcher{pattern: pattern, re: re}, nil
+}
+
+// Match returns true if name matches the pattern. nil ObjectMatcher matches any name.
+func (x *ObjectMatcher) Match(name CSObjectID) bool {
+	if x == nil {
+		return true
+	}
+	return x.re.MatchString(string(name))
+}
+
+func (x *ObjectMatcher) String() string {
+	if x == nil {
+		return ""
+	}
+	return x.pattern
+}
+
+func globToRegexp(pattern string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				// "**/" matches zero or more directories
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+
+		case '?':
+			b.WriteString("[^/]")
+
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			// "]" just after "[" or "[!" is a literal character in the class
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end >= len(runes) {
+				return "", goerr.Wrap(ErrInvalidOption, "unterminated character class in glob").With("pattern", pattern)
+			}
+
+			class := runes[i+1 : end]
+			b.WriteString("[")
+			if len(class) > 0 && (class[0] == '!' || class[0] == '^') {
+				b.WriteString("^")
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '\\' || r == '[' || r == ']' {
+					b.WriteString(`\`)
+				}
+				b.WriteRune(r)
+			}
+			b.WriteString("]")
+			i = end
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String(), nil
+}
@@ -0,0 +1,88 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+func TestGlobMatcher(t *testing.T) {
+	testCases := map[string]struct {
+		pattern string
+		match   []types.CSObjectID
+		unmatch []types.CSObjectID
+	}{
+		"single star does not cross directory": {
+			pattern: "logs/*.json",
+			match:   []types.CSObjectID{"logs/a.json", "logs/.json"},
+			unmatch: []types.CSObjectID{"logs/2024/a.json", "logs/a.json.gz", "other/a.json"},
+		},
+		"double star matches nested prefixes": {
+			pattern: "logs/**/*.json",
+			match:   []types.CSObjectID{"logs/a.json", "logs/2024/a.json", "logs/2024/02/17/a.json"},
+			unmatch: []types.CSObjectID{"logs/a.csv", "logs2/a.json", "x/logs/a.json"},
+		},
+		"trailing double star matches everything under prefix": {
+			pattern: "logs/**",
+			match:   []types.CSObjectID{"logs/a", "logs/2024/02/a.json"},
+			unmatch: []types.CSObjectID{"log/a", "other/logs/a"},
+		},
+		"double star in the middle of name": {
+			pattern: "logs/**.gz",
+			match:   []types.CSObjectID{"logs/a.gz", "logs/2024/a.gz"},
+			unmatch: []types.CSObjectID{"logs/a.json"},
+		},
+		"question mark": {
+			pattern: "logs/?.json",
+			match:   []types.CSObjectID{"logs/a.json"},
+			unmatch: []types.CSObjectID{"logs/ab.json", "logs//.json"},
+		},
+		"character class": {
+			pattern: "logs/202[34]/*.json",
+			match:   []types.CSObjectID{"logs/2023/a.json", "logs/2024/a.json"},
+			unmatch: []types.CSObjectID{"logs/2022/a.json"},
+		},
+		"character range and negation": {
+			pattern: "logs/[a-c][!0-9].json",
+			match:   []types.CSObjectID{"logs/ax.json", "logs/c_.json"},
+			unmatch: []types.CSObjectID{"logs/a1.json", "logs/dx.json"},
+		},
+		"regex meta characters are literal": {
+			pattern: "logs/a+b(1).json",
+			match:   []types.CSObjectID{"logs/a+b(1).json"},
+			unmatch: []types.CSObjectID{"logs/aab1.json"},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			m := gt.R1(types.NewGlobMatcher(tc.pattern)).NoError(t)
+			for _, name := range tc.match {
+				gt.True(t, m.Match(name))
+			}
+			for _, name := range tc.unmatch {
+				gt.False(t, m.Match(name))
+			}
+		})
+	}
+}
+
+func TestGlobMatcherInvalid(t *testing.T) {
+	_, err := types.NewGlobMatcher("logs/[abc.json")
+	gt.Error(t, err)
+}
+
+func TestRegexMatcher(t *testing.T) {
+	m := gt.R1(types.NewRegexMatcher(`^logs/\d{4}/.+\.json$`)).NoError(t)
+	gt.True(t, m.Match("logs/2024/02/a.json"))
+	gt.False(t, m.Match("logs/abcd/a.json"))
+
+	_, err := types.NewRegexMatcher(`logs/(`)
+	gt.Error(t, err)
+}
+
+func TestNilMatcher(t *testing.T) {
+	var m *types.ObjectMatcher
+	gt.True(t, m.Match("anything"))
+}
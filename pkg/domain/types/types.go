@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/google/uuid"
@@ -37,9 +38,11 @@ func NewLogID(data any) (LogID, error) {
 type GoogleProjectID string
 type PubSubTopicID string
 type PubSubMessageID string
+type PubSubSubscriptionID string
 
-func (x GoogleProjectID) String() string { return string(x) }
-func (x PubSubTopicID) String() string   { return string(x) }
+func (x GoogleProjectID) String() string      { return string(x) }
+func (x PubSubTopicID) String() string        { return string(x) }
+func (x PubSubSubscriptionID) String() string { return string(x) }
 
 type BQDatasetID string
 type BQTableID string
@@ -48,6 +51,13 @@ type BQPartition string
 func (x BQDatasetID) String() string { return string(x) }
 func (x BQTableID) String() string   { return string(x) }
 
+// BQRequestID is an ID of an insert request to BigQuery Storage Write API. The default stream of the API has no ID per request, then it consists of name of the write stream, trace ID sent by the writer and offset of the append if available. It is recorded in IngestLog to trace the request.
+type BQRequestID string
+
+// NewBQRequestID generates a random ID for an insert that is not sent to BigQuery, e.g. by mock.
+func NewBQRequestID() BQRequestID    { return BQRequestID(uuid.NewString()) }
+func (x BQRequestID) String() string { return string(x) }
+
 const (
 	BQPartitionNone  BQPartition = ""
 	BQPartitionHour  BQPartition = "hour"
@@ -70,6 +80,53 @@ func (x BQPartition) Type() bigquery.TimePartitioningType {
 	return ""
 }
 
+// ShardSuffix returns date suffix of sharded table for t in UTC, e.g. "20240501" for day. It returns empty string if x is not a valid granularity.
+func (x BQPartition) ShardSuffix(t time.Time) string {
+	layouts := map[BQPartition]string{
+		BQPartitionHour:  "2006010215",
+		BQPartitionDay:   "20060102",
+		BQPartitionMonth: "200601",
+		BQPartitionYear:  "2006",
+	}
+
+	if layout, ok := layouts[x]; ok {
+		return t.UTC().Format(layout)
+	}
+	return ""
+}
+
+// BQShardBy is a time source of date suffix of sharded table.
+type BQShardBy string
+
+const (
+	// BQShardByTimestamp derives the suffix from timestamp of the log. It is default.
+	BQShardByTimestamp BQShardBy = "timestamp"
+	// BQShardByObject derives the suffix from created time of the object.
+	BQShardByObject BQShardBy = "object"
+)
+
+// FixedSchemaMode is a way to handle a record that has fields not declared in fixed schema of the destination table.
+type FixedSchemaMode string
+
+const (
+	// FixedSchemaStrict rejects ingestion if any record has an undeclared field. It is default.
+	FixedSchemaStrict FixedSchemaMode = "strict"
+	// FixedSchemaLoose removes undeclared fields from records and ingests them.
+	FixedSchemaLoose FixedSchemaMode = "loose"
+)
+
+// FieldType is a type of field in log data declared by schema policy. The field value is converted to the type before ingestion.
+type FieldType string
+
+const (
+	FieldTimestamp  FieldType = "timestamp"
+	FieldString     FieldType = "string"
+	FieldNumeric    FieldType = "numeric"
+	FieldBigNumeric FieldType = "bignumeric"
+	FieldBytes      FieldType = "bytes"
+	FieldGeography  FieldType = "geography"
+)
+
 type CSBucket string
 type CSObjectID string
 type CSUrl string
@@ -133,13 +190,83 @@ type ObjectParser string
 
 const (
 	JSONParser ObjectParser = "json"
+	CSVParser  ObjectParser = "csv"
+)
+
+// IsValid returns true if the parser is supported.
+func (x ObjectParser) IsValid() bool {
+	switch x {
+	case JSONParser, CSVParser:
+		return true
+	}
+	return false
+}
+
+// CSVEmptyPolicy specifies how an empty field of CSV is converted to a value of the record.
+type CSVEmptyPolicy string
+
+const (
+	// CSVEmptyString keeps an empty field as empty string "" (default).
+	CSVEmptyString CSVEmptyPolicy = "string"
+	// CSVEmptyNull converts an empty field to null. The field is removed from data of the log because a field of null value can not be inferred.
+	CSVEmptyNull CSVEmptyPolicy = "null"
+	// CSVEmptySentinel replaces an empty field with a sentinel string specified by the source.
+	CSVEmptySentinel CSVEmptyPolicy = "sentinel"
+)
+
+// IsValid returns true if the policy is supported. Empty policy means CSVEmptyString.
+func (x CSVEmptyPolicy) IsValid() bool {
+	switch x {
+	case CSVEmptyString, CSVEmptyNull, CSVEmptySentinel, "":
+		return true
+	}
+	return false
+}
+
+// ExpiredAction is handling of an object older than max_age of the source.
+type ExpiredAction string
+
+const (
+	// ExpiredSkip skips the source of the object with log (default).
+	ExpiredSkip ExpiredAction = "skip"
+	// ExpiredDeadLetter fails the load of the object with ErrObjectExpired, and then the object is published to dead letter topic if configured.
+	ExpiredDeadLetter ExpiredAction = "dead_letter"
+)
+
+// IsValid returns true if the action is supported. Empty action means ExpiredSkip.
+func (x ExpiredAction) IsValid() bool {
+	switch x {
+	case ExpiredSkip, ExpiredDeadLetter, "":
+		return true
+	}
+	return false
+}
+
+// UnroutableAction is handling of an object for which event policy selects no source in Enqueue.
+type UnroutableAction string
+
+const (
+	// UnroutableSkip skips the object and reports it in the response.
+	UnroutableSkip UnroutableAction = "skip"
+	// UnroutableDeadLetter publishes the object to dead letter topic instead of enqueue, and reports it in the response.
+	UnroutableDeadLetter UnroutableAction = "dead_letter"
 )
 
+// IsValid returns true if the action is supported. Empty action is also valid and means the default behavior.
+func (x UnroutableAction) IsValid() bool {
+	switch x {
+	case UnroutableSkip, UnroutableDeadLetter, "":
+		return true
+	}
+	return false
+}
+
 type ObjectCompress string
 
 const (
 	NoCompress ObjectCompress = ""
 	GZIPComp   ObjectCompress = "gzip"
+	ZstdComp   ObjectCompress = "zstd"
 )
 
 type ObjectSchema string
@@ -168,3 +295,15 @@ const (
 	MsgRunning   MsgState = "running"
 	MsgCompleted MsgState = "completed"
 )
+
+// RetryDecision is how a failed message is handled, decided by error classifier of UseCase.
+type RetryDecision string
+
+const (
+	// RetryDecisionRetry nacks the message to be redelivered by Pub/Sub.
+	RetryDecisionRetry RetryDecision = "retry"
+	// RetryDecisionDeadLetter acks the message and leaves objects of the failed load to dead letter topic.
+	RetryDecisionDeadLetter RetryDecision = "dead_letter"
+	// RetryDecisionDrop acks the message and discards it without publishing to dead letter topic.
+	RetryDecisionDrop RetryDecision = "drop"
+)
@@ -15,6 +15,7 @@ import (
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/utils"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
@@ -50,9 +51,22 @@ func New(ctx context.Context, projectID types.GoogleProjectID) (*Client, error)
 	}, nil
 }
 
+// Close closes both Storage Write API client and BigQuery client.
+func (x *Client) Close() error {
+	if err := x.mwClient.Close(); err != nil {
+		_ = x.bqClient.Close()
+		return goerr.Wrap(err, "failed to close bigquery storage write client")
+	}
+	if err := x.bqClient.Close(); err != nil {
+		return goerr.Wrap(err, "failed to close bigquery client")
+	}
+	return nil
+}
+
 // Query implements interfaces.BigQuery.
-func (x *Client) Query(ctx context.Context, query string) (interfaces.BigQueryIterator, error) {
+func (x *Client) Query(ctx context.Context, query string, params ...bigquery.QueryParameter) (interfaces.BigQueryIterator, error) {
 	q := x.bqClient.Query(query)
+	q.Parameters = params
 	it, err := q.Read(ctx)
 	if err != nil {
 		return nil, goerr.Wrap(err, "failed to read query result")
@@ -124,7 +138,7 @@ func (x *Client) Insert(ctx context.Context, datasetID types.BQDatasetID, tableI
 		// First, json->proto message
 		err = protojson.Unmarshal(raw, message)
 		if err != nil {
-			return goerr.Wrap(err, "failed to Unmarshal json message").With("raw", string(raw))
+			return goerr.Wrap(types.ErrRecordSchemaMismatch, "failed to Unmarshal json message").With("raw", string(raw)).With("cause", err.Error())
 		}
 		// Then, proto message -> bytes.
 		b, err := proto.Marshal(message)
@@ -203,9 +217,39 @@ func (x *Client) UpdateTable(ctx context.Context, dataset types.BQDatasetID, tab
 	return nil
 }
 
-// CreateTable implements interfaces.BigQuery.
+// ListTables implements interfaces.BigQuery.
+func (x *Client) ListTables(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error) {
+	var tables []types.BQTableID
+	it := x.bqClient.Dataset(dataset.String()).Tables(ctx)
+	for {
+		table, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to list tables").With("dataset", dataset)
+		}
+		tables = append(tables, types.BQTableID(table.TableID))
+	}
+
+	return tables, nil
+}
+
+// DeleteTable implements interfaces.BigQuery.
+func (x *Client) DeleteTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error {
+	if err := x.bqClient.Dataset(dataset.String()).Table(table.String()).Delete(ctx); err != nil {
+		return goerr.Wrap(err, "failed to delete table").With("dataset", dataset).With("table", table)
+	}
+
+	return nil
+}
+
+// CreateTable implements interfaces.BigQuery. If the table already exists, it returns types.ErrTableAlreadyExists.
 func (x *Client) CreateTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error {
 	if err := x.bqClient.Dataset(dataset.String()).Table(table.String()).Create(ctx, md); err != nil {
+		if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == 409 {
+			return goerr.Wrap(types.ErrTableAlreadyExists, gErr.Message).With("dataset", dataset).With("table", table)
+		}
 		return goerr.Wrap(err, "failed to create table").With("dataset", dataset).With("table", table)
 	}
 
@@ -169,7 +169,7 @@ func TestConcurrency(t *testing.T) {
 					records[q] = d[p+q]
 				}
 
-				gt.NoError(t, s.Insert(ctx, records))
+				gt.R1(s.Insert(ctx, records, model.InsertOptions{})).NoError(t)
 			}
 
 		}(dataSet[i])
@@ -0,0 +1,23 @@
+package bq
+
+import (
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+type StreamWriter = streamWriter
+
+func NewStreamWithWriter(datasetID types.BQDatasetID, tableID types.BQTableID, schema bigquery.Schema, w StreamWriter) (*Stream, error) {
+	messageDescriptor, err := newMessageDescriptor(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{
+		datasetID:     datasetID,
+		tableID:       tableID,
+		schema:        schema,
+		msgDescriptor: messageDescriptor,
+		mgr:           w,
+	}, nil
+}
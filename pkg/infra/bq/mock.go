@@ -5,8 +5,11 @@ import (
 	"sync"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"google.golang.org/api/iterator"
 )
 
 type Mock struct {
@@ -15,6 +18,8 @@ type Mock struct {
 	MockGetMetadata (func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID) (*bigquery.TableMetadata, error))
 	MockUpdateTable (func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, md bigquery.TableMetadataToUpdate, eTag string) error)
 	MockCreateTable (func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error)
+	MockListTables  (func(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error))
+	MockDeleteTable (func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error)
 }
 
 // CreateTable implements interfaces.BigQuery.
@@ -25,6 +30,22 @@ func (x *Mock) CreateTable(ctx context.Context, dataset types.BQDatasetID, table
 	return nil
 }
 
+// ListTables implements interfaces.BigQuery.
+func (x *Mock) ListTables(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error) {
+	if x.MockListTables != nil {
+		return x.MockListTables(ctx, dataset)
+	}
+	return nil, nil
+}
+
+// DeleteTable implements interfaces.BigQuery.
+func (x *Mock) DeleteTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error {
+	if x.MockDeleteTable != nil {
+		return x.MockDeleteTable(ctx, dataset, table)
+	}
+	return nil
+}
+
 func NewMock() *Mock {
 	return &Mock{}
 }
@@ -34,14 +55,33 @@ var _ interfaces.BigQuery = &Mock{}
 type MockStream struct {
 	mutex    sync.Mutex
 	Inserted [][]any
+	// RequestIDs are IDs returned by Insert in order, including failed inserts.
+	RequestIDs []types.BQRequestID
+	// Options are options given to Insert in order, including failed inserts.
+	Options []model.InsertOptions
+
+	// MockInsert is called before recording data. If it returns error, data is not recorded as inserted.
+	MockInsert func(ctx context.Context, data []any) error
 }
 
-func (x *MockStream) Insert(ctx context.Context, data []any) error {
+func (x *MockStream) Insert(ctx context.Context, data []any, opts model.InsertOptions) (types.BQRequestID, error) {
+	requestID := types.NewBQRequestID()
+	x.mutex.Lock()
+	x.RequestIDs = append(x.RequestIDs, requestID)
+	x.Options = append(x.Options, opts)
+	x.mutex.Unlock()
+
+	if x.MockInsert != nil {
+		if err := x.MockInsert(ctx, data); err != nil {
+			return requestID, err
+		}
+	}
+
 	x.mutex.Lock()
 	defer x.mutex.Unlock()
 
 	x.Inserted = append(x.Inserted, data)
-	return nil
+	return requestID, nil
 }
 
 func (x *MockStream) Close() error {
@@ -52,7 +92,32 @@ func (x *Mock) NewStream(ctx context.Context, datasetID types.BQDatasetID, table
 	return &MockStream{}, nil
 }
 
-func (x *Mock) Query(ctx context.Context, query string) (interfaces.BigQueryIterator, error) {
+// MockIterator is a mock of interfaces.BigQueryIterator. If MockNext is nil, Rows are returned in order for *map[string]bigquery.Value and then iterator.Done.
+type MockIterator struct {
+	MockNext func(dst interface{}) error
+	Rows     []map[string]bigquery.Value
+}
+
+func (x *MockIterator) Next(dst interface{}) error {
+	if x.MockNext != nil {
+		return x.MockNext(dst)
+	}
+
+	if len(x.Rows) == 0 {
+		return iterator.Done
+	}
+	row, ok := dst.(*map[string]bigquery.Value)
+	if !ok {
+		return goerr.New("dst must be *map[string]bigquery.Value in MockIterator").With("dst", dst)
+	}
+	*row = x.Rows[0]
+	x.Rows = x.Rows[1:]
+	return nil
+}
+
+var _ interfaces.BigQueryIterator = &MockIterator{}
+
+func (x *Mock) Query(ctx context.Context, query string, params ...bigquery.QueryParameter) (interfaces.BigQueryIterator, error) {
 	if x.MockQuery != nil {
 		return x.MockQuery(ctx, query)
 	}
@@ -105,8 +170,27 @@ type GeneralMock struct {
 		MD      bigquery.TableMetadataToUpdate
 		ETag    string
 	}
+	DeletedTable []struct {
+		Dataset types.BQDatasetID
+		Table   types.BQTableID
+	}
+
+	// Tables is returned by ListTables regardless of dataset.
+	Tables []types.BQTableID
 
 	Queries []string
+	// QueryParameters are parameters given to Query in the same order as Queries.
+	QueryParameters [][]bigquery.QueryParameter
+	// QueryResult is returned by Query. It is nil if not set.
+	QueryResult interfaces.BigQueryIterator
+	// MockQuery is called by Query if set, and its result is returned instead of QueryResult, e.g. to return a new iterator for each query.
+	MockQuery func(ctx context.Context, query string) (interfaces.BigQueryIterator, error)
+
+	// MockInsert is set to streams created by NewStream.
+	MockInsert func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error
+
+	// MockCreateTable is called by CreateTable after recording the table. Its error is returned by CreateTable.
+	MockCreateTable func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error
 
 	mutex sync.Mutex
 }
@@ -122,6 +206,9 @@ func (x *GeneralMock) CreateTable(ctx context.Context, dataset types.BQDatasetID
 		MD      *bigquery.TableMetadata
 	}{Dataset: dataset, Table: table, MD: md})
 
+	if x.MockCreateTable != nil {
+		return x.MockCreateTable(ctx, dataset, table, md)
+	}
 	return nil
 }
 
@@ -149,17 +236,26 @@ func (x *GeneralMock) NewStream(ctx context.Context, datasetID types.BQDatasetID
 	}{Dataset: datasetID, Table: tableID, Schema: schema})
 
 	s := &MockStream{}
+	if x.MockInsert != nil {
+		s.MockInsert = func(ctx context.Context, data []any) error {
+			return x.MockInsert(ctx, datasetID, tableID, data)
+		}
+	}
 	x.Streams = append(x.Streams, s)
 	return s, nil
 }
 
 // Query implements interfaces.BigQuery.
-func (x *GeneralMock) Query(ctx context.Context, query string) (interfaces.BigQueryIterator, error) {
+func (x *GeneralMock) Query(ctx context.Context, query string, params ...bigquery.QueryParameter) (interfaces.BigQueryIterator, error) {
 	x.mutex.Lock()
 	defer x.mutex.Unlock()
 
 	x.Queries = append(x.Queries, query)
-	return nil, nil
+	x.QueryParameters = append(x.QueryParameters, params)
+	if x.MockQuery != nil {
+		return x.MockQuery(ctx, query)
+	}
+	return x.QueryResult, nil
 }
 
 // UpdateTable implements interfaces.BigQuery.
@@ -177,6 +273,27 @@ func (x *GeneralMock) UpdateTable(ctx context.Context, dataset types.BQDatasetID
 	return nil
 }
 
+// ListTables implements interfaces.BigQuery.
+func (x *GeneralMock) ListTables(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	return x.Tables, nil
+}
+
+// DeleteTable implements interfaces.BigQuery.
+func (x *GeneralMock) DeleteTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	x.DeletedTable = append(x.DeletedTable, struct {
+		Dataset types.BQDatasetID
+		Table   types.BQTableID
+	}{Dataset: dataset, Table: table})
+
+	return nil
+}
+
 type MockInsertedData struct {
 	DatasetID types.BQDatasetID
 	TableID   types.BQTableID
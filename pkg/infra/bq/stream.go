@@ -3,10 +3,13 @@ package bq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra/bq/writer"
+	"github.com/m-mizutani/swarm/pkg/utils"
 
 	"cloud.google.com/go/bigquery"
 	mw "cloud.google.com/go/bigquery/storage/managedwriter"
@@ -24,22 +27,21 @@ type Stream struct {
 	schema        bigquery.Schema
 	msgDescriptor protoreflect.MessageDescriptor
 
-	mgr *writer.Manager
+	mgr streamWriter
 }
 
-func newStream(ctx context.Context, mwClient *mw.Client, projectID types.GoogleProjectID, datasetID types.BQDatasetID, tableID types.BQTableID, schema bigquery.Schema) (*Stream, error) {
-	convertedSchema, err := adapt.BQSchemaToStorageTableSchema(schema)
-	if err != nil {
-		return nil, goerr.Wrap(err, "failed to convert schema")
-	}
+// streamWriter appends rows to the table. It is implemented by writer.Manager.
+type streamWriter interface {
+	// Append appends rows and returns ID of the request. The ID is returned also with error if the request has been sent.
+	Append(ctx context.Context, rows [][]byte) (types.BQRequestID, error)
+	Renew(ctx context.Context) error
+	Close() error
+}
 
-	descriptor, err := adapt.StorageSchemaToProto2Descriptor(convertedSchema, "root")
+func newStream(ctx context.Context, mwClient *mw.Client, projectID types.GoogleProjectID, datasetID types.BQDatasetID, tableID types.BQTableID, schema bigquery.Schema) (*Stream, error) {
+	messageDescriptor, err := newMessageDescriptor(schema)
 	if err != nil {
-		return nil, goerr.Wrap(err, "failed to convert schema to descriptor")
-	}
-	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
-	if !ok {
-		return nil, goerr.Wrap(err, "adapted descriptor is not a message descriptor")
+		return nil, err
 	}
 	descriptorProto, err := adapt.NormalizeDescriptor(messageDescriptor)
 	if err != nil {
@@ -60,40 +62,74 @@ func newStream(ctx context.Context, mwClient *mw.Client, projectID types.GoogleP
 	}, nil
 }
 
-func (x *Stream) Insert(ctx context.Context, data []any) error {
+func newMessageDescriptor(schema bigquery.Schema) (protoreflect.MessageDescriptor, error) {
+	convertedSchema, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to convert schema")
+	}
+
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(convertedSchema, "root")
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to convert schema to descriptor")
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, goerr.Wrap(err, "adapted descriptor is not a message descriptor")
+	}
+	return messageDescriptor, nil
+}
+
+// Insert appends data to the table by Storage Write API. It returns ID of the last append request given by the writer, which consists of the write stream name, trace ID of the writer and offset if available, because the default stream of the API has no ID per request.
+//
+// Storage Write API has no option like skipInvalidRows and ignoreUnknownValues of insertAll API, then opts are applied by the client. Unknown values are discarded when converting data to protobuf messages. Invalid rows are dropped when they can not be converted or are rejected by the API, and then the rest of rows are appended again.
+func (x *Stream) Insert(ctx context.Context, data []any, opts model.InsertOptions) (types.BQRequestID, error) {
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: opts.IgnoreUnknownValues}
+
 	var rows [][]byte
-	for _, v := range data {
+	for i, v := range data {
 		message := dynamicpb.NewMessage(x.msgDescriptor)
 
 		raw, err := json.Marshal(v)
 		if err != nil {
-			return goerr.Wrap(err, "failed to Marshal json message").With("v", v)
+			return "", goerr.Wrap(err, "failed to Marshal json message").With("v", v)
 		}
 
 		// First, json->proto message
-		err = protojson.Unmarshal(raw, message)
+		err = unmarshaler.Unmarshal(raw, message)
 		if err != nil {
-			return goerr.Wrap(err, "failed to Unmarshal json message").With("raw", string(raw))
+			if opts.SkipInvalidRows {
+				utils.CtxLogger(ctx).Warn("skip invalid row", "dataset", x.datasetID, "table", x.tableID, "index", i, "error", err.Error())
+				continue
+			}
+			return "", goerr.Wrap(types.ErrRecordSchemaMismatch, "failed to Unmarshal json message").With("raw", string(raw)).With("cause", err.Error())
 		}
 		// Then, proto message -> bytes.
 		b, err := proto.Marshal(message)
 		if err != nil {
-			return goerr.Wrap(err, "failed to Marshal proto message")
+			return "", goerr.Wrap(err, "failed to Marshal proto message")
 		}
 
 		rows = append(rows, b)
 	}
+	if len(rows) == 0 && len(data) > 0 {
+		return "", nil // all rows are skipped
+	}
 
 	// After updating BigQuery schema, there is a delay for propagation of the schema change. According to the following document, it takes about 10 minutes.
 	// https://issuetracker.google.com/issues/64329577#comment3
 	// Then, we wait for 15 minutes to avoid the schema propagation delay.
+	// If the table still lacks fields of the rows after the wait, the rows are rejected as unknown field.
+	var requestID types.BQRequestID
+	var extraFields bool
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
 	defer cancel()
 	if err := backoff(ctx, func(c int) (bool, error) {
-		w := x.mgr.Writer(ctx)
-		defer w.Release()
-
-		if err := w.Append(ctx, rows); err != nil {
+		id, err := x.mgr.Append(ctx, rows)
+		if id != "" {
+			requestID = id
+		}
+		extraFields = err == types.ErrSchemaNotMatched
+		if err != nil {
 			if err == types.ErrSchemaNotMatched {
 				// If schema does not matched, it seems reconnection of stream is required
 				if err := x.mgr.Renew(ctx); err != nil {
@@ -101,14 +137,60 @@ func (x *Stream) Insert(ctx context.Context, data []any) error {
 				}
 				return false, nil // retry
 			}
+
+			var rowErrs *model.InsertRowErrors
+			if errors.As(err, &rowErrs) {
+				if !opts.SkipInvalidRows {
+					return true, rowErrs // rows are rejected, no need to retry
+				}
+
+				// All rows in the request are rejected by any invalid row, then append valid rows again
+				valid := dropRejectedRows(rows, rowErrs)
+				if len(valid) == len(rows) {
+					return true, rowErrs
+				}
+				utils.CtxLogger(ctx).Warn("skip rejected rows", "dataset", x.datasetID, "table", x.tableID, "request_id", requestID, "error", rowErrs.Error())
+				rows = valid
+				return len(rows) == 0, nil
+			}
+
+			return true, err // failed to append, the rows are not inserted
 		}
 
 		return true, nil // done without error
 	}); err != nil {
-		return err
+		if extraFields && errors.Is(err, context.DeadlineExceeded) {
+			return requestID, unknownFieldErrors(len(rows))
+		}
+		return requestID, err
+	}
+
+	return requestID, nil
+}
+
+// unknownFieldErrors returns errors of all rows rejected because the table does not have fields of them.
+func unknownFieldErrors(n int) *model.InsertRowErrors {
+	rowErrs := &model.InsertRowErrors{}
+	for i := 0; i < n; i++ {
+		rowErrs.Errors = append(rowErrs.Errors, &model.InsertRowError{
+			Index:  i,
+			Code:   model.InsertRowErrorUnknownField,
+			Reason: "table does not have fields of the row",
+		})
 	}
+	return rowErrs
+}
 
-	return nil
+// dropRejectedRows returns rows except rows rejected in rowErrs.
+func dropRejectedRows(rows [][]byte, rowErrs *model.InsertRowErrors) [][]byte {
+	reasons := rowErrs.Reasons()
+	valid := make([][]byte, 0, len(rows))
+	for i, row := range rows {
+		if _, rejected := reasons[i]; !rejected {
+			valid = append(valid, row)
+		}
+	}
+	return valid
 }
 
 func (x *Stream) Close() error {
@@ -0,0 +1,108 @@
+package bq_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+)
+
+type mockWriter struct {
+	appendRows func(n int, rows [][]byte) error
+	appended   int
+	renewed    int
+}
+
+func (x *mockWriter) Append(ctx context.Context, rows [][]byte) (types.BQRequestID, error) {
+	x.appended++
+	return types.BQRequestID(fmt.Sprintf("projects/p/datasets/d/tables/t/streams/_default#swarm:%d", x.appended)), x.appendRows(x.appended, rows)
+}
+
+func (x *mockWriter) Renew(ctx context.Context) error {
+	x.renewed++
+	return nil
+}
+
+func (x *mockWriter) Close() error { return nil }
+
+func TestStreamInsert(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	}
+	data := []any{
+		map[string]any{"name": "blue"},
+		map[string]any{"name": "orange"},
+	}
+
+	t.Run("return error of append", func(t *testing.T) {
+		w := &mockWriter{
+			appendRows: func(n int, rows [][]byte) error {
+				return errors.New("connection reset")
+			},
+		}
+		stream := gt.R1(bq.NewStreamWithWriter("test-dataset", "test-table", schema, w)).NoError(t)
+
+		requestID, err := stream.Insert(context.Background(), data, model.InsertOptions{})
+		gt.Error(t, err)
+		gt.String(t, err.Error()).Contains("connection reset")
+		gt.Equal(t, requestID, "projects/p/datasets/d/tables/t/streams/_default#swarm:1")
+		gt.Equal(t, w.appended, 1)
+	})
+
+	t.Run("renew stream and retry if schema does not match", func(t *testing.T) {
+		w := &mockWriter{
+			appendRows: func(n int, rows [][]byte) error {
+				if n == 1 {
+					return types.ErrSchemaNotMatched
+				}
+				return nil
+			},
+		}
+		stream := gt.R1(bq.NewStreamWithWriter("test-dataset", "test-table", schema, w)).NoError(t)
+
+		// ID of the request given by writer is returned, not generated by the stream
+		requestID := gt.R1(stream.Insert(context.Background(), data, model.InsertOptions{})).NoError(t)
+		gt.Equal(t, requestID, "projects/p/datasets/d/tables/t/streams/_default#swarm:2")
+		gt.Equal(t, w.appended, 2)
+		gt.Equal(t, w.renewed, 1)
+	})
+
+	t.Run("reject rows as unknown field if schema does not match until timeout", func(t *testing.T) {
+		w := &mockWriter{
+			appendRows: func(n int, rows [][]byte) error {
+				return types.ErrSchemaNotMatched
+			},
+		}
+		stream := gt.R1(bq.NewStreamWithWriter("test-dataset", "test-table", schema, w)).NoError(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := stream.Insert(ctx, data, model.InsertOptions{})
+		var rowErrs *model.InsertRowErrors
+		gt.True(t, errors.As(err, &rowErrs))
+		gt.True(t, rowErrs.UnknownField())
+		gt.A(t, rowErrs.Errors).Length(2)
+	})
+
+	t.Run("append all rows", func(t *testing.T) {
+		var appended [][]byte
+		w := &mockWriter{
+			appendRows: func(n int, rows [][]byte) error {
+				appended = rows
+				return nil
+			},
+		}
+		stream := gt.R1(bq.NewStreamWithWriter("test-dataset", "test-table", schema, w)).NoError(t)
+
+		requestID := gt.R1(stream.Insert(context.Background(), data, model.InsertOptions{})).NoError(t)
+		gt.NotEqual(t, requestID, "")
+		gt.A(t, appended).Length(2)
+	})
+}
@@ -2,6 +2,7 @@ package writer
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sync"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/googleapis/gax-go/v2/apierror"
 	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/utils"
 	"google.golang.org/protobuf/types/descriptorpb"
@@ -32,6 +34,7 @@ type factory struct {
 }
 
 func (x *factory) newWriter(ctx context.Context) (*writer, error) {
+	traceID := "swarm:" + uuid.NewString()
 	ms, err := x.mwClient.NewManagedStream(ctx,
 		mw.WithDestinationTable(
 			mw.TableParentFromParts(
@@ -42,13 +45,15 @@ func (x *factory) newWriter(ctx context.Context) (*writer, error) {
 		),
 		// mw.WithType(mw.CommittedStream),
 		mw.WithSchemaDescriptor(x.proto),
+		// Trace ID is sent to BigQuery with requests of the connection to identify the writer
+		mw.WithTraceID(traceID),
 	)
 	if err != nil {
 		return nil, goerr.Wrap(err, "failed to create managed stream")
 	}
 
 	w := &writer{
-		id: uuid.NewString(),
+		id: traceID,
 		s:  ms,
 	}
 	utils.CtxLogger(ctx).Debug("created new writer", "writer_id", w.id)
@@ -125,24 +130,62 @@ func (x *Manager) Writer(ctx context.Context) *writer {
 	return x.currentWriter
 }
 
-func (x *writer) Append(ctx context.Context, rows [][]byte) error {
+// Append appends rows by the current writer and returns ID of the request. The writer is released after the append result is received.
+func (x *Manager) Append(ctx context.Context, rows [][]byte) (types.BQRequestID, error) {
+	w := x.Writer(ctx)
+	defer w.Release()
+	return w.Append(ctx, rows)
+}
+
+// Append appends rows to the managed stream. Storage Write API has no ID per request in the default stream, then the returned ID consists of values that BigQuery receives or returns: name of the write stream, trace ID of the writer and offset of the append if the stream returns it, e.g. "projects/p/datasets/d/tables/t/streams/_default#swarm:<uuid>". The ID is returned also with error if the request has been sent.
+func (x *writer) Append(ctx context.Context, rows [][]byte) (types.BQRequestID, error) {
+	utils.CtxLogger(ctx).Debug("append rows", "writer_id", x.id, "stream", x.s.StreamName(), "count", len(rows))
+
 	arResult, err := x.s.AppendRows(ctx, rows)
 	if err != nil {
-		return goerr.Wrap(err, "failed to append rows")
+		return "", goerr.Wrap(err, "failed to append rows").With("writer_id", x.id)
 	}
 
-	if _, err := arResult.FullResponse(ctx); err != nil {
+	resp, err := arResult.FullResponse(ctx)
+	requestID := types.BQRequestID(x.s.StreamName() + "#" + x.id)
+	if offset := resp.GetAppendResult().GetOffset(); offset != nil {
+		requestID = types.BQRequestID(fmt.Sprintf("%s@%d", requestID, offset.GetValue()))
+	}
+
+	if rowErrs := resp.GetRowErrors(); len(rowErrs) > 0 {
+		// When any row has error, all rows in the request are rejected
+		insertErr := &model.InsertRowErrors{}
+		for _, rowErr := range rowErrs {
+			insertErr.Errors = append(insertErr.Errors, &model.InsertRowError{
+				Index:  int(rowErr.GetIndex()),
+				Code:   rowErrorCode(rowErr.GetCode()),
+				Reason: rowErr.GetCode().String() + ": " + rowErr.GetMessage(),
+			})
+		}
+		return requestID, insertErr
+	}
+	if err != nil {
 		if apiErr, ok := apierror.FromError(err); ok {
 			storageErr := &storagepb.StorageError{}
 			if e := apiErr.Details().ExtractProtoMessage(storageErr); e == nil && storageErr.Code == storagepb.StorageError_SCHEMA_MISMATCH_EXTRA_FIELDS {
 				utils.CtxLogger(ctx).Debug("schema does not matched, should retry")
-				return types.ErrSchemaNotMatched
+				return requestID, types.ErrSchemaNotMatched
 			}
 		}
-		return goerr.Wrap(err, "failed to get append result")
+		return requestID, goerr.Wrap(err, "failed to get append result").With("request_id", requestID)
 	}
 
-	return nil
+	return requestID, nil
+}
+
+// rowErrorCode converts code of a row error of Storage Write API to InsertRowErrorCode.
+func rowErrorCode(code storagepb.RowError_RowErrorCode) model.InsertRowErrorCode {
+	switch code {
+	case storagepb.RowError_FIELDS_ERROR:
+		return model.InsertRowErrorFields
+	default:
+		return model.InsertRowErrorUnspecified
+	}
 }
 
 func (x *writer) Release() {
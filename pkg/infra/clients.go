@@ -9,6 +9,7 @@ type Clients struct {
 	bq     interfaces.BigQuery
 	cs     interfaces.CloudStorage
 	pubsub interfaces.PubSub
+	sub    interfaces.PubSubSubscription
 	policy *policy.Client
 	db     interfaces.Database
 }
@@ -22,11 +23,12 @@ func New(options ...Option) *Clients {
 	return c
 }
 
-func (x *Clients) BigQuery() interfaces.BigQuery         { return x.bq }
-func (x *Clients) CloudStorage() interfaces.CloudStorage { return x.cs }
-func (x *Clients) PubSub() interfaces.PubSub             { return x.pubsub }
-func (x *Clients) Policy() *policy.Client                { return x.policy }
-func (x *Clients) Database() interfaces.Database         { return x.db }
+func (x *Clients) BigQuery() interfaces.BigQuery                     { return x.bq }
+func (x *Clients) CloudStorage() interfaces.CloudStorage             { return x.cs }
+func (x *Clients) PubSub() interfaces.PubSub                         { return x.pubsub }
+func (x *Clients) PubSubSubscription() interfaces.PubSubSubscription { return x.sub }
+func (x *Clients) Policy() *policy.Client                            { return x.policy }
+func (x *Clients) Database() interfaces.Database                     { return x.db }
 
 type Option func(*Clients)
 
@@ -48,6 +50,12 @@ func WithPubSub(pubsub interfaces.PubSub) Option {
 	}
 }
 
+func WithPubSubSubscription(sub interfaces.PubSubSubscription) Option {
+	return func(c *Clients) {
+		c.sub = sub
+	}
+}
+
 func WithPolicy(policy *policy.Client) Option {
 	return func(c *Clients) {
 		c.policy = policy
@@ -26,11 +26,24 @@ func New(ctx context.Context) (*Client, error) {
 	}, nil
 }
 
+func (x *Client) Close() error {
+	if err := x.client.Close(); err != nil {
+		return goerr.Wrap(err, "failed to close storage client")
+	}
+	return nil
+}
+
+// object returns a handle of obj. It is pinned to the generation if obj has it.
+func (x *Client) object(obj model.CloudStorageObject) *storage.ObjectHandle {
+	handle := x.client.Bucket(obj.Bucket.String()).Object(obj.Name.String())
+	if obj.Generation != 0 {
+		handle = handle.Generation(obj.Generation)
+	}
+	return handle
+}
+
 func (x *Client) Open(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
-	r, err := x.client.
-		Bucket(obj.Bucket.String()).
-		Object(obj.Name.String()).
-		NewReader(ctx)
+	r, err := x.object(obj).NewReader(ctx)
 	if err != nil {
 		return nil, goerr.Wrap(err, "failed to create reader")
 	}
@@ -39,10 +52,7 @@ func (x *Client) Open(ctx context.Context, obj model.CloudStorageObject) (io.Rea
 }
 
 func (x *Client) Attrs(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
-	attrs, err := x.client.
-		Bucket(obj.Bucket.String()).
-		Object(obj.Name.String()).
-		Attrs(ctx)
+	attrs, err := x.object(obj).Attrs(ctx)
 	if err != nil {
 		return nil, goerr.Wrap(err, "failed to get object attributes")
 	}
@@ -54,4 +64,11 @@ func (x *Client) List(ctx context.Context, bucket types.CSBucket, query *storage
 	return x.client.Bucket(bucket.String()).Objects(ctx, query)
 }
 
+func (x *Client) NewWriter(ctx context.Context, obj model.CloudStorageObject) io.WriteCloser {
+	return x.client.
+		Bucket(obj.Bucket.String()).
+		Object(obj.Name.String()).
+		NewWriter(ctx)
+}
+
 var _ interfaces.CloudStorage = &Client{}
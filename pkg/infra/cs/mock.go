@@ -15,6 +15,8 @@ type Mock struct {
 	MockOpen  func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error)
 	MockAttrs func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error)
 	MockList  func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator
+
+	MockNewWriter func(ctx context.Context, obj model.CloudStorageObject) io.WriteCloser
 }
 
 type MockObjectIterator struct {
@@ -56,4 +58,11 @@ func (x *Mock) List(ctx context.Context, bucket types.CSBucket, query *storage.Q
 	return nil
 }
 
+func (x *Mock) NewWriter(ctx context.Context, obj model.CloudStorageObject) io.WriteCloser {
+	if x.MockNewWriter != nil {
+		return x.MockNewWriter(ctx, obj)
+	}
+	return nil
+}
+
 var _ interfaces.CloudStorage = &Mock{}
@@ -10,6 +10,7 @@ import (
 	"cloud.google.com/go/bigquery"
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 )
 
@@ -27,6 +28,16 @@ func (x *Client) GetMetadata(ctx context.Context, dataset types.BQDatasetID, tab
 	return &bigquery.TableMetadata{}, nil
 }
 
+// ListTables implements interfaces.BigQuery. Dumper has no table to list.
+func (x *Client) ListTables(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error) {
+	return nil, nil
+}
+
+// DeleteTable implements interfaces.BigQuery. Nothing to do in dumper.
+func (x *Client) DeleteTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error {
+	return nil
+}
+
 func (x *Client) NewStream(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, schema bigquery.Schema) (interfaces.BigQueryStream, error) {
 	return &Stream{}, nil
 }
@@ -35,8 +46,8 @@ func (x *Client) NewStream(ctx context.Context, datasetID types.BQDatasetID, tab
 type Stream struct {
 }
 
-func (x *Stream) Insert(ctx context.Context, data []any) error {
-	return nil
+func (x *Stream) Insert(ctx context.Context, data []any, opts model.InsertOptions) (types.BQRequestID, error) {
+	return "", nil
 }
 
 func (x *Stream) Close() error {
@@ -64,7 +75,7 @@ func (x *Client) Insert(ctx context.Context, datasetID types.BQDatasetID, tableI
 }
 
 // Query implements interfaces.BigQuery. It is not implemented and panics if called.
-func (x *Client) Query(ctx context.Context, query string) (interfaces.BigQueryIterator, error) {
+func (x *Client) Query(ctx context.Context, query string, params ...bigquery.QueryParameter) (interfaces.BigQueryIterator, error) {
 	panic("unimplemented, must not be called in dumper")
 }
 
@@ -2,6 +2,7 @@ package firestore
 
 import (
 	"context"
+	"net/url"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -90,6 +91,39 @@ func (x *Client) UpdateState(ctx context.Context, msgType types.MsgType, id stri
 	return nil
 }
 
+const watermarkCollection = "watermark"
+
+// GetWatermark returns the watermark of id. It returns nil if the watermark is not found.
+func (x *Client) GetWatermark(ctx context.Context, id string) (*model.Watermark, error) {
+	doc, err := x.client.Collection(watermarkCollection).Doc(watermarkDocID(id)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, goerr.Wrap(err, "failed to get watermark").With("id", id)
+	}
+
+	var wm model.Watermark
+	if err := doc.DataTo(&wm); err != nil {
+		return nil, goerr.Wrap(err, "failed to unmarshal watermark")
+	}
+
+	return &wm, nil
+}
+
+// PutWatermark saves the watermark. Existing watermark of the same ID is overwritten.
+func (x *Client) PutWatermark(ctx context.Context, wm *model.Watermark) error {
+	if _, err := x.client.Collection(watermarkCollection).Doc(watermarkDocID(wm.ID)).Set(ctx, wm); err != nil {
+		return goerr.Wrap(err, "failed to put watermark").With("id", wm.ID)
+	}
+	return nil
+}
+
+// watermarkDocID converts ID of watermark (e.g. gs://bucket/prefix/) to document ID because document ID can not contain "/".
+func watermarkDocID(id string) string {
+	return url.PathEscape(id)
+}
+
 func New(ctx context.Context, projectID string, databaseID string) (*Client, error) {
 	client, err := firestore.NewClientWithDatabase(ctx, projectID, databaseID)
 	if err != nil {
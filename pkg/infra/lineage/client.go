@@ -0,0 +1,119 @@
+package lineage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	datalineage "google.golang.org/api/datalineage/v1"
+)
+
+const processName = "swarm"
+
+// Client records Lineage into Data Lineage API of Dataplex (Data Catalog). A process of swarm is created at the first write, and a run with a lineage event from source objects to the table is created for each ingestion.
+type Client struct {
+	svc       *datalineage.Service
+	projectID types.GoogleProjectID
+	parent    string
+
+	mutex   sync.Mutex
+	process string
+}
+
+// New creates a Data Lineage client. projectID is a project of BigQuery tables, and lineage is recorded in location of the project, e.g. "us".
+func New(ctx context.Context, projectID types.GoogleProjectID, location string) (*Client, error) {
+	svc, err := datalineage.NewService(ctx)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to create data lineage client")
+	}
+
+	return &Client{
+		svc:       svc,
+		projectID: projectID,
+		parent:    "projects/" + projectID.String() + "/locations/" + location,
+	}, nil
+}
+
+// Write implements interfaces.LineageSink.
+func (x *Client) Write(ctx context.Context, lineage *model.Lineage) error {
+	process, err := x.getProcess(ctx)
+	if err != nil {
+		return err
+	}
+
+	state := "COMPLETED"
+	if !lineage.Success {
+		state = "FAILED"
+	}
+	run, err := x.svc.Projects.Locations.Processes.Runs.Create(process, &datalineage.GoogleCloudDatacatalogLineageV1Run{
+		DisplayName: string(lineage.IngestID),
+		StartTime:   lineage.StartedAt.UTC().Format(time.RFC3339Nano),
+		EndTime:     lineage.FinishedAt.UTC().Format(time.RFC3339Nano),
+		State:       state,
+	}).Context(ctx).Do()
+	if err != nil {
+		return goerr.Wrap(err, "failed to create lineage run").With("process", process).With("lineage", lineage)
+	}
+
+	target := &datalineage.GoogleCloudDatacatalogLineageV1EntityReference{
+		FullyQualifiedName: BigQueryFQN(x.projectID, lineage.Dataset, lineage.Table),
+	}
+	event := &datalineage.GoogleCloudDatacatalogLineageV1LineageEvent{
+		StartTime: lineage.StartedAt.UTC().Format(time.RFC3339Nano),
+		EndTime:   lineage.FinishedAt.UTC().Format(time.RFC3339Nano),
+	}
+	for _, src := range lineage.Sources {
+		event.Links = append(event.Links, &datalineage.GoogleCloudDatacatalogLineageV1EventLink{
+			Source: &datalineage.GoogleCloudDatacatalogLineageV1EntityReference{
+				FullyQualifiedName: CloudStorageFQN(src),
+			},
+			Target: target,
+		})
+	}
+
+	if _, err := x.svc.Projects.Locations.Processes.Runs.LineageEvents.Create(run.Name, event).Context(ctx).Do(); err != nil {
+		return goerr.Wrap(err, "failed to create lineage event").With("run", run.Name).With("lineage", lineage)
+	}
+
+	return nil
+}
+
+// getProcess returns name of the process of swarm. It is created at the first call.
+func (x *Client) getProcess(ctx context.Context) (string, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.process != "" {
+		return x.process, nil
+	}
+
+	process, err := x.svc.Projects.Locations.Processes.Create(x.parent, &datalineage.GoogleCloudDatacatalogLineageV1Process{
+		DisplayName: processName,
+		Origin: &datalineage.GoogleCloudDatacatalogLineageV1Origin{
+			SourceType: "CUSTOM",
+			Name:       processName,
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to create lineage process").With("parent", x.parent)
+	}
+
+	x.process = process.Name
+	return x.process, nil
+}
+
+// BigQueryFQN returns fully qualified name of the BigQuery table in Data Lineage.
+func BigQueryFQN(projectID types.GoogleProjectID, dataset types.BQDatasetID, table types.BQTableID) string {
+	return "bigquery:" + projectID.String() + "." + dataset.String() + "." + table.String()
+}
+
+// CloudStorageFQN returns fully qualified name of the Cloud Storage object in Data Lineage.
+func CloudStorageFQN(obj *model.CloudStorageObject) string {
+	return "gcs:" + obj.Bucket.String() + "." + obj.Name.String()
+}
+
+var _ interfaces.LineageSink = &Client{}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+// otelScope is instrumentation scope name of meter used by swarm.
+const otelScope = "github.com/m-mizutani/swarm"
+
+type otel struct {
+	meter otelmetric.Meter
+}
+
+// NewOTel returns interfaces.Metrics that emits metrics via the OpenTelemetry meter provider, e.g. otel.GetMeterProvider() or MeterProvider of the SDK configured with an exporter.
+func NewOTel(provider otelmetric.MeterProvider) interfaces.Metrics {
+	return &otel{meter: provider.Meter(otelScope)}
+}
+
+func (x *otel) Counter(name, help string, labels ...string) interfaces.MetricCounter {
+	counter, err := x.meter.Int64Counter(name, otelmetric.WithDescription(help))
+	if err != nil {
+		// Instrument is still usable even if err is returned, e.g. by invalid name
+		utils.HandleError(context.Background(), "failed to create OpenTelemetry counter", goerr.Wrap(err).With("name", name))
+	}
+	return &otelCounter{counter: counter, labels: labels}
+}
+
+func (x *otel) GaugeFunc(name, help string, fn func() float64) {
+	_, err := x.meter.Float64ObservableGauge(name,
+		otelmetric.WithDescription(help),
+		otelmetric.WithFloat64Callback(func(ctx context.Context, o otelmetric.Float64Observer) error {
+			o.Observe(fn())
+			return nil
+		}),
+	)
+	if err != nil {
+		utils.HandleError(context.Background(), "failed to create OpenTelemetry gauge", goerr.Wrap(err).With("name", name))
+	}
+}
+
+type otelCounter struct {
+	counter otelmetric.Int64Counter
+	labels  []string
+}
+
+// Add adds n to the counter with attributes of labels and values. Missing values are treated as empty like CounterVec.
+func (x *otelCounter) Add(n int64, values ...string) {
+	if x.counter == nil || n < 0 {
+		return
+	}
+
+	attrs := make([]attribute.KeyValue, len(x.labels))
+	for i, label := range x.labels {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		attrs[i] = attribute.String(label, v)
+	}
+	x.counter.Add(context.Background(), n, otelmetric.WithAttributes(attrs...))
+}
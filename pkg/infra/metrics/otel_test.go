@@ -0,0 +1,53 @@
+package metrics_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/infra/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTel(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m := metrics.NewOTel(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	c := m.Counter("test_count_total", "Test counter")
+	c.Add(3)
+	c.Add(1)
+	vec := m.Counter("test_ingests_total", "Test counter vec", "table", "result")
+	vec.Add(2, "logs.access", "success")
+	vec.Add(1, "logs.access", "failure")
+	m.GaugeFunc("test_func", "Test function", func() float64 { return 42 })
+
+	var rm metricdata.ResourceMetrics
+	gt.NoError(t, reader.Collect(context.Background(), &rm))
+	gt.A(t, rm.ScopeMetrics).Length(1)
+
+	records := map[string]metricdata.Aggregation{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		records[m.Name] = m.Data
+	}
+
+	sum := gt.Cast[metricdata.Sum[int64]](t, records["test_count_total"])
+	gt.True(t, sum.IsMonotonic)
+	gt.A(t, sum.DataPoints).Length(1)
+	gt.Equal(t, sum.DataPoints[0].Value, 4)
+
+	vecSum := gt.Cast[metricdata.Sum[int64]](t, records["test_ingests_total"])
+	values := map[attribute.Distinct]int64{}
+	for _, dp := range vecSum.DataPoints {
+		values[dp.Attributes.Equivalent()] = dp.Value
+	}
+	success := attribute.NewSet(attribute.String("table", "logs.access"), attribute.String("result", "success"))
+	failure := attribute.NewSet(attribute.String("table", "logs.access"), attribute.String("result", "failure"))
+	gt.Equal(t, values[success.Equivalent()], 2)
+	gt.Equal(t, values[failure.Equivalent()], 1)
+
+	gauge := gt.Cast[metricdata.Gauge[float64]](t, records["test_func"])
+	gt.A(t, gauge.DataPoints).Length(1)
+	gt.Equal(t, gauge.DataPoints[0].Value, 42)
+}
@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// NewOTLP returns interfaces.Metrics that exports metrics periodically to OTLP/HTTP endpoint via OpenTelemetry SDK. The endpoint and other settings are configured by OTEL_EXPORTER_OTLP_* environment variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT. The returned function must be called before exit to flush remaining metrics.
+func NewOTLP(ctx context.Context) (interfaces.Metrics, func(ctx context.Context) error, error) {
+	exporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return nil, nil, goerr.Wrap(err, "failed to create OTLP metric exporter")
+	}
+
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	return NewOTel(provider), provider.Shutdown, nil
+}
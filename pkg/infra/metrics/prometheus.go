@@ -0,0 +1,36 @@
+package metrics
+
+import "github.com/m-mizutani/swarm/pkg/domain/interfaces"
+
+type prometheus struct {
+	reg *Registry
+}
+
+// NewPrometheus returns interfaces.Metrics that creates metrics in the registry exposed in Prometheus text format.
+func NewPrometheus(reg *Registry) interfaces.Metrics {
+	return &prometheus{reg: reg}
+}
+
+func (x *prometheus) Counter(name, help string, labels ...string) interfaces.MetricCounter {
+	if len(labels) == 0 {
+		return &prometheusCounter{counter: x.reg.Counter(name, help)}
+	}
+	return &prometheusCounter{vec: x.reg.CounterVec(name, help, labels...)}
+}
+
+func (x *prometheus) GaugeFunc(name, help string, fn func() float64) {
+	x.reg.GaugeFunc(name, help, fn)
+}
+
+type prometheusCounter struct {
+	counter *Counter
+	vec     *CounterVec
+}
+
+func (x *prometheusCounter) Add(n int64, values ...string) {
+	if x.vec != nil {
+		x.vec.With(values...).Add(n)
+		return
+	}
+	x.counter.Add(n)
+}
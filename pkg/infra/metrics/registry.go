@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry is a set of metrics exposed in Prometheus text format. It is not a complete implementation of Prometheus client, but enough to watch progress of long running process.
+type Registry struct {
+	mutex   sync.RWMutex
+	metrics map[string]metric
+}
+
+type metricType string
+
+const (
+	counterType metricType = "counter"
+	gaugeType   metricType = "gauge"
+)
+
+type metric interface {
+	help() string
+	metricType() metricType
+	samples() []sample
+}
+
+// sample is a value of metric. labels is formatted label pairs without braces, e.g. `table="logs.access"`, and empty for metric without label.
+type sample struct {
+	labels string
+	value  float64
+}
+
+func New() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Counter returns a counter metric with the name. If the name is already registered as counter, it returns the same counter.
+func (x *Registry) Counter(name, help string) *Counter {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if c, ok := x.metrics[name].(*Counter); ok {
+		return c
+	}
+	c := &Counter{desc: help}
+	x.metrics[name] = c
+	return c
+}
+
+// CounterVec returns a set of counter metrics partitioned by values of labels. If the name is already registered as CounterVec, it returns the same one.
+func (x *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if c, ok := x.metrics[name].(*CounterVec); ok {
+		return c
+	}
+	c := &CounterVec{desc: help, labels: labels, counters: make(map[string]*Counter)}
+	x.metrics[name] = c
+	return c
+}
+
+// Gauge returns a gauge metric with the name. If the name is already registered as gauge, it returns the same gauge.
+func (x *Registry) Gauge(name, help string) *Gauge {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if g, ok := x.metrics[name].(*Gauge); ok {
+		return g
+	}
+	g := &Gauge{desc: help}
+	x.metrics[name] = g
+	return g
+}
+
+// GaugeFunc registers a gauge metric that is calculated by fn when metrics are exported.
+func (x *Registry) GaugeFunc(name, help string, fn func() float64) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	x.metrics[name] = &gaugeFunc{desc: help, fn: fn}
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format.
+func (x *Registry) WriteTo(w io.Writer) (int64, error) {
+	x.mutex.RLock()
+	names := make([]string, 0, len(x.metrics))
+	for name := range x.metrics {
+		names = append(names, name)
+	}
+	x.mutex.RUnlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		x.mutex.RLock()
+		m := x.metrics[name]
+		x.mutex.RUnlock()
+
+		if m.help() != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, m.help())
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, m.metricType())
+		for _, s := range m.samples() {
+			if s.labels == "" {
+				fmt.Fprintf(&b, "%s %s\n", name, formatValue(s.value))
+			} else {
+				fmt.Fprintf(&b, "%s{%s} %s\n", name, s.labels, formatValue(s.value))
+			}
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP implements http.Handler to expose metrics.
+func (x *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = x.WriteTo(w)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		return fmt.Sprintf("%d", int64(v))
+	default:
+		return fmt.Sprintf("%g", v)
+	}
+}
+
+// Counter is a monotonically increasing metric.
+type Counter struct {
+	desc string
+	v    atomic.Int64
+}
+
+func (x *Counter) Add(n int64) {
+	if x == nil || n < 0 {
+		return
+	}
+	x.v.Add(n)
+}
+
+func (x *Counter) Inc() { x.Add(1) }
+
+func (x *Counter) Value() int64 {
+	if x == nil {
+		return 0
+	}
+	return x.v.Load()
+}
+
+func (x *Counter) help() string           { return x.desc }
+func (x *Counter) metricType() metricType { return counterType }
+func (x *Counter) samples() []sample      { return []sample{{value: float64(x.Value())}} }
+
+// CounterVec is a set of counters partitioned by label values, e.g. counters of each destination table.
+type CounterVec struct {
+	desc   string
+	labels []string
+
+	mutex    sync.Mutex
+	counters map[string]*Counter
+}
+
+// With returns a counter of the label values. values must be given in the same order as labels of CounterVec, and missing values are treated as empty. It returns nil (no-op counter) if x is nil.
+func (x *CounterVec) With(values ...string) *Counter {
+	if x == nil {
+		return nil
+	}
+
+	pairs := make([]string, len(x.labels))
+	for i, label := range x.labels {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = fmt.Sprintf(`%s="%s"`, label, escapeLabelValue(v))
+	}
+	key := strings.Join(pairs, ",")
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	c, ok := x.counters[key]
+	if !ok {
+		c = &Counter{}
+		x.counters[key] = c
+	}
+	return c
+}
+
+func (x *CounterVec) help() string           { return x.desc }
+func (x *CounterVec) metricType() metricType { return counterType }
+func (x *CounterVec) samples() []sample {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	samples := make([]sample, 0, len(x.counters))
+	for key, c := range x.counters {
+		samples = append(samples, sample{labels: key, value: float64(c.Value())})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+	return samples
+}
+
+// escapeLabelValue escapes backslash, double quote and line feed in a label value by rules of Prometheus text format.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	desc string
+	bits atomic.Uint64
+}
+
+func (x *Gauge) Set(v float64) {
+	if x == nil {
+		return
+	}
+	x.bits.Store(math.Float64bits(v))
+}
+
+func (x *Gauge) Add(d float64) {
+	if x == nil {
+		return
+	}
+	for {
+		old := x.bits.Load()
+		if x.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+func (x *Gauge) Value() float64 {
+	if x == nil {
+		return 0
+	}
+	return math.Float64frombits(x.bits.Load())
+}
+
+func (x *Gauge) help() string           { return x.desc }
+func (x *Gauge) metricType() metricType { return gaugeType }
+func (x *Gauge) samples() []sample      { return []sample{{value: x.Value()}} }
+
+type gaugeFunc struct {
+	desc string
+	fn   func() float64
+}
+
+func (x *gaugeFunc) help() string           { return x.desc }
+func (x *gaugeFunc) metricType() metricType { return gaugeType }
+func (x *gaugeFunc) samples() []sample      { return []sample{{value: x.fn()}} }
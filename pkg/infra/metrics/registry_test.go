@@ -0,0 +1,68 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/infra/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := metrics.New()
+	c := reg.Counter("test_count_total", "Test counter")
+	c.Add(3)
+	c.Inc()
+	gt.Equal(t, reg.Counter("test_count_total", "Test counter"), c)
+
+	g := reg.Gauge("test_gauge", "")
+	g.Set(1.5)
+	g.Add(1)
+
+	reg.GaugeFunc("test_func", "Test function", func() float64 { return 42 })
+
+	var buf bytes.Buffer
+	gt.R1(reg.WriteTo(&buf)).NoError(t)
+	gt.Equal(t, buf.String(), `# HELP test_count_total Test counter
+# TYPE test_count_total counter
+test_count_total 4
+# HELP test_func Test function
+# TYPE test_func gauge
+test_func 42
+# TYPE test_gauge gauge
+test_gauge 2.5
+`)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var c *metrics.Counter
+	c.Inc()
+	gt.Equal(t, c.Value(), 0)
+
+	var g *metrics.Gauge
+	g.Set(1)
+	gt.Equal(t, g.Value(), 0)
+}
+
+func TestCounterVec(t *testing.T) {
+	reg := metrics.New()
+	vec := reg.CounterVec("test_ingests_total", "Test counter vec", "table", "result")
+	vec.With("logs.access", "success").Inc()
+	vec.With("logs.access", "success").Inc()
+	vec.With("logs.access", "failure").Inc()
+	vec.With(`a"b\c`, "success").Inc()
+	gt.Equal(t, reg.CounterVec("test_ingests_total", "Test counter vec", "table", "result"), vec)
+
+	var buf bytes.Buffer
+	gt.R1(reg.WriteTo(&buf)).NoError(t)
+	gt.Equal(t, buf.String(), `# HELP test_ingests_total Test counter vec
+# TYPE test_ingests_total counter
+test_ingests_total{table="a\"b\\c",result="success"} 1
+test_ingests_total{table="logs.access",result="failure"} 1
+test_ingests_total{table="logs.access",result="success"} 2
+`)
+
+	var nilVec *metrics.CounterVec
+	nilVec.With("x").Inc()
+	gt.Equal(t, nilVec.With("x").Value(), 0)
+}
@@ -1,16 +1,21 @@
 package policy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 
 	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/topdown/print"
 )
 
@@ -156,8 +161,88 @@ func (x *Client) Query(ctx context.Context, query string, input interface{}, out
 
 	rs, err := rego.New(regoOpt...).Eval(ctx)
 	if err != nil {
-		return goerr.Wrap(err, "fail to eval local policy").With("input", input)
+		return x.wrapEvalError(err).With("input", input)
 	}
+
+	return decodeResultSet(rs, output)
+}
+
+// QueryBatch evaluates schema policy for each of `inputs` with a query prepared only once. The result of inputs[i] is written to outputs[i]. Length of outputs must be same as inputs.
+func (x *Client) QueryBatch(ctx context.Context, query string, inputs []any, outputs []*model.SchemaPolicyOutput, options ...QueryOption) error {
+	if len(inputs) != len(outputs) {
+		return goerr.Wrap(types.ErrAssertion, "length of inputs and outputs must be same").With("inputs", len(inputs)).With("outputs", len(outputs))
+	}
+	cfg := newQueryConfig(options...)
+
+	regoOpt := []func(r *rego.Rego){
+		rego.Query(query),
+		rego.Compiler(x.compiler),
+	}
+	if cfg.regoPrint != nil {
+		regoOpt = append(regoOpt, rego.PrintHook(&regoPrintHook{
+			callback: cfg.regoPrint,
+		}))
+	}
+
+	prepared, err := rego.New(regoOpt...).PrepareForEval(ctx)
+	if err != nil {
+		return goerr.Wrap(err, "fail to prepare local policy").With("query", query)
+	}
+
+	for i, input := range inputs {
+		rs, err := prepared.Eval(ctx, rego.EvalInput(input))
+		if err != nil {
+			return x.wrapEvalError(err).With("input", input)
+		}
+
+		outputs[i] = &model.SchemaPolicyOutput{}
+		if err := decodeResultSet(rs, outputs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wrapEvalError wraps error of rego.Eval. If it is a structured error of OPA, it is converted to model.PolicyError with the rule of the location, so that operators can find which rule and line failed.
+func (x *Client) wrapEvalError(err error) *goerr.Error {
+	var topdownErr *topdown.Error
+	if !errors.As(err, &topdownErr) {
+		return goerr.Wrap(err, "fail to eval local policy")
+	}
+
+	policyErr := &model.PolicyError{
+		Code:    topdownErr.Code,
+		Message: topdownErr.Message,
+	}
+	if loc := topdownErr.Location; loc != nil {
+		policyErr.Location = fmt.Sprintf("%s:%d", loc.File, loc.Row)
+		policyErr.Rule = x.findRule(loc)
+	}
+
+	return goerr.Wrap(policyErr, "fail to eval local policy").
+		With("code", policyErr.Code).
+		With("location", policyErr.Location).
+		With("rule", policyErr.Rule)
+}
+
+// findRule returns full path of the rule that contains loc. It returns empty string if not found.
+func (x *Client) findRule(loc *ast.Location) string {
+	for _, module := range x.compiler.Modules {
+		for _, rule := range module.Rules {
+			if rule.Location == nil || rule.Location.File != loc.File {
+				continue
+			}
+			lastRow := rule.Location.Row + bytes.Count(rule.Location.Text, []byte("\n"))
+			if rule.Location.Row <= loc.Row && loc.Row <= lastRow {
+				return rule.Ref().String()
+			}
+		}
+	}
+	return ""
+}
+
+func decodeResultSet(rs rego.ResultSet, output any) error {
 	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
 		return goerr.Wrap(types.ErrNoPolicyResult)
 	}
@@ -166,7 +251,10 @@ func (x *Client) Query(ctx context.Context, query string, input interface{}, out
 	if err != nil {
 		return goerr.Wrap(err, "fail to marshal a result of rego.Eval").With("rs", rs)
 	}
-	if err := json.Unmarshal(raw, output); err != nil {
+	// Numbers in log data are decoded as json.Number to keep precision of large integers.
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(output); err != nil {
 		return goerr.Wrap(err, "fail to unmarshal a result of rego.Eval to out").With("rs", rs)
 	}
 
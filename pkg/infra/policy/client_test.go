@@ -2,10 +2,15 @@ package policy_test
 
 import (
 	"context"
+	"errors"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra/policy"
 )
 
@@ -114,3 +119,67 @@ func TestClient_Query_NoResult(t *testing.T) {
 	err = client.Query(ctx, "data", input, &output)
 	gt.Error(t, err)
 }
+
+func TestClient_Query_PolicyError(t *testing.T) {
+	const brokenPolicy = `package schema.broken
+
+table = "a" { input.kind == "x" }
+
+table = "b" { input.kind == "x" }
+
+log[{"dataset": "my_dataset", "table": table, "timestamp": 1, "data": input}]
+`
+	client := gt.R1(policy.New(policy.WithPolicyData("broken.rego", brokenPolicy))).NoError(t)
+
+	var output model.SchemaPolicyOutput
+	err := client.Query(context.Background(), "data.schema.broken", map[string]any{"kind": "x"}, &output)
+	gt.Error(t, err)
+
+	var policyErr *model.PolicyError
+	gt.True(t, errors.As(err, &policyErr))
+	gt.Equal(t, policyErr.Code, "eval_conflict_error")
+	gt.True(t, regexp.MustCompile(`^broken\.rego:\d+$`).MatchString(policyErr.Location))
+	gt.True(t, strings.HasPrefix(policyErr.Rule, "data.schema.broken."))
+	gt.String(t, err.Error()).Contains(policyErr.Location)
+
+	// No error for input not causing conflict
+	gt.NoError(t, client.Query(context.Background(), "data.schema.broken", map[string]any{"kind": "y"}, &output))
+}
+
+func TestClient_QueryBatch(t *testing.T) {
+	const schemaPolicy = `package schema.test
+
+log[{
+	"dataset": "my_dataset",
+	"table": "my_table",
+	"timestamp": input.ts,
+	"data": input,
+}] {
+	input.ts > 0
+}
+`
+	client := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+	ctx := context.Background()
+
+	inputs := []any{
+		map[string]any{"ts": 100, "name": "a"},
+		map[string]any{"ts": 0, "name": "b"},
+		map[string]any{"ts": 200, "name": "c"},
+	}
+
+	outputs := make([]*model.SchemaPolicyOutput, len(inputs))
+	gt.NoError(t, client.QueryBatch(ctx, "data.schema.test", inputs, outputs))
+
+	for i, input := range inputs {
+		var expected model.SchemaPolicyOutput
+		gt.NoError(t, client.Query(ctx, "data.schema.test", input, &expected))
+		gt.Equal(t, *outputs[i], expected)
+	}
+	gt.A(t, outputs[0].Logs).Length(1)
+	gt.A(t, outputs[1].Logs).Length(0)
+
+	t.Run("length mismatch", func(t *testing.T) {
+		err := client.QueryBatch(ctx, "data.schema.test", inputs, make([]*model.SchemaPolicyOutput, 1))
+		gt.True(t, errors.Is(err, types.ErrAssertion))
+	})
+}
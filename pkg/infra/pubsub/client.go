@@ -21,7 +21,7 @@ func New(ctx context.Context, projectID types.GoogleProjectID, topicID types.Pub
 	return &Client{topic: topic}, nil
 }
 
-func (x *Client) Publish(ctx context.Context, data []byte) (types.PubSubMessageID, error) {
-	msgID, err := x.topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+func (x *Client) Publish(ctx context.Context, data []byte, attrs map[string]string) (types.PubSubMessageID, error) {
+	msgID, err := x.topic.Publish(ctx, &pubsub.Message{Data: data, Attributes: attrs}).Get(ctx)
 	return types.PubSubMessageID(msgID), err
 }
@@ -1,29 +1,121 @@
 package pubsub
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 )
 
+const (
+	dumpFileExt = ".msg"
+	gzipFileExt = ".gz"
+)
+
 type Dumper struct {
 	outDir string
+	gzip   bool
+}
+
+type DumperOption func(*Dumper)
+
+// WithDumpGzip makes Dumper write gzip compressed files with ".msg.gz" extension.
+func WithDumpGzip() DumperOption {
+	return func(x *Dumper) {
+		x.gzip = true
+	}
 }
 
-func NewDumper(outDir string) *Dumper {
-	return &Dumper{outDir: outDir}
+func NewDumper(outDir string, options ...DumperOption) *Dumper {
+	x := &Dumper{outDir: outDir}
+	for _, opt := range options {
+		opt(x)
+	}
+	return x
 }
 
-func (x *Dumper) Publish(ctx context.Context, data []byte) (types.PubSubMessageID, error) {
+// Publish writes data into a file in outDir. Attributes are not dumped.
+func (x *Dumper) Publish(ctx context.Context, data []byte, attrs map[string]string) (types.PubSubMessageID, error) {
 	id := types.PubSubMessageID(uuid.NewString())
 
-	path := filepath.Clean(filepath.Join(x.outDir, string(id)+".msg"))
+	fileName := string(id) + dumpFileExt
+	if x.gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return "", goerr.Wrap(err, "failed to compress message")
+		}
+		if err := w.Close(); err != nil {
+			return "", goerr.Wrap(err, "failed to close gzip writer")
+		}
+		data = buf.Bytes()
+		fileName += gzipFileExt
+	}
+
+	path := filepath.Clean(filepath.Join(x.outDir, fileName))
 	if err := os.WriteFile(path, data, 0600); err != nil {
 		return "", err
 	}
 
 	return id, nil
 }
+
+// ReadDump reads messages dumped by Dumper in dir to replay them. Both plain (".msg") and gzip compressed (".msg.gz") files are read transparently. Messages are returned in order of file name.
+func ReadDump(dir string) ([][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to read dump directory").With("dir", dir)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && (strings.HasSuffix(name, dumpFileExt) || strings.HasSuffix(name, dumpFileExt+gzipFileExt)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var messages [][]byte
+	for _, name := range names {
+		data, err := readDumpFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, data)
+	}
+
+	return messages, nil
+}
+
+func readDumpFile(path string) ([]byte, error) {
+	fd, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to open dump file").With("path", path)
+	}
+	defer fd.Close()
+
+	var r io.Reader = fd
+	if strings.HasSuffix(path, gzipFileExt) {
+		gz, err := gzip.NewReader(fd)
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to open gzip dump file").With("path", path)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to read dump file").With("path", path)
+	}
+	return data, nil
+}
@@ -0,0 +1,58 @@
+package pubsub_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
+)
+
+func TestDumperReplay(t *testing.T) {
+	testCases := map[string]struct {
+		options []pubsub.DumperOption
+		ext     string
+	}{
+		"plain": {
+			ext: ".msg",
+		},
+		"gzip": {
+			options: []pubsub.DumperOption{pubsub.WithDumpGzip()},
+			ext:     ".msg.gz",
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			dumper := pubsub.NewDumper(dir, tc.options...)
+
+			messages := map[string]bool{
+				`{"objects":[{"cs":{"bucket":"b","name":"a.log"}}]}`: true,
+				`{"objects":[{"cs":{"bucket":"b","name":"b.log"}}]}`: true,
+			}
+			for msg := range messages {
+				gt.R1(dumper.Publish(ctx, []byte(msg), nil)).NoError(t)
+			}
+
+			entries := gt.R1(os.ReadDir(dir)).NoError(t)
+			gt.A(t, entries).Length(2)
+			for _, entry := range entries {
+				gt.True(t, strings.HasSuffix(entry.Name(), tc.ext))
+			}
+
+			// Ignore other files in the directory
+			gt.NoError(t, os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a message"), 0600))
+
+			replayed := gt.R1(pubsub.ReadDump(dir)).NoError(t)
+			gt.A(t, replayed).Length(2)
+			for _, data := range replayed {
+				gt.True(t, messages[string(data)])
+			}
+		})
+	}
+}
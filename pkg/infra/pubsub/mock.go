@@ -2,33 +2,68 @@ package pubsub
 
 import (
 	"context"
+	"sync"
 
 	"github.com/google/uuid"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 )
 
 type Mock struct {
-	MockPublish func(ctx context.Context, data []byte) (types.PubSubMessageID, error)
+	MockPublish func(ctx context.Context, data []byte, attrs map[string]string) (types.PubSubMessageID, error)
 	Results     []*MockResult
 }
 
 type MockResult struct {
-	ID   types.PubSubMessageID
-	Data []byte
+	ID    types.PubSubMessageID
+	Data  []byte
+	Attrs map[string]string
 }
 
 func NewMock() *Mock {
 	mock := &Mock{}
-	mock.MockPublish = func(ctx context.Context, data []byte) (types.PubSubMessageID, error) {
+	mock.MockPublish = func(ctx context.Context, data []byte, attrs map[string]string) (types.PubSubMessageID, error) {
 		mock.Results = append(mock.Results, &MockResult{
-			ID:   types.PubSubMessageID(uuid.NewString()),
-			Data: data,
+			ID:    types.PubSubMessageID(uuid.NewString()),
+			Data:  data,
+			Attrs: attrs,
 		})
 		return mock.Results[len(mock.Results)-1].ID, nil
 	}
 	return mock
 }
 
-func (x *Mock) Publish(ctx context.Context, data []byte) (types.PubSubMessageID, error) {
-	return x.MockPublish(ctx, data)
+func (x *Mock) Publish(ctx context.Context, data []byte, attrs map[string]string) (types.PubSubMessageID, error) {
+	return x.MockPublish(ctx, data, attrs)
+}
+
+// MockSubscription delivers Messages to handler in order, and records acked and nacked message IDs.
+type MockSubscription struct {
+	Messages []*MockMessage
+
+	mutex  sync.Mutex
+	Acked  []types.PubSubMessageID
+	Nacked []types.PubSubMessageID
+}
+
+type MockMessage struct {
+	ID    types.PubSubMessageID
+	Data  []byte
+	Attrs map[string]string
+}
+
+func (x *MockSubscription) Receive(ctx context.Context, handler interfaces.PubSubHandler) error {
+	for _, msg := range x.Messages {
+		err := handler(ctx, msg.ID, msg.Data, msg.Attrs)
+
+		x.mutex.Lock()
+		if err != nil {
+			x.Nacked = append(x.Nacked, msg.ID)
+		} else {
+			x.Acked = append(x.Acked, msg.ID)
+		}
+		x.mutex.Unlock()
+	}
+
+	return nil
 }
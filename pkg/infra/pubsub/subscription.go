@@ -0,0 +1,45 @@
+package pubsub
+
+import (
+	"context"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+type Subscription struct {
+	sub *pubsub.Subscription
+}
+
+// NewSubscription creates a pull subscriber of the subscription. concurrency is max number of messages handled at the same time.
+func NewSubscription(ctx context.Context, projectID types.GoogleProjectID, subID types.PubSubSubscriptionID, concurrency int) (*Subscription, error) {
+	client, err := pubsub.NewClient(ctx, projectID.String())
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to create Pub/Sub client").With("projectID", projectID)
+	}
+
+	sub := client.Subscription(subID.String())
+	if concurrency > 0 {
+		sub.ReceiveSettings.MaxOutstandingMessages = concurrency
+	}
+
+	return &Subscription{sub: sub}, nil
+}
+
+// Receive implements interfaces.PubSubSubscription.
+func (x *Subscription) Receive(ctx context.Context, handler interfaces.PubSubHandler) error {
+	err := x.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		if err := handler(ctx, types.PubSubMessageID(msg.ID), msg.Data, msg.Attributes); err != nil {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		return goerr.Wrap(err, "failed to receive Pub/Sub messages").With("subscription", x.sub.ID())
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// CloudStorage writes LoadLog as a JSON object into Cloud Storage. The object name is "{prefix}{yyyy}/{mm}/{dd}/{request ID}.json" by started_at of LoadLog.
+type CloudStorage struct {
+	client interfaces.CloudStorage
+	bucket types.CSBucket
+	prefix string
+}
+
+func NewCloudStorage(client interfaces.CloudStorage, bucket types.CSBucket, prefix string) *CloudStorage {
+	return &CloudStorage{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+func (x *CloudStorage) Write(ctx context.Context, log *model.LoadLog) error {
+	obj := model.CloudStorageObject{
+		Bucket: x.bucket,
+		Name:   types.CSObjectID(x.prefix + path.Join(log.StartedAt.UTC().Format("2006/01/02"), string(log.ID)+".json")),
+	}
+
+	w := x.client.NewWriter(ctx, obj)
+	if err := json.NewEncoder(w).Encode(log); err != nil {
+		_ = w.Close()
+		return goerr.Wrap(err, "failed to write LoadLog to Cloud Storage").With("obj", obj)
+	}
+	if err := w.Close(); err != nil {
+		return goerr.Wrap(err, "failed to close Cloud Storage writer").With("obj", obj)
+	}
+
+	return nil
+}
+
+// Writer writes LoadLog as a JSON line into io.Writer such as os.Stdout.
+type Writer struct {
+	mutex        sync.Mutex
+	w            io.Writer
+	cloudLogging bool
+}
+
+type WriterOption func(*Writer)
+
+// WithCloudLogging adds "severity" and "message" fields of Cloud Logging structured log to the JSON line, so that the whole LoadLog is ingested as one log entry (jsonPayload) from stdout of Cloud Run and can be routed by its content. Severity is ERROR if the load failed, otherwise INFO.
+func WithCloudLogging() WriterOption {
+	return func(x *Writer) {
+		x.cloudLogging = true
+	}
+}
+
+func NewWriter(w io.Writer, options ...WriterOption) *Writer {
+	x := &Writer{w: w}
+	for _, opt := range options {
+		opt(x)
+	}
+	return x
+}
+
+// cloudLoggingEntry is LoadLog with special fields of Cloud Logging structured log.
+type cloudLoggingEntry struct {
+	*model.LoadLog
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (x *Writer) Write(ctx context.Context, log *model.LoadLog) error {
+	var v any = log
+	if x.cloudLogging {
+		severity := "INFO"
+		if !log.Success {
+			severity = "ERROR"
+		}
+		v = &cloudLoggingEntry{LoadLog: log, Severity: severity, Message: "load log"}
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if err := json.NewEncoder(x.w).Encode(v); err != nil {
+		return goerr.Wrap(err, "failed to write LoadLog")
+	}
+	return nil
+}
+
+var (
+	_ interfaces.LoadLogSink = &CloudStorage{}
+	_ interfaces.LoadLogSink = &Writer{}
+)
@@ -0,0 +1,78 @@
+package sink_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/infra/sink"
+)
+
+func TestWriter(t *testing.T) {
+	ctx := context.Background()
+	log := &model.LoadLog{
+		ID:         "req-1",
+		StartedAt:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		FinishedAt: time.Date(2024, 3, 1, 0, 0, 1, 0, time.UTC),
+		Sources: []*model.SourceLog{
+			{
+				CS:       &model.CloudStorageObject{Bucket: "my-bucket", Name: "1.log"},
+				RowCount: 3,
+			},
+		},
+		Ingests: []*model.IngestLog{
+			{LogCount: 3},
+		},
+		Error: "failed to ingest",
+	}
+
+	t.Run("LoadLog as a JSON line", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := sink.NewWriter(&buf)
+		gt.NoError(t, w.Write(ctx, log))
+
+		gt.Equal(t, strings.Count(buf.String(), "\n"), 1)
+		var actual model.LoadLog
+		gt.NoError(t, json.Unmarshal(buf.Bytes(), &actual))
+		gt.Equal(t, actual.ID, log.ID)
+		gt.Equal(t, actual.Error, log.Error)
+		gt.A(t, actual.Sources).Length(1).At(0, func(t testing.TB, v *model.SourceLog) {
+			gt.Equal(t, v.CS, log.Sources[0].CS)
+			gt.Equal(t, v.RowCount, 3)
+		})
+		gt.A(t, actual.Ingests).Length(1)
+
+		var fields map[string]any
+		gt.NoError(t, json.Unmarshal(buf.Bytes(), &fields))
+		gt.Equal(t, fields["severity"], nil)
+	})
+
+	t.Run("LoadLog as a structured log entry of Cloud Logging", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := sink.NewWriter(&buf, sink.WithCloudLogging())
+		gt.NoError(t, w.Write(ctx, log))
+		gt.NoError(t, w.Write(ctx, &model.LoadLog{ID: "req-2", Success: true}))
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		gt.A(t, lines).Length(2)
+
+		var actual model.LoadLog
+		gt.NoError(t, json.Unmarshal([]byte(lines[0]), &actual))
+		gt.Equal(t, actual.ID, log.ID)
+		gt.Equal(t, actual.FinishedAt, log.FinishedAt)
+		gt.A(t, actual.Sources).Length(1)
+
+		var fields map[string]any
+		gt.NoError(t, json.Unmarshal([]byte(lines[0]), &fields))
+		gt.Equal(t, fields["severity"], any("ERROR"))
+		gt.Equal(t, fields["message"], any("load log"))
+
+		gt.NoError(t, json.Unmarshal([]byte(lines[1]), &fields))
+		gt.Equal(t, fields["severity"], any("INFO"))
+	})
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// normalizeArrays returns copy of v for schema inference in which null elements and empty objects are removed from arrays, and empty arrays are removed from objects, because BigQuery does not allow NULL in ARRAY and a value without element or field can not be inferred. Paths of arrays that have no element after removal are added to empty with the element type, RECORD if elements were empty objects or "" if unknown. It fails with ErrUnsupportedArray if an array has elements of different types or an array has an array as element. path is dot separated path of v in data, and it is empty for data itself.
+func normalizeArrays(path string, v any, empty map[string]bigquery.FieldType) (any, error) {
+	switch value := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for key, elem := range value {
+			normalized, err := normalizeArrays(joinFieldPath(path, key), elem, empty)
+			if err != nil {
+				return nil, err
+			}
+			if arr, ok := normalized.([]any); ok && len(arr) == 0 {
+				continue
+			}
+			copied[key] = normalized
+		}
+		return copied, nil
+
+	case []any:
+		var elems []any
+		var elemType string
+		for _, elem := range value {
+			if elem == nil {
+				continue
+			}
+
+			t := arrayElemType(elem)
+			switch {
+			case t == "ARRAY":
+				return nil, goerr.Wrap(types.ErrUnsupportedArray, "array of array is not supported by BigQuery").With("field", path)
+			case elemType == "":
+				elemType = t
+			case elemType != t:
+				return nil, goerr.Wrap(types.ErrUnsupportedArray, fmt.Sprintf("array %s has elements of different types: %s and %s", path, elemType, t)).With("field", path)
+			}
+
+			normalized, err := normalizeArrays(path, elem, empty)
+			if err != nil {
+				return nil, err
+			}
+			if obj, ok := normalized.(map[string]any); ok && len(obj) == 0 {
+				continue
+			}
+			elems = append(elems, normalized)
+		}
+
+		if len(elems) == 0 {
+			if _, ok := empty[path]; !ok || elemType != "" {
+				empty[path] = bigquery.FieldType(elemType)
+			}
+		}
+		return elems, nil
+	}
+
+	return v, nil
+}
+
+// arrayElemType returns BigQuery type name of elem inferred by bqs. Type of unknown value is returned as Go type to be reported.
+func arrayElemType(elem any) string {
+	switch elem.(type) {
+	case string:
+		return string(bigquery.StringFieldType)
+	case float32, float64:
+		return string(bigquery.FloatFieldType)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return string(bigquery.IntegerFieldType)
+	case bool:
+		return string(bigquery.BooleanFieldType)
+	case time.Time:
+		return string(bigquery.TimestampFieldType)
+	case map[string]any:
+		return string(bigquery.RecordFieldType)
+	case []any:
+		return "ARRAY"
+	}
+	return fmt.Sprintf("%T", elem)
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// emptyArrayType returns column type of an array field that has no element in any record. Declared type of the field is used if exists, otherwise STRING.
+func emptyArrayType(records []*model.LogRecord, path string) bigquery.FieldType {
+	for _, record := range records {
+		spec, ok := record.Fields[path]
+		if !ok {
+			continue
+		}
+		switch spec.Type {
+		case types.FieldTimestamp:
+			return bigquery.TimestampFieldType
+		case types.FieldString:
+			return bigquery.StringFieldType
+		}
+		if ft, ok := model.BigQueryFieldType(spec.Type); ok {
+			return ft
+		}
+	}
+	return bigquery.StringFieldType
+}
+
+// addEmptyArrays adds REPEATED columns of array fields in data that have no element in any record and then are not inferred. Missing parent RECORD columns are added, and the parent is REPEATED if it is an array of empty objects. An array of empty objects without such child is not added because RECORD column requires a field. A field under a non-RECORD column is ignored because it conflicts with other records anyway.
+func addEmptyArrays(schema bigquery.Schema, records []*model.LogRecord, empty map[string]bigquery.FieldType) bigquery.Schema {
+	paths := make([]string, 0, len(empty))
+	for path, elemType := range empty {
+		if elemType != bigquery.RecordFieldType {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		elemType := empty[path]
+		if elemType == "" {
+			elemType = emptyArrayType(records, path)
+		}
+		field := &bigquery.FieldSchema{
+			Type:     elemType,
+			Repeated: true,
+		}
+		schema = addFieldPath(schema, "", strings.Split("data."+path, "."), field, empty)
+	}
+	return schema
+}
+
+// addFieldPath adds field to schema at path under prefix if no column exists there, and returns the schema.
+func addFieldPath(schema bigquery.Schema, prefix string, path []string, field *bigquery.FieldSchema, empty map[string]bigquery.FieldType) bigquery.Schema {
+	for _, f := range schema {
+		if f.Name != path[0] {
+			continue
+		}
+		if len(path) > 1 && f.Type == bigquery.RecordFieldType {
+			f.Schema = addFieldPath(f.Schema, joinFieldPath(prefix, path[0]), path[1:], field, empty)
+		}
+		return schema
+	}
+
+	if len(path) == 1 {
+		field.Name = path[0]
+		return append(schema, field)
+	}
+
+	// prefix of data itself is "data", and paths in empty are relative to data
+	parentPath := strings.TrimPrefix(joinFieldPath(prefix, path[0]), "data.")
+	return append(schema, &bigquery.FieldSchema{
+		Name:     path[0],
+		Type:     bigquery.RecordFieldType,
+		Repeated: empty[parentPath] == bigquery.RecordFieldType,
+		Schema:   addFieldPath(nil, joinFieldPath(prefix, path[0]), path[1:], field, empty),
+	})
+}
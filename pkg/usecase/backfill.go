@@ -0,0 +1,371 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/go-multierror"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+const defaultBackfillQueueSize = 256
+
+// backfillObject is an object resolved to load requests in Backfill.
+type backfillObject struct {
+	url      types.CSUrl
+	requests []*model.LoadRequest
+}
+
+// Backfill loads all objects under prefixes of req.URLs by a staged pipeline: list → resolve → load. Each stage runs with its own concurrency and passes objects to the next stage through a bounded queue, so that listing, event policy evaluation and ingestion overlap without piling up objects in memory. Failure of an object does not stop other objects; errors are aggregated and failed objects are written by WithFailureWriter if configured. A listing error stops listing, and objects listed before it are still loaded.
+func (x *UseCase) Backfill(ctx context.Context, req *model.BackfillRequest) (*model.BackfillResponse, error) {
+	startedAt := time.Now()
+	queueSize := req.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultBackfillQueueSize
+	}
+	batchSize := max(req.BatchSize, 1)
+
+	if req.CountOnly {
+		return x.countBackfill(ctx, req, startedAt)
+	}
+
+	progress, err := x.loadBackfillProgress(ctx, req.ProgressID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		listed, size, loaded, failed, skipped atomic.Int64
+
+		mutex sync.Mutex
+		mErr  *multierror.Error
+	)
+	fail := func(objects []*backfillObject, err error) {
+		x.metrics.errors.Inc()
+		failed.Add(int64(len(objects)))
+		for _, obj := range objects {
+			x.failures.Write(ctx, obj.url, err)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, obj := range objects {
+			mErr = multierror.Append(mErr, goerr.Wrap(err, "failed to backfill object").With("url", obj.url))
+		}
+	}
+
+	// wait blocks while req.Pause is paused. Progress is saved before blocking, and also by completion of each object in flight while paused, so that the saved progress catches up with all finished objects.
+	wait := func() error {
+		if !req.Pause.Paused() {
+			return nil
+		}
+		x.saveBackfillProgress(ctx, progress)
+		return req.Pause.Wait(ctx)
+	}
+	complete := func(objects []*backfillObject) {
+		loaded.Add(int64(len(objects)))
+		x.metrics.objectsProcessed.Add(int64(len(objects)))
+		progress.add(objects)
+		if req.Pause.Paused() {
+			x.saveBackfillProgress(ctx, progress)
+		}
+	}
+
+	// List stage
+	attrsCh := make(chan *storage.ObjectAttrs, queueSize)
+	var listErr error
+	listDone := make(chan struct{})
+	go func() {
+		defer close(listDone)
+		defer close(attrsCh)
+		listErr = x.listBackfill(ctx, req, func(attrs *storage.ObjectAttrs) error {
+			if progress.done(backfillURL(attrs)) {
+				skipped.Add(1)
+				return nil
+			}
+			if err := wait(); err != nil {
+				return err
+			}
+
+			listed.Add(1)
+			size.Add(attrs.Size)
+			x.metrics.objectsFound.Inc()
+			select {
+			case attrsCh <- attrs:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	// Resolve stage
+	objCh := make(chan *backfillObject, queueSize)
+	var resolveWg sync.WaitGroup
+	for i := 0; i < max(req.ResolveConcurrency, 1); i++ {
+		resolveWg.Add(1)
+		go func() {
+			defer resolveWg.Done()
+			for attrs := range attrsCh {
+				obj := &backfillObject{url: backfillURL(attrs)}
+				if err := wait(); err != nil {
+					fail([]*backfillObject{obj}, err)
+					continue
+				}
+				requests, err := x.resolveObjectAttrs(ctx, attrs)
+				if err != nil {
+					fail([]*backfillObject{obj}, err)
+					continue
+				}
+				if len(requests) == 0 {
+					complete([]*backfillObject{obj})
+					continue
+				}
+
+				obj.requests = requests
+				objCh <- obj
+			}
+		}()
+	}
+	go func() {
+		resolveWg.Wait()
+		close(objCh)
+	}()
+
+	// Objects are grouped into batches loaded under one load ID
+	batchCh := make(chan []*backfillObject, max(queueSize/batchSize, 1))
+	go func() {
+		defer close(batchCh)
+		var batch []*backfillObject
+		for obj := range objCh {
+			batch = append(batch, obj)
+			if len(batch) >= batchSize {
+				batchCh <- batch
+				batch = nil
+			}
+		}
+		if len(batch) > 0 {
+			batchCh <- batch
+		}
+	}()
+
+	// Load stage
+	var loadWg sync.WaitGroup
+	for i := 0; i < max(req.LoadConcurrency, 1); i++ {
+		loadWg.Add(1)
+		go func() {
+			defer loadWg.Done()
+			for batch := range batchCh {
+				if err := wait(); err != nil {
+					fail(batch, err)
+					continue
+				}
+				var requests []*model.LoadRequest
+				for _, obj := range batch {
+					requests = append(requests, obj.requests...)
+				}
+				if err := x.Load(ctx, requests); err != nil {
+					fail(batch, err)
+					continue
+				}
+				complete(batch)
+			}
+		}()
+	}
+	loadWg.Wait()
+	<-listDone
+	x.saveBackfillProgress(ctx, progress)
+
+	resp := &model.BackfillResponse{
+		Elapsed: time.Since(startedAt),
+		Listed:  listed.Load(),
+		Size:    size.Load(),
+		Loaded:  loaded.Load(),
+		Failed:  failed.Load(),
+		Skipped: skipped.Load(),
+	}
+	utils.CtxLogger(ctx).Info("backfill finished", "resp", resp)
+
+	if listErr != nil {
+		return resp, goerr.Wrap(listErr, "failed to list objects for backfill")
+	}
+	return resp, mErr.ErrorOrNil()
+}
+
+// countBackfill lists objects of req and returns their count and total size without downloading or loading them.
+func (x *UseCase) countBackfill(ctx context.Context, req *model.BackfillRequest, startedAt time.Time) (*model.BackfillResponse, error) {
+	resp := &model.BackfillResponse{}
+	err := x.listBackfill(ctx, req, func(attrs *storage.ObjectAttrs) error {
+		resp.Listed++
+		resp.Size += attrs.Size
+		return nil
+	})
+	resp.Elapsed = time.Since(startedAt)
+	utils.CtxLogger(ctx).Info("backfill count finished", "resp", resp)
+
+	if err != nil {
+		return resp, goerr.Wrap(err, "failed to list objects for backfill")
+	}
+	return resp, nil
+}
+
+// listBackfill calls fn for each object under prefixes of req that matches the filter.
+func (x *UseCase) listBackfill(ctx context.Context, req *model.BackfillRequest, fn func(attrs *storage.ObjectAttrs) error) error {
+	for _, url := range req.URLs {
+		bucket, prefix, err := url.ParseAsCloudStorage()
+		if err != nil {
+			return err
+		}
+
+		err = x.listCloudStorage(ctx, bucket, prefix, req.ListConcurrency, func(attrs *storage.ObjectAttrs) error {
+			if !req.Filter.Match(types.CSObjectID(attrs.Name)) {
+				return nil
+			}
+			return fn(attrs)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func backfillURL(attrs *storage.ObjectAttrs) types.CSUrl {
+	return types.CSUrl(fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name))
+}
+
+// backfillProgressShardSize is max total bytes of URLs in a Watermark document of backfill progress. A Firestore document is limited to 1 MiB, then URLs of completed objects are split into multiple documents. It is a variable to be changed by test.
+var backfillProgressShardSize = 512 * 1024
+
+// backfillProgress is a set of URLs of objects completed by Backfill. It is saved as Watermark documents of which Names are the URLs in completion order. The first document has ID of the progress and following ones have "<id>#<n>" as ID. A document is filled up to backfillProgressShardSize, and only the last document and new documents are written by saving, then saving cost does not grow with the number of completed objects. Methods of nil backfillProgress do nothing, and then no progress is kept.
+type backfillProgress struct {
+	id    string
+	mutex sync.Mutex
+	urls  map[types.CSUrl]struct{}
+
+	// shards are Names of saved documents. Only the last one can have room for more URLs.
+	shards    [][]string
+	shardSize int
+	// pending is URLs completed after the last save
+	pending []string
+}
+
+func backfillProgressShardID(id string, n int) string {
+	if n == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s#%d", id, n)
+}
+
+// loadBackfillProgress returns progress of id saved by previous Backfill. It returns nil if id is empty.
+func (x *UseCase) loadBackfillProgress(ctx context.Context, id string) (*backfillProgress, error) {
+	if id == "" {
+		return nil, nil
+	}
+
+	progress := &backfillProgress{id: id, urls: make(map[types.CSUrl]struct{})}
+	for n := 0; ; n++ {
+		wm, err := x.getWatermark(ctx, backfillProgressShardID(id, n))
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to get backfill progress").With("id", id).With("shard", n)
+		}
+		if wm == nil {
+			break
+		}
+
+		progress.shards = append(progress.shards, wm.Names)
+		progress.shardSize = 0
+		for _, name := range wm.Names {
+			progress.urls[types.CSUrl(name)] = struct{}{}
+			progress.shardSize += len(name)
+		}
+	}
+	if len(progress.shards) > 0 {
+		utils.CtxLogger(ctx).Info("resume backfill progress", "id", id, "completed", len(progress.urls), "shards", len(progress.shards))
+	}
+	return progress, nil
+}
+
+func (x *backfillProgress) done(url types.CSUrl) bool {
+	if x == nil {
+		return false
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	_, ok := x.urls[url]
+	return ok
+}
+
+func (x *backfillProgress) add(objects []*backfillObject) {
+	if x == nil {
+		return
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for _, obj := range objects {
+		if _, ok := x.urls[obj.url]; ok {
+			continue
+		}
+		x.urls[obj.url] = struct{}{}
+		x.pending = append(x.pending, string(obj.url))
+	}
+}
+
+// saveBackfillProgress saves URLs completed after the last save into Database. An error is only logged because the progress is saved again later and failure of saving it must not stop Backfill.
+func (x *UseCase) saveBackfillProgress(ctx context.Context, progress *backfillProgress) {
+	if progress == nil {
+		return
+	}
+
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	if len(progress.pending) == 0 {
+		return
+	}
+
+	// The last shard is rewritten with pending URLs, and new shards are added when it is full
+	shards := slices.Clone(progress.shards)
+	first := max(len(shards)-1, 0)
+	if len(shards) == 0 {
+		shards = append(shards, nil)
+	}
+	shardSize := progress.shardSize
+	last := len(shards) - 1
+	shards[last] = slices.Clone(shards[last])
+	for _, url := range progress.pending {
+		if shardSize+len(url) > backfillProgressShardSize && len(shards[last]) > 0 {
+			shards = append(shards, nil)
+			last++
+			shardSize = 0
+		}
+		shards[last] = append(shards[last], url)
+		shardSize += len(url)
+	}
+
+	now := time.Now()
+	for n := first; n < len(shards); n++ {
+		wm := &model.Watermark{
+			ID:        backfillProgressShardID(progress.id, n),
+			Names:     shards[n],
+			UpdatedAt: now,
+		}
+		if err := x.putWatermark(ctx, wm); err != nil {
+			// Shards written before the failure are written again by the next save
+			utils.HandleError(ctx, "failed to save backfill progress", goerr.Wrap(err).With("shard", n))
+			return
+		}
+	}
+
+	progress.shards = shards
+	progress.shardSize = shardSize
+	progress.pending = nil
+	utils.CtxLogger(ctx).Info("backfill progress saved", "id", progress.id, "completed", len(progress.urls), "shards", len(shards))
+}
@@ -0,0 +1,364 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestBackfill(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"parser": "json",
+}] {
+	endswith(input.cs.name, ".log")
+}
+`
+	const objectCount = 7
+
+	// newUseCase returns UseCase of which bucket has objectCount objects and extra objects under "logs/" prefix
+	newUseCase := func(t *testing.T, opened *[]string, sink *fakeLoadLogSink, extra ...string) (*usecase.UseCase, *bq.GeneralMock) {
+		var mutex sync.Mutex
+		csClient := &cs.Mock{
+			MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+				gt.Equal(t, bucket, "my-bucket")
+				if query.Prefix != "logs/" {
+					return &cs.MockObjectIterator{
+						MockNext: func() (*storage.ObjectAttrs, error) {
+							return nil, errors.New("permission denied")
+						},
+					}
+				}
+
+				it := &cs.MockObjectIterator{}
+				for i := 0; i < objectCount; i++ {
+					it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: "my-bucket", Name: fmt.Sprintf("logs/%d.log", i), Size: int64(1024 * (i + 1))})
+				}
+				for _, name := range extra {
+					it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: "my-bucket", Name: name, Size: 1024})
+				}
+				// Filtered out by request filter
+				it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: "my-bucket", Name: "logs/readme.txt", Size: 1024})
+				return it
+			},
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				mutex.Lock()
+				*opened = append(*opened, obj.Name.String())
+				mutex.Unlock()
+
+				if obj.Name == "logs/broken.log" {
+					return nil, errors.New("broken")
+				}
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+		}
+		bqClient := bq.NewGeneralMock()
+		uc := newTestUseCase(t, testClients{
+			BigQuery:     bqClient,
+			CloudStorage: csClient,
+			Policy: []policy.Option{
+				policy.WithPolicyData("event.rego", eventPolicy),
+				policy.WithFile("testdata/policy/schema.rego"),
+			},
+		}, usecase.WithLoadLogSink(sink))
+		return uc, bqClient
+	}
+
+	t.Run("all listed objects are loaded in batches", func(t *testing.T) {
+		var opened []string
+		sink := &fakeLoadLogSink{}
+		uc, bqClient := newUseCase(t, &opened, sink)
+
+		resp := gt.R1(uc.Backfill(context.Background(), &model.BackfillRequest{
+			URLs:               []types.ObjectURL{"gs://my-bucket/logs/"},
+			Filter:             gt.R1(types.NewGlobMatcher("logs/*.log")).NoError(t),
+			ResolveConcurrency: 2,
+			LoadConcurrency:    2,
+			BatchSize:          3,
+			QueueSize:          1,
+		})).NoError(t)
+		gt.Equal(t, resp.Listed, int64(objectCount))
+		gt.Equal(t, resp.Loaded, int64(objectCount))
+		gt.Equal(t, resp.Failed, int64(0))
+
+		// Every object is loaded exactly once
+		sort.Strings(opened)
+		var expected []string
+		for i := 0; i < objectCount; i++ {
+			expected = append(expected, fmt.Sprintf("logs/%d.log", i))
+		}
+		gt.Equal(t, opened, expected)
+
+		// 7 objects are loaded as batches of 3, 3 and 1
+		gt.A(t, sink.written).Length(3)
+		var sizes []int
+		for _, raw := range sink.written {
+			var loadLog model.LoadLog
+			gt.NoError(t, json.Unmarshal(raw, &loadLog))
+			gt.True(t, loadLog.Success)
+			sizes = append(sizes, len(loadLog.Sources))
+		}
+		sort.Ints(sizes)
+		gt.Equal(t, sizes, []int{1, 3, 3})
+
+		var inserted int
+		for _, s := range bqClient.Streams {
+			for _, rows := range s.Inserted {
+				inserted += len(rows)
+			}
+		}
+		gt.Equal(t, inserted, objectCount*4)
+	})
+
+	t.Run("failure of an object does not stop other objects", func(t *testing.T) {
+		var opened []string
+		sink := &fakeLoadLogSink{}
+		uc, _ := newUseCase(t, &opened, sink, "logs/broken.log")
+
+		resp, err := uc.Backfill(context.Background(), &model.BackfillRequest{
+			URLs:            []types.ObjectURL{"gs://my-bucket/logs/"},
+			Filter:          gt.R1(types.NewGlobMatcher("logs/*.log")).NoError(t),
+			LoadConcurrency: 4,
+		})
+		gt.Error(t, err)
+		gt.Equal(t, resp.Listed, int64(objectCount+1))
+		gt.Equal(t, resp.Loaded, int64(objectCount))
+		gt.Equal(t, resp.Failed, int64(1))
+		gt.A(t, opened).Length(objectCount + 1)
+	})
+
+	t.Run("objects listed before listing error are loaded", func(t *testing.T) {
+		var opened []string
+		sink := &fakeLoadLogSink{}
+		uc, _ := newUseCase(t, &opened, sink)
+
+		resp, err := uc.Backfill(context.Background(), &model.BackfillRequest{
+			URLs:   []types.ObjectURL{"gs://my-bucket/logs/", "gs://my-bucket/denied/"},
+			Filter: gt.R1(types.NewGlobMatcher("logs/*.log")).NoError(t),
+		})
+		gt.Error(t, err)
+		gt.Equal(t, resp.Listed, int64(objectCount))
+		gt.Equal(t, resp.Loaded, int64(objectCount))
+		gt.A(t, opened).Length(objectCount)
+	})
+
+	t.Run("count only reports count and size without loading", func(t *testing.T) {
+		var opened []string
+		sink := &fakeLoadLogSink{}
+		uc, bqClient := newUseCase(t, &opened, sink, "logs/broken.log")
+
+		resp := gt.R1(uc.Backfill(context.Background(), &model.BackfillRequest{
+			URLs:      []types.ObjectURL{"gs://my-bucket/logs/"},
+			Filter:    gt.R1(types.NewGlobMatcher("logs/*.log")).NoError(t),
+			CountOnly: true,
+		})).NoError(t)
+		gt.Equal(t, resp.Listed, int64(objectCount+1))
+		// 1024 * (1 + 2 + ... + 7) for logs/N.log and 1024 for logs/broken.log
+		gt.Equal(t, resp.Size, int64(1024*28+1024))
+		gt.Equal(t, resp.Loaded, int64(0))
+		gt.A(t, opened).Length(0)
+		gt.A(t, bqClient.Streams).Length(0)
+		gt.A(t, sink.written).Length(0)
+	})
+}
+
+func TestBackfillPause(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"parser": "json",
+}] {
+	endswith(input.cs.name, ".log")
+}
+`
+	const objectCount = 5
+	ctx := context.Background()
+
+	pause := &model.PauseSwitch{}
+	var (
+		mutex  sync.Mutex
+		opened []string
+	)
+	csClient := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			it := &cs.MockObjectIterator{}
+			for i := 0; i < objectCount; i++ {
+				it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: "my-bucket", Name: fmt.Sprintf("logs/%d.log", i), Size: 1024})
+			}
+			return it
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			mutex.Lock()
+			opened = append(opened, obj.Name.String())
+			mutex.Unlock()
+
+			// Paused while loading the first object, e.g. by SIGUSR1
+			if obj.Name == "logs/0.log" {
+				pause.Pause()
+			}
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithPolicyData("event.rego", eventPolicy),
+		policy.WithFile("testdata/policy/schema.rego"),
+	)).NoError(t)
+	db := &mockWatermarkDatabase{watermarks: map[string]*model.Watermark{}}
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+		infra.WithDatabase(db),
+	))
+	req := &model.BackfillRequest{
+		URLs:       []types.ObjectURL{"gs://my-bucket/logs/"},
+		Pause:      pause,
+		ProgressID: "backfill-test",
+	}
+	openedObjects := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, opened...)
+	}
+
+	type result struct {
+		resp *model.BackfillResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := uc.Backfill(ctx, req)
+		done <- result{resp, err}
+	}()
+
+	// The object in flight is finished and its progress is saved while paused
+	var wm *model.Watermark
+	for i := 0; i < 100; i++ {
+		wm = gt.R1(db.GetWatermark(ctx, "backfill-test")).NoError(t)
+		if wm != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	gt.NotEqual(t, wm, nil)
+	gt.Equal(t, wm.Names, []string{"gs://my-bucket/logs/0.log"})
+
+	// No new object is loaded while paused
+	time.Sleep(100 * time.Millisecond)
+	gt.Equal(t, openedObjects(), []string{"logs/0.log"})
+	select {
+	case <-done:
+		t.Fatal("backfill must not finish while paused")
+	default:
+	}
+
+	pause.Resume()
+	r := <-done
+	gt.NoError(t, r.err)
+	gt.Equal(t, r.resp.Loaded, int64(objectCount))
+	gt.A(t, openedObjects()).Length(objectCount)
+
+	wm = gt.R1(db.GetWatermark(ctx, "backfill-test")).NoError(t)
+	gt.A(t, wm.Names).Length(objectCount)
+
+	t.Run("completed objects are skipped by restarted backfill", func(t *testing.T) {
+		resp := gt.R1(uc.Backfill(ctx, req)).NoError(t)
+		gt.Equal(t, resp.Skipped, int64(objectCount))
+		gt.Equal(t, resp.Listed, int64(0))
+		gt.A(t, openedObjects()).Length(objectCount)
+	})
+}
+
+func TestBackfillProgressShards(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"parser": "json",
+}] {
+	endswith(input.cs.name, ".log")
+}
+`
+	// Each document has 2 URLs, e.g. "gs://my-bucket/logs/0.log" is 25 bytes
+	defer usecase.SetBackfillProgressShardSize(60)()
+
+	ctx := context.Background()
+	objectCount := 5
+	var opened atomic.Int64
+	csClient := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			it := &cs.MockObjectIterator{}
+			for i := 0; i < objectCount; i++ {
+				it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: "my-bucket", Name: fmt.Sprintf("logs/%d.log", i), Size: 1024})
+			}
+			return it
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			opened.Add(1)
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithPolicyData("event.rego", eventPolicy),
+		policy.WithFile("testdata/policy/schema.rego"),
+	)).NoError(t)
+	db := &mockWatermarkDatabase{watermarks: map[string]*model.Watermark{}}
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+		infra.WithDatabase(db),
+	))
+	req := &model.BackfillRequest{
+		URLs:       []types.ObjectURL{"gs://my-bucket/logs/"},
+		ProgressID: "backfill-test",
+	}
+	resp := gt.R1(uc.Backfill(ctx, req)).NoError(t)
+	gt.Equal(t, resp.Loaded, int64(objectCount))
+
+	var names []string
+	for i, id := range []string{"backfill-test", "backfill-test#1", "backfill-test#2"} {
+		wm := gt.R1(db.GetWatermark(ctx, id)).NoError(t)
+		gt.NotEqual(t, wm, nil)
+		gt.A(t, wm.Names).Length([]int{2, 2, 1}[i])
+		names = append(names, wm.Names...)
+	}
+	gt.A(t, names).Length(objectCount)
+	gt.Equal(t, gt.R1(db.GetWatermark(ctx, "backfill-test#3")).NoError(t), nil)
+
+	t.Run("new objects are added to the last document and all documents are read by restarted backfill", func(t *testing.T) {
+		objectCount = 6
+		resp := gt.R1(uc.Backfill(ctx, req)).NoError(t)
+		gt.Equal(t, resp.Skipped, int64(5))
+		gt.Equal(t, resp.Loaded, int64(1))
+		gt.Equal(t, opened.Load(), int64(6))
+
+		last := gt.R1(db.GetWatermark(ctx, "backfill-test#2")).NoError(t)
+		gt.Equal(t, last.Names, []string{"gs://my-bucket/logs/4.log", "gs://my-bucket/logs/5.log"})
+		gt.Equal(t, gt.R1(db.GetWatermark(ctx, "backfill-test#3")).NoError(t), nil)
+	})
+}
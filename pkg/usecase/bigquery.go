@@ -2,6 +2,14 @@ package usecase
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/m-mizutani/bqs"
@@ -13,43 +21,136 @@ import (
 )
 
 func createOrUpdateTable(ctx context.Context, bq interfaces.BigQuery, datasetID types.BQDatasetID, tableID types.BQTableID, md *bigquery.TableMetadata) (bigquery.Schema, error) {
+	schema, _, err := applyTableSchema(ctx, bq, datasetID, tableID, md)
+	return schema, err
+}
+
+// applyTableSchema creates the table or updates schema of the table with md. It returns the finalized schema and true if the table is created or the schema is changed.
+func applyTableSchema(ctx context.Context, bq interfaces.BigQuery, datasetID types.BQDatasetID, tableID types.BQTableID, md *bigquery.TableMetadata) (bigquery.Schema, bool, error) {
 	old, err := bq.GetMetadata(ctx, datasetID, tableID)
 	if err != nil {
-		return nil, goerr.Wrap(err, "Failed to get metadata").With("datasetID", datasetID).With("tableID", tableID)
+		return nil, false, goerr.Wrap(err, "Failed to get metadata").With("datasetID", datasetID).With("tableID", tableID)
 	}
 
 	if old == nil {
 		utils.CtxLogger(ctx).Info("creating new table", "datasetID", datasetID, "tableID", tableID)
-		return md.Schema, bq.CreateTable(ctx, datasetID, tableID, md)
+		err := bq.CreateTable(ctx, datasetID, tableID, md)
+		if err == nil {
+			return md.Schema, true, nil
+		}
+		if !errors.Is(err, types.ErrTableAlreadyExists) {
+			return nil, false, err
+		}
+
+		// Another ingestion created the table concurrently. Then apply schema to the created table as well as existing one
+		utils.CtxLogger(ctx).Info("table has been created concurrently, updating it", "datasetID", datasetID, "tableID", tableID)
+		if old, err = bq.GetMetadata(ctx, datasetID, tableID); err != nil {
+			return nil, false, goerr.Wrap(err, "Failed to get metadata").With("datasetID", datasetID).With("tableID", tableID)
+		}
+		if old == nil {
+			return nil, false, goerr.Wrap(types.ErrTableNotFound, "table already exists but metadata is not found").With("datasetID", datasetID).With("tableID", tableID)
+		}
+	}
+
+	// Type is empty in metadata of a table created by old API or mock, and it is regarded as a table
+	if old.Type != "" && old.Type != bigquery.RegularTable {
+		return nil, false, goerr.Wrap(types.ErrDestinationNotTable, "logs can not be inserted into non-table destination").With("datasetID", datasetID).With("tableID", tableID).With("type", old.Type)
+	}
+
+	// Fail before update because BigQuery rejects changing type or mode of an existing column with an opaque error
+	if err := checkSchemaConflict(old.Schema, md.Schema); err != nil {
+		return nil, false, goerr.Wrap(err).With("datasetID", datasetID).With("tableID", tableID)
 	}
 
-	merged, err := bqs.Merge(old.Schema, md.Schema)
+	// Keep policy tags of existing table before merging because merged schema may share fields with old schema
+	oldTags := schemaPolicyTags(old.Schema, "")
+
+	// BigQuery does not allow changing NULLABLE column to REQUIRED, then keep mode of existing columns. bqs.Merge also rejects the mode change.
+	merged, err := bqs.Merge(old.Schema, withRequiredOf(md.Schema, old.Schema))
 	if err != nil {
-		return nil, goerr.Wrap(err, "Failed to merge schema").With("old", old.Schema).With("new", md.Schema)
+		return nil, false, goerr.Wrap(err, "Failed to merge schema").With("old", old.Schema).With("new", md.Schema)
 	}
+	mergePolicyTags(merged, md.Schema)
+	keepRequired(merged, old.Schema)
+	tagsChanged := !equalPolicyTagMap(oldTags, schemaPolicyTags(merged, ""))
+
+	descChanged := md.Description != "" && md.Description != old.Description
 
-	// If schema is not changed, do nothing
-	if bqs.Equal(old.Schema, merged) {
-		return merged, nil
+	// If schema and description are not changed, do nothing
+	if bqs.Equal(old.Schema, merged) && !tagsChanged && !descChanged {
+		return merged, false, nil
 	}
 
 	update := bigquery.TableMetadataToUpdate{
 		Schema: merged,
 	}
+	if descChanged {
+		update.Description = md.Description
+	}
 	utils.CtxLogger(ctx).Info("updating table schema", "datasetID", datasetID, "tableID", tableID)
 
 	if err := bq.UpdateTable(ctx, datasetID, tableID, update, old.ETag); err != nil {
-		return nil, goerr.Wrap(err, "Failed to update table").With("datasetID", datasetID).With("tableID", tableID)
+		return nil, false, goerr.Wrap(err, "Failed to update table").With("datasetID", datasetID).With("tableID", tableID)
+	}
+	return merged, true, nil
+}
+
+const (
+	lastLoadIDLabel = "swarm_last_load_id"
+	lastLoadAtLabel = "swarm_last_load_at"
+)
+
+// setLoadLabels sets request ID of ctx and loadedAt as labels of the last load into dst table. loadedAt is formatted as Unix seconds because a label value can not contain colon. Failure is only reported because the labels are for traceability and should not fail ingestion.
+func setLoadLabels(ctx context.Context, bq interfaces.BigQuery, dst model.BigQueryDest, loadedAt time.Time) {
+	reqID, _ := utils.CtxRequestID(ctx)
+
+	var update bigquery.TableMetadataToUpdate
+	update.SetLabel(lastLoadIDLabel, reqID.String())
+	update.SetLabel(lastLoadAtLabel, strconv.FormatInt(loadedAt.Unix(), 10))
+
+	// Empty ETag to overwrite labels regardless of concurrent schema updates
+	if err := bq.UpdateTable(ctx, dst.Dataset, dst.Table, update, ""); err != nil {
+		utils.HandleError(ctx, "failed to set load labels", goerr.Wrap(err).With("dst", dst))
+	}
+}
+
+// checkSchemaConflict returns types.ErrSchemaConflict if schema changes type or mode of a column in current schema.
+func checkSchemaConflict(current, schema bigquery.Schema) error {
+	diff := model.DiffSchema(model.BigQueryDest{}, current, schema)
+	if !diff.Incompatible() {
+		return nil
+	}
+
+	c := diff.Retyped[0]
+	return goerr.Wrap(types.ErrSchemaConflict, fmt.Sprintf("incompatible type change of column %s: %s -> %s", c.Name, fieldTypeString(c.Old), fieldTypeString(c.New))).
+		With("field", c.Name).
+		With("old", c.Old).
+		With("new", c.New).
+		With("conflicts", diff.Retyped)
+}
+
+func fieldTypeString(f model.SchemaField) string {
+	if f.Repeated {
+		return "REPEATED " + string(f.Type)
 	}
-	return merged, nil
+	return string(f.Type)
 }
 
-func inferSchema[T any](data []T) (bigquery.Schema, error) {
+// inferSchema infers table schema of records. A JSON array of scalars becomes a REPEATED column of the type. An array field that has no element in any record (e.g. always []) becomes a REPEATED column of the declared type of the field, or STRING as fallback. An array that has elements of different types fails with ErrUnsupportedArray.
+func inferSchema(records []*model.LogRecord) (bigquery.Schema, error) {
 	var merged bigquery.Schema
-	for _, d := range data {
-		schema, err := bqs.Infer(d)
+	empty := map[string]bigquery.FieldType{}
+	for _, record := range records {
+		data, err := normalizeArrays("", record.Data, empty)
 		if err != nil {
-			return nil, goerr.Wrap(err, "Failed to infer schema").With("data", d)
+			return nil, goerr.Wrap(err, "failed to infer schema").With("id", record.ID)
+		}
+		normalized := *record
+		normalized.Data = data
+
+		schema, err := bqs.Infer(&normalized)
+		if err != nil {
+			return nil, goerr.Wrap(err, "Failed to infer schema").With("data", record)
 		}
 
 		merged, err = bqs.Merge(merged, schema)
@@ -58,15 +159,17 @@ func inferSchema[T any](data []T) (bigquery.Schema, error) {
 		}
 	}
 
-	return merged, nil
+	return addEmptyArrays(merged, records, empty), nil
 }
 
 func setupLoadLogTable(ctx context.Context, bq interfaces.BigQuery, meta *model.MetadataConfig) (bigquery.Schema, error) {
 	schema, err := bqs.Infer(&model.LoadLog{
 		Sources: []*model.SourceLog{
 			{
-				CS:     &model.CloudStorageObject{},
-				Source: model.Source{},
+				CS:          &model.CloudStorageObject{},
+				Source:      model.Source{},
+				Manifest:    &model.CloudStorageObject{},
+				PolicyError: &model.PolicyError{},
 			},
 		},
 		Ingests: []*model.IngestLog{{}},
@@ -76,14 +179,393 @@ func setupLoadLogTable(ctx context.Context, bq interfaces.BigQuery, meta *model.
 	}
 	md := &bigquery.TableMetadata{
 		Schema: schema,
-		TimePartitioning: &bigquery.TimePartitioning{
-			Field: "started_at",
-			Type:  bigquery.MonthPartitioningType,
-		},
 	}
+
+	// Partitioning and clustering are applied only when the table is created
+	if meta.PartitionType() != types.BQPartitionNone {
+		pt := meta.PartitionType().Type()
+		if pt == "" {
+			return nil, goerr.Wrap(types.ErrInvalidOption, "invalid partition type of metadata table").With("partition", meta.PartitionType())
+		}
+		md.TimePartitioning = &bigquery.TimePartitioning{
+			Field:      meta.PartitionField(),
+			Type:       pt,
+			Expiration: meta.Expiration(),
+		}
+	}
+	if len(meta.Clustering()) > 0 {
+		md.Clustering = &bigquery.Clustering{
+			Fields: meta.Clustering(),
+		}
+	}
+
 	if _, err := createOrUpdateTable(ctx, bq, meta.Dataset(), meta.Table(), md); err != nil {
 		return nil, goerr.Wrap(err, "failed to create or update table")
 	}
 
 	return schema, nil
 }
+
+// bigQueryLoadLogSink inserts LoadLog into the metadata table of BigQuery.
+type bigQueryLoadLogSink struct {
+	stream interfaces.BigQueryStream
+}
+
+func newBigQueryLoadLogSink(ctx context.Context, bq interfaces.BigQuery, meta *model.MetadataConfig) (*bigQueryLoadLogSink, error) {
+	schema, err := setupLoadLogTable(ctx, bq, meta)
+	if err != nil {
+		return nil, err
+	}
+	s, err := bq.NewStream(ctx, meta.Dataset(), meta.Table(), schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bigQueryLoadLogSink{stream: s}, nil
+}
+
+func (x *bigQueryLoadLogSink) Write(ctx context.Context, log *model.LoadLog) error {
+	if _, err := x.stream.Insert(ctx, []any{log.Raw()}, model.InsertOptions{}); err != nil {
+		return goerr.Wrap(err, "failed to insert LoadLog into BigQuery")
+	}
+	return nil
+}
+
+// truncatePartitions deletes existing rows in partitions that are covered by timestamps of records. It does nothing if the table does not exist or is not partitioned.
+func truncatePartitions(ctx context.Context, bq interfaces.BigQuery, dst model.BigQueryDest, records []*model.LogRecord) error {
+	if dst.Partition == types.BQPartitionNone {
+		utils.CtxLogger(ctx).Warn("skip truncating partition because table is not partitioned", "dst", dst)
+		return nil
+	}
+	pt := dst.Partition.Type()
+	if pt == "" {
+		return goerr.Wrap(types.ErrInvalidPolicyResult, "invalid time unit").With("Partition", dst.Partition)
+	}
+
+	md, err := bq.GetMetadata(ctx, dst.Dataset, dst.Table)
+	if err != nil {
+		return goerr.Wrap(err, "failed to get metadata").With("dst", dst)
+	}
+	if md == nil {
+		return nil
+	}
+
+	partitions := map[time.Time]struct{}{}
+	for _, record := range records {
+		partitions[truncateTime(record.Timestamp, dst.Partition)] = struct{}{}
+	}
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	var values []string
+	for p := range partitions {
+		values = append(values, fmt.Sprintf(`TIMESTAMP("%s")`, p.Format(time.RFC3339)))
+	}
+	sort.Strings(values)
+
+	query := fmt.Sprintf("DELETE FROM `%s.%s` WHERE TIMESTAMP_TRUNC(timestamp, %s) IN (%s)",
+		dst.Dataset, dst.Table, pt, strings.Join(values, ", "))
+
+	utils.CtxLogger(ctx).Info("truncating partitions", "dst", dst, "query", query)
+	if _, err := bq.Query(ctx, query); err != nil {
+		return goerr.Wrap(err, "failed to truncate partitions").With("dst", dst).With("query", query)
+	}
+
+	return nil
+}
+
+// truncatedPartitions keeps partitions truncated in the process. Objects loaded in the same run can share a partition, and truncating it again for a later object deletes rows inserted by the earlier one. nil is disabled.
+type truncatedPartitions struct {
+	mutex sync.Mutex
+	done  map[model.BigQueryDest]map[time.Time]struct{}
+}
+
+func newTruncatedPartitions() *truncatedPartitions {
+	return &truncatedPartitions{
+		done: map[model.BigQueryDest]map[time.Time]struct{}{},
+	}
+}
+
+// truncate deletes existing rows in partitions of records that have not been truncated yet. The lock is held until the deletion completes, so that a concurrent load waits for it before inserting rows into the same partition.
+func (x *truncatedPartitions) truncate(ctx context.Context, bq interfaces.BigQuery, dst model.BigQueryDest, records []*model.LogRecord) error {
+	if x == nil {
+		return nil
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	done := x.done[dst]
+	var remaining []*model.LogRecord
+	for _, record := range records {
+		if _, ok := done[truncateTime(record.Timestamp, dst.Partition)]; !ok {
+			remaining = append(remaining, record)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	if err := truncatePartitions(ctx, bq, dst, remaining); err != nil {
+		return err
+	}
+
+	if done == nil {
+		done = map[time.Time]struct{}{}
+		x.done[dst] = done
+	}
+	for _, record := range remaining {
+		done[truncateTime(record.Timestamp, dst.Partition)] = struct{}{}
+	}
+	return nil
+}
+
+func truncateTime(t time.Time, pt types.BQPartition) time.Time {
+	t = t.UTC()
+	switch pt {
+	case types.BQPartitionHour:
+		return t.Truncate(time.Hour)
+	case types.BQPartitionDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case types.BQPartitionMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case types.BQPartitionYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return t
+	}
+}
+
+const insertErrorTableSuffix = "_errors"
+
+// insertErrorTable writes rows rejected by BigQuery into "<table>_errors" table. The table and the stream are prepared when the first rejected row is written.
+type insertErrorTable struct {
+	bq     interfaces.BigQuery
+	dst    model.BigQueryDest
+	mutex  sync.Mutex
+	stream interfaces.BigQueryStream
+}
+
+func (x *insertErrorTable) Write(ctx context.Context, records []*model.LogRecord, reasons map[int]string) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	tableID := types.BQTableID(x.dst.Table.String() + insertErrorTableSuffix)
+	if x.stream == nil {
+		schema, err := bqs.Infer(&model.InsertErrorLog{})
+		if err != nil {
+			return goerr.Wrap(err, "failed to infer schema of error table")
+		}
+		md, err := buildBQMetadata(schema, x.dst.Partition)
+		if err != nil {
+			return err
+		}
+		finalized, err := createOrUpdateTable(ctx, x.bq, x.dst.Dataset, tableID, md)
+		if err != nil {
+			return goerr.Wrap(err, "failed to create error table").With("dst", x.dst)
+		}
+
+		stream, err := x.bq.NewStream(ctx, x.dst.Dataset, tableID, finalized)
+		if err != nil {
+			return err
+		}
+		x.stream = stream
+	}
+
+	now := time.Now()
+	var data []any
+	for i, record := range records {
+		reason, ok := reasons[i]
+		if !ok {
+			continue
+		}
+
+		raw, err := json.Marshal(record.Data)
+		if err != nil {
+			return goerr.Wrap(err, "failed to marshal rejected record").With("id", record.ID)
+		}
+
+		errLog := &model.InsertErrorLog{
+			ID:        record.ID,
+			IngestID:  record.IngestID,
+			Timestamp: record.Timestamp,
+			FailedAt:  now,
+			Reason:    reason,
+			Record:    string(raw),
+		}
+		data = append(data, errLog.Raw())
+	}
+
+	if _, err := x.stream.Insert(ctx, data, model.InsertOptions{}); err != nil {
+		return goerr.Wrap(err, "failed to insert rejected rows into error table").With("dataset", x.dst.Dataset).With("table", tableID)
+	}
+	return nil
+}
+
+func (x *insertErrorTable) Close() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.stream != nil {
+		utils.SafeClose(x.stream)
+	}
+}
+
+// collectPolicyTags returns policy tags declared by FieldSpec of records. Key is dot separated path of column, e.g. "data.user.email".
+func collectPolicyTags(records []*model.LogRecord) map[string][]string {
+	tags := map[string][]string{}
+	for _, record := range records {
+		for name, spec := range record.Fields {
+			if len(spec.PolicyTags) > 0 {
+				tags["data."+name] = spec.PolicyTags
+			}
+		}
+	}
+	return tags
+}
+
+// collectFieldTypes returns BigQuery column types declared by FieldSpec of records, such as NUMERIC. Key is dot separated path of column, e.g. "data.price".
+func collectFieldTypes(records []*model.LogRecord) map[string]bigquery.FieldType {
+	fieldTypes := map[string]bigquery.FieldType{}
+	for _, record := range records {
+		for name, spec := range record.Fields {
+			if ft, ok := model.BigQueryFieldType(spec.Type); ok {
+				fieldTypes["data."+name] = ft
+			}
+		}
+	}
+	return fieldTypes
+}
+
+// setFieldTypes sets declared column types to columns in schema. Values of the columns are inferred as STRING because they are kept as string in data until insertion. A column that does not exist in schema is ignored.
+func setFieldTypes(schema bigquery.Schema, fieldTypes map[string]bigquery.FieldType) {
+	for name, ft := range fieldTypes {
+		if field := lookupField(schema, strings.Split(name, ".")); field != nil && field.Type == bigquery.StringFieldType {
+			field.Type = ft
+		}
+	}
+}
+
+// setPolicyTags sets policy tags to columns in schema. A column that does not exist in schema is ignored.
+func setPolicyTags(schema bigquery.Schema, tags map[string][]string) {
+	for name, names := range tags {
+		if field := lookupField(schema, strings.Split(name, ".")); field != nil {
+			field.PolicyTags = &bigquery.PolicyTagList{Names: names}
+		}
+	}
+}
+
+func lookupField(schema bigquery.Schema, path []string) *bigquery.FieldSchema {
+	for _, field := range schema {
+		if field.Name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return field
+		}
+		return lookupField(field.Schema, path[1:])
+	}
+	return nil
+}
+
+// mergePolicyTags copies policy tags in src to columns with the same name in dst.
+func mergePolicyTags(dst, src bigquery.Schema) {
+	for _, s := range src {
+		for _, d := range dst {
+			if d.Name != s.Name {
+				continue
+			}
+
+			if s.PolicyTags != nil {
+				d.PolicyTags = s.PolicyTags
+			}
+			mergePolicyTags(d.Schema, s.Schema)
+		}
+	}
+}
+
+// schemaPolicyTags returns policy tags of columns in schema keyed by dotted column path.
+func schemaPolicyTags(schema bigquery.Schema, prefix string) map[string]*bigquery.PolicyTagList {
+	tags := map[string]*bigquery.PolicyTagList{}
+	for _, field := range schema {
+		path := prefix + field.Name
+		if field.PolicyTags != nil {
+			tags[path] = field.PolicyTags
+		}
+		for k, v := range schemaPolicyTags(field.Schema, path+".") {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+func equalPolicyTagMap(a, b map[string]*bigquery.PolicyTagList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !equalPolicyTags(v, b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPolicyTags(a, b *bigquery.PolicyTagList) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.Names) != len(b.Names) {
+		return false
+	}
+	for i := range a.Names {
+		if a.Names[i] != b.Names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setRequired marks the top-level field of name as REQUIRED in schema.
+func setRequired(schema bigquery.Schema, name string) {
+	if field := lookupField(schema, []string{name}); field != nil {
+		field.Required = true
+	}
+}
+
+// withRequiredOf returns copy of schema in which REQUIRED mode of fields is the same as fields with the same name in src. schema is not modified.
+func withRequiredOf(schema, src bigquery.Schema) bigquery.Schema {
+	copied := copySchema(schema)
+	keepRequired(copied, src)
+	return copied
+}
+
+// copySchema returns deep copy of fields in schema.
+func copySchema(schema bigquery.Schema) bigquery.Schema {
+	if schema == nil {
+		return nil
+	}
+	copied := make(bigquery.Schema, len(schema))
+	for i, field := range schema {
+		f := *field
+		f.Schema = copySchema(field.Schema)
+		copied[i] = &f
+	}
+	return copied
+}
+
+// keepRequired sets REQUIRED mode of fields in dst to the same as fields with the same name in src.
+func keepRequired(dst, src bigquery.Schema) {
+	for _, s := range src {
+		for _, d := range dst {
+			if d.Name != s.Name {
+				continue
+			}
+			d.Required = s.Required
+			if d.Type == bigquery.RecordFieldType && s.Type == bigquery.RecordFieldType {
+				keepRequired(d.Schema, s.Schema)
+			}
+		}
+	}
+}
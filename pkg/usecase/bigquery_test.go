@@ -2,11 +2,15 @@ package usecase_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra/bq"
 	"github.com/m-mizutani/swarm/pkg/usecase"
@@ -46,3 +50,256 @@ func TestCreateOrUpdateTable(t *testing.T) {
 			},
 		})).NoError(t)
 }
+
+func TestCreateOrUpdateTableSchemaConflict(t *testing.T) {
+	ctx := context.Background()
+	current := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "user", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "id", Type: bigquery.StringFieldType},
+		}},
+	}
+
+	testCases := map[string]struct {
+		schema bigquery.Schema
+		field  string
+		isErr  bool
+	}{
+		"narrowing type of column": {
+			schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.IntegerFieldType},
+			},
+			field: "name",
+			isErr: true,
+		},
+		"changing type of nested column": {
+			schema: bigquery.Schema{
+				{Name: "user", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "id", Type: bigquery.IntegerFieldType},
+				}},
+			},
+			field: "user.id",
+			isErr: true,
+		},
+		"adding column is compatible": {
+			schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType},
+				{Name: "age", Type: bigquery.IntegerFieldType},
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			bqClient := bq.NewGeneralMock()
+			bqClient.Metadata = []*bigquery.TableMetadata{{Schema: current}}
+
+			_, err := usecase.CreateOrUpdateTable(ctx, bqClient, "my_dataset", "my_table", &bigquery.TableMetadata{Schema: tc.schema})
+			if !tc.isErr {
+				gt.NoError(t, err)
+				gt.A(t, bqClient.UpdatedTable).Length(1)
+				return
+			}
+
+			gt.Error(t, err)
+			gt.True(t, errors.Is(err, types.ErrSchemaConflict))
+			gt.String(t, err.Error()).Contains(tc.field)
+			gt.String(t, err.Error()).Contains("STRING -> INTEGER")
+			gt.A(t, bqClient.UpdatedTable).Length(0)
+		})
+	}
+}
+
+func TestCreateOrUpdateTableAlreadyExists(t *testing.T) {
+	ctx := context.Background()
+	created := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	}
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "age", Type: bigquery.IntegerFieldType},
+	}
+
+	t.Run("update table created concurrently", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		// Table does not exist at first, and it has been created by another ingestion when CreateTable is called
+		bqClient.Metadata = []*bigquery.TableMetadata{nil, {Schema: created, ETag: "tag"}}
+		bqClient.MockCreateTable = func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error {
+			return goerr.Wrap(types.ErrTableAlreadyExists, "Already Exists")
+		}
+
+		result, err := usecase.CreateOrUpdateTable(ctx, bqClient, "my_dataset", "my_table", &bigquery.TableMetadata{Schema: schema})
+		gt.NoError(t, err)
+		gt.A(t, result).Length(2)
+		gt.A(t, bqClient.CreatedTable).Length(1)
+		gt.A(t, bqClient.UpdatedTable).Length(1)
+		gt.Equal(t, bqClient.UpdatedTable[0].ETag, "tag")
+		gt.A(t, bqClient.UpdatedTable[0].MD.Schema).Length(2)
+	})
+
+	t.Run("no update if created table has same schema", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{nil, {Schema: schema}}
+		bqClient.MockCreateTable = func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error {
+			return goerr.Wrap(types.ErrTableAlreadyExists, "Already Exists")
+		}
+
+		gt.R1(usecase.CreateOrUpdateTable(ctx, bqClient, "my_dataset", "my_table", &bigquery.TableMetadata{Schema: schema})).NoError(t)
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+	})
+
+	t.Run("other error of CreateTable is returned", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.MockCreateTable = func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, md *bigquery.TableMetadata) error {
+			return errors.New("permission denied")
+		}
+
+		_, err := usecase.CreateOrUpdateTable(ctx, bqClient, "my_dataset", "my_table", &bigquery.TableMetadata{Schema: schema})
+		gt.Error(t, err)
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+	})
+}
+
+func TestCreateOrUpdateTableNotTable(t *testing.T) {
+	ctx := context.Background()
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	}
+
+	testCases := map[string]struct {
+		tableType bigquery.TableType
+		isErr     bool
+	}{
+		"view":              {tableType: bigquery.ViewTable, isErr: true},
+		"materialized view": {tableType: bigquery.MaterializedView, isErr: true},
+		"table":             {tableType: bigquery.RegularTable},
+		"unknown type":      {tableType: ""},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			bqClient := bq.NewGeneralMock()
+			bqClient.Metadata = []*bigquery.TableMetadata{{Type: tc.tableType, Schema: schema}}
+
+			_, err := usecase.CreateOrUpdateTable(ctx, bqClient, "my_dataset", "my_table", &bigquery.TableMetadata{Schema: schema})
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrDestinationNotTable))
+			} else {
+				gt.NoError(t, err)
+			}
+			gt.A(t, bqClient.CreatedTable).Length(0)
+			gt.A(t, bqClient.UpdatedTable).Length(0)
+		})
+	}
+}
+
+func TestInferSchemaArrays(t *testing.T) {
+	dataField := func(t *testing.T, schema bigquery.Schema) bigquery.Schema {
+		for _, f := range schema {
+			if f.Name == "data" {
+				return f.Schema
+			}
+		}
+		t.Fatal("data field is not found")
+		return nil
+	}
+	lookup := func(t *testing.T, schema bigquery.Schema, name string) *bigquery.FieldSchema {
+		for _, f := range schema {
+			if f.Name == name {
+				return f
+			}
+		}
+		t.Fatalf("field %s is not found", name)
+		return nil
+	}
+	newRecords := func(fields map[string]model.FieldSpec, data ...map[string]any) []*model.LogRecord {
+		var records []*model.LogRecord
+		for i, d := range data {
+			records = append(records, &model.LogRecord{
+				ID:        types.LogID(fmt.Sprintf("log-%d", i)),
+				Timestamp: time.Now(),
+				Data:      d,
+				Fields:    fields,
+			})
+		}
+		return records
+	}
+
+	t.Run("string array becomes REPEATED STRING", func(t *testing.T) {
+		records := newRecords(nil,
+			map[string]any{"tags": []any{"a", "b"}},
+			map[string]any{"tags": []any{"c", nil}},
+		)
+		schema := gt.R1(usecase.InferSchema(records)).NoError(t)
+		tags := lookup(t, dataField(t, schema), "tags")
+		gt.Equal(t, tags.Type, bigquery.StringFieldType)
+		gt.True(t, tags.Repeated)
+	})
+
+	t.Run("empty array uses type of non-empty array in other record", func(t *testing.T) {
+		records := newRecords(nil,
+			map[string]any{"scores": []any{}},
+			map[string]any{"scores": []any{1.5, 2.0}},
+		)
+		schema := gt.R1(usecase.InferSchema(records)).NoError(t)
+		scores := lookup(t, dataField(t, schema), "scores")
+		gt.Equal(t, scores.Type, bigquery.FloatFieldType)
+		gt.True(t, scores.Repeated)
+	})
+
+	t.Run("empty array uses declared type or STRING as fallback", func(t *testing.T) {
+		records := newRecords(map[string]model.FieldSpec{
+			"amounts":      {Type: types.FieldNumeric},
+			"nested.times": {Type: types.FieldTimestamp},
+		},
+			map[string]any{
+				"tags":    []any{},
+				"amounts": []any{nil},
+				"nested":  map[string]any{"times": []any{}},
+				"items":   []any{map[string]any{"labels": []any{}}},
+			},
+		)
+		schema := gt.R1(usecase.InferSchema(records)).NoError(t)
+		data := dataField(t, schema)
+
+		tags := lookup(t, data, "tags")
+		gt.Equal(t, tags.Type, bigquery.StringFieldType)
+		gt.True(t, tags.Repeated)
+
+		amounts := lookup(t, data, "amounts")
+		gt.Equal(t, amounts.Type, bigquery.NumericFieldType)
+		gt.True(t, amounts.Repeated)
+
+		nested := lookup(t, data, "nested")
+		gt.Equal(t, nested.Type, bigquery.RecordFieldType)
+		gt.False(t, nested.Repeated)
+		times := lookup(t, nested.Schema, "times")
+		gt.Equal(t, times.Type, bigquery.TimestampFieldType)
+		gt.True(t, times.Repeated)
+
+		// Array of objects keeps REPEATED RECORD even if the objects have only empty arrays
+		items := lookup(t, data, "items")
+		gt.Equal(t, items.Type, bigquery.RecordFieldType)
+		gt.True(t, items.Repeated)
+		labels := lookup(t, items.Schema, "labels")
+		gt.Equal(t, labels.Type, bigquery.StringFieldType)
+		gt.True(t, labels.Repeated)
+	})
+
+	t.Run("mixed array fails", func(t *testing.T) {
+		records := newRecords(nil,
+			map[string]any{"values": []any{"a", 1.0}},
+		)
+		_, err := usecase.InferSchema(records)
+		gt.True(t, errors.Is(err, types.ErrUnsupportedArray))
+		gt.String(t, err.Error()).Contains("array values has elements of different types: STRING and FLOAT")
+	})
+
+	t.Run("array of array fails", func(t *testing.T) {
+		records := newRecords(nil,
+			map[string]any{"matrix": []any{[]any{1.0}}},
+		)
+		_, err := usecase.InferSchema(records)
+		gt.True(t, errors.Is(err, types.ErrUnsupportedArray))
+	})
+}
@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"context"
+	"io"
+	"slices"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+)
+
+// BuildConfig is configuration of standard clients and UseCase created by Build.
+type BuildConfig struct {
+	// ProjectID is Google Cloud project ID of BigQuery. It is required unless BigQuery is set.
+	ProjectID types.GoogleProjectID
+
+	// PolicyDirs are directory paths of Rego policy files.
+	PolicyDirs []string
+	// PolicyOptions are applied to policy client in addition to PolicyDirs, e.g. policy.WithPolicyData.
+	PolicyOptions []policy.Option
+
+	// BigQuery and CloudStorage are used instead of creating new clients if set, e.g. for testing. They are also closed by the cleanup function if they implement io.Closer.
+	BigQuery     interfaces.BigQuery
+	CloudStorage interfaces.CloudStorage
+
+	// Options are applied to UseCase.
+	Options []Option
+}
+
+// Build creates BigQuery, Cloud Storage and policy clients by cfg, and returns UseCase with them. The returned cleanup function closes the clients and must be called after using UseCase. If Build fails, clients created in Build are closed.
+func Build(ctx context.Context, cfg BuildConfig) (*UseCase, func() error, error) {
+	var closers []io.Closer
+	cleanup := func() error {
+		var mErr *multierror.Error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i].Close(); err != nil {
+				mErr = multierror.Append(mErr, err)
+			}
+		}
+		return mErr.ErrorOrNil()
+	}
+
+	// Clone not to append options to the backing array of cfg.PolicyOptions owned by the caller
+	policyOptions := slices.Clone(cfg.PolicyOptions)
+	for _, dir := range cfg.PolicyDirs {
+		policyOptions = append(policyOptions, policy.WithDir(dir))
+	}
+	policyClient, err := policy.New(policyOptions...)
+	if err != nil {
+		return nil, nil, goerr.Wrap(err, "failed to create policy client")
+	}
+
+	bqClient := cfg.BigQuery
+	if bqClient == nil {
+		if cfg.ProjectID == "" {
+			return nil, nil, goerr.Wrap(types.ErrInvalidOption, "ProjectID is required to create BigQuery client")
+		}
+		client, err := bq.New(ctx, cfg.ProjectID)
+		if err != nil {
+			return nil, nil, err
+		}
+		bqClient = client
+	}
+	if closer, ok := bqClient.(io.Closer); ok {
+		closers = append(closers, closer)
+	}
+
+	csClient := cfg.CloudStorage
+	if csClient == nil {
+		client, err := cs.New(ctx)
+		if err != nil {
+			_ = cleanup()
+			return nil, nil, err
+		}
+		csClient = client
+	}
+	if closer, ok := csClient.(io.Closer); ok {
+		closers = append(closers, closer)
+	}
+
+	uc := New(
+		infra.New(
+			infra.WithPolicy(policyClient),
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+		),
+		cfg.Options...,
+	)
+
+	return uc, cleanup, nil
+}
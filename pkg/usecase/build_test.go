@@ -0,0 +1,95 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+type closableBigQuery struct {
+	*bq.GeneralMock
+	closed *[]string
+	err    error
+}
+
+func (x *closableBigQuery) Close() error {
+	*x.closed = append(*x.closed, "bigquery")
+	return x.err
+}
+
+type closableCloudStorage struct {
+	*cs.Mock
+	closed *[]string
+}
+
+func (x *closableCloudStorage) Close() error {
+	*x.closed = append(*x.closed, "cloudstorage")
+	return nil
+}
+
+func TestBuild(t *testing.T) {
+	const schemaPolicy = `package schema.test
+
+log[{"dataset": "d", "table": "t", "timestamp": 1, "data": input}]
+`
+
+	t.Run("cleanup closes clients", func(t *testing.T) {
+		var closed []string
+		uc, cleanup, err := usecase.Build(context.Background(), usecase.BuildConfig{
+			PolicyOptions: []policy.Option{policy.WithPolicyData("schema.rego", schemaPolicy)},
+			BigQuery:      &closableBigQuery{GeneralMock: bq.NewGeneralMock(), closed: &closed},
+			CloudStorage:  &closableCloudStorage{Mock: &cs.Mock{}, closed: &closed},
+		})
+		gt.NoError(t, err)
+		gt.NotEqual(t, uc, nil)
+		gt.A(t, closed).Length(0)
+
+		gt.NoError(t, cleanup())
+		// Clients are closed in reverse order of creation
+		gt.Equal(t, closed, []string{"cloudstorage", "bigquery"})
+	})
+
+	t.Run("cleanup closes all clients even if one fails", func(t *testing.T) {
+		var closed []string
+		_, cleanup, err := usecase.Build(context.Background(), usecase.BuildConfig{
+			PolicyOptions: []policy.Option{policy.WithPolicyData("schema.rego", schemaPolicy)},
+			BigQuery:      &closableBigQuery{GeneralMock: bq.NewGeneralMock(), closed: &closed, err: errors.New("close failed")},
+			CloudStorage:  &closableCloudStorage{Mock: &cs.Mock{}, closed: &closed},
+		})
+		gt.NoError(t, err)
+
+		err = cleanup()
+		gt.Error(t, err)
+		gt.String(t, err.Error()).Contains("close failed")
+		gt.Equal(t, closed, []string{"cloudstorage", "bigquery"})
+	})
+
+	t.Run("project ID is required without BigQuery client", func(t *testing.T) {
+		_, _, err := usecase.Build(context.Background(), usecase.BuildConfig{
+			PolicyOptions: []policy.Option{policy.WithPolicyData("schema.rego", schemaPolicy)},
+			CloudStorage:  &cs.Mock{},
+		})
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+
+	t.Run("do not modify policy options of config", func(t *testing.T) {
+		options := make([]policy.Option, 1, 2)
+		options[0] = policy.WithPolicyData("schema.rego", schemaPolicy)
+		_, _, err := usecase.Build(context.Background(), usecase.BuildConfig{
+			PolicyDirs:    []string{t.TempDir()},
+			PolicyOptions: options,
+			BigQuery:      bq.NewGeneralMock(),
+			CloudStorage:  &cs.Mock{},
+		})
+		gt.NoError(t, err)
+		// Spare capacity of options is not overwritten by the option of PolicyDirs
+		gt.True(t, options[:2][1] == nil)
+	})
+}
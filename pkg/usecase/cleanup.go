@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// ListCleanupTables returns tables in the dataset that match the filter. Either prefix or labels of the filter is required to avoid selecting all tables in the dataset by mistake.
+func (x *UseCase) ListCleanupTables(ctx context.Context, dataset types.BQDatasetID, filter model.CleanupFilter) ([]types.BQTableID, error) {
+	if filter.Prefix == "" && len(filter.Labels) == 0 {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "prefix or labels is required for cleanup").With("dataset", dataset)
+	}
+
+	tables, err := x.clients.BigQuery().ListTables(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []types.BQTableID
+	for _, table := range tables {
+		if !strings.HasPrefix(table.String(), filter.Prefix) {
+			continue
+		}
+
+		if len(filter.Labels) > 0 {
+			md, err := x.clients.BigQuery().GetMetadata(ctx, dataset, table)
+			if err != nil {
+				return nil, err
+			}
+			if md == nil || !matchLabels(md.Labels, filter.Labels) {
+				continue
+			}
+		}
+
+		targets = append(targets, table)
+	}
+
+	return targets, nil
+}
+
+// DeleteTables deletes the tables in the dataset. It stops at the first failure.
+func (x *UseCase) DeleteTables(ctx context.Context, dataset types.BQDatasetID, tables []types.BQTableID) error {
+	for _, table := range tables {
+		utils.CtxLogger(ctx).Info("deleting table", "dataset", dataset, "table", table)
+		if err := x.clients.BigQuery().DeleteTable(ctx, dataset, table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchLabels returns true if labels have all keys of required with the same values. A required label with empty value matches only a label of which key exists with empty value, e.g. a key-only label.
+func matchLabels(labels, required map[string]string) bool {
+	for k, v := range required {
+		if actual, ok := labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
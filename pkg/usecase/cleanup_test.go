@@ -0,0 +1,91 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestCleanupTables(t *testing.T) {
+	labels := map[types.BQTableID]map[string]string{
+		"scratch_a":  {"env": "test"},
+		"scratch_b":  {"env": "prod"},
+		"test_logs":  {"env": "test", "owner": "alice"},
+		"prod_logs":  nil,
+		"scratch_c":  {"env": "test", "owner": "bob"},
+		"scratchpad": {},
+		"temp_logs":  {"temporary": ""},
+	}
+	tables := []types.BQTableID{"scratch_a", "scratch_b", "test_logs", "prod_logs", "scratch_c", "scratchpad", "temp_logs"}
+
+	testCases := map[string]struct {
+		filter model.CleanupFilter
+		expect []types.BQTableID
+		isErr  bool
+	}{
+		"prefix": {
+			filter: model.CleanupFilter{Prefix: "scratch_"},
+			expect: []types.BQTableID{"scratch_a", "scratch_b", "scratch_c"},
+		},
+		"label": {
+			filter: model.CleanupFilter{Labels: map[string]string{"env": "test"}},
+			expect: []types.BQTableID{"scratch_a", "test_logs", "scratch_c"},
+		},
+		"prefix and labels": {
+			filter: model.CleanupFilter{Prefix: "scratch", Labels: map[string]string{"env": "test", "owner": "bob"}},
+			expect: []types.BQTableID{"scratch_c"},
+		},
+		"label with empty value requires the key": {
+			filter: model.CleanupFilter{Labels: map[string]string{"temporary": ""}},
+			expect: []types.BQTableID{"temp_logs"},
+		},
+		"no match": {
+			filter: model.CleanupFilter{Prefix: "tmp_"},
+		},
+		"no filter": {
+			isErr: true,
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctx := context.Background()
+			var deleted []types.BQTableID
+			mock := &bq.Mock{
+				MockListTables: func(ctx context.Context, dataset types.BQDatasetID) ([]types.BQTableID, error) {
+					gt.Equal(t, dataset, "my_dataset")
+					return tables, nil
+				},
+				MockGetMetadata: func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) (*bigquery.TableMetadata, error) {
+					return &bigquery.TableMetadata{Labels: labels[table]}, nil
+				},
+				MockDeleteTable: func(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) error {
+					deleted = append(deleted, table)
+					return nil
+				},
+			}
+			uc := usecase.New(infra.New(infra.WithBigQuery(mock)))
+
+			targets, err := uc.ListCleanupTables(ctx, "my_dataset", tc.filter)
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrInvalidOption))
+				return
+			}
+			gt.NoError(t, err)
+			gt.Equal(t, targets, tc.expect)
+			// Listing targets must not delete anything, as dry run
+			gt.A(t, deleted).Length(0)
+
+			gt.NoError(t, uc.DeleteTables(ctx, "my_dataset", targets))
+			gt.Equal(t, deleted, tc.expect)
+		})
+	}
+}
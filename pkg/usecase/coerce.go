@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"strconv"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// coerceRecords checks values in data of records with data column of schema, because Storage Write API rejects a value of which JSON type does not match the column, e.g. a number for STRING column. If convert is true, a value that can be represented as the column type is converted, e.g. 200 to "200" for STRING column. Otherwise, any value of different type is rejected. It returns types.ErrFieldTypeMismatch with dot separated path of the field for a value that can not be inserted. Records are copied only if any value is converted.
+func coerceRecords(dst model.BigQueryDest, records []*model.LogRecord, schema bigquery.Schema, convert bool) ([]*model.LogRecord, error) {
+	dataField := findFieldFold(schema, "data")
+	if dataField == nil || dataField.Type != bigquery.RecordFieldType {
+		return records, nil
+	}
+
+	coerced := make([]*model.LogRecord, len(records))
+	for i, record := range records {
+		data, changed, err := coerceData(record.Data, dataField.Schema, "", convert)
+		if err != nil {
+			return nil, goerr.Wrap(err).With("dst", dst).With("id", record.ID)
+		}
+		if !changed {
+			coerced[i] = record
+			continue
+		}
+
+		copied := *record
+		copied.Data = data
+		coerced[i] = &copied
+	}
+	return coerced, nil
+}
+
+// coerceData returns copy of data of which values are coerced to types of columns in schema, and true if any value is changed. Fields that are not declared in schema and columns of other types than STRING, INTEGER, FLOAT, BOOLEAN and RECORD are kept as they are.
+func coerceData(data any, schema bigquery.Schema, prefix string, convert bool) (any, bool, error) {
+	obj, ok := data.(map[string]any)
+	if !ok {
+		return data, false, nil
+	}
+
+	var copied map[string]any
+	for key, value := range obj {
+		field := findFieldFold(schema, key)
+		if field == nil {
+			continue
+		}
+
+		coerced, changed, err := coerceField(value, field, prefix+key, convert)
+		if err != nil {
+			return nil, false, err
+		}
+		if !changed {
+			continue
+		}
+		if copied == nil {
+			copied = make(map[string]any, len(obj))
+			for k, v := range obj {
+				copied[k] = v
+			}
+		}
+		copied[key] = coerced
+	}
+
+	if copied == nil {
+		return data, false, nil
+	}
+	return copied, true, nil
+}
+
+func coerceField(value any, field *bigquery.FieldSchema, path string, convert bool) (any, bool, error) {
+	if !field.Repeated {
+		return coerceValue(value, field, path, convert)
+	}
+
+	values, ok := value.([]any)
+	if !ok {
+		return nil, false, fieldTypeMismatch(value, field, path)
+	}
+	var copied []any
+	for i, v := range values {
+		coerced, changed, err := coerceValue(v, field, path, convert)
+		if err != nil {
+			return nil, false, err
+		}
+		if !changed {
+			continue
+		}
+		if copied == nil {
+			copied = append([]any{}, values...)
+		}
+		copied[i] = coerced
+	}
+
+	if copied == nil {
+		return value, false, nil
+	}
+	return copied, true, nil
+}
+
+func coerceValue(value any, field *bigquery.FieldSchema, path string, convert bool) (any, bool, error) {
+	switch field.Type {
+	case bigquery.RecordFieldType:
+		if _, ok := value.(map[string]any); !ok {
+			return nil, false, fieldTypeMismatch(value, field, path)
+		}
+		return coerceData(value, field.Schema, path+".", convert)
+
+	case bigquery.StringFieldType:
+		switch v := value.(type) {
+		case string:
+			return value, false, nil
+		case float64:
+			if convert {
+				return strconv.FormatFloat(v, 'f', -1, 64), true, nil
+			}
+		case bool:
+			if convert {
+				return strconv.FormatBool(v), true, nil
+			}
+		}
+
+	case bigquery.IntegerFieldType:
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int64(v)) {
+				return value, false, nil
+			}
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && convert {
+				return float64(n), true, nil
+			}
+		}
+
+	case bigquery.FloatFieldType:
+		switch v := value.(type) {
+		case float64:
+			return value, false, nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil && convert {
+				return f, true, nil
+			}
+		}
+
+	case bigquery.BooleanFieldType:
+		switch v := value.(type) {
+		case bool:
+			return value, false, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil && convert {
+				return b, true, nil
+			}
+		}
+
+	default:
+		return value, false, nil
+	}
+
+	return nil, false, fieldTypeMismatch(value, field, path)
+}
+
+func fieldTypeMismatch(value any, field *bigquery.FieldSchema, path string) error {
+	return goerr.Wrap(types.ErrFieldTypeMismatch, "value does not match type of column").
+		With("field", path).
+		With("type", field.Type).
+		With("repeated", field.Repeated).
+		With("value", value)
+}
@@ -0,0 +1,128 @@
+package usecase
+
+import (
+	"strings"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// dateUnit is a granularity of a segment of date path layout.
+type dateUnit int
+
+const (
+	dateUnitNone dateUnit = iota
+	dateUnitYear
+	dateUnitMonth
+	dateUnitDay
+	dateUnitHour
+)
+
+// parseDateUnit returns the finest unit of date elements of Go time layout in segment.
+func parseDateUnit(segment string) dateUnit {
+	switch {
+	case strings.Contains(segment, "15"):
+		return dateUnitHour
+	case strings.Contains(segment, "02"):
+		return dateUnitDay
+	case strings.Contains(segment, "01"), strings.Contains(segment, "Jan"):
+		return dateUnitMonth
+	case strings.Contains(segment, "2006"):
+		return dateUnitYear
+	}
+	return dateUnitNone
+}
+
+func (x dateUnit) truncate(t time.Time) time.Time {
+	switch x {
+	case dateUnitYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case dateUnitMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case dateUnitDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(time.Hour)
+	}
+}
+
+func (x dateUnit) next(t time.Time) time.Time {
+	switch x {
+	case dateUnitYear:
+		return t.AddDate(1, 0, 0)
+	case dateUnitMonth:
+		return t.AddDate(0, 1, 0)
+	case dateUnitDay:
+		return t.AddDate(0, 0, 1)
+	default:
+		return t.Add(time.Hour)
+	}
+}
+
+// datePrefixes returns object name prefixes of date path layout that cover the range [Start, End) of dp. The layout is split into segments by "/", and each segment must be finer than the previous one, e.g. "2006/01/02/". A period fully in the range is listed by the prefix of its segment, and only periods at the boundary of the range are divided into finer segments. Then listing a long range does not need a prefix for each day. Start and End are converted to UTC and the layout is formatted in UTC, then date paths must be written in UTC.
+func datePrefixes(dp *model.DatePathRange) ([]string, error) {
+	if dp.Layout == "" {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "layout of date path is required")
+	}
+	if !dp.Start.Before(dp.End) {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "start of date path range must be before end").With("start", dp.Start).With("end", dp.End)
+	}
+
+	// layouts[i] is the layout up to and including i-th segment
+	var layouts []string
+	var units []dateUnit
+	var consumed int
+	for consumed < len(dp.Layout) {
+		end := strings.Index(dp.Layout[consumed:], "/")
+		if end < 0 {
+			end = len(dp.Layout) - consumed - 1
+		}
+		consumed += end + 1
+		layout := dp.Layout[:consumed]
+
+		unit := parseDateUnit(layout[strings.LastIndex(strings.TrimSuffix(layout, "/"), "/")+1:])
+		if unit == dateUnitNone {
+			return nil, goerr.Wrap(types.ErrInvalidOption, "segment of date path has no date element").With("layout", dp.Layout)
+		}
+		if len(units) > 0 && unit <= units[len(units)-1] {
+			return nil, goerr.Wrap(types.ErrInvalidOption, "segment of date path must be finer than the previous one").With("layout", dp.Layout)
+		}
+		layouts = append(layouts, layout)
+		units = append(units, unit)
+	}
+
+	start, end := dp.Start.UTC(), dp.End.UTC()
+	var prefixes []string
+	var walk func(level int, from, to time.Time)
+	walk = func(level int, from, to time.Time) {
+		unit := units[level]
+		for t := unit.truncate(from); t.Before(to); t = unit.next(t) {
+			periodEnd := unit.next(t)
+			covered := !t.Before(start) && !periodEnd.After(end)
+			if covered || level == len(units)-1 {
+				prefixes = append(prefixes, t.Format(layouts[level]))
+				continue
+			}
+			walk(level+1, maxTime(t, from), minTime(periodEnd, to))
+		}
+	}
+	walk(0, start, end)
+
+	return prefixes, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,94 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+const (
+	// DeadLetterAttrError is an attribute key of dead letter message to describe reason of the failure.
+	DeadLetterAttrError = "error"
+	// DeadLetterAttrRequestID is an attribute key of dead letter message to indicate request ID of the failed load.
+	DeadLetterAttrRequestID = "request_id"
+	// DeadLetterAttrFailedAt is an attribute key of dead letter message to indicate time of the failure in RFC3339 format.
+	DeadLetterAttrFailedAt = "failed_at"
+
+	// deadLetterAttrValueLimit is max bytes of an attribute value of Pub/Sub message.
+	deadLetterAttrValueLimit = 1024
+)
+
+// deadLetterFailure publishes objects of requests failed by err to dead letter topic only if ClassifyError decides dead-letter. Retried error is not published because the message is redelivered and the objects are loaded again, and dropped error is discarded.
+func (x *UseCase) deadLetterFailure(ctx context.Context, requests []*model.LoadRequest, err error) {
+	if decision := x.ClassifyError(err); decision != types.RetryDecisionDeadLetter {
+		utils.CtxLogger(ctx).Debug("failed objects are not published to dead letter topic", "decision", decision)
+		return
+	}
+	x.publishDeadLetter(ctx, requests, err)
+}
+
+// publishDeadLetter republishes objects of failed load requests to dead letter topic in the same format as Enqueue. Then the objects can be reprocessed by subscribing the topic.
+func (x *UseCase) publishDeadLetter(ctx context.Context, requests []*model.LoadRequest, cause error) {
+	if x.deadLetter == nil {
+		return
+	}
+
+	reqID, _ := utils.CtxRequestID(ctx)
+
+	// One object may have multiple sources. It should be published only once.
+	var objects []*model.Object
+	seen := map[string]struct{}{}
+	for _, req := range requests {
+		if req.Object.CS == nil {
+			continue
+		}
+		key := req.Object.CS.Bucket.String() + "/" + req.Object.CS.Name.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		obj := req.Object
+		objects = append(objects, &obj)
+	}
+	if len(objects) == 0 {
+		return
+	}
+
+	raw, err := json.Marshal(model.SwarmMessage{Objects: objects})
+	if err != nil {
+		utils.HandleError(ctx, "failed to marshal dead letter message", goerr.Wrap(err))
+		return
+	}
+
+	attrs := map[string]string{
+		DeadLetterAttrError:     truncateAttrValue(cause.Error()),
+		DeadLetterAttrRequestID: reqID.String(),
+		DeadLetterAttrFailedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	msgID, err := x.deadLetter.Publish(ctx, raw, attrs)
+	if err != nil {
+		utils.HandleError(ctx, "failed to publish dead letter message", goerr.Wrap(err).With("objects", objects))
+		return
+	}
+
+	utils.CtxLogger(ctx).Warn("published failed objects to dead letter topic", "msgID", msgID, "count", len(objects))
+}
+
+// truncateAttrValue truncates v to deadLetterAttrValueLimit bytes not to be rejected by Pub/Sub. It does not split a multi-byte character.
+func truncateAttrValue(v string) string {
+	if len(v) <= deadLetterAttrValueLimit {
+		return v
+	}
+	n := deadLetterAttrValueLimit
+	for n > 0 && !utf8.RuneStart(v[n]) {
+		n--
+	}
+	return v[:n]
+}
@@ -3,16 +3,31 @@ package usecase
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
 	"google.golang.org/api/iterator"
 )
 
+// Enqueue publishes objects under URLs of the request to Pub/Sub as swarm messages. If policy client is configured, objects are checked by event policy before publishing, and unroutable objects are handled by req.Unroutable, or fail or are skipped according to WithSkipUnroutable if it is empty.
 func (x *UseCase) Enqueue(ctx context.Context, req *model.EnqueueRequest) (*model.EnqueueResponse, error) {
+	if !req.Unroutable.IsValid() {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "unroutable action must be skip or dead_letter").With("unroutable", req.Unroutable)
+	}
+	if req.Unroutable != "" && x.clients.Policy() == nil {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "policy client is required to check unroutable objects").With("unroutable", req.Unroutable)
+	}
+	if req.Unroutable == types.UnroutableDeadLetter && x.deadLetter == nil && !req.DryRun {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "dead letter topic is required to dead-letter unroutable objects")
+	}
+
 	startedAt := time.Now()
 	var (
 		totalCount int64
@@ -20,28 +35,59 @@ func (x *UseCase) Enqueue(ctx context.Context, req *model.EnqueueRequest) (*mode
 		sizeLimit  int64 = int64(x.enqueueSizeLimit * 1024 * 1024) // MiB
 	)
 
-	var objects []*model.Object
-	for _, url := range req.URLs {
-		bucket, objPrefix, err := url.ParseAsCloudStorage()
+	var plan *model.EnqueuePlan
+	publish := x.enqueueObjects
+	if req.DryRun {
+		plan = &model.EnqueuePlan{}
+		publish = func(ctx context.Context, objects []*model.Object) error {
+			plan.Messages = append(plan.Messages, objects)
+			return nil
+		}
+	}
+
+	// subPrefixes are appended to prefix of each URL. Empty string means the whole prefix.
+	subPrefixes := []string{""}
+	if req.DatePath != nil {
+		prefixes, err := datePrefixes(req.DatePath)
 		if err != nil {
 			return nil, err
 		}
+		subPrefixes = prefixes
+	}
 
-		query := &storage.Query{
-			Prefix: objPrefix.String(),
+	var objects, unroutable []*model.Object
+	for _, url := range req.URLs {
+		bucket, objPrefix, err := url.ParseAsCloudStorage()
+		if err != nil {
+			return nil, err
 		}
 
-		it := x.clients.CloudStorage().List(ctx, bucket, query)
-		for {
-			attrs, err := it.Next()
-			if err != nil {
-				if err == iterator.Done {
-					break
+		fn := func(attrs *storage.ObjectAttrs) error {
+			if !req.Filter.Match(types.CSObjectID(attrs.Name)) {
+				if plan != nil {
+					obj := model.NewObjectFromCloudStorageAttrs(attrs)
+					plan.Filtered = append(plan.Filtered, &obj)
 				}
-				return nil, goerr.Wrap(err, "failed to list objects")
+				return nil
 			}
 
 			obj := model.NewObjectFromCloudStorageAttrs(attrs)
+			// Unroutable object is not enqueued because it would fail in loading anyway. The check is available only if event policy is configured.
+			if x.clients.Policy() != nil {
+				sources, err := x.ObjectToSources(ctx, obj)
+				if err != nil && !(req.Unroutable != "" && errors.Is(err, types.ErrNoSourceMatched)) {
+					return err
+				}
+				if len(sources) == 0 {
+					if plan != nil {
+						plan.Unroutable = append(plan.Unroutable, &obj)
+					}
+					unroutable = append(unroutable, &obj)
+					return nil
+				}
+			}
+
+			x.metrics.objectsFound.Inc()
 			if obj.Size != nil {
 				totalSize += *obj.Size
 			}
@@ -49,29 +95,151 @@ func (x *UseCase) Enqueue(ctx context.Context, req *model.EnqueueRequest) (*mode
 
 			if sumObjectSize(&obj, objects...) > int64(sizeLimit) ||
 				len(objects) >= x.enqueueCountLimit {
-				if err := enqueueObjects(ctx, x.clients.PubSub(), objects); err != nil {
-					return nil, err
+				if err := publish(ctx, objects); err != nil {
+					return err
 				}
 				objects = nil
 			}
 
 			objects = append(objects, &obj)
+			return nil
+		}
+
+		for _, sub := range subPrefixes {
+			if err := x.listCloudStorage(ctx, bucket, objPrefix+types.CSObjectID(sub), x.enqueueListConcurrency, fn); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	if len(objects) > 0 {
-		if err := enqueueObjects(ctx, x.clients.PubSub(), objects); err != nil {
+		if err := publish(ctx, objects); err != nil {
 			return nil, err
 		}
 	}
 
+	if len(unroutable) > 0 {
+		utils.CtxLogger(ctx).Warn("unroutable objects are not enqueued", "count", len(unroutable), "action", req.Unroutable)
+		if req.Unroutable == types.UnroutableDeadLetter && !req.DryRun {
+			// Dead letter messages are split by the same limits as enqueued messages because they are loaded by subscribing the topic.
+			cause := goerr.Wrap(types.ErrNoSourceMatched, "no source for object in enqueue")
+			for _, chunk := range chunkObjects(unroutable, x.enqueueCountLimit, sizeLimit) {
+				requests := make([]*model.LoadRequest, len(chunk))
+				for i, obj := range chunk {
+					requests[i] = &model.LoadRequest{Object: *obj}
+				}
+				x.publishDeadLetter(ctx, requests, cause)
+			}
+		}
+	}
+
 	return &model.EnqueueResponse{
-		Elapsed: time.Since(startedAt),
-		Count:   totalCount,
-		Size:    totalSize,
+		Elapsed:    time.Since(startedAt),
+		Count:      totalCount,
+		Size:       totalSize,
+		Plan:       plan,
+		Unroutable: unroutable,
 	}, nil
 }
 
+// listCloudStorage calls fn for each object under prefix in bucket. If concurrency is more than 1, sub-prefixes split by "/" delimiter are listed in parallel. fn is always called in the caller goroutine, then it does not need to be goroutine-safe. Order of objects is not guaranteed in parallel listing.
+func (x *UseCase) listCloudStorage(ctx context.Context, bucket types.CSBucket, prefix types.CSObjectID, concurrency int, fn func(attrs *storage.ObjectAttrs) error) error {
+	client := x.clients.CloudStorage()
+	if concurrency <= 1 {
+		return x.iterateObjects(client.List(ctx, bucket, &storage.Query{Prefix: prefix.String()}), fn)
+	}
+
+	// Objects right under the prefix are handled here, and sub-prefixes are listed by workers
+	var subPrefixes []string
+	query := &storage.Query{Prefix: prefix.String(), Delimiter: "/"}
+	err := x.iterateObjects(client.List(ctx, bucket, query), func(attrs *storage.ObjectAttrs) error {
+		if attrs.Prefix != "" {
+			subPrefixes = append(subPrefixes, attrs.Prefix)
+			return nil
+		}
+		return fn(attrs)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefixCh := make(chan string, len(subPrefixes))
+	for _, p := range subPrefixes {
+		prefixCh <- p
+	}
+	close(prefixCh)
+
+	attrsCh := make(chan *storage.ObjectAttrs, concurrency)
+	errCh := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range prefixCh {
+				err := x.iterateObjects(client.List(ctx, bucket, &storage.Query{Prefix: p}), func(attrs *storage.ObjectAttrs) error {
+					select {
+					case attrsCh <- attrs:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+				if err != nil {
+					// Send error before cancel to report the original error rather than context.Canceled of other workers
+					errCh <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(attrsCh)
+	}()
+
+	var fnErr error
+	for attrs := range attrsCh {
+		if fnErr != nil {
+			continue // drain to release workers
+		}
+		if err := fn(attrs); err != nil {
+			fnErr = err
+			cancel()
+		}
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+
+	close(errCh)
+	for err := range errCh {
+		return err
+	}
+	return nil
+}
+
+func (x *UseCase) iterateObjects(it interfaces.CSObjectIterator, fn func(attrs *storage.ObjectAttrs) error) error {
+	for {
+		attrs, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				return nil
+			}
+			x.metrics.errors.Inc()
+			return goerr.Wrap(err, "failed to list objects")
+		}
+
+		if err := fn(attrs); err != nil {
+			return err
+		}
+	}
+}
+
 func sumObjectSize(newOjb *model.Object, objects ...*model.Object) int64 {
 	var sum int64
 	if newOjb.Size != nil {
@@ -86,6 +254,32 @@ func sumObjectSize(newOjb *model.Object, objects ...*model.Object) int64 {
 	return sum
 }
 
+// chunkObjects splits objects into chunks of which count is up to countLimit and total size is up to sizeLimit. An object larger than sizeLimit is put into a chunk alone.
+func chunkObjects(objects []*model.Object, countLimit int, sizeLimit int64) [][]*model.Object {
+	var chunks [][]*model.Object
+	var chunk []*model.Object
+	for _, obj := range objects {
+		if len(chunk) > 0 && (sumObjectSize(obj, chunk...) > sizeLimit || len(chunk) >= countLimit) {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+		chunk = append(chunk, obj)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func (x *UseCase) enqueueObjects(ctx context.Context, objects []*model.Object) error {
+	if err := enqueueObjects(ctx, x.clients.PubSub(), objects); err != nil {
+		x.metrics.errors.Inc()
+		return err
+	}
+	x.metrics.objectsProcessed.Add(int64(len(objects)))
+	return nil
+}
+
 func enqueueObjects(ctx context.Context, client interfaces.PubSub, objects []*model.Object) error {
 	msg := model.SwarmMessage{
 		Objects: objects,
@@ -96,7 +290,7 @@ func enqueueObjects(ctx context.Context, client interfaces.PubSub, objects []*mo
 		return goerr.Wrap(err, "failed to marshal message")
 	}
 
-	if _, err := client.Publish(ctx, raw); err != nil {
+	if _, err := client.Publish(ctx, raw, nil); err != nil {
 		return err
 	}
 
@@ -3,7 +3,14 @@ package usecase_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/m-mizutani/gt"
@@ -12,8 +19,11 @@ import (
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
 	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/metrics"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
 	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
 	"github.com/m-mizutani/swarm/pkg/usecase"
+	"google.golang.org/api/iterator"
 )
 
 func TestEnqueue(t *testing.T) {
@@ -58,3 +68,519 @@ func TestEnqueue(t *testing.T) {
 	})
 	gt.V(t, calledList).Equal(1)
 }
+
+func TestEnqueueWithFilter(t *testing.T) {
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			return &cs.MockObjectIterator{
+				Attrs: []*storage.ObjectAttrs{
+					{Bucket: "bucket", Name: "logs/a.json", Size: 100},
+					{Bucket: "bucket", Name: "logs/2024/02/b.json", Size: 100},
+					{Bucket: "bucket", Name: "logs/2024/02/c.csv", Size: 100},
+				},
+			}
+		},
+	}
+	pubsubMock := pubsub.NewMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithCloudStorage(csMock),
+		infra.WithPubSub(pubsubMock),
+	))
+
+	req := &model.EnqueueRequest{
+		URLs:   []types.ObjectURL{"gs://bucket/logs/"},
+		Filter: gt.R1(types.NewGlobMatcher("logs/**/*.json")).NoError(t),
+	}
+
+	resp := gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+	gt.V(t, resp.Count).Equal(2)
+	gt.A(t, pubsubMock.Results).Length(1).At(0, func(t testing.TB, v *pubsub.MockResult) {
+		var msg model.SwarmMessage
+		gt.NoError(t, json.Unmarshal(v.Data, &msg))
+		gt.A(t, msg.Objects).Length(2)
+		gt.Equal(t, msg.Objects[0].CS.Name, "logs/a.json")
+		gt.Equal(t, msg.Objects[1].CS.Name, "logs/2024/02/b.json")
+	})
+}
+
+func TestEnqueueDatePath(t *testing.T) {
+	var prefixes []string
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			prefixes = append(prefixes, query.Prefix)
+			return &cs.MockObjectIterator{
+				Attrs: []*storage.ObjectAttrs{
+					{Bucket: "bucket", Name: query.Prefix + "a.json", Size: 100},
+				},
+			}
+		},
+	}
+	pubsubMock := pubsub.NewMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithCloudStorage(csMock),
+		infra.WithPubSub(pubsubMock),
+	))
+
+	t.Run("list only date sub-prefixes in range", func(t *testing.T) {
+		prefixes = nil
+		req := &model.EnqueueRequest{
+			URLs: []types.ObjectURL{"gs://bucket/logs/"},
+			DatePath: &model.DatePathRange{
+				Layout: "2006/01/02/",
+				Start:  time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+			},
+		}
+
+		resp := gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+		// January and February 2024 are fully in the range, then listed by month prefix
+		gt.Equal(t, prefixes, []string{
+			"logs/2023/12/31/",
+			"logs/2024/01/",
+			"logs/2024/02/",
+			"logs/2024/03/01/",
+		})
+		gt.V(t, resp.Count).Equal(4)
+	})
+
+	t.Run("hour in the middle of day", func(t *testing.T) {
+		prefixes = nil
+		req := &model.EnqueueRequest{
+			URLs: []types.ObjectURL{"gs://bucket/logs/"},
+			DatePath: &model.DatePathRange{
+				Layout: "dt=2006-01-02/15/",
+				Start:  time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC),
+				End:    time.Date(2024, 3, 3, 1, 30, 0, 0, time.UTC),
+			},
+		}
+
+		gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+		gt.Equal(t, prefixes, []string{
+			"logs/dt=2024-03-01/22/",
+			"logs/dt=2024-03-01/23/",
+			"logs/dt=2024-03-02/",
+			"logs/dt=2024-03-03/00/",
+			"logs/dt=2024-03-03/01/",
+		})
+	})
+
+	t.Run("layout without trailing slash", func(t *testing.T) {
+		prefixes = nil
+		req := &model.EnqueueRequest{
+			URLs: []types.ObjectURL{"gs://bucket/logs/"},
+			DatePath: &model.DatePathRange{
+				Layout: "2006/01/02",
+				Start:  time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+			},
+		}
+
+		gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+		gt.Equal(t, prefixes, []string{
+			"logs/2024/02/28",
+			"logs/2024/02/29",
+			"logs/2024/03/01",
+		})
+	})
+
+	t.Run("range is converted to UTC", func(t *testing.T) {
+		prefixes = nil
+		jst := time.FixedZone("JST", 9*60*60)
+		req := &model.EnqueueRequest{
+			URLs: []types.ObjectURL{"gs://bucket/logs/"},
+			DatePath: &model.DatePathRange{
+				Layout: "2006/01/02/15/",
+				Start:  time.Date(2024, 3, 2, 8, 0, 0, 0, jst),
+				End:    time.Date(2024, 3, 2, 10, 0, 0, 0, jst),
+			},
+		}
+
+		gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+		gt.Equal(t, prefixes, []string{
+			"logs/2024/03/01/23/",
+			"logs/2024/03/02/00/",
+		})
+	})
+
+	t.Run("invalid layout", func(t *testing.T) {
+		for _, layout := range []string{"01/2006/", "logs/2006/", ""} {
+			req := &model.EnqueueRequest{
+				URLs: []types.ObjectURL{"gs://bucket/logs/"},
+				DatePath: &model.DatePathRange{
+					Layout: layout,
+					Start:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+					End:    time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+				},
+			}
+			_, err := uc.Enqueue(context.Background(), req)
+			gt.True(t, errors.Is(err, types.ErrInvalidOption))
+		}
+	})
+}
+
+func TestEnqueueStats(t *testing.T) {
+	attrs := []*storage.ObjectAttrs{
+		{Bucket: "bucket", Name: "object1", Size: 100},
+		{Bucket: "bucket", Name: "object2", Size: 100},
+		{Bucket: "bucket", Name: "object3", Size: 100},
+		{Bucket: "bucket", Name: "object4", Size: 100},
+	}
+
+	paused := make(chan struct{})
+	resume := make(chan struct{})
+	var n int
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			return &cs.MockObjectIterator{
+				MockNext: func() (*storage.ObjectAttrs, error) {
+					if n == 3 {
+						close(paused)
+						<-resume
+					}
+					if n >= len(attrs) {
+						return nil, iterator.Done
+					}
+					n++
+					return attrs[n-1], nil
+				},
+			}
+		},
+	}
+
+	reg := metrics.New()
+	uc := usecase.New(infra.New(
+		infra.WithCloudStorage(csMock),
+		infra.WithPubSub(pubsub.NewMock()),
+	), usecase.WithEnqueueCountLimit(2), usecase.WithMetrics(reg), usecase.WithoutIngestMetrics())
+
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	scrape := func(t *testing.T) string {
+		resp := gt.R1(http.Get(srv.URL)).NoError(t)
+		defer resp.Body.Close()
+		gt.Equal(t, resp.StatusCode, http.StatusOK)
+		return string(gt.R1(io.ReadAll(resp.Body)).NoError(t))
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := uc.Enqueue(context.Background(), &model.EnqueueRequest{
+			URLs: []types.ObjectURL{"gs://bucket/prefix/"},
+		})
+		errCh <- err
+	}()
+
+	<-paused
+	// Three objects are found, and first two objects are enqueued
+	body := scrape(t)
+	gt.String(t, body).Contains("swarm_objects_found_total 3\n")
+	gt.String(t, body).Contains("swarm_objects_processed_total 2\n")
+	gt.String(t, body).Contains("swarm_objects_remaining 1\n")
+	gt.String(t, body).Contains("swarm_errors_total 0\n")
+	gt.String(t, body).Contains("# TYPE swarm_objects_processed_per_second gauge\n")
+	// Enqueue does not ingest records
+	gt.String(t, body).NotContains("swarm_rows_ingested")
+
+	close(resume)
+	gt.NoError(t, <-errCh)
+
+	body = scrape(t)
+	gt.String(t, body).Contains("swarm_objects_processed_total 4\n")
+	gt.String(t, body).Contains("swarm_objects_remaining 0\n")
+}
+
+func TestEnqueueParallelListing(t *testing.T) {
+	const (
+		shardCount     = 16
+		objectsInShard = 100
+	)
+
+	var mutex sync.Mutex
+	var queries []storage.Query
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			mutex.Lock()
+			queries = append(queries, *query)
+			mutex.Unlock()
+
+			var attrs []*storage.ObjectAttrs
+			if query.Delimiter == "/" {
+				gt.Equal(t, query.Prefix, "logs/")
+				// Objects right under the prefix and sub-prefixes
+				attrs = append(attrs,
+					&storage.ObjectAttrs{Bucket: "bucket", Name: "logs/root1.json", Size: 1},
+					&storage.ObjectAttrs{Bucket: "bucket", Name: "logs/root2.json", Size: 1},
+				)
+				for i := 0; i < shardCount; i++ {
+					attrs = append(attrs, &storage.ObjectAttrs{Prefix: fmt.Sprintf("logs/%02d/", i)})
+				}
+			} else {
+				for i := 0; i < objectsInShard; i++ {
+					attrs = append(attrs, &storage.ObjectAttrs{
+						Bucket: "bucket",
+						Name:   fmt.Sprintf("%s%03d.json", query.Prefix, i),
+						Size:   1,
+					})
+				}
+			}
+			return &cs.MockObjectIterator{Attrs: attrs}
+		},
+	}
+
+	pubsubMock := pubsub.NewMock()
+	uc := usecase.New(infra.New(
+		infra.WithCloudStorage(csMock),
+		infra.WithPubSub(pubsubMock),
+	), usecase.WithEnqueueListConcurrency(4))
+
+	req := &model.EnqueueRequest{
+		URLs: []types.ObjectURL{"gs://bucket/logs/"},
+	}
+	resp := gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+	expected := shardCount*objectsInShard + 2
+	gt.V(t, resp.Count).Equal(int64(expected))
+
+	// 1 query with delimiter and 1 query for each shard
+	gt.A(t, queries).Length(shardCount + 1)
+
+	enqueued := map[types.CSObjectID]int{}
+	for _, result := range pubsubMock.Results {
+		var msg model.SwarmMessage
+		gt.NoError(t, json.Unmarshal(result.Data, &msg))
+		gt.True(t, len(msg.Objects) <= 128)
+		for _, obj := range msg.Objects {
+			enqueued[obj.CS.Name]++
+		}
+	}
+	gt.Equal(t, len(enqueued), expected)
+	for name, count := range enqueued {
+		if count != 1 {
+			t.Errorf("object %s is enqueued %d times", name, count)
+		}
+	}
+	gt.Equal(t, enqueued["logs/root1.json"], 1)
+	gt.Equal(t, enqueued["logs/15/099.json"], 1)
+}
+
+func TestEnqueueParallelListingError(t *testing.T) {
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			if query.Delimiter == "/" {
+				return &cs.MockObjectIterator{Attrs: []*storage.ObjectAttrs{
+					{Prefix: "logs/a/"},
+					{Prefix: "logs/b/"},
+				}}
+			}
+			if query.Prefix == "logs/b/" {
+				return &cs.MockObjectIterator{MockNext: func() (*storage.ObjectAttrs, error) {
+					return nil, errors.New("list failed")
+				}}
+			}
+			return &cs.MockObjectIterator{Attrs: []*storage.ObjectAttrs{
+				{Bucket: "bucket", Name: query.Prefix + "x.json"},
+			}}
+		},
+	}
+
+	uc := usecase.New(infra.New(
+		infra.WithCloudStorage(csMock),
+		infra.WithPubSub(pubsub.NewMock()),
+	), usecase.WithEnqueueListConcurrency(2))
+
+	_, err := uc.Enqueue(context.Background(), &model.EnqueueRequest{
+		URLs: []types.ObjectURL{"gs://bucket/logs/"},
+	})
+	gt.Error(t, err)
+	gt.String(t, err.Error()).Contains("list failed")
+}
+
+func TestEnqueueDryRun(t *testing.T) {
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			return &cs.MockObjectIterator{
+				Attrs: []*storage.ObjectAttrs{
+					{Bucket: "bucket", Name: "logs/a.json", Size: 100},
+					{Bucket: "bucket", Name: "logs/b.json", Size: 200},
+					{Bucket: "bucket", Name: "logs/c.csv", Size: 100},
+					{Bucket: "bucket", Name: "logs/d.json", Size: 300},
+				},
+			}
+		},
+	}
+	pubsubMock := pubsub.NewMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithCloudStorage(csMock),
+		infra.WithPubSub(pubsubMock),
+	), usecase.WithEnqueueCountLimit(2))
+
+	req := &model.EnqueueRequest{
+		URLs:   []types.ObjectURL{"gs://bucket/logs/"},
+		Filter: gt.R1(types.NewGlobMatcher("logs/*.json")).NoError(t),
+		DryRun: true,
+	}
+
+	resp := gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+	gt.A(t, pubsubMock.Results).Length(0)
+	gt.V(t, resp.Count).Equal(3)
+	gt.V(t, resp.Size).Equal(600)
+
+	// Objects are split into messages by count limit, and an object not matching filter is reported as filtered
+	gt.NotEqual(t, resp.Plan, nil)
+	gt.A(t, resp.Plan.Messages).Length(2)
+	gt.A(t, resp.Plan.Messages[0]).Length(2)
+	gt.Equal(t, resp.Plan.Messages[0][0].CS.Name, "logs/a.json")
+	gt.Equal(t, resp.Plan.Messages[0][1].CS.Name, "logs/b.json")
+	gt.A(t, resp.Plan.Messages[1]).Length(1)
+	gt.Equal(t, resp.Plan.Messages[1][0].CS.Name, "logs/d.json")
+	gt.Equal(t, *resp.Plan.Messages[1][0].Size, int64(300))
+	gt.A(t, resp.Plan.Filtered).Length(1)
+	gt.Equal(t, resp.Plan.Filtered[0].CS.Name, "logs/c.csv")
+
+	t.Run("plan is not set without dry run", func(t *testing.T) {
+		req.DryRun = false
+		resp := gt.R1(uc.Enqueue(context.Background(), req)).NoError(t)
+		gt.Equal(t, resp.Plan, nil)
+		gt.A(t, pubsubMock.Results).Length(2)
+	})
+}
+
+func TestEnqueueUnroutable(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+}] {
+	endswith(input.cs.name, ".json")
+}
+`
+	csMock := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			return &cs.MockObjectIterator{
+				Attrs: []*storage.ObjectAttrs{
+					{Bucket: "bucket", Name: "logs/a.json", Size: 100},
+					{Bucket: "bucket", Name: "logs/b.txt", Size: 100},
+				},
+			}
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("event.rego", eventPolicy))).NoError(t)
+
+	t.Run("unroutable object fails enqueue", func(t *testing.T) {
+		pubsubMock := pubsub.NewMock()
+		uc := usecase.New(infra.New(
+			infra.WithCloudStorage(csMock),
+			infra.WithPubSub(pubsubMock),
+			infra.WithPolicy(pClient),
+		))
+
+		_, err := uc.Enqueue(context.Background(), &model.EnqueueRequest{
+			URLs: []types.ObjectURL{"gs://bucket/logs/"},
+		})
+		gt.True(t, errors.Is(err, types.ErrNoSourceMatched))
+	})
+
+	t.Run("unroutable object is skipped", func(t *testing.T) {
+		pubsubMock := pubsub.NewMock()
+		uc := usecase.New(infra.New(
+			infra.WithCloudStorage(csMock),
+			infra.WithPubSub(pubsubMock),
+			infra.WithPolicy(pClient),
+		), usecase.WithSkipUnroutable(true))
+
+		resp := gt.R1(uc.Enqueue(context.Background(), &model.EnqueueRequest{
+			URLs:   []types.ObjectURL{"gs://bucket/logs/"},
+			DryRun: true,
+		})).NoError(t)
+		gt.V(t, resp.Count).Equal(1)
+		gt.A(t, resp.Plan.Messages).Length(1)
+		gt.A(t, resp.Plan.Messages[0]).Length(1)
+		gt.Equal(t, resp.Plan.Messages[0][0].CS.Name, "logs/a.json")
+		gt.A(t, resp.Plan.Unroutable).Length(1)
+		gt.Equal(t, resp.Plan.Unroutable[0].CS.Name, "logs/b.txt")
+	})
+
+	t.Run("unroutable object is dead-lettered", func(t *testing.T) {
+		pubsubMock := pubsub.NewMock()
+		deadLetterMock := pubsub.NewMock()
+		uc := usecase.New(infra.New(
+			infra.WithCloudStorage(csMock),
+			infra.WithPubSub(pubsubMock),
+			infra.WithPolicy(pClient),
+		), usecase.WithDeadLetterPubSub(deadLetterMock))
+
+		resp := gt.R1(uc.Enqueue(context.Background(), &model.EnqueueRequest{
+			URLs:       []types.ObjectURL{"gs://bucket/logs/"},
+			Unroutable: types.UnroutableDeadLetter,
+		})).NoError(t)
+		gt.V(t, resp.Count).Equal(1)
+		gt.A(t, resp.Unroutable).Length(1).At(0, func(t testing.TB, v *model.Object) {
+			gt.Equal(t, v.CS.Name, "logs/b.txt")
+		})
+
+		gt.A(t, pubsubMock.Results).Length(1).At(0, func(t testing.TB, v *pubsub.MockResult) {
+			var msg model.SwarmMessage
+			gt.NoError(t, json.Unmarshal(v.Data, &msg))
+			gt.A(t, msg.Objects).Length(1)
+			gt.Equal(t, msg.Objects[0].CS.Name, "logs/a.json")
+		})
+		gt.A(t, deadLetterMock.Results).Length(1).At(0, func(t testing.TB, v *pubsub.MockResult) {
+			var msg model.SwarmMessage
+			gt.NoError(t, json.Unmarshal(v.Data, &msg))
+			gt.A(t, msg.Objects).Length(1)
+			gt.Equal(t, msg.Objects[0].CS.Name, "logs/b.txt")
+		})
+	})
+
+	t.Run("unroutable objects are dead-lettered in chunks", func(t *testing.T) {
+		csMock := &cs.Mock{
+			MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+				it := &cs.MockObjectIterator{}
+				for i := 0; i < 5; i++ {
+					it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: "bucket", Name: fmt.Sprintf("logs/%d.txt", i), Size: 100})
+				}
+				return it
+			},
+		}
+		deadLetterMock := pubsub.NewMock()
+		uc := usecase.New(infra.New(
+			infra.WithCloudStorage(csMock),
+			infra.WithPubSub(pubsub.NewMock()),
+			infra.WithPolicy(pClient),
+		), usecase.WithDeadLetterPubSub(deadLetterMock), usecase.WithEnqueueCountLimit(2))
+
+		resp := gt.R1(uc.Enqueue(context.Background(), &model.EnqueueRequest{
+			URLs:       []types.ObjectURL{"gs://bucket/logs/"},
+			Unroutable: types.UnroutableDeadLetter,
+		})).NoError(t)
+		gt.A(t, resp.Unroutable).Length(5)
+
+		gt.A(t, deadLetterMock.Results).Length(3)
+		var names []types.CSObjectID
+		for i, result := range deadLetterMock.Results {
+			var msg model.SwarmMessage
+			gt.NoError(t, json.Unmarshal(result.Data, &msg))
+			gt.A(t, msg.Objects).Length([]int{2, 2, 1}[i])
+			for _, obj := range msg.Objects {
+				names = append(names, obj.CS.Name)
+			}
+		}
+		gt.Equal(t, names, []types.CSObjectID{"logs/0.txt", "logs/1.txt", "logs/2.txt", "logs/3.txt", "logs/4.txt"})
+	})
+
+	t.Run("dead letter topic is required", func(t *testing.T) {
+		uc := usecase.New(infra.New(
+			infra.WithCloudStorage(csMock),
+			infra.WithPubSub(pubsub.NewMock()),
+			infra.WithPolicy(pClient),
+		))
+
+		_, err := uc.Enqueue(context.Background(), &model.EnqueueRequest{
+			URLs:       []types.ObjectURL{"gs://bucket/logs/"},
+			Unroutable: types.UnroutableDeadLetter,
+		})
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+}
@@ -0,0 +1,149 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"google.golang.org/api/iterator"
+)
+
+// dimension is a dimension table of WithEnrichment cached in memory. Rows are indexed by value of the key column as string.
+type dimension struct {
+	cfg model.EnrichmentConfig
+
+	mutex    sync.Mutex
+	rows     map[string]map[string]any
+	loadedAt time.Time
+}
+
+// LoadDimensions loads all dimension tables of WithEnrichment into memory. It is expected to be called at startup to fail fast by a wrong configuration. If it is not called, a dimension table is loaded when it is used at first.
+func (x *UseCase) LoadDimensions(ctx context.Context) error {
+	for _, d := range x.dimensions {
+		if err := d.load(ctx, x.clients.BigQuery()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enrich adds columns of dimension rows matched with data to data in place. A record that does not have the lookup field, or of which value is not found in the dimension table (missing key), is not changed. Existing fields of data are not overwritten.
+func (x *UseCase) enrich(ctx context.Context, data any) error {
+	obj, ok := data.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	for _, d := range x.dimensions {
+		value, ok := lookupPath(obj, d.cfg.Field)
+		if !ok || value == nil {
+			continue
+		}
+
+		rows, err := d.get(ctx, x.clients.BigQuery())
+		if err != nil {
+			return err
+		}
+
+		key := dimensionKey(value)
+		row, ok := rows[key]
+		if !ok {
+			utils.CtxLogger(ctx).Debug("key is not found in dimension table", "field", d.cfg.Field, "key", key, "dataset", d.cfg.Dataset, "table", d.cfg.Table)
+			continue
+		}
+
+		for column, v := range row {
+			if column == d.cfg.Key || v == nil {
+				continue
+			}
+			if _, exists := obj[column]; exists {
+				continue
+			}
+			obj[column] = cloneWithoutNil(v)
+		}
+	}
+
+	return nil
+}
+
+// get returns rows of the dimension table. The table is loaded if it has not been loaded or Refresh interval has passed. If reloading fails, the previous rows are used with error log because stale enrichment is better than failing ingestion.
+func (x *dimension) get(ctx context.Context, bq interfaces.BigQuery) (map[string]map[string]any, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.rows != nil && (x.cfg.Refresh <= 0 || time.Since(x.loadedAt) < x.cfg.Refresh) {
+		return x.rows, nil
+	}
+
+	if err := x.loadLocked(ctx, bq); err != nil {
+		if x.rows == nil {
+			return nil, err
+		}
+		utils.HandleError(ctx, "failed to refresh dimension table, use previous rows", err)
+		x.loadedAt = time.Now() // wait for the next interval to retry
+	}
+	return x.rows, nil
+}
+
+func (x *dimension) load(ctx context.Context, bq interfaces.BigQuery) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.loadLocked(ctx, bq)
+}
+
+func (x *dimension) loadLocked(ctx context.Context, bq interfaces.BigQuery) error {
+	query := fmt.Sprintf("SELECT * FROM `%s.%s`", x.cfg.Dataset, x.cfg.Table)
+	it, err := bq.Query(ctx, query)
+	if err != nil {
+		return goerr.Wrap(err, "failed to query dimension table").With("query", query)
+	}
+
+	rows := make(map[string]map[string]any)
+	for {
+		var row map[string]bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			return goerr.Wrap(err, "failed to read dimension table").With("query", query)
+		}
+
+		key, ok := row[x.cfg.Key]
+		if !ok || key == nil {
+			continue
+		}
+
+		// Values are converted to JSON compatible ones, e.g. time.Time to RFC3339 string, in the same way as data of records
+		raw, err := json.Marshal(row)
+		if err != nil {
+			return goerr.Wrap(err, "failed to marshal row of dimension table").With("query", query)
+		}
+		var converted map[string]any
+		if err := json.Unmarshal(raw, &converted); err != nil {
+			return goerr.Wrap(err, "failed to unmarshal row of dimension table").With("query", query)
+		}
+		rows[dimensionKey(key)] = converted
+	}
+
+	x.rows = rows
+	x.loadedAt = time.Now()
+	utils.CtxLogger(ctx).Info("dimension table loaded", "dataset", x.cfg.Dataset, "table", x.cfg.Table, "rows", len(rows))
+	return nil
+}
+
+// dimensionKey converts v to a string to compare a value of a record with a key of dimension table. float64 is formatted without exponent because a large integer, e.g. account ID, may be decoded as float64.
+func dimensionKey(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,119 @@
+package usecase_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestLoadEnrichment(t *testing.T) {
+	ctx := context.Background()
+
+	// accountName is returned as account_name of the dimension row of recipientAccountId in cloudtrail_example.json
+	var accountName string
+	newUseCase := func(t *testing.T, refresh time.Duration) (*usecase.UseCase, *bq.GeneralMock) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.MockQuery = func(ctx context.Context, query string) (interfaces.BigQueryIterator, error) {
+			return &bq.MockIterator{
+				Rows: []map[string]bigquery.Value{
+					{"account_id": int64(783957204773), "account_name": accountName, "owner": nil},
+					{"account_id": int64(111111111111), "account_name": "other"},
+				},
+			}, nil
+		}
+
+		uc := newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   cloudTrailExampleRaw,
+			Policy:   []policy.Option{policy.WithDir("testdata/policy")},
+		},
+			usecase.WithEnrichment(model.EnrichmentConfig{
+				Field:   "recipientAccountId",
+				Dataset: "master",
+				Table:   "accounts",
+				Key:     "account_id",
+				Refresh: refresh,
+			}),
+			usecase.WithEnrichment(model.EnrichmentConfig{
+				Field:   "userIdentity.invokedBy",
+				Dataset: "master",
+				Table:   "services",
+				Key:     "service",
+			}),
+		)
+		return uc, bqClient
+	}
+
+	load := func(t *testing.T, uc *usecase.UseCase) {
+		req := &model.LoadRequest{
+			Source: model.Source{
+				Parser: types.JSONParser,
+				Schema: "cloudtrail",
+			},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+	}
+
+	// insertedData returns data of records inserted by the last stream
+	insertedData := func(t *testing.T, bqClient *bq.GeneralMock) []map[string]any {
+		var resp []map[string]any
+		stream := bqClient.Streams[len(bqClient.Streams)-1]
+		for _, rows := range stream.Inserted {
+			for _, row := range rows {
+				record := gt.Cast[*model.LogRecordRaw](t, row)
+				resp = append(resp, gt.Cast[map[string]any](t, record.Data))
+			}
+		}
+		return resp
+	}
+
+	t.Run("add columns of matched dimension row", func(t *testing.T) {
+		accountName = "production"
+		uc, bqClient := newUseCase(t, 0)
+		gt.NoError(t, uc.LoadDimensions(ctx))
+		gt.A(t, bqClient.Queries).Length(2)
+		gt.Equal(t, bqClient.Queries[0], "SELECT * FROM `master.accounts`")
+
+		load(t, uc)
+		data := insertedData(t, bqClient)
+		gt.A(t, data).Length(4)
+		for _, d := range data {
+			gt.Equal(t, d["account_name"], any("production"))
+			// key column and null column are not added
+			_, ok := d["account_id"]
+			gt.False(t, ok)
+			_, ok = d["owner"]
+			gt.False(t, ok)
+			// key of the second dimension is missing, then no column is added
+			_, ok = d["service"]
+			gt.False(t, ok)
+		}
+
+		// Dimension tables are not queried again without refresh
+		gt.A(t, bqClient.Queries).Length(2)
+	})
+
+	t.Run("dimension table is loaded at first use and refreshed", func(t *testing.T) {
+		accountName = "staging"
+		uc, bqClient := newUseCase(t, time.Nanosecond)
+
+		load(t, uc)
+		gt.Equal(t, insertedData(t, bqClient)[0]["account_name"], any("staging"))
+
+		accountName = "renamed"
+		load(t, uc)
+		gt.Equal(t, insertedData(t, bqClient)[0]["account_name"], any("renamed"))
+	})
+}
@@ -2,20 +2,64 @@ package usecase
 
 import (
 	"context"
+	"mime"
+	"strings"
 
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
 )
 
+// ObjectToSources returns sources of the object selected by event policy. If no source is selected or the parser of a source is not supported, the object is unroutable and ErrNoSourceMatched is returned. If WithSkipUnroutable is enabled, empty sources are returned without error instead, and the object is skipped with log.
 func (x *UseCase) ObjectToSources(ctx context.Context, obj model.Object) ([]*model.Source, error) {
 	var event model.EventPolicyOutput
 	if err := x.clients.Policy().Query(ctx, "data.event", obj, &event); err != nil {
 		return nil, err
 	}
 	if len(event.Sources) == 0 {
-		return nil, goerr.Wrap(types.ErrNoPolicyResult, "no source in event").With("input", obj)
+		return x.unroutable(ctx, goerr.Wrap(types.ErrNoSourceMatched, "no source in event").With("input", obj))
+	}
+
+	for _, src := range event.Sources {
+		if src.Parser == "" {
+			src.Parser = selectParser(obj)
+		}
+		if !src.Parser.IsValid() {
+			return x.unroutable(ctx, goerr.Wrap(types.ErrNoSourceMatched, "unsupported parser").With("input", obj).With("parser", src.Parser))
+		}
 	}
 
 	return event.Sources, nil
 }
+
+// unroutable returns err for an object that has no source, or no source and no error if skipUnroutable is enabled.
+func (x *UseCase) unroutable(ctx context.Context, err error) ([]*model.Source, error) {
+	if !x.skipUnroutable {
+		return nil, err
+	}
+
+	utils.CtxLogger(ctx).Warn("skip unroutable object", "error", err)
+	return nil, nil
+}
+
+// selectParser chooses parser from content type and name of the object. JSON parser is used if it can not be determined.
+func selectParser(obj model.Object) types.ObjectParser {
+	if mediaType, _, err := mime.ParseMediaType(obj.ContentType); err == nil {
+		switch mediaType {
+		case "text/csv":
+			return types.CSVParser
+		case "application/json", "application/x-ndjson", "application/jsonl":
+			return types.JSONParser
+		}
+	}
+
+	if obj.CS != nil {
+		name := strings.TrimSuffix(obj.CS.Name.String(), ".gz")
+		if strings.HasSuffix(name, ".csv") {
+			return types.CSVParser
+		}
+	}
+
+	return types.JSONParser
+}
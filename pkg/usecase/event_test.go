@@ -0,0 +1,261 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestObjectToSourcesParserSelection(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "auto",
+}] {
+	input.cs.bucket == "auto-bucket"
+}
+
+src[{
+	"schema": "explicit",
+	"parser": "json",
+}] {
+	input.cs.bucket == "explicit-bucket"
+}
+`
+	pClient := gt.R1(policy.New(policy.WithPolicyData("event.rego", eventPolicy))).NoError(t)
+	uc := usecase.New(infra.New(infra.WithPolicy(pClient)))
+
+	testCases := map[string]struct {
+		bucket      types.CSBucket
+		name        types.CSObjectID
+		contentType string
+		expect      types.ObjectParser
+	}{
+		"csv content type": {
+			bucket:      "auto-bucket",
+			name:        "logs/data",
+			contentType: "text/csv; charset=utf-8",
+			expect:      types.CSVParser,
+		},
+		"json content type": {
+			bucket:      "auto-bucket",
+			name:        "logs/data.csv",
+			contentType: "application/json",
+			expect:      types.JSONParser,
+		},
+		"csv suffix without content type": {
+			bucket: "auto-bucket",
+			name:   "logs/data.csv.gz",
+			expect: types.CSVParser,
+		},
+		"default is json": {
+			bucket:      "auto-bucket",
+			name:        "logs/data",
+			contentType: "application/octet-stream",
+			expect:      types.JSONParser,
+		},
+		"explicit parser is prioritized": {
+			bucket:      "explicit-bucket",
+			name:        "logs/data.csv",
+			contentType: "text/csv",
+			expect:      types.JSONParser,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			obj := model.Object{
+				CS: &model.CloudStorageObject{
+					Bucket: tc.bucket,
+					Name:   tc.name,
+				},
+				ContentType: tc.contentType,
+			}
+			sources := gt.R1(uc.ObjectToSources(context.Background(), obj)).NoError(t)
+			gt.A(t, sources).Length(1)
+			gt.Equal(t, sources[0].Parser, tc.expect)
+		})
+	}
+}
+
+func TestLoadDataByObjectMetadataLabel(t *testing.T) {
+	// Both objects have the same naming convention, and only the label tells log type
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"records_path": "Records",
+}] {
+	input.metadata["log-type"] == "cloudtrail"
+}
+`
+	const schemaPolicy = `package schema.cloudtrail
+
+log[{
+	"dataset": "my_dataset",
+	"table": "cloudtrail",
+	"id": input.eventID,
+	"timestamp": time.parse_rfc3339_ns(input.eventTime) / 1000000000,
+	"data": input,
+}]
+`
+	testCases := map[string]struct {
+		metadata map[string]string
+		isErr    bool
+		ingests  int
+	}{
+		"label selects cloudtrail source": {
+			metadata: map[string]string{"log-type": "cloudtrail"},
+			ingests:  1,
+		},
+		"other label selects no source": {
+			metadata: map[string]string{"log-type": "access_log"},
+			isErr:    true,
+		},
+		"no label": {
+			isErr: true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			csClient := &cs.Mock{
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					return &storage.ObjectAttrs{
+						Bucket:   obj.Bucket.String(),
+						Name:     obj.Name.String(),
+						Size:     1024,
+						Metadata: tc.metadata,
+					}, nil
+				},
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(
+				policy.WithPolicyData("event.rego", eventPolicy),
+				policy.WithPolicyData("schema.rego", schemaPolicy),
+			)).NoError(t)
+			bqClient := bq.NewGeneralMock()
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			err := uc.LoadDataByObject(context.Background(), "gs://my-bucket/logs/data.json")
+			if tc.isErr {
+				gt.Error(t, err)
+			} else {
+				gt.NoError(t, err)
+			}
+			gt.A(t, bqClient.Streams).Length(tc.ingests)
+		})
+	}
+}
+
+func TestObjectToSourcesUnroutable(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+}] {
+	input.cs.bucket == "cloudtrail-bucket"
+}
+
+src[{
+	"schema": "xml",
+	"parser": "xml",
+}] {
+	input.cs.bucket == "xml-bucket"
+}
+`
+	testCases := map[string]struct {
+		bucket types.CSBucket
+		skip   bool
+		isErr  bool
+	}{
+		"routable object": {
+			bucket: "cloudtrail-bucket",
+		},
+		"no source in fail mode": {
+			bucket: "unknown-bucket",
+			isErr:  true,
+		},
+		"no source in skip mode": {
+			bucket: "unknown-bucket",
+			skip:   true,
+		},
+		"unsupported parser in fail mode": {
+			bucket: "xml-bucket",
+			isErr:  true,
+		},
+		"unsupported parser in skip mode": {
+			bucket: "xml-bucket",
+			skip:   true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			csClient := &cs.Mock{
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					return &storage.ObjectAttrs{Bucket: obj.Bucket.String(), Name: obj.Name.String(), Size: 1024}, nil
+				},
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(
+				policy.WithPolicyData("event.rego", eventPolicy),
+				policy.WithFile("testdata/policy/schema.rego"),
+			)).NoError(t)
+			bqClient := bq.NewGeneralMock()
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), usecase.WithSkipUnroutable(tc.skip))
+
+			obj := model.Object{
+				CS: &model.CloudStorageObject{Bucket: tc.bucket, Name: "logs/data.json"},
+			}
+			sources, err := uc.ObjectToSources(context.Background(), obj)
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrNoSourceMatched))
+			} else {
+				gt.NoError(t, err)
+			}
+
+			// Loading the object follows the same mode, and skipped object is not ingested
+			err = uc.LoadDataByObject(context.Background(), types.CSUrl("gs://"+tc.bucket.String()+"/logs/data.json"))
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrNoSourceMatched))
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+
+			if tc.skip {
+				gt.A(t, sources).Length(0)
+				gt.A(t, bqClient.Streams).Length(0)
+			} else {
+				gt.A(t, sources).Length(1)
+				gt.A(t, bqClient.Streams).Length(1)
+			}
+		})
+	}
+}
@@ -1,7 +1,34 @@
 package usecase
 
+import (
+	"context"
+
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/infra"
+)
+
 var (
 	CloneWithoutNil     = cloneWithoutNil
 	CreateOrUpdateTable = createOrUpdateTable
-	IngestRecords       = ingestRecords
+	TruncatePartitions  = truncatePartitions
+	SampleRecords       = sampleRecords
+	InferSchema         = inferSchema
 )
+
+func IngestRecords(ctx context.Context, bq interfaces.BigQuery, dst model.BigQueryDest, records []*model.LogRecord, concurrency int, options ...Option) (*model.IngestLog, error) {
+	options = append([]Option{WithIngestRecordConcurrency(concurrency)}, options...)
+	uc := New(infra.New(infra.WithBigQuery(bq)), options...)
+	return uc.ingestRecords(ctx, dst, records)
+}
+
+func WatchOnce(ctx context.Context, uc *UseCase, req *model.WatchRequest) (int, error) {
+	return uc.watchOnce(ctx, req)
+}
+
+// SetBackfillProgressShardSize changes max bytes of URLs in a document of backfill progress and returns a function to restore it.
+func SetBackfillProgressShardSize(size int) func() {
+	prev := backfillProgressShardSize
+	backfillProgressShardSize = size
+	return func() { backfillProgressShardSize = prev }
+}
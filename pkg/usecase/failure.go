@@ -0,0 +1,32 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// failureWriter writes URLs of objects that failed to be loaded as lines of "{url}\t{error}". A line is written as soon as the load fails, so that the list is kept even if the process is interrupted in a long backfill. URLs can be retried by passing the first column to ingest command.
+type failureWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// Write appends url and err into the writer. It does nothing if x is nil (failure writer is not configured). Failure of writing is only reported because it should not stop loading other objects.
+func (x *failureWriter) Write(ctx context.Context, url types.CSUrl, err error) {
+	if x == nil {
+		return
+	}
+
+	msg := strings.Join(strings.Fields(err.Error()), " ")
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, wErr := fmt.Fprintf(x.w, "%s\t%s\n", url, msg); wErr != nil {
+		utils.HandleError(ctx, "failed to write failed object", wErr)
+	}
+}
@@ -0,0 +1,506 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// parsePathFields returns values of keys in Hive-style "key=value" segments of the object name. Segments without "=" and keys not in keys are ignored, and a missing key is not included in the result. Value is unescaped if it is URL encoded, e.g. "2024-01-01T00%3A00". If a key appears multiple times, the last one is used.
+func parsePathFields(name types.CSObjectID, keys []string) map[string]string {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	fields := map[string]string{}
+	for _, segment := range strings.Split(name.String(), "/") {
+		key, value, ok := strings.Cut(segment, "=")
+		if !ok || !slices.Contains(keys, key) {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+// setPathFields sets fields parsed by parsePathFields into data if data is an object. A field of data takes precedence over the same name path field.
+func setPathFields(data any, fields map[string]string) {
+	obj, ok := data.(map[string]any)
+	if !ok {
+		return
+	}
+	for key, value := range fields {
+		if _, exists := obj[key]; !exists {
+			obj[key] = value
+		}
+	}
+}
+
+// convertFields converts values of fields declared in schema policy. data is modified in place. A field that does not exist in data is ignored because it may be optional in the log. If a field is in array of objects, values in all elements are converted.
+func convertFields(data any, fields map[string]model.FieldSpec) error {
+	for name, spec := range fields {
+		if err := convertField(data, strings.Split(name, "."), spec); err != nil {
+			return goerr.Wrap(err).With("field", name)
+		}
+	}
+	return nil
+}
+
+// renameFields renames fields of data in place. Key of renames is dot separated path of a field, and value is new name of the field in the same object. A field that does not exist in data is ignored. Renames are applied in order of the path, and if a field of the new name already exists, ErrInvalidPolicyResult is returned instead of overwriting it. Arrays in the path are not traversed.
+func renameFields(data any, renames map[string]string) error {
+	paths := make([]string, 0, len(renames))
+	for from := range renames {
+		paths = append(paths, from)
+	}
+	slices.Sort(paths)
+
+	for _, from := range paths {
+		to := renames[from]
+		path := strings.Split(from, ".")
+
+		obj, ok := data.(map[string]any)
+		for _, key := range path[:len(path)-1] {
+			if !ok {
+				break
+			}
+			obj, ok = obj[key].(map[string]any)
+		}
+		if !ok {
+			continue
+		}
+
+		name := path[len(path)-1]
+		value, ok := obj[name]
+		if !ok || name == to {
+			continue
+		}
+		if _, exists := obj[to]; exists {
+			return goerr.Wrap(types.ErrInvalidPolicyResult, "renamed field already exists in log.data").With("field", from).With("to", to)
+		}
+		delete(obj, name)
+		obj[to] = value
+	}
+
+	return nil
+}
+
+// projectFields returns data that has only fields in include, and then removes fields in exclude. Paths are dot separated, and including a nested field keeps only the listed fields in its parent object. Arrays in the path are not traversed. data is modified in place by exclude.
+func projectFields(data any, include, exclude []string) any {
+	obj, ok := data.(map[string]any)
+	if !ok {
+		return data
+	}
+
+	if len(include) > 0 {
+		paths := make([][]string, len(include))
+		for i, field := range include {
+			paths[i] = strings.Split(field, ".")
+		}
+		obj = includeFields(obj, paths)
+	}
+	for _, field := range exclude {
+		excludeField(obj, strings.Split(field, "."))
+	}
+
+	return obj
+}
+
+func includeFields(data map[string]any, paths [][]string) map[string]any {
+	result := map[string]any{}
+	nested := map[string][][]string{}
+	for _, path := range paths {
+		value, ok := data[path[0]]
+		if !ok {
+			continue
+		}
+		if len(path) == 1 {
+			result[path[0]] = value
+			continue
+		}
+		nested[path[0]] = append(nested[path[0]], path[1:])
+	}
+
+	for key, subPaths := range nested {
+		// Whole of the object is already included
+		if _, ok := result[key]; ok {
+			continue
+		}
+		child, ok := data[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		if sub := includeFields(child, subPaths); len(sub) > 0 {
+			result[key] = sub
+		}
+	}
+
+	return result
+}
+
+func excludeField(data map[string]any, path []string) {
+	if len(path) == 1 {
+		delete(data, path[0])
+		return
+	}
+	if child, ok := data[path[0]].(map[string]any); ok {
+		excludeField(child, path[1:])
+	}
+}
+
+func convertField(data any, path []string, spec model.FieldSpec) error {
+	if spec.Type == "" {
+		return nil
+	}
+
+	switch v := data.(type) {
+	case []any:
+		for _, elem := range v {
+			if err := convertField(elem, path, spec); err != nil {
+				return err
+			}
+		}
+
+	case map[string]any:
+		value, ok := v[path[0]]
+		if !ok {
+			return nil
+		}
+		if len(path) > 1 {
+			return convertField(value, path[1:], spec)
+		}
+
+		converted, err := convertValue(value, spec)
+		if err != nil {
+			return err
+		}
+		v[path[0]] = converted
+	}
+
+	return nil
+}
+
+func convertValue(value any, spec model.FieldSpec) (any, error) {
+	if values, ok := value.([]any); ok {
+		converted := make([]any, len(values))
+		for i := range values {
+			v, err := convertValue(values[i], spec)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = v
+		}
+		return converted, nil
+	}
+
+	switch spec.Type {
+	case types.FieldTimestamp:
+		return parseTimestamp(value, spec.Format)
+	case types.FieldString:
+		return formatString(value)
+	case types.FieldNumeric, types.FieldBigNumeric:
+		return model.ParseDecimal(value, spec.Type)
+	case types.FieldBytes:
+		return parseBytes(value)
+	case types.FieldGeography:
+		return parseGeography(value)
+	default:
+		return nil, goerr.Wrap(types.ErrInvalidPolicyResult, "field type is invalid").With("type", spec.Type)
+	}
+}
+
+// parseBytes validates that value is a base64 encoded string for BYTES column. The value is kept as string and decoded when inserting.
+func parseBytes(value any) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", goerr.New("bytes field must be base64 encoded string").With("value", value)
+	}
+	if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+		return "", goerr.Wrap(err, "failed to decode bytes field as base64").With("value", value)
+	}
+	return s, nil
+}
+
+// geoJSONDepth is nesting depth of arrays in "coordinates" of GeoJSON geometry types. Point is [lng, lat], and each type nests arrays of the previous one.
+var geoJSONDepth = map[string]int{
+	"Point":           1,
+	"MultiPoint":      2,
+	"LineString":      2,
+	"MultiLineString": 3,
+	"Polygon":         3,
+	"MultiPolygon":    4,
+}
+
+// wktPattern matches type and body of WKT geometry, e.g. "POINT(1 2)", "POLYGON Z ((...))" and "LINESTRING EMPTY".
+var wktPattern = regexp.MustCompile(`(?i)^(POINT|LINESTRING|POLYGON|MULTIPOINT|MULTILINESTRING|MULTIPOLYGON|GEOMETRYCOLLECTION)\s*(?:Z|M|ZM)?\s*(EMPTY|\(.*\))$`)
+
+// parseGeography validates value as WKT string, GeoJSON geometry object or string of GeoJSON geometry for GEOGRAPHY column, and returns it as string. A GeoJSON object is serialized as JSON string.
+func parseGeography(value any) (string, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		if err := validateGeoJSON(v); err != nil {
+			return "", goerr.Wrap(err).With("value", value)
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", goerr.Wrap(err, "failed to marshal GeoJSON").With("value", value)
+		}
+		return string(raw), nil
+
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasPrefix(s, "{") {
+			var obj map[string]any
+			if err := json.Unmarshal([]byte(s), &obj); err != nil {
+				return "", goerr.Wrap(err, "failed to parse geography field as GeoJSON").With("value", value)
+			}
+			if err := validateGeoJSON(obj); err != nil {
+				return "", goerr.Wrap(err).With("value", value)
+			}
+			return s, nil
+		}
+
+		if err := validateWKT(s); err != nil {
+			return "", goerr.Wrap(err).With("value", value)
+		}
+		return s, nil
+
+	default:
+		return "", goerr.New("geography field must be WKT string or GeoJSON").With("value", value)
+	}
+}
+
+// validateGeoJSON checks type and structure of coordinates of GeoJSON geometry. Feature and FeatureCollection are not geometry and rejected as BigQuery does.
+func validateGeoJSON(obj map[string]any) error {
+	t, _ := obj["type"].(string)
+	if t == "GeometryCollection" {
+		geometries, ok := obj["geometries"].([]any)
+		if !ok {
+			return goerr.New("GeometryCollection must have geometries array")
+		}
+		for _, g := range geometries {
+			child, ok := g.(map[string]any)
+			if !ok {
+				return goerr.New("geometry of GeometryCollection must be object")
+			}
+			if err := validateGeoJSON(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	depth, ok := geoJSONDepth[t]
+	if !ok {
+		return goerr.New("unsupported GeoJSON geometry type").With("type", t)
+	}
+	if !validCoordinates(obj["coordinates"], depth) {
+		return goerr.New("invalid coordinates of GeoJSON geometry").With("type", t)
+	}
+	return nil
+}
+
+// validCoordinates returns true if v is arrays nested depth times with positions of 2 or more numbers at the bottom.
+func validCoordinates(v any, depth int) bool {
+	arr, ok := v.([]any)
+	if !ok {
+		return false
+	}
+	if depth == 1 {
+		if len(arr) < 2 {
+			return false
+		}
+		for _, n := range arr {
+			switch n.(type) {
+			case float64, json.Number:
+			default:
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, elem := range arr {
+		if !validCoordinates(elem, depth-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateWKT checks type keyword, balanced parentheses and characters of coordinates of WKT geometry. Coordinates are checked by BigQuery on insertion.
+func validateWKT(s string) error {
+	m := wktPattern.FindStringSubmatch(s)
+	if m == nil {
+		return goerr.New("geography field is not valid WKT")
+	}
+
+	body := m[2]
+	var depth int
+	for _, c := range body {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return goerr.New("unbalanced parentheses in WKT")
+			}
+		case strings.EqualFold(m[1], "GEOMETRYCOLLECTION"):
+			// Collection has type keywords of child geometries
+		case strings.ContainsRune("0123456789.+-eE, \t\n", c):
+		case strings.EqualFold(body, "EMPTY"):
+		default:
+			return goerr.New("invalid character in WKT").With("char", string(c))
+		}
+	}
+	if depth != 0 {
+		return goerr.New("unbalanced parentheses in WKT")
+	}
+	return nil
+}
+
+func parseTimestamp(value any, format string) (time.Time, error) {
+	switch format {
+	case "", model.TimestampFormatRFC3339:
+		s, ok := value.(string)
+		if !ok {
+			return time.Time{}, goerr.New("timestamp field must be string for RFC3339").With("value", value)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, goerr.Wrap(err, "failed to parse timestamp field").With("value", value)
+		}
+		return t.UTC(), nil
+
+	case model.TimestampFormatUnix, model.TimestampFormatUnixMilli:
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return time.Time{}, goerr.Wrap(err, "failed to parse timestamp field as number").With("value", value)
+			}
+			n = f
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return time.Time{}, goerr.Wrap(err, "failed to parse timestamp field as number").With("value", value)
+			}
+			n = f
+		default:
+			return time.Time{}, goerr.New("timestamp field must be number for Unix time").With("value", value)
+		}
+
+		if format == model.TimestampFormatUnixMilli {
+			n /= 1000
+		}
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return time.Time{}, goerr.New("timestamp field must be string for layout").With("value", value).With("layout", format)
+		}
+		t, err := time.Parse(format, s)
+		if err != nil {
+			return time.Time{}, goerr.Wrap(err, "failed to parse timestamp field").With("value", value).With("layout", format)
+		}
+		return t.UTC(), nil
+	}
+}
+
+// formatString converts value to string. A number is formatted with original digits of JSON if available, so that a large integer such as 64-bit ID keeps precision.
+func formatString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", goerr.New("string field must be string, number or boolean").With("value", value)
+	}
+}
+
+// rangePartitionKey returns integer value of field in data for range partitioning. It returns nil if the field does not exist or is null, and then the record is stored in __NULL__ partition of BigQuery. A value that is not an integer fails.
+func rangePartitionKey(data any, field string) (*int64, error) {
+	value, ok := lookupPath(data, field)
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	var key int64
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, goerr.Wrap(types.ErrInvalidPolicyResult, "range partition field must be integer").With("field", field).With("value", value)
+		}
+		key = n
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return nil, goerr.Wrap(types.ErrInvalidPolicyResult, "range partition field must be integer").With("field", field).With("value", value)
+		}
+		key = int64(v)
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, goerr.Wrap(types.ErrInvalidPolicyResult, "range partition field must be integer").With("field", field).With("value", value)
+		}
+		key = n
+	default:
+		return nil, goerr.Wrap(types.ErrInvalidPolicyResult, "range partition field must be integer").With("field", field).With("value", value)
+	}
+	return &key, nil
+}
+
+// restoreNumbers replaces json.Number in data with float64 in place and returns the replaced data. JSON numbers are decoded as json.Number to keep original digits for string fields, and other numbers are handled as float64 as before.
+func restoreNumbers(data any) (any, error) {
+	switch v := data.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to parse number").With("value", v)
+		}
+		return f, nil
+
+	case map[string]any:
+		for key, value := range v {
+			restored, err := restoreNumbers(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = restored
+		}
+
+	case []any:
+		for i := range v {
+			restored, err := restoreNumbers(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = restored
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,132 @@
+package usecase
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+type fixedSchema struct {
+	schema bigquery.Schema
+	mode   types.FixedSchemaMode
+}
+
+func fixedSchemaKey(dataset types.BQDatasetID, table types.BQTableID) string {
+	return dataset.String() + "." + table.String()
+}
+
+// ReadFixedSchema reads BigQuery schema in JSON format, e.g. output of `bq show --schema`, for WithFixedSchema.
+func ReadFixedSchema(r io.Reader) (bigquery.Schema, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to read fixed schema")
+	}
+
+	schema, err := bigquery.SchemaFromJSON(raw)
+	if err != nil {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "invalid fixed schema").With("error", err.Error())
+	}
+	if len(schema) == 0 {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "fixed schema has no field")
+	}
+	return schema, nil
+}
+
+func (x *UseCase) fixedSchemaOf(dst model.BigQueryDest) *fixedSchema {
+	return x.fixedSchemas[fixedSchemaKey(dst.Dataset, dst.Table)]
+}
+
+// conform validates data of records with the fixed schema. In strict mode, it returns types.ErrUnexpectedField for the first record that has undeclared fields, and types.ErrFieldTypeMismatch for a value of which type does not match the column. In loose mode, it returns copies of records from which undeclared fields are removed and of which values are converted to the column types if possible.
+func (x *fixedSchema) conform(ctx context.Context, dst model.BigQueryDest, records []*model.LogRecord) ([]*model.LogRecord, error) {
+	dataField := findFieldFold(x.schema, "data")
+	if dataField == nil || dataField.Type != bigquery.RecordFieldType {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "fixed schema must have RECORD data column").With("dst", dst)
+	}
+
+	conformed := make([]*model.LogRecord, len(records))
+	removed := 0
+	for i, record := range records {
+		data, unexpected := conformData(record.Data, dataField.Schema, "")
+		if len(unexpected) == 0 {
+			conformed[i] = record
+			continue
+		}
+
+		slices.Sort(unexpected)
+		if x.mode != types.FixedSchemaLoose {
+			return nil, goerr.Wrap(types.ErrUnexpectedField, "record has fields not declared in fixed schema").
+				With("dst", dst).
+				With("id", record.ID).
+				With("fields", unexpected)
+		}
+
+		copied := *record
+		copied.Data = data
+		conformed[i] = &copied
+		removed += len(unexpected)
+	}
+
+	if removed > 0 {
+		utils.CtxLogger(ctx).Warn("removed fields not declared in fixed schema", "dst", dst, "count", removed)
+	}
+	return coerceRecords(dst, conformed, x.schema, x.mode == types.FixedSchemaLoose)
+}
+
+// conformData returns copy of data without fields that are not declared in schema, and dot separated paths of the removed fields. Arrays are checked element by element with the same schema because they are REPEATED fields. data is not copied if no field is removed.
+func conformData(data any, schema bigquery.Schema, prefix string) (any, []string) {
+	switch v := data.(type) {
+	case map[string]any:
+		var unexpected []string
+		copied := make(map[string]any, len(v))
+		for key, value := range v {
+			field := findFieldFold(schema, key)
+			if field == nil {
+				unexpected = append(unexpected, prefix+key)
+				continue
+			}
+			if field.Type == bigquery.RecordFieldType {
+				var nested []string
+				value, nested = conformData(value, field.Schema, prefix+key+".")
+				unexpected = append(unexpected, nested...)
+			}
+			copied[key] = value
+		}
+		if len(unexpected) == 0 {
+			return data, nil
+		}
+		return copied, unexpected
+
+	case []any:
+		var unexpected []string
+		copied := make([]any, len(v))
+		for i, elem := range v {
+			var nested []string
+			copied[i], nested = conformData(elem, schema, prefix)
+			unexpected = append(unexpected, nested...)
+		}
+		if len(unexpected) == 0 {
+			return data, nil
+		}
+		return copied, unexpected
+
+	default:
+		return data, nil
+	}
+}
+
+// findFieldFold returns the field of the name in schema. Column names are compared case-insensitively as BigQuery does.
+func findFieldFold(schema bigquery.Schema, name string) *bigquery.FieldSchema {
+	for _, field := range schema {
+		if strings.EqualFold(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package usecase
+
+import (
+	"sync"
+	"time"
+
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// insertedRecords tracks IDs of records inserted successfully into each destination table for a while. When a load partially fails and is retried (e.g. by redelivery of Pub/Sub message), records inserted by the previous attempt are excluded from the retry to avoid duplication beyond dedup of insert ID by BigQuery.
+type insertedRecords struct {
+	ttl    time.Duration
+	mutex  sync.Mutex
+	rows   map[insertedKey]time.Time
+	purged time.Time
+}
+
+type insertedKey struct {
+	dataset types.BQDatasetID
+	table   types.BQTableID
+	id      types.LogID
+}
+
+func newInsertedRecords(ttl time.Duration) *insertedRecords {
+	return &insertedRecords{
+		ttl:    ttl,
+		rows:   map[insertedKey]time.Time{},
+		purged: time.Now(),
+	}
+}
+
+// filter returns records that have not been inserted into dst within ttl. Records without ID are always returned because they can not be identified. It returns records as is if x is nil.
+func (x *insertedRecords) filter(dst model.BigQueryDest, records []*model.LogRecord) []*model.LogRecord {
+	if x == nil {
+		return records
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	now := time.Now()
+	remaining := make([]*model.LogRecord, 0, len(records))
+	for _, record := range records {
+		if record.ID != "" {
+			if at, ok := x.rows[insertedKey{dataset: dst.Dataset, table: dst.Table, id: record.ID}]; ok && now.Sub(at) < x.ttl {
+				continue
+			}
+		}
+		remaining = append(remaining, record)
+	}
+	return remaining
+}
+
+// add marks records as inserted into dst. Expired entries are purged at most once per ttl.
+func (x *insertedRecords) add(dst model.BigQueryDest, records []*model.LogRecord) {
+	if x == nil {
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(x.purged) >= x.ttl {
+		for key, at := range x.rows {
+			if now.Sub(at) >= x.ttl {
+				delete(x.rows, key)
+			}
+		}
+		x.purged = now
+	}
+
+	for _, record := range records {
+		if record.ID != "" {
+			x.rows[insertedKey{dataset: dst.Dataset, table: dst.Table, id: record.ID}] = now
+		}
+	}
+}
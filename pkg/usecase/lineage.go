@@ -0,0 +1,33 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// writeLineage writes lineage from sources to the destination table of the ingestion into lineage sinks. Failure of writing lineage is only reported because it should not stop ingestion.
+func (x *UseCase) writeLineage(ctx context.Context, log *model.IngestLog, sources []*model.CloudStorageObject) {
+	if len(x.lineageSinks) == 0 || log == nil || len(sources) == 0 {
+		return
+	}
+
+	reqID, _ := utils.CtxRequestID(ctx)
+	lineage := &model.Lineage{
+		RequestID:  reqID,
+		IngestID:   log.ID,
+		Sources:    sources,
+		Dataset:    log.DatasetID,
+		Table:      log.TableID,
+		StartedAt:  log.StartedAt,
+		FinishedAt: log.FinishedAt,
+		Success:    log.Success,
+	}
+
+	for _, sink := range x.lineageSinks {
+		if err := sink.Write(ctx, lineage); err != nil {
+			utils.HandleError(ctx, "failed to write lineage", err)
+		}
+	}
+}
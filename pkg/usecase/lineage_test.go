@@ -0,0 +1,102 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+type fakeLineageSink struct {
+	mutex   sync.Mutex
+	written []*model.Lineage
+}
+
+func (x *fakeLineageSink) Write(ctx context.Context, lineage *model.Lineage) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.written = append(x.written, lineage)
+	return nil
+}
+
+func TestLoadLineage(t *testing.T) {
+	const schemaPolicy = `package schema.lineage
+
+log[{
+	"dataset": "my_dataset",
+	"table": input.kind,
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	objects := map[types.CSObjectID]string{
+		"a.log": `{"kind":"access"}` + "\n" + `{"kind":"audit"}`,
+		"b.log": `{"kind":"access"}`,
+	}
+
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(objects[obj.Name]))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("lineage.rego", schemaPolicy))).NoError(t)
+	sink := &fakeLineageSink{}
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bq.NewGeneralMock()),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithLineageSink(sink),
+	)
+
+	var requests []*model.LoadRequest
+	for _, name := range []types.CSObjectID{"a.log", "b.log"} {
+		requests = append(requests, &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "lineage"},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: name},
+			},
+		})
+	}
+	gt.NoError(t, uc.Load(ctx, requests))
+
+	gt.A(t, sink.written).Length(2)
+	lineages := map[types.BQTableID]*model.Lineage{}
+	for _, l := range sink.written {
+		lineages[l.Table] = l
+	}
+
+	sourceNames := func(l *model.Lineage) []string {
+		var names []string
+		for _, src := range l.Sources {
+			names = append(names, src.Bucket.String()+"/"+src.Name.String())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	access := lineages["access"]
+	gt.NotEqual(t, access, nil)
+	gt.Equal(t, access.Dataset, "my_dataset")
+	gt.True(t, access.Success)
+	gt.NotEqual(t, access.IngestID, "")
+	gt.Equal(t, sourceNames(access), []string{"test-bucket/a.log", "test-bucket/b.log"})
+
+	audit := lineages["audit"]
+	gt.NotEqual(t, audit, nil)
+	gt.Equal(t, sourceNames(audit), []string{"test-bucket/a.log"})
+}
@@ -1,19 +1,35 @@
 package usecase
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
 	"math"
+	"math/rand"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
 	"github.com/hashicorp/go-multierror"
+	"github.com/klauspost/compress/zstd"
+	"github.com/m-mizutani/bqs"
 	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
-	"github.com/m-mizutani/swarm/pkg/infra"
 	"github.com/m-mizutani/swarm/pkg/utils"
 )
 
@@ -30,32 +46,122 @@ func (x *UseCase) LoadDataByObject(ctx context.Context, url types.CSUrl) error {
 
 	attrs, err := x.clients.CloudStorage().Attrs(ctx, csObj)
 	if err != nil {
+		if x.skipMissing(ctx, err, &csObj) {
+			return nil
+		}
 		return goerr.Wrap(err, "failed to get object attributes").With("obj", csObj)
 	}
 
+	return x.loadObjectAttrs(ctx, attrs)
+}
+
+// LoadDataByObjects loads objects of urls by LoadDataByObject in parallel. Number of concurrent loads is limited by concurrency, and 1 or less means sequential. All urls are tried even if some of them fail, and the errors are aggregated. Failed urls are also written by WithFailureWriter if configured.
+func (x *UseCase) LoadDataByObjects(ctx context.Context, urls []types.CSUrl, concurrency int) error {
+	errs := make([]error, len(urls))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := x.LoadDataByObject(ctx, url); err != nil {
+				x.failures.Write(ctx, url, err)
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	var mErr *multierror.Error
+	for i, err := range errs {
+		if err != nil {
+			mErr = multierror.Append(mErr, goerr.Wrap(err, "failed to load data").With("url", urls[i]))
+		}
+	}
+	return mErr.ErrorOrNil()
+}
+
+// loadObjectAttrs loads the object by sources selected by event policy.
+func (x *UseCase) loadObjectAttrs(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	loadReq, err := x.resolveObjectAttrs(ctx, attrs)
+	if err != nil {
+		return err
+	}
+	if len(loadReq) == 0 {
+		return nil
+	}
+
+	return x.Load(ctx, loadReq)
+}
+
+// resolveObjectAttrs returns load requests of the object by sources selected by event policy. It returns no request if the object is skipped, e.g. smaller than minObjectSize or unroutable with WithSkipUnroutable. A source of which max_age the object exceeds is skipped, or the object fails with ErrObjectExpired and is published to dead letter topic by expired_action of the source.
+func (x *UseCase) resolveObjectAttrs(ctx context.Context, attrs *storage.ObjectAttrs) ([]*model.LoadRequest, error) {
+	if attrs.Size < x.minObjectSize {
+		utils.CtxLogger(ctx).Info("skip object smaller than threshold", "bucket", attrs.Bucket, "name", attrs.Name, "size", attrs.Size, "threshold", x.minObjectSize)
+		return nil, nil
+	}
+
 	obj := model.NewObjectFromCloudStorageAttrs(attrs)
 	sources, err := x.ObjectToSources(ctx, obj)
 	if err != nil {
-		return goerr.Wrap(err, "failed to convert event to sources")
+		return nil, goerr.Wrap(err, "failed to convert event to sources")
 	}
 
-	var loadReq []*model.LoadRequest
+	var loadReq, expired []*model.LoadRequest
 	for _, src := range sources {
-		loadReq = append(loadReq, &model.LoadRequest{
+		req := &model.LoadRequest{
 			Object: obj,
 			Source: *src,
-		})
+		}
+		if !isExpired(attrs, src.MaxAge, time.Now()) {
+			loadReq = append(loadReq, req)
+			continue
+		}
+
+		if src.ExpiredAction == types.ExpiredDeadLetter {
+			expired = append(expired, req)
+			continue
+		}
+		utils.CtxLogger(ctx).Info("skip object older than max age of source", "bucket", attrs.Bucket, "name", attrs.Name, "updated", attrs.Updated, "schema", src.Schema, "max_age", src.MaxAge)
 	}
 
-	return x.Load(ctx, loadReq)
+	// The object is rejected as a whole if any source requires dead letter, so that it can be replayed with all sources
+	if len(expired) > 0 {
+		err := goerr.Wrap(types.ErrObjectExpired, "object is older than max age of source").With("bucket", attrs.Bucket).With("name", attrs.Name).With("updated", attrs.Updated).With("schema", expired[0].Source.Schema)
+		x.deadLetterFailure(ctx, append(loadReq, expired...), err)
+		return nil, err
+	}
+
+	return loadReq, nil
+}
+
+// isExpired returns true if the object was last updated more than maxAge seconds before now. maxAge 0 means no limit, and an object without updated time is never expired.
+func isExpired(attrs *storage.ObjectAttrs, maxAge float64, now time.Time) bool {
+	if maxAge <= 0 || attrs.Updated.IsZero() {
+		return false
+	}
+	return now.Sub(attrs.Updated) > time.Duration(maxAge*float64(time.Second))
 }
 
 type ingestRequest struct {
 	dst     model.BigQueryDest
 	records []*model.LogRecord
+	sources []*model.CloudStorageObject
 }
 
 func (x *UseCase) Load(ctx context.Context, requests []*model.LoadRequest) error {
+	_, ctx = utils.CtxRequestID(ctx)
+
+	if err := x.load(ctx, requests); err != nil {
+		x.deadLetterFailure(ctx, requests, err)
+		return err
+	}
+	return nil
+}
+
+func (x *UseCase) load(ctx context.Context, requests []*model.LoadRequest) (retErr error) {
 	reqID, ctx := utils.CtxRequestID(ctx)
 
 	loadLog := model.LoadLog{
@@ -63,70 +169,91 @@ func (x *UseCase) Load(ctx context.Context, requests []*model.LoadRequest) error
 		StartedAt: time.Now(),
 	}
 
+	sinks := x.loadLogSinks
 	if x.metadata != nil {
-		schema, err := setupLoadLogTable(ctx, x.clients.BigQuery(), x.metadata)
-		if err != nil {
-			return err
-		}
-		s, err := x.clients.BigQuery().NewStream(ctx, x.metadata.Dataset(), x.metadata.Table(), schema)
+		sink, err := newBigQueryLoadLogSink(ctx, x.clients.BigQuery(), x.metadata)
 		if err != nil {
 			return err
 		}
-
-		defer func() {
-			if err := s.Insert(ctx, []any{loadLog.Raw()}); err != nil {
-				utils.HandleError(ctx, "failed to insert request log", err)
-			}
-		}()
+		sinks = append([]interfaces.LoadLogSink{sink}, sinks...)
 	}
+	defer func() {
+		for _, sink := range sinks {
+			if err := sink.Write(ctx, &loadLog); err != nil {
+				utils.HandleError(ctx, "failed to write request log", err)
+				// Error of the load is prioritized because it is recorded in the log
+				if x.metadataStrict && retErr == nil {
+					retErr = goerr.Wrap(err, "failed to write load log in strict metadata mode").With("id", loadLog.ID)
+				}
+			}
+		}
+	}()
 	defer func() {
 		loadLog.FinishedAt = time.Now()
 		utils.CtxLogger(ctx).Info("request handled", "req", requests, "proc.log", loadLog)
 	}()
 
-	logRecords, srcLogs, err := importLogRecords(ctx, x.clients, requests, x.readObjectConcurrency)
-	loadLog.Sources = srcLogs
+	// Data objects listed in manifests are loaded under the same load ID as a batch
+	requests, err := x.expandManifests(ctx, requests)
 	if err != nil {
 		loadLog.Error = err.Error()
 		return err
 	}
 
-	reqCh := make(chan ingestRequest, len(logRecords))
-	for dst := range logRecords {
-		reqCh <- ingestRequest{dst: dst, records: logRecords[dst]}
+	logRecords, srcLogs, srcMap, importErr := x.importLogRecords(ctx, requests)
+	loadLog.Sources = srcLogs
+	if importErr != nil {
+		loadLog.Error = importErr.Error()
+		return importErr
+	}
+
+	// Destinations are sorted and results are kept in the order, so that LoadLog.Ingests is deterministic regardless of completion order of ingestion
+	dests := logRecords.Dests()
+	reqCh := make(chan int, len(dests))
+	for i := range dests {
+		reqCh <- i
 	}
 	close(reqCh)
 
-	errCh := make(chan error, len(logRecords))
-	logCh := make(chan *model.IngestLog, len(logRecords))
+	ingestLogs := make([]*model.IngestLog, len(dests))
+	ingestErrs := make([]error, len(dests))
 	var wg sync.WaitGroup
 	for i := 0; i < x.ingestTableConcurrency; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			for req := range reqCh {
-				log, err := ingestRecords(ctx, x.clients.BigQuery(), req.dst, req.records, x.ingestRecordConcurrency)
-				logCh <- log
+			for idx := range reqCh {
+				req := ingestRequest{dst: dests[idx], records: logRecords[dests[idx]], sources: srcMap[dests[idx]]}
+				if err := x.truncatedPartitions.truncate(ctx, x.clients.BigQuery(), req.dst, req.records); err != nil {
+					ingestErrs[idx] = err
+					continue
+				}
+
+				log, err := x.ingestRecords(ctx, req.dst, req.records)
+				ingestLogs[idx] = log
 				if err != nil {
 					log.Error = err.Error()
-					errCh <- err
+					ingestErrs[idx] = err
 				}
+				x.writeLineage(ctx, log, req.sources)
 			}
 		}()
 	}
 
 	wg.Wait()
 
-	close(logCh)
-	for log := range logCh {
-		loadLog.Ingests = append(loadLog.Ingests, log)
+	for _, log := range ingestLogs {
+		if log != nil {
+			loadLog.Ingests = append(loadLog.Ingests, log)
+		}
 	}
 
-	close(errCh)
-	for err := range errCh {
-		loadLog.Error = err.Error()
-		return err
+	for _, err := range ingestErrs {
+		if err != nil {
+			loadLog.Error = err.Error()
+			return err
+		}
 	}
 
 	loadLog.Success = true
@@ -138,24 +265,35 @@ type importSourceResponse struct {
 	log    *model.SourceLog
 }
 
-func importLogRecords(ctx context.Context, clients *infra.Clients, requests []*model.LoadRequest, concurrency int) (model.LogRecordSet, []*model.SourceLog, *multierror.Error) {
+// importLogRecords imports objects of requests and returns log records for each destination, logs of sources and source objects for each destination.
+func (x *UseCase) importLogRecords(ctx context.Context, requests []*model.LoadRequest) (model.LogRecordSet, []*model.SourceLog, map[model.BigQueryDest][]*model.CloudStorageObject, *multierror.Error) {
 	var logs []*model.SourceLog
 	dstMap := model.LogRecordSet{}
+	srcMap := map[model.BigQueryDest][]*model.CloudStorageObject{}
 
 	var wg sync.WaitGroup
 	reqCh := make(chan *model.LoadRequest, len(requests))
 	respCh := make(chan *importSourceResponse, len(requests))
 	errCh := make(chan error, len(requests))
 
-	for i := 0; i < concurrency; i++ {
+	for i := 0; i < x.readObjectConcurrency; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for req := range reqCh {
-				result, err := importSource(ctx, clients, req)
+				result, err := x.importSource(ctx, req)
 				if err != nil {
-					utils.HandleError(ctx, "failed to import source", err)
-					errCh <- err
+					if x.skipMissing(ctx, err, req.Object.CS) {
+						result.log.Missing = true
+					} else {
+						result.log.Error = err.Error()
+						var policyErr *model.PolicyError
+						if errors.As(err, &policyErr) {
+							result.log.PolicyError = policyErr
+						}
+						utils.HandleError(ctx, "failed to import source", err)
+						errCh <- err
+					}
 				}
 				respCh <- result
 			}
@@ -173,6 +311,14 @@ func importLogRecords(ctx context.Context, clients *infra.Clients, requests []*m
 	for req := range respCh {
 		logs = append(logs, req.log)
 		dstMap.Merge(req.dstMap)
+		if req.log.CS != nil {
+			for dst := range req.dstMap {
+				// One object may have multiple sources to the same destination
+				if !slices.ContainsFunc(srcMap[dst], func(obj *model.CloudStorageObject) bool { return *obj == *req.log.CS }) {
+					srcMap[dst] = append(srcMap[dst], req.log.CS)
+				}
+			}
+		}
 	}
 
 	var mErr *multierror.Error
@@ -180,16 +326,30 @@ func importLogRecords(ctx context.Context, clients *infra.Clients, requests []*m
 		mErr = multierror.Append(mErr, err)
 	}
 
-	return dstMap, logs, mErr
+	return dstMap, logs, srcMap, mErr
+}
+
+// skipMissing returns true if err is caused by an object that does not exist and it should be skipped according to failOnMissing option.
+func (x *UseCase) skipMissing(ctx context.Context, err error, obj *model.CloudStorageObject) bool {
+	if x.failOnMissing || !errors.Is(err, storage.ErrObjectNotExist) {
+		return false
+	}
+
+	utils.CtxLogger(ctx).Warn("skip missing object", "obj", obj, "error", err.Error())
+	return true
 }
 
-func importSource(ctx context.Context, clients *infra.Clients, req *model.LoadRequest) (*importSourceResponse, error) {
+func (x *UseCase) importSource(ctx context.Context, req *model.LoadRequest) (*importSourceResponse, error) {
+	req = x.overrideSchema(ctx, req)
+	req, compressErr := x.overrideCompress(ctx, req)
+
 	result := &importSourceResponse{
 		dstMap: model.LogRecordSet{},
 		log: &model.SourceLog{
 			CS:        req.Object.CS,
 			RowCount:  0,
 			Source:    req.Source,
+			Manifest:  req.Manifest,
 			StartedAt: time.Now(),
 		},
 	}
@@ -197,91 +357,925 @@ func importSource(ctx context.Context, clients *infra.Clients, req *model.LoadRe
 		result.log.FinishedAt = time.Now()
 	}()
 
-	rows, err := downloadCloudStorageObject(ctx, clients.CloudStorage(), req)
+	// SourceLog is returned with the error to record the failed object
+	if compressErr != nil {
+		return result, compressErr
+	}
+
+	clients := x.clients
+	obj := *req.Object.CS
+	if x.objectVersion {
+		if err := x.setObjectVersion(ctx, req, result.log); err != nil {
+			return result, err
+		}
+		// Download the recorded generation. Otherwise the object may be overwritten between getting attributes and downloading.
+		obj.Generation = result.log.Generation
+	}
+
+	rows, err := downloadCloudStorageObject(ctx, clients.CloudStorage(), obj, req, x.maxObjectSize, x.verifyChecksum)
 	if err != nil {
 		return result, err
 	}
 
-	for _, row := range rows {
-		result.log.RowCount++
+	return x.importRows(ctx, req, rows, result)
+}
 
-		var output model.SchemaPolicyOutput
-		if err := clients.Policy().Query(ctx, req.Source.Schema.Query(), row, &output); err != nil {
-			return result, err
+// importRows evaluates rows parsed from the object of req by schema policy, and adds log records of them into result.
+func (x *UseCase) importRows(ctx context.Context, req *model.LoadRequest, rows []any, result *importSourceResponse) (*importSourceResponse, error) {
+	var err error
+	if len(req.Source.Drop) > 0 {
+		kept := make([]any, 0, len(rows))
+		for _, row := range rows {
+			if req.Source.Drop.Match(row) {
+				result.log.DropCount++
+				continue
+			}
+			kept = append(kept, row)
 		}
+		rows = kept
+	}
 
-		if len(output.Logs) == 0 {
-			utils.CtxLogger(ctx).Warn("No log data in schema policy", "req", req, "record", row)
-			continue
-		}
+	// objTime is created time of the object, used to shard the destination by object. modTime is modified time of the object, used when schema policy does not return timestamp. They are retrieved only when required.
+	var objTime, modTime float64
+	// seq is sequence number of log records in the object, passed to logIDGenerator
+	var seq int
+
+	// pathFields are fields parsed from the object name, added to data of each log
+	var pathFields map[string]string
+	if req.Object.CS != nil {
+		pathFields = parsePathFields(req.Object.CS.Name, req.Source.PathFields)
+	}
+
+	// fieldNames is a set of top-level field names of data for each destination, used to check MaxFields of the source
+	fieldNames := map[model.BigQueryDest]map[string]struct{}{}
+
+	// addLogs converts logs of schema policy output to records of destinations
+	addLogs := func(logs []*model.Log) error {
+		for _, log := range logs {
+			ingestedAt := time.Now()
+			if log.Timestamp == 0 && x.objectTimeFallback {
+				if modTime == 0 {
+					if modTime, err = x.objectModifiedTime(ctx, req.Object); err != nil {
+						return err
+					}
+				}
+				log.Timestamp = modTime
+			}
+			useIngestedAt := log.Timestamp == 0 && x.ingestTimeFallback
+			if useIngestedAt {
+				log.Timestamp = float64(ingestedAt.UnixMicro()) / 1e6
+			}
+
+			if x.lowerCaseDest {
+				x.normalizeDest(ctx, &log.BigQueryDest)
+			}
+
+			if log.Partition == types.BQPartitionNone && !log.RangePartition.Enabled() && log.Shard == types.BQPartitionNone {
+				log.Partition = x.defaultPartition
+			}
 
-		for _, log := range output.Logs {
 			if err := log.Validate(); err != nil {
-				return result, err
+				return err
+			}
+
+			if log.Shard != types.BQPartitionNone {
+				shardTime := log.Timestamp
+				if log.ShardBy == types.BQShardByObject {
+					if objTime == 0 {
+						if objTime, err = x.objectTimestamp(ctx, req.Object); err != nil {
+							return err
+						}
+					}
+					shardTime = objTime
+				}
+				suffix := log.Shard.ShardSuffix(time.Unix(int64(shardTime), 0))
+				log.Table = types.BQTableID(log.Table.String() + "_" + suffix)
 			}
 
 			newData := cloneWithoutNil(log.Data)
+			if err := renameFields(newData, log.RenameFields); err != nil {
+				return err
+			}
+			if err := convertFields(newData, log.Fields); err != nil {
+				return err
+			}
+			var partitionKey *int64
+			if log.RangePartition.Enabled() {
+				if partitionKey, err = rangePartitionKey(newData, log.RangePartition.Field); err != nil {
+					return err
+				}
+			}
+			newData = projectFields(newData, log.Include, log.Exclude)
+			setPathFields(newData, pathFields)
+			if err := x.enrich(ctx, newData); err != nil {
+				return err
+			}
+			if newData, err = restoreNumbers(newData); err != nil {
+				return err
+			}
+
+			if req.Source.MaxFields > 0 {
+				if err := checkMaxFields(fieldNames, log.BigQueryDest, newData, req.Source.MaxFields); err != nil {
+					return err
+				}
+			}
+
+			insertID, err := log.InsertID()
+			if err != nil {
+				return err
+			}
+			if insertID != "" {
+				log.ID = insertID
+			}
 
 			if log.ID == "" {
-				// TODO: Fix this when adding another object storage service, such as S3
-				log.ID, err = types.NewLogID(newData)
-				if err != nil {
-					return result, err
+				if x.logIDGenerator != nil {
+					log.ID = x.logIDGenerator(req.Object, seq)
+				} else {
+					log.ID, err = types.NewLogID(newData)
+					if err != nil {
+						return err
+					}
 				}
 			}
+			seq++
+
+			if !sampled(log.ID, req.Source.SampleRate) {
+				result.log.SampledOutCount++
+				continue
+			}
 
 			tsNano := math.Mod(log.Timestamp, 1.0) * 1000 * 1000 * 1000
 			record := &model.LogRecord{
 				ID:         log.ID,
 				Timestamp:  time.Unix(int64(log.Timestamp), int64(tsNano)),
-				IngestedAt: time.Now(),
+				IngestedAt: ingestedAt,
 
 				// If there is a field that has nil value in the log.Data, the field can not be estimated field type by bqs.Infer. It will cause an error when inserting data to BigQuery. So, remove nil value from log.Data.
-				Data: newData,
+				Data:   newData,
+				Fields: log.Fields,
+
+				Description:  log.Description,
+				PartitionKey: partitionKey,
+			}
+			if useIngestedAt {
+				// Use the exact ingested time rather than the float timestamp to avoid rounding error
+				record.Timestamp = ingestedAt
 			}
 
 			result.dstMap[log.BigQueryDest] = append(result.dstMap[log.BigQueryDest], record)
 		}
+		return nil
+	}
+
+	if req.Source.ObjectPolicy {
+		output, err := x.queryObjectPolicy(ctx, req.Source.Schema.Query(), rows)
+		if err != nil {
+			return result, err
+		}
+		if output != nil {
+			result.log.RowCount = len(rows)
+			if len(output.Logs) == 0 && len(rows) > 0 {
+				if req.Source.FailOnEmpty {
+					return result, goerr.Wrap(types.ErrNoPolicyResult, "no log data in object schema policy").With("req", req)
+				}
+				utils.CtxLogger(ctx).Warn("No log data in object schema policy", "req", req)
+			}
+			if err := addLogs(output.Logs); err != nil {
+				return result, err
+			}
+
+			result.log.Success = true
+			return result, nil
+		}
+		utils.CtxLogger(ctx).Debug("batch_log is not defined in schema policy, fall back to per-record evaluation", "req", req)
+	}
+
+	batchSize := max(x.policyBatchSize, 1)
+	var outputs []*model.SchemaPolicyOutput
+	for i, row := range rows {
+		result.log.RowCount++
+
+		if i%batchSize == 0 {
+			end := min(i+batchSize, len(rows))
+			if outputs, err = x.querySchemaPolicy(ctx, req.Source.Schema.Query(), rows[i:end]); err != nil {
+				return result, err
+			}
+		}
+		output := outputs[i%batchSize]
+
+		if len(output.Logs) == 0 {
+			if req.Source.FailOnEmpty {
+				return result, goerr.Wrap(types.ErrNoPolicyResult, "no log data in schema policy").With("req", req).With("record", row)
+			}
+			utils.CtxLogger(ctx).Warn("No log data in schema policy", "req", req, "record", row)
+			continue
+		}
+
+		if err := addLogs(output.Logs); err != nil {
+			return result, err
+		}
 	}
 
 	result.log.Success = true
 	return result, nil
 }
 
-func downloadCloudStorageObject(ctx context.Context, csClient interfaces.CloudStorage, req *model.LoadRequest) ([]any, error) {
-	var records []any
-	reader, err := csClient.Open(ctx, *req.Object.CS)
+// sampled returns true if the log of id should be ingested by sampling rate. The result is determined by hash of id, so that the same log is always kept or skipped. rate 0 or 1 and more means no sampling.
+func sampled(id types.LogID, rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// checkMaxFields adds top-level field names of data to names of dst, and returns types.ErrTooManyFields if number of the names exceeds maxFields.
+func checkMaxFields(names map[model.BigQueryDest]map[string]struct{}, dst model.BigQueryDest, data any, maxFields int) error {
+	obj, ok := data.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	if names[dst] == nil {
+		names[dst] = map[string]struct{}{}
+	}
+	for key := range obj {
+		names[dst][key] = struct{}{}
+	}
+
+	if n := len(names[dst]); n > maxFields {
+		return goerr.Wrap(types.ErrTooManyFields, fmt.Sprintf("number of top-level fields of data is %d, exceeding max_fields %d", n, maxFields)).
+			With("dst", dst).
+			With("count", n).
+			With("max_fields", maxFields)
+	}
+	return nil
+}
+
+// querySchemaPolicy evaluates schema policy for rows. If policyBatchSize is more than 1, rows are evaluated by prepared query in a batch. Otherwise, each row is evaluated one by one.
+func (x *UseCase) querySchemaPolicy(ctx context.Context, query string, rows []any) ([]*model.SchemaPolicyOutput, error) {
+	outputs := make([]*model.SchemaPolicyOutput, len(rows))
+	if x.policyBatchSize > 1 {
+		if err := x.clients.Policy().QueryBatch(ctx, query, rows, outputs); err != nil {
+			return nil, err
+		}
+		return outputs, nil
+	}
+
+	for i, row := range rows {
+		outputs[i] = &model.SchemaPolicyOutput{}
+		if err := x.clients.Policy().Query(ctx, query, row, outputs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+// objectPolicyRule is a rule name of schema policy evaluated with all rows of an object for Source.ObjectPolicy.
+const objectPolicyRule = "batch_log"
+
+// queryObjectPolicy evaluates batch_log rule of schema policy with all rows of an object as input array, and returns logs of the rule as one output. It returns nil if the rule is not defined in the policy, and then the caller should fall back to per-record evaluation.
+func (x *UseCase) queryObjectPolicy(ctx context.Context, query string, rows []any) (*model.SchemaPolicyOutput, error) {
+	if rows == nil {
+		// Pass empty array rather than null so that the policy can iterate input
+		rows = []any{}
+	}
+
+	var logs []*model.Log
+	if err := x.clients.Policy().Query(ctx, query+"."+objectPolicyRule, rows, &logs); err != nil {
+		if errors.Is(err, types.ErrNoPolicyResult) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &model.SchemaPolicyOutput{Logs: logs}, nil
+}
+
+// normalizeDest converts dataset and table name of dst to lower case. It warns if the name is changed because it means the schema policy emits inconsistent casing.
+func (x *UseCase) normalizeDest(ctx context.Context, dst *model.BigQueryDest) {
+	dataset := types.BQDatasetID(strings.ToLower(dst.Dataset.String()))
+	table := types.BQTableID(strings.ToLower(dst.Table.String()))
+	if dataset == dst.Dataset && table == dst.Table {
+		return
+	}
+
+	utils.CtxLogger(ctx).Warn("destination name is normalized to lower case",
+		"dataset", dst.Dataset, "table", dst.Table,
+		"normalized.dataset", dataset, "normalized.table", table,
+	)
+	dst.Dataset = dataset
+	dst.Table = table
+}
+
+// setObjectVersion sets generation and CRC32C of the object of req into srcLog.
+func (x *UseCase) setObjectVersion(ctx context.Context, req *model.LoadRequest, srcLog *model.SourceLog) error {
+	attrs, err := x.clients.CloudStorage().Attrs(ctx, *req.Object.CS)
+	if err != nil {
+		return goerr.Wrap(err, "failed to get object attributes for version").With("req", req)
+	}
+	if attrs == nil {
+		return nil
+	}
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, attrs.CRC32C)
+	srcLog.Generation = attrs.Generation
+	srcLog.CRC32C = base64.StdEncoding.EncodeToString(crc)
+	return nil
+}
+
+// overrideCompress returns a copy of req of which Source.Compress is replaced by custom metadata of the object with the key of WithCompressMetadataKey. If the key is not configured or the object does not have the metadata, req is returned as it is.
+func (x *UseCase) overrideCompress(ctx context.Context, req *model.LoadRequest) (*model.LoadRequest, error) {
+	if x.compressMetadataKey == "" {
+		return req, nil
+	}
+	value, ok := req.Object.Metadata[x.compressMetadataKey]
+	if !ok {
+		return req, nil
+	}
+
+	var compress types.ObjectCompress
+	switch v := strings.ToLower(strings.TrimSpace(value)); v {
+	case "gzip", "zstd":
+		compress = types.ObjectCompress(v)
+	case "none":
+		compress = types.NoCompress
+	default:
+		return req, goerr.Wrap(types.ErrUnsupportedCompress, "compression type in object metadata is not supported").
+			With("obj", req.Object.CS).With("key", x.compressMetadataKey).With("value", value)
+	}
+	if compress == req.Source.Compress {
+		return req, nil
+	}
+
+	utils.CtxLogger(ctx).Debug("override compression type by object metadata", "obj", req.Object.CS, "from", req.Source.Compress, "to", compress)
+	newReq := *req
+	newReq.Source.Compress = compress
+	return &newReq, nil
+}
+
+// schemaOverrideKey is a key of custom metadata of an object to override Source.Schema. See WithSchemaOverride.
+const schemaOverrideKey = "swarm-schema"
+
+// overrideSchema returns a copy of req of which Source.Schema is replaced by "swarm-schema" custom metadata of the object if it is allowed by WithSchemaOverride. Otherwise, req is returned as it is.
+func (x *UseCase) overrideSchema(ctx context.Context, req *model.LoadRequest) *model.LoadRequest {
+	if len(x.schemaOverrides) == 0 {
+		return req
+	}
+	schema, ok := req.Object.Metadata[schemaOverrideKey]
+	if !ok || types.ObjectSchema(schema) == req.Source.Schema {
+		return req
+	}
+	if _, ok := x.schemaOverrides[types.ObjectSchema(schema)]; !ok {
+		utils.CtxLogger(ctx).Warn("schema override by object metadata is not allowed", "obj", req.Object.CS, "schema", schema)
+		return req
+	}
+
+	utils.CtxLogger(ctx).Info("override schema by object metadata", "obj", req.Object.CS, "from", req.Source.Schema, "to", schema)
+	newReq := *req
+	newReq.Source.Schema = types.ObjectSchema(schema)
+	return &newReq
+}
+
+// objectTimestamp returns created time of the object as Unix timestamp (second). If the object has no created time, it is retrieved from Cloud Storage.
+func (x *UseCase) objectTimestamp(ctx context.Context, obj model.Object) (float64, error) {
+	if obj.CreatedAt != nil && *obj.CreatedAt > 0 {
+		return float64(*obj.CreatedAt), nil
+	}
+
+	if obj.CS != nil {
+		attrs, err := x.clients.CloudStorage().Attrs(ctx, *obj.CS)
+		if err != nil {
+			return 0, goerr.Wrap(err, "failed to get object attributes for timestamp fallback").With("obj", obj.CS)
+		}
+		if attrs != nil && !attrs.Updated.IsZero() {
+			return float64(attrs.Updated.UnixMicro()) / 1000 / 1000, nil
+		}
+	}
+
+	return 0, goerr.Wrap(types.ErrInvalidPolicyResult, "object time is not available").With("obj", obj)
+}
+
+// objectModifiedTime returns last modified time (Updated attribute) of the object in Cloud Storage as Unix timestamp (second). It is retrieved from Cloud Storage because the object of a request has only created time.
+func (x *UseCase) objectModifiedTime(ctx context.Context, obj model.Object) (float64, error) {
+	if obj.CS != nil {
+		attrs, err := x.clients.CloudStorage().Attrs(ctx, *obj.CS)
+		if err != nil {
+			return 0, goerr.Wrap(err, "failed to get object attributes for timestamp fallback").With("obj", obj.CS)
+		}
+		if attrs != nil && !attrs.Updated.IsZero() {
+			return float64(attrs.Updated.UnixMicro()) / 1000 / 1000, nil
+		}
+	}
+
+	return 0, goerr.Wrap(types.ErrInvalidPolicyResult, "log.timestamp is not set and modified time of object is not available").With("obj", obj)
+}
+
+// downloadCloudStorageObject reads and parses obj, the object of req that may be pinned to a generation. If maxSize is more than 0, reading data larger than maxSize bytes after decompression fails with types.ErrObjectTooLarge. If verifyChecksum is true, CRC32C of the object is verified before parsing.
+func downloadCloudStorageObject(ctx context.Context, csClient interfaces.CloudStorage, obj model.CloudStorageObject, req *model.LoadRequest, maxSize int64, verifyChecksum bool) ([]any, error) {
+	reader, err := csClient.Open(ctx, obj)
 	if err != nil {
 		return nil, goerr.Wrap(err, "failed to open object").With("req", req)
 	}
 	defer reader.Close()
 
-	if req.Source.Compress == types.GZIPComp {
+	if verifyChecksum {
+		if reader, err = verifyCRC32C(ctx, csClient, obj, req, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseObject(reader, req, maxSize)
+}
+
+// parseObject decompresses data of reader and parses it into rows by Source of req. reader is not closed.
+func parseObject(reader io.ReadCloser, req *model.LoadRequest, maxSize int64) ([]any, error) {
+	var records []any
+	switch req.Source.Compress {
+	case types.GZIPComp:
 		r, err := gzip.NewReader(reader)
 		if err != nil {
 			return nil, goerr.Wrap(err, "failed to create gzip reader").With("req", req)
 		}
 		defer r.Close()
 		reader = r
+
+	case types.ZstdComp:
+		r, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, goerr.Wrap(err, "failed to create zstd reader").With("req", req)
+		}
+		defer r.Close()
+		reader = r.IOReadCloser()
+	}
+
+	if maxSize > 0 {
+		reader = &sizeLimitedReader{ReadCloser: reader, remaining: maxSize}
+	}
+
+	body, err := skipLeadingBOM(reader)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to read object").With("req", req)
+	}
+
+	if req.Source.Parser == types.CSVParser {
+		return parseCSV(body, req.Source.CSVEmpty, req.Source.CSVEmptySentinel)
+	}
+
+	var limiter *jsonLimitReader
+	if req.Source.MaxRecordDepth > 0 || req.Source.MaxRecordSize > 0 {
+		limiter = &jsonLimitReader{r: body, maxDepth: req.Source.MaxRecordDepth, maxSize: req.Source.MaxRecordSize}
+		if req.Source.RecordsPath != "" {
+			// A parsed value is an envelope of records, e.g. {"Records":[...]} of CloudTrail. The limits are checked for each extracted record, and the envelope is only checked not to exceed the depth of records in it.
+			if limiter.maxDepth > 0 {
+				limiter.maxDepth += len(strings.Split(req.Source.RecordsPath, ".")) + 1
+			}
+			limiter.maxSize = 0
+		}
+		body = limiter
+	}
+
+	addRecord := func(record any) error {
+		if req.Source.RecordsPath == "" {
+			records = append(records, record)
+			return nil
+		}
+
+		extracted, err := extractRecords(record, req.Source.RecordsPath)
+		if err != nil {
+			return goerr.Wrap(err, "failed to extract records").With("req", req)
+		}
+		for _, r := range extracted {
+			if err := checkRecordLimits(r, req.Source.MaxRecordDepth, req.Source.MaxRecordSize); err != nil {
+				return goerr.Wrap(err, "failed to extract records").With("req", req)
+			}
+		}
+		if req.Source.EnvelopePath != "" {
+			if extracted, err = mergeEnvelope(record, extracted, req.Source.EnvelopePath); err != nil {
+				return goerr.Wrap(err, "failed to merge envelope").With("req", req)
+			}
+		}
+		records = append(records, extracted...)
+		return nil
+	}
+
+	if delim := req.Source.Delimiter; delim != "" && delim != "\n" {
+		if err := decodeDelimitedJSON(body, []byte(delim), req.Source.SingleObject, addRecord); err != nil {
+			if limiter != nil && limiter.err != nil {
+				err = limiter.err
+			}
+			return nil, goerr.Wrap(err, "failed to decode JSON").With("req", req)
+		}
+		return records, nil
 	}
 
-	decoder := json.NewDecoder(reader)
+	decoder := json.NewDecoder(body)
+	// Keep original digits of numbers until fields are converted. A large integer loses precision as float64.
+	decoder.UseNumber()
 	for decoder.More() {
 		var record any
 		if err := decoder.Decode(&record); err != nil {
 			return nil, goerr.Wrap(err, "failed to decode JSON").With("req", req)
 		}
+		if req.Source.SingleObject && decoder.More() {
+			return nil, goerr.New("object has multiple JSON values in single object mode").With("req", req)
+		}
+		if err := addRecord(record); err != nil {
+			return nil, err
+		}
+	}
+	// decoder.More returns false on read error, then the limit error is checked after the loop
+	if limiter != nil && limiter.err != nil {
+		return nil, goerr.Wrap(limiter.err, "failed to decode JSON").With("req", req)
+	}
+
+	return records, nil
+}
+
+// decodeDelimitedJSON splits r by delim and calls fn with a JSON value decoded from each record. Whitespace around a record is ignored and an empty record is skipped, e.g. before the first record separator of JSON text sequences (RFC 7464). A record must have exactly one JSON value.
+func decodeDelimitedJSON(r io.Reader, delim []byte, singleObject bool, fn func(record any) error) error {
+	scanner := bufio.NewScanner(r)
+	// A record is held in memory anyway, then the buffer can grow up to the record size
+	scanner.Buffer(nil, math.MaxInt)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.Index(data, delim); i >= 0 {
+			return i + len(delim), data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	var count int
+	for scanner.Scan() {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		count++
+		if singleObject && count > 1 {
+			return goerr.New("object has multiple JSON values in single object mode")
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var record any
+		if err := decoder.Decode(&record); err != nil {
+			return goerr.Wrap(err, "failed to decode delimited record").With("record", count)
+		}
+		if decoder.More() {
+			return goerr.New("delimited record has multiple JSON values").With("record", count)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return goerr.Wrap(err, "failed to read delimited records")
+	}
+	return nil
+}
+
+// utf8BOM is byte order mark of UTF-8 that some exporters put at the beginning of the object.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipLeadingBOM returns a reader of r without leading whitespace and UTF-8 BOM. Whitespace before and after the BOM is skipped.
+func skipLeadingBOM(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	for {
+		head, err := br.Peek(len(utf8BOM))
+		if bytes.Equal(head, utf8BOM) {
+			_, _ = br.Discard(len(utf8BOM))
+			continue
+		}
+		if len(head) > 0 && isSpace(head[0]) {
+			_, _ = br.Discard(1)
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return br, nil
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// verifyCRC32C reads all bytes of reader and compares CRC32C of them with the checksum in attributes of the object. It returns a reader of the read bytes if they match, and types.ErrCorruptObject if not. Verification is skipped for an object with gzip content encoding because Cloud Storage decompresses it in download and the checksum is of compressed bytes.
+func verifyCRC32C(ctx context.Context, csClient interfaces.CloudStorage, obj model.CloudStorageObject, req *model.LoadRequest, reader io.ReadCloser) (io.ReadCloser, error) {
+	attrs, err := csClient.Attrs(ctx, obj)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to get object attributes for checksum").With("req", req)
+	}
+	if attrs == nil || attrs.ContentEncoding == "gzip" {
+		utils.CtxLogger(ctx).Debug("skip checksum verification", "obj", req.Object.CS)
+		return reader, nil
+	}
+
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to read object").With("req", req)
+	}
+
+	if actual := crc32.Checksum(raw, crc32.MakeTable(crc32.Castagnoli)); actual != attrs.CRC32C {
+		return nil, goerr.Wrap(types.ErrCorruptObject, "CRC32C of downloaded object does not match").
+			With("obj", req.Object.CS).
+			With("expected", attrs.CRC32C).
+			With("actual", actual).
+			With("size", len(raw))
+	}
+
+	return io.NopCloser(bytes.NewReader(raw)), nil
+}
+
+// sizeLimitedReader returns types.ErrObjectTooLarge when read data exceeds the limit. It prevents OOM by highly compressed object, a.k.a. zip bomb.
+type sizeLimitedReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (x *sizeLimitedReader) Read(p []byte) (int, error) {
+	if x.remaining < 0 {
+		return 0, types.ErrObjectTooLarge
+	}
+
+	// Read one more byte than remaining to detect exceeding the limit
+	if int64(len(p)) > x.remaining+1 {
+		p = p[:x.remaining+1]
+	}
+	n, err := x.ReadCloser.Read(p)
+	x.remaining -= int64(n)
+	if x.remaining < 0 {
+		return 0, goerr.Wrap(types.ErrObjectTooLarge, "decompressed object size exceeds the limit")
+	}
+	return n, err
+}
+
+// jsonLimitReader checks nesting depth and byte size of each top-level JSON value while json.Decoder reads it, so that a malicious value is rejected before it is decoded into memory. 0 means no limit. The error is returned by every Read after a limit is exceeded, because json.Decoder reads ahead and may surface the error later.
+type jsonLimitReader struct {
+	r        io.Reader
+	maxDepth int
+	maxSize  int64
+
+	depth    int
+	size     int64
+	inString bool
+	escaped  bool
+	err      error
+}
+
+func (x *jsonLimitReader) Read(p []byte) (int, error) {
+	if x.err != nil {
+		return 0, x.err
+	}
+
+	n, err := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		if x.err = x.scan(p[i]); x.err != nil {
+			return i, x.err
+		}
+	}
+	return n, err
+}
+
+// scan updates state of the current top-level value by c.
+func (x *jsonLimitReader) scan(c byte) error {
+	if x.inString {
+		switch {
+		case x.escaped:
+			x.escaped = false
+		case c == '\\':
+			x.escaped = true
+		case c == '"':
+			x.inString = false
+		}
+	} else {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			if x.depth == 0 {
+				// Between top-level values
+				x.size = 0
+				return nil
+			}
+		case '"':
+			x.inString = true
+		case '{', '[':
+			x.depth++
+			if x.maxDepth > 0 && x.depth > x.maxDepth {
+				return goerr.Wrap(types.ErrRecordTooDeep, fmt.Sprintf("nesting depth of JSON value exceeds max_record_depth %d", x.maxDepth))
+			}
+		case '}', ']':
+			if x.depth > 0 {
+				x.depth--
+			}
+		}
+	}
+
+	x.size++
+	if x.maxSize > 0 && x.size > x.maxSize {
+		return goerr.Wrap(types.ErrRecordTooLarge, fmt.Sprintf("size of JSON value exceeds max_record_size %d bytes", x.maxSize))
+	}
+	if !x.inString && x.depth == 0 && (c == '}' || c == ']' || c == '"') {
+		// End of top-level object, array or string
+		x.size = 0
+	}
+	return nil
+}
+
+// checkRecordLimits checks nesting depth and byte size of record extracted by records_path, as same as jsonLimitReader checks a top-level JSON value. The size is measured as compact JSON. 0 means no limit.
+func checkRecordLimits(record any, maxDepth int, maxSize int64) error {
+	if maxDepth > 0 && valueDepth(record) > maxDepth {
+		return goerr.Wrap(types.ErrRecordTooDeep, fmt.Sprintf("nesting depth of record exceeds max_record_depth %d", maxDepth))
+	}
+	if maxSize > 0 {
+		raw, err := json.Marshal(record)
+		if err != nil {
+			return goerr.Wrap(err, "failed to marshal record")
+		}
+		if int64(len(raw)) > maxSize {
+			return goerr.Wrap(types.ErrRecordTooLarge, fmt.Sprintf("size of record exceeds max_record_size %d bytes", maxSize)).With("size", len(raw))
+		}
+	}
+	return nil
+}
+
+// valueDepth returns nesting depth of objects and arrays in v. A scalar value is 0.
+func valueDepth(v any) int {
+	var depth int
+	switch v := v.(type) {
+	case map[string]any:
+		for _, elem := range v {
+			depth = max(depth, valueDepth(elem))
+		}
+	case []any:
+		for _, elem := range v {
+			depth = max(depth, valueDepth(elem))
+		}
+	default:
+		return 0
+	}
+	return depth + 1
+}
+
+// parseCSV converts CSV data to records. The first line is used as header, and values are stored as string with the header as key. An empty field is converted by emptyPolicy: kept as "", converted to nil or replaced with sentinel.
+func parseCSV(r io.Reader, emptyPolicy types.CSVEmptyPolicy, sentinel string) ([]any, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, goerr.Wrap(err, "failed to read CSV header")
+	}
+
+	var records []any
+	for {
+		row, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, goerr.Wrap(err, "failed to read CSV row").With("line", len(records)+2)
+		}
+
+		record := make(map[string]any, len(header))
+		for i, key := range header {
+			if row[i] != "" {
+				record[key] = row[i]
+				continue
+			}
 
+			switch emptyPolicy {
+			case types.CSVEmptyNull:
+				record[key] = nil
+			case types.CSVEmptySentinel:
+				record[key] = sentinel
+			default:
+				record[key] = ""
+			}
+		}
 		records = append(records, record)
 	}
 
 	return records, nil
 }
 
+// extractRecords returns elements of an array at path in the record. Nested field can be specified by dot separated path, e.g. "detail.Records".
+func extractRecords(record any, path string) ([]any, error) {
+	v, ok := lookupPath(record, path)
+	if !ok {
+		return nil, goerr.New("records path is not found in object").With("path", path)
+	}
+
+	records, ok := v.([]any)
+	if !ok {
+		return nil, goerr.New("value of records path is not array").With("path", path)
+	}
+	return records, nil
+}
+
+// mergeEnvelope returns records into which fields of the object at path in the parsed object are merged. A field of the record takes precedence over the same name field of the envelope.
+func mergeEnvelope(parsed any, records []any, path string) ([]any, error) {
+	v, ok := lookupPath(parsed, path)
+	if !ok {
+		return nil, goerr.New("envelope path is not found in object").With("path", path)
+	}
+	envelope, ok := v.(map[string]any)
+	if !ok {
+		return nil, goerr.New("value of envelope path is not object").With("path", path)
+	}
+
+	merged := make([]any, len(records))
+	for i, record := range records {
+		obj, ok := record.(map[string]any)
+		if !ok {
+			return nil, goerr.New("record is not object, envelope can not be merged").With("path", path).With("record", record)
+		}
+
+		m := make(map[string]any, len(envelope)+len(obj))
+		for k, v := range envelope {
+			m[k] = v
+		}
+		for k, v := range obj {
+			m[k] = v
+		}
+		merged[i] = m
+	}
+
+	return merged, nil
+}
+
+// lookupPath returns value at dot separated path in v.
+func lookupPath(v any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
 const maxIngestLogCount = 256
 
-func ingestRecords(ctx context.Context, bq interfaces.BigQuery, bqDst model.BigQueryDest, records []*model.LogRecord, concurrency int) (*model.IngestLog, error) {
+// acquireInsertSlot waits for a slot of concurrent BigQuery inserts in the process. The returned function must be called to release the slot after inserting.
+func (x *UseCase) acquireInsertSlot(ctx context.Context) (func(), error) {
+	if x.insertSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case x.insertSlots <- struct{}{}:
+		return func() { <-x.insertSlots }, nil
+	case <-ctx.Done():
+		return nil, goerr.Wrap(ctx.Err(), "canceled while waiting insert slot")
+	}
+}
+
+// reportDedupWindow warns records whose timestamp is older than dedupWindow because BigQuery does not deduplicate them by insert ID. It does nothing if dedupWindow is 0.
+func (x *UseCase) reportDedupWindow(ctx context.Context, dst model.BigQueryDest, records []*model.LogRecord) {
+	if x.dedupWindow <= 0 {
+		return
+	}
+
+	threshold := time.Now().Add(-x.dedupWindow)
+	var count int
+	var oldest time.Time
+	for _, r := range records {
+		if !r.Timestamp.Before(threshold) {
+			continue
+		}
+		if count == 0 || r.Timestamp.Before(oldest) {
+			oldest = r.Timestamp
+		}
+		count++
+	}
+	if count == 0 {
+		return
+	}
+
+	x.metrics.rowsOutsideDedupWindow.Add(int64(count))
+	utils.CtxLogger(ctx).Warn("records are outside of dedup window, insert ID does not prevent duplication",
+		"dst", dst,
+		"count", count,
+		"oldest", oldest,
+		"window", x.dedupWindow,
+	)
+}
+
+func (x *UseCase) ingestRecords(ctx context.Context, bqDst model.BigQueryDest, records []*model.LogRecord) (*model.IngestLog, error) {
 	ingestID, ctx := utils.CtxIngestID(ctx)
+	bq := x.clients.BigQuery()
 
 	result := &model.IngestLog{
 		ID:        ingestID,
@@ -293,22 +1287,60 @@ func ingestRecords(ctx context.Context, bq interfaces.BigQuery, bqDst model.BigQ
 
 	defer func() {
 		result.FinishedAt = time.Now()
+		x.metrics.countTableIngest(bqDst, result.Success)
 	}()
 
-	schema, err := inferSchema(records)
-	if err != nil {
-		return result, err
+	if x.ingestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, x.ingestTimeout)
+		defer cancel()
+	}
+
+	var schema bigquery.Schema
+	if fixed := x.fixedSchemaOf(bqDst); fixed != nil {
+		conformed, err := fixed.conform(ctx, bqDst, records)
+		if err != nil {
+			return result, err
+		}
+		records = conformed
+		// Fixed schema is used as is, including mode of columns
+		schema = fixed.schema
+	} else {
+		inferred, err := inferSchema(sampleRecords(records, x.schemaSampleHead, x.schemaSampleRandom))
+		if err != nil {
+			return result, err
+		}
+		setPolicyTags(inferred, collectPolicyTags(records))
+		setFieldTypes(inferred, collectFieldTypes(records))
+		if schema, err = x.pinnedSchema(ctx, bqDst, inferred); err != nil {
+			return result, err
+		}
+		if x.schemaPin {
+			// Values of records are inferred as other types than pinned ones, e.g. number for pinned STRING column
+			if records, err = coerceRecords(bqDst, records, schema, true); err != nil {
+				return result, err
+			}
+		}
+
+		if x.ingestTimeFallback {
+			setRequired(schema, "timestamp")
+		}
 	}
 
 	md, err := buildBQMetadata(schema, bqDst.Partition)
 	if err != nil {
 		return result, err
 	}
+	setRangePartitioning(md, bqDst.RangePartition)
+	md.Description = model.TableDescription(records)
 
-	finalized, err := createOrUpdateTable(ctx, bq, bqDst.Dataset, bqDst.Table, md)
+	finalized, changed, err := applyTableSchema(ctx, bq, bqDst.Dataset, bqDst.Table, md)
 	if err != nil {
 		return result, goerr.Wrap(err, "failed to update schema").With("dst", bqDst)
 	}
+	if changed {
+		x.recordSchemaChange(ctx, bqDst, finalized)
+	}
 
 	jsonSchema, err := schemaToJSON(schema)
 	if err != nil {
@@ -316,6 +1348,22 @@ func ingestRecords(ctx context.Context, bq interfaces.BigQuery, bqDst model.BigQ
 	}
 	result.TableSchema = string(jsonSchema)
 
+	x.reportDedupWindow(ctx, bqDst, records)
+
+	if !x.force {
+		if remaining := x.insertedRecords.filter(bqDst, records); len(remaining) < len(records) {
+			utils.CtxLogger(ctx).Info("skip records already inserted by previous attempt", "dst", bqDst, "count", len(records)-len(remaining))
+			records = remaining
+		}
+	}
+
+	if x.sortByTimestamp {
+		records = slices.Clone(records)
+		slices.SortStableFunc(records, func(a, b *model.LogRecord) int {
+			return a.Timestamp.Compare(b.Timestamp)
+		})
+	}
+
 	recordsCh := make(chan []*model.LogRecord, len(records)/maxIngestLogCount+1)
 	for i := 0; i < len(records); i += maxIngestLogCount {
 		end := min(i+maxIngestLogCount, len(records))
@@ -328,12 +1376,28 @@ func ingestRecords(ctx context.Context, bq interfaces.BigQuery, bqDst model.BigQ
 	if err != nil {
 		return result, err
 	}
-	defer utils.SafeClose(stream)
+	ws := &widenableStream{
+		bq:      bq,
+		dst:     bqDst,
+		schema:  finalized,
+		stream:  stream,
+		onWiden: x.recordSchemaChange,
+
+		retryUnknownField: x.retryUnknownField,
+		insertOptions:     x.insertOptions,
+	}
+	defer ws.Close()
+
+	var errTable *insertErrorTable
+	if x.insertErrorTable {
+		errTable = &insertErrorTable{bq: bq, dst: bqDst}
+		defer errTable.Close()
+	}
 
-	errCh := make(chan error)
+	errCh := make(chan error, x.ingestRecordConcurrency)
 
 	var wg sync.WaitGroup
-	for i := 0; i < concurrency; i++ {
+	for i := 0; i < x.ingestRecordConcurrency; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -345,11 +1409,27 @@ func ingestRecords(ctx context.Context, bq interfaces.BigQuery, bqDst model.BigQ
 					data[i] = subRecords[i].Raw()
 				}
 
-				startedAt := time.Now()
-				if err := stream.Insert(ctx, data); err != nil {
-					errCh <- goerr.Wrap(err, "failed to insert data").With("dst", bqDst)
+				release, err := x.acquireInsertSlot(ctx)
+				if err != nil {
+					errCh <- err
 					return
 				}
+
+				startedAt := time.Now()
+				if err := ws.Insert(ctx, subRecords, data); err != nil {
+					var rowErrs *model.InsertRowErrors
+					if errTable != nil && errors.As(err, &rowErrs) {
+						err = routeRejectedRows(ctx, ws, errTable, subRecords, data, rowErrs)
+					}
+					if err != nil {
+						release()
+						errCh <- goerr.Wrap(err, "failed to insert data").With("dst", bqDst)
+						return
+					}
+				}
+				release()
+				// Only chunks of which append is confirmed are tracked, then failed or canceled chunks are inserted again by the retry
+				x.insertedRecords.add(bqDst, subRecords)
 				utils.CtxLogger(ctx).Debug("inserted data", "dst", bqDst, "count", len(data), "duration", time.Since(startedAt))
 			}
 		}()
@@ -358,15 +1438,188 @@ func ingestRecords(ctx context.Context, bq interfaces.BigQuery, bqDst model.BigQ
 	wg.Wait()
 	close(errCh)
 
+	if x.ingestRequestID {
+		result.RequestIDs = ws.requestIDs
+	}
+
 	var mErr *multierror.Error
 	for err := range errCh {
+		if errors.Is(err, context.DeadlineExceeded) && x.ingestTimeout > 0 {
+			err = goerr.Wrap(err, "ingest timeout exceeded").With("dst", bqDst).With("timeout", x.ingestTimeout)
+		}
 		utils.HandleError(ctx, "failed to insert data", err)
 		mErr = multierror.Append(mErr, err)
 	}
 	if mErr != nil {
+		x.metrics.errors.Inc()
 		return result, mErr
 	}
+	x.metrics.rowsIngested.Add(int64(len(records)))
+
+	if !bqs.Equal(schema, ws.schema) {
+		if jsonSchema, err := schemaToJSON(ws.schema); err == nil {
+			result.TableSchema = string(jsonSchema)
+		}
+	}
 
 	result.Success = true
+	if x.loadLabels {
+		setLoadLabels(ctx, bq, bqDst, time.Now())
+	}
 	return result, nil
 }
+
+// routeRejectedRows writes rows rejected by BigQuery into the error table, and inserts other rows into the original table again because all rows in the request are rejected.
+func routeRejectedRows(ctx context.Context, ws *widenableStream, errTable *insertErrorTable, records []*model.LogRecord, data []any, rowErrs *model.InsertRowErrors) error {
+	reasons := rowErrs.Reasons()
+	utils.CtxLogger(ctx).Warn("rows are rejected, routing to error table", "dst", ws.dst, "count", len(reasons))
+
+	if err := errTable.Write(ctx, records, reasons); err != nil {
+		return err
+	}
+
+	var validRecords []*model.LogRecord
+	var validData []any
+	for i := range records {
+		if _, rejected := reasons[i]; !rejected {
+			validRecords = append(validRecords, records[i])
+			validData = append(validData, data[i])
+		}
+	}
+	if len(validData) == 0 {
+		return nil
+	}
+
+	return ws.Insert(ctx, validRecords, validData)
+}
+
+// sampleRecords returns first head records and random records picked from the rest. If both head and random are 0, it returns all records.
+func sampleRecords(records []*model.LogRecord, head, random int) []*model.LogRecord {
+	if (head == 0 && random == 0) || len(records) <= head+random {
+		return records
+	}
+
+	samples := make([]*model.LogRecord, 0, head+random)
+	samples = append(samples, records[:head]...)
+	for _, i := range rand.Perm(len(records) - head)[:random] {
+		samples = append(samples, records[head+i])
+	}
+
+	return samples
+}
+
+// widenableStream is a wrapper of BigQueryStream. When inserting records fails because the records have fields that are not in the table schema (e.g. schema is inferred by sampled records), it widens the table schema with the records and retries the insertion with a new stream.
+type widenableStream struct {
+	bq     interfaces.BigQuery
+	dst    model.BigQueryDest
+	mutex  sync.RWMutex
+	schema bigquery.Schema
+	stream interfaces.BigQueryStream
+	closed []interfaces.BigQueryStream
+
+	// onWiden is called with the widened schema after the table schema is changed
+	onWiden func(ctx context.Context, dst model.BigQueryDest, schema bigquery.Schema)
+
+	// retryUnknownField is a flag to widen and retry also when BigQuery rejects rows by unknown field
+	retryUnknownField bool
+
+	// insertOptions are given to every insertion of the stream
+	insertOptions model.InsertOptions
+
+	// requestIDs are IDs of insert requests issued by the stream, including failed ones
+	requestIDs []types.BQRequestID
+}
+
+func (x *widenableStream) Insert(ctx context.Context, records []*model.LogRecord, data []any) error {
+	x.mutex.RLock()
+	stream := x.stream
+	x.mutex.RUnlock()
+
+	err := x.insert(ctx, stream, data)
+	if err == nil || !x.needWiden(err) {
+		return err
+	}
+
+	utils.CtxLogger(ctx).Warn("records do not match table schema, widening schema", "dst", x.dst, "error", err)
+	if err := x.widen(ctx, stream, records); err != nil {
+		return err
+	}
+
+	x.mutex.RLock()
+	stream = x.stream
+	x.mutex.RUnlock()
+	return x.insert(ctx, stream, data)
+}
+
+// insert inserts data by stream and keeps ID of the request.
+func (x *widenableStream) insert(ctx context.Context, stream interfaces.BigQueryStream, data []any) error {
+	requestID, err := stream.Insert(ctx, data, x.insertOptions)
+	if requestID != "" {
+		x.mutex.Lock()
+		x.requestIDs = append(x.requestIDs, requestID)
+		x.mutex.Unlock()
+	}
+	return err
+}
+
+// needWiden returns true if err of insertion can be resolved by widening the table schema.
+func (x *widenableStream) needWiden(err error) bool {
+	if errors.Is(err, types.ErrRecordSchemaMismatch) {
+		return true
+	}
+
+	var rowErrs *model.InsertRowErrors
+	return x.retryUnknownField && errors.As(err, &rowErrs) && rowErrs.UnknownField()
+}
+
+func (x *widenableStream) widen(ctx context.Context, failed interfaces.BigQueryStream, records []*model.LogRecord) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	schema, err := inferSchema(records)
+	if err != nil {
+		return err
+	}
+	merged, err := bqs.Merge(x.schema, schema)
+	if err != nil {
+		return goerr.Wrap(err, "failed to merge schema").With("dst", x.dst)
+	}
+
+	// Other goroutine may have already widened the schema
+	if bqs.Equal(x.schema, merged) && x.stream != failed {
+		return nil
+	}
+
+	md, err := buildBQMetadata(merged, x.dst.Partition)
+	if err != nil {
+		return err
+	}
+	setRangePartitioning(md, x.dst.RangePartition)
+	finalized, changed, err := applyTableSchema(ctx, x.bq, x.dst.Dataset, x.dst.Table, md)
+	if err != nil {
+		return goerr.Wrap(err, "failed to widen schema").With("dst", x.dst)
+	}
+	if changed && x.onWiden != nil {
+		x.onWiden(ctx, x.dst, finalized)
+	}
+
+	stream, err := x.bq.NewStream(ctx, x.dst.Dataset, x.dst.Table, finalized)
+	if err != nil {
+		return err
+	}
+
+	x.closed = append(x.closed, x.stream)
+	x.schema = finalized
+	x.stream = stream
+	return nil
+}
+
+func (x *widenableStream) Close() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	for _, s := range x.closed {
+		utils.SafeClose(s)
+	}
+	utils.SafeClose(x.stream)
+}
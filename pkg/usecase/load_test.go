@@ -2,22 +2,45 @@ package usecase_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"log/slog"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
 	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/m-mizutani/bqs"
+	"github.com/m-mizutani/goerr"
 	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
 	"github.com/m-mizutani/swarm/pkg/infra/bq"
 	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/metrics"
 	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
 	"github.com/m-mizutani/swarm/pkg/usecase"
 	"github.com/m-mizutani/swarm/pkg/utils"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 func TestLoadDataByObject(t *testing.T) {
@@ -51,6 +74,43 @@ var cloudTrailExampleRaw []byte
 //go:embed testdata/object/cloudtrail_example.json.gz
 var cloudTrailExampleGzip []byte
 
+// testClients are clients of UseCase created by newTestUseCase. If CloudStorage is nil, a mock returning Object as content of any object is used. Policy client is created by Policy options if any. BigQuery and Database are set only if not nil.
+type testClients struct {
+	BigQuery     interfaces.BigQuery
+	CloudStorage interfaces.CloudStorage
+	Object       []byte
+	Policy       []policy.Option
+	Database     interfaces.Database
+}
+
+// newTestUseCase creates UseCase with clients for tests.
+func newTestUseCase(t *testing.T, clients testClients, options ...usecase.Option) *usecase.UseCase {
+	t.Helper()
+
+	csClient := clients.CloudStorage
+	if csClient == nil {
+		csClient = &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(clients.Object)), nil
+			},
+		}
+	}
+
+	infraOptions := []infra.Option{infra.WithCloudStorage(csClient)}
+	if clients.BigQuery != nil {
+		infraOptions = append(infraOptions, infra.WithBigQuery(clients.BigQuery))
+	}
+	if len(clients.Policy) > 0 {
+		pClient := gt.R1(policy.New(clients.Policy...)).NoError(t)
+		infraOptions = append(infraOptions, infra.WithPolicy(pClient))
+	}
+	if clients.Database != nil {
+		infraOptions = append(infraOptions, infra.WithDatabase(clients.Database))
+	}
+
+	return usecase.New(infra.New(infraOptions...), options...)
+}
+
 func TestLoadData(t *testing.T) {
 	testCases := map[string]struct {
 		objectName types.CSObjectID
@@ -127,6 +187,127 @@ func TestLoadData(t *testing.T) {
 	}
 }
 
+func TestLoadMetadataStrict(t *testing.T) {
+	testCases := map[string]struct {
+		strict bool
+		isErr  bool
+	}{
+		"fail load if metadata insert fails in strict mode": {
+			strict: true,
+			isErr:  true,
+		},
+		"ignore metadata insert failure by default": {
+			strict: false,
+			isErr:  false,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			bqClient.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+				if datasetID == "meta-dataset" {
+					return errors.New("quota exceeded")
+				}
+				return nil
+			}
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+
+			uc := usecase.New(
+				infra.New(
+					infra.WithBigQuery(bqClient),
+					infra.WithCloudStorage(csClient),
+					infra.WithPolicy(pClient),
+				),
+				usecase.WithMetadata(model.NewMetadataConfig("meta-dataset", "load_logs")),
+				usecase.WithMetadataStrict(tc.strict),
+			)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "cloudtrail",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.String(t, err.Error()).Contains("quota exceeded")
+			} else {
+				gt.NoError(t, err)
+			}
+
+			// Data is ingested regardless of metadata failure
+			gt.A(t, bqClient.Streams).Length(2)
+			gt.A(t, bqClient.Streams[1].Inserted[0]).Length(4)
+		})
+	}
+}
+
+func TestLoadIngestsOrder(t *testing.T) {
+	const schemaPolicy = `package schema.multi
+
+log[{
+	"dataset": input.dataset,
+	"table": input.table,
+	"id": input.id,
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const data = `{"id":"1","dataset":"ds_b","table":"t2"}
+{"id":"2","dataset":"ds_a","table":"t3"}
+{"id":"3","dataset":"ds_b","table":"t1"}
+{"id":"4","dataset":"ds_a","table":"t1"}
+{"id":"5","dataset":"ds_c","table":"t0"}
+{"id":"6","dataset":"ds_a","table":"t2"}
+`
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data)), nil
+		},
+	}
+
+	expected := []string{"ds_a.t1", "ds_a.t2", "ds_a.t3", "ds_b.t1", "ds_b.t2", "ds_c.t0"}
+	for i := 0; i < 10; i++ {
+		sink := &fakeLoadLogSink{}
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bq.NewGeneralMock()),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), usecase.WithLoadLogSink(sink), usecase.WithIngestTableConcurrency(len(expected)))
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "multi"},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+
+		gt.A(t, sink.written).Length(1)
+		var loadLog model.LoadLog
+		gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+
+		var actual []string
+		for _, ingest := range loadLog.Ingests {
+			actual = append(actual, fmt.Sprintf("%s.%s", ingest.DatasetID, ingest.TableID))
+		}
+		gt.Equal(t, actual, expected)
+	}
+}
+
 func TestIngestRecordBigNum(t *testing.T) {
 	bqMock := bq.NewGeneralMock()
 	ctx := context.Background()
@@ -159,3 +340,4746 @@ func TestIngestRecordBigNum(t *testing.T) {
 		gt.Equal(t, total, dataSize)
 	})
 }
+
+func TestLoadLogTableOptions(t *testing.T) {
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	meta := model.NewMetadataConfig("test-dataset", "test-table",
+		model.WithMetadataPartition("started_at", types.BQPartitionDay),
+		model.WithMetadataClustering("success"),
+		model.WithMetadataExpiration(24*time.Hour),
+	)
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(&cs.Mock{}),
+		),
+		usecase.WithMetadata(meta),
+	)
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{}))
+
+	gt.A(t, bqClient.CreatedTable).Length(1).At(0, func(t testing.TB, v struct {
+		Dataset types.BQDatasetID
+		Table   types.BQTableID
+		MD      *bigquery.TableMetadata
+	}) {
+		gt.Equal(t, v.Table, "test-table")
+		gt.Equal(t, v.MD.TimePartitioning.Field, "started_at")
+		gt.Equal(t, v.MD.TimePartitioning.Type, bigquery.DayPartitioningType)
+		gt.Equal(t, v.MD.TimePartitioning.Expiration, 24*time.Hour)
+		gt.A(t, v.MD.Clustering.Fields).Length(1).At(0, func(t testing.TB, v string) {
+			gt.Equal(t, v, "success")
+		})
+	})
+}
+
+type fakeLoadLogSink struct {
+	mutex   sync.Mutex
+	written [][]byte
+}
+
+func (x *fakeLoadLogSink) Write(ctx context.Context, log *model.LoadLog) error {
+	raw, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.written = append(x.written, raw)
+	return nil
+}
+
+func TestLoadLogSink(t *testing.T) {
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+	sink := &fakeLoadLogSink{}
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bq.NewGeneralMock()),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithLoadLogSink(sink),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser:   types.JSONParser,
+			Schema:   "cloudtrail",
+			Compress: types.NoCompress,
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "cloudtrail_example.log",
+			},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	gt.A(t, sink.written).Length(1)
+	var loadLog model.LoadLog
+	gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+	gt.True(t, loadLog.Success)
+	gt.NotEqual(t, loadLog.ID, "")
+	gt.False(t, loadLog.FinishedAt.IsZero())
+	gt.A(t, loadLog.Sources).Length(1).At(0, func(t testing.TB, v *model.SourceLog) {
+		// The object is a single JSON row, and its Records are expanded into logs by schema policy
+		gt.Equal(t, v.RowCount, 1)
+	})
+	gt.A(t, loadLog.Ingests).Length(1).At(0, func(t testing.TB, v *model.IngestLog) {
+		gt.Equal(t, v.LogCount, 4)
+	})
+}
+
+func TestLoadIngestRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		options []usecase.Option
+		record  bool
+	}{
+		"record request IDs of inserts": {
+			options: []usecase.Option{usecase.WithIngestRequestID()},
+			record:  true,
+		},
+		"not record request IDs by default": {
+			record: false,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+			bqClient := bq.NewGeneralMock()
+			sink := &fakeLoadLogSink{}
+
+			uc := usecase.New(
+				infra.New(
+					infra.WithBigQuery(bqClient),
+					infra.WithCloudStorage(csClient),
+					infra.WithPolicy(pClient),
+				),
+				append(tc.options, usecase.WithLoadLogSink(sink))...,
+			)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "cloudtrail",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+			gt.A(t, bqClient.Streams).Length(1)
+			issued := bqClient.Streams[0].RequestIDs
+			gt.A(t, issued).Length(1)
+
+			gt.A(t, sink.written).Length(1)
+			var loadLog model.LoadLog
+			gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+			gt.A(t, loadLog.Ingests).Length(1).At(0, func(t testing.TB, v *model.IngestLog) {
+				if tc.record {
+					gt.Equal(t, v.RequestIDs, issued)
+				} else {
+					gt.A(t, v.RequestIDs).Length(0)
+				}
+			})
+		})
+	}
+}
+
+func TestLoadInsertOptions(t *testing.T) {
+	testCases := map[string]struct {
+		options []usecase.Option
+		expect  model.InsertOptions
+	}{
+		"skip invalid rows": {
+			options: []usecase.Option{usecase.WithSkipInvalidRows()},
+			expect:  model.InsertOptions{SkipInvalidRows: true},
+		},
+		"ignore unknown values": {
+			options: []usecase.Option{usecase.WithIgnoreUnknownValues()},
+			expect:  model.InsertOptions{IgnoreUnknownValues: true},
+		},
+		"both options": {
+			options: []usecase.Option{usecase.WithSkipInvalidRows(), usecase.WithIgnoreUnknownValues()},
+			expect:  model.InsertOptions{SkipInvalidRows: true, IgnoreUnknownValues: true},
+		},
+		"no option by default": {
+			expect: model.InsertOptions{},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+			bqClient := bq.NewGeneralMock()
+
+			uc := usecase.New(
+				infra.New(
+					infra.WithBigQuery(bqClient),
+					infra.WithCloudStorage(csClient),
+					infra.WithPolicy(pClient),
+				),
+				tc.options...,
+			)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "cloudtrail",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+			gt.A(t, bqClient.Streams).Length(1).At(0, func(t testing.TB, v *bq.MockStream) {
+				gt.A(t, v.Options).Length(1).At(0, func(t testing.TB, v model.InsertOptions) {
+					gt.Equal(t, v, tc.expect)
+				})
+			})
+		})
+	}
+}
+
+func TestLoadInsertTracking(t *testing.T) {
+	const schemaPolicy = `package schema.tracking
+
+log[{
+	"dataset": "my_dataset",
+	"table": "my_table",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	var logData bytes.Buffer
+	for i := 0; i < 600; i++ {
+		fmt.Fprintf(&logData, "{\"seq\":%d}\n", i)
+	}
+
+	newUseCase := func(bqClient *bq.GeneralMock, options ...usecase.Option) *usecase.UseCase {
+		return newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   logData.Bytes(),
+			Policy:   []policy.Option{policy.WithPolicyData("schema.rego", schemaPolicy)},
+		}, append([]usecase.Option{usecase.WithIngestRecordConcurrency(1)}, options...)...)
+	}
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "tracking"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+
+	// The second chunk fails only in the first attempt
+	failSecondChunk := func(bqClient *bq.GeneralMock) {
+		var calls int
+		bqClient.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+			calls++
+			if calls == 2 {
+				return errors.New("transient error")
+			}
+			return nil
+		}
+	}
+	insertedSeq := func(streams []*bq.MockStream) []float64 {
+		var seq []float64
+		for _, s := range streams {
+			for _, data := range s.Inserted {
+				for _, v := range data {
+					raw := gt.Cast[*model.LogRecordRaw](t, v)
+					seq = append(seq, gt.Cast[map[string]any](t, raw.Data)["seq"].(float64))
+				}
+			}
+		}
+		return seq
+	}
+
+	t.Run("retry inserts only records not inserted", func(t *testing.T) {
+		ctx := context.Background()
+		bqClient := bq.NewGeneralMock()
+		failSecondChunk(bqClient)
+		uc := newUseCase(bqClient, usecase.WithInsertTracking(time.Hour))
+
+		gt.Error(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		first := insertedSeq(bqClient.Streams)
+		// The first chunk (256 records) is inserted, and the third chunk is not inserted because the worker stops by the error
+		gt.A(t, first).Length(256)
+
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		retried := insertedSeq(bqClient.Streams[1:])
+		gt.A(t, retried).Length(600 - 256)
+		for _, seq := range retried {
+			gt.True(t, seq >= 256)
+		}
+	})
+
+	t.Run("chunk of which append is not confirmed is inserted again", func(t *testing.T) {
+		ctx := context.Background()
+		bqClient := bq.NewGeneralMock()
+		var calls int
+		bqClient.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+			calls++
+			if calls == 2 {
+				// The append hangs and is canceled by the ingest timeout
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}
+		uc := newUseCase(bqClient,
+			usecase.WithInsertTracking(time.Hour),
+			usecase.WithIngestTimeout(100*time.Millisecond),
+		)
+
+		gt.Error(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		gt.A(t, insertedSeq(bqClient.Streams)).Length(256)
+
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		retried := insertedSeq(bqClient.Streams[1:])
+		gt.A(t, retried).Length(600 - 256)
+		for _, seq := range retried {
+			gt.True(t, seq >= 256)
+		}
+	})
+
+	t.Run("retry inserts all records without tracking", func(t *testing.T) {
+		ctx := context.Background()
+		bqClient := bq.NewGeneralMock()
+		failSecondChunk(bqClient)
+		uc := newUseCase(bqClient)
+
+		gt.Error(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		gt.A(t, insertedSeq(bqClient.Streams[1:])).Length(600)
+	})
+}
+
+func TestTruncatePartitions(t *testing.T) {
+	ctx := context.Background()
+	dst := model.BigQueryDest{
+		Dataset:   "test-dataset",
+		Table:     "test-table",
+		Partition: types.BQPartitionDay,
+	}
+	records := []*model.LogRecord{
+		{Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Timestamp: time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC)},
+		{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	t.Run("table exists", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{}}
+		gt.NoError(t, usecase.TruncatePartitions(ctx, bqClient, dst, records))
+		gt.A(t, bqClient.Queries).Length(1).At(0, func(t testing.TB, v string) {
+			gt.Equal(t, v, "DELETE FROM `test-dataset.test-table` WHERE TIMESTAMP_TRUNC(timestamp, DAY) IN "+
+				`(TIMESTAMP("2024-01-01T00:00:00Z"), TIMESTAMP("2024-01-02T00:00:00Z"))`)
+		})
+	})
+
+	t.Run("table does not exist", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, usecase.TruncatePartitions(ctx, bqClient, dst, records))
+		gt.A(t, bqClient.Queries).Length(0)
+	})
+}
+
+func TestLoadTruncatePartitionOnce(t *testing.T) {
+	const schemaPolicy = `package schema.truncate
+
+log[{
+	"dataset": "my_dataset",
+	"table": "my_table",
+	"partition": "day",
+	"timestamp": input.ts,
+	"data": input,
+}]
+`
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			// Both objects have records in the same partition (2024-02-17)
+			data := fmt.Sprintf(`{"ts":1708130907,"name":"%s"}`, obj.Name)
+			return io.NopCloser(bytes.NewReader([]byte(data))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+	bqClient := bq.NewGeneralMock()
+	for i := 0; i < 4; i++ {
+		bqClient.Metadata = append(bqClient.Metadata, &bigquery.TableMetadata{})
+	}
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithTruncatePartition(),
+	)
+
+	var wg sync.WaitGroup
+	for _, name := range []types.CSObjectID{"1.log", "2.log"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &model.LoadRequest{
+				Source: model.Source{Parser: types.JSONParser, Schema: "truncate"},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: name},
+				},
+			}
+			gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+		}()
+	}
+	wg.Wait()
+
+	// The partition is truncated only by the first load, then rows of both objects are kept
+	gt.A(t, bqClient.Queries).Length(1).At(0, func(t testing.TB, v string) {
+		gt.Equal(t, v, "DELETE FROM `my_dataset.my_table` WHERE TIMESTAMP_TRUNC(timestamp, DAY) IN "+
+			`(TIMESTAMP("2024-02-17T00:00:00Z"))`)
+	})
+	var names []string
+	for _, s := range bqClient.Streams {
+		for _, data := range s.Inserted {
+			for _, v := range data {
+				raw := gt.Cast[*model.LogRecordRaw](t, v)
+				names = append(names, gt.Cast[map[string]any](t, raw.Data)["name"].(string))
+			}
+		}
+	}
+	sort.Strings(names)
+	gt.Equal(t, names, []string{"1.log", "2.log"})
+}
+
+func TestIngestRecordsWithSchemaSample(t *testing.T) {
+	ctx := context.Background()
+	dst := model.BigQueryDest{
+		Dataset: "test-dataset",
+		Table:   "test-table",
+	}
+
+	newRecords := func() []*model.LogRecord {
+		var records []*model.LogRecord
+		for i := 0; i < 1000; i++ {
+			records = append(records, &model.LogRecord{
+				ID:        types.LogID(uuid.NewString()),
+				Timestamp: time.Now(),
+				Data: map[string]any{
+					"name": "blue",
+					"num":  i,
+					"nested": map[string]any{
+						"ok": true,
+					},
+				},
+				IngestedAt: time.Now(),
+			})
+		}
+		return records
+	}
+
+	fullMock := bq.NewGeneralMock()
+	gt.R1(usecase.IngestRecords(ctx, fullMock, dst, newRecords(), 4)).NoError(t)
+
+	sampledMock := bq.NewGeneralMock()
+	resp := gt.R1(usecase.IngestRecords(ctx, sampledMock, dst, newRecords(), 4, usecase.WithSchemaSample(10, 10))).NoError(t)
+	gt.True(t, resp.Success)
+
+	gt.A(t, fullMock.CreatedTable).Length(1)
+	gt.A(t, sampledMock.CreatedTable).Length(1)
+	gt.True(t, bqs.Equal(fullMock.CreatedTable[0].MD.Schema, sampledMock.CreatedTable[0].MD.Schema))
+
+	gt.A(t, sampledMock.Streams).Length(1).At(0, func(t testing.TB, stream *bq.MockStream) {
+		total := 0
+		for _, r := range stream.Inserted {
+			total += len(r)
+		}
+		gt.Equal(t, total, 1000)
+	})
+}
+
+func TestSampleRecords(t *testing.T) {
+	var records []*model.LogRecord
+	for i := 0; i < 100; i++ {
+		records = append(records, &model.LogRecord{ID: types.LogID(uuid.NewString())})
+	}
+
+	samples := usecase.SampleRecords(records, 5, 10)
+	gt.A(t, samples).Length(15)
+	for i := 0; i < 5; i++ {
+		gt.Equal(t, samples[i], records[i])
+	}
+
+	// Returns all records if sampling is disabled or records are fewer than samples
+	gt.A(t, usecase.SampleRecords(records, 0, 0)).Length(100)
+	gt.A(t, usecase.SampleRecords(records, 60, 60)).Length(100)
+}
+
+func TestIngestRecordsWithInsertErrorTable(t *testing.T) {
+	ctx := context.Background()
+	dst := model.BigQueryDest{
+		Dataset: "test-dataset",
+		Table:   "test-table",
+	}
+
+	var records []*model.LogRecord
+	for i := 0; i < 10; i++ {
+		records = append(records, &model.LogRecord{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"num": i},
+			IngestedAt: time.Now(),
+		})
+	}
+
+	bqMock := bq.NewGeneralMock()
+	bqMock.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+		if tableID != "test-table" {
+			return nil
+		}
+
+		rowErrs := &model.InsertRowErrors{}
+		for i, d := range data {
+			r := gt.Cast[*model.LogRecordRaw](t, d)
+			if n := r.Data.(map[string]any)["num"]; n == 3 || n == 7 {
+				rowErrs.Errors = append(rowErrs.Errors, &model.InsertRowError{Index: i, Reason: "INVALID: bad row"})
+			}
+		}
+		if len(rowErrs.Errors) > 0 {
+			return rowErrs
+		}
+		return nil
+	}
+
+	t.Run("without error table", func(t *testing.T) {
+		resp, err := usecase.IngestRecords(ctx, bqMock, dst, records, 1)
+		gt.Error(t, err)
+		gt.False(t, resp.Success)
+	})
+
+	bqMock.Streams = nil
+	bqMock.OpenedStream = nil
+	bqMock.CreatedTable = nil
+
+	t.Run("with error table", func(t *testing.T) {
+		resp := gt.R1(usecase.IngestRecords(ctx, bqMock, dst, records, 1, usecase.WithInsertErrorTable())).NoError(t)
+		gt.True(t, resp.Success)
+
+		gt.A(t, bqMock.CreatedTable).Length(2).At(1, func(t testing.TB, v struct {
+			Dataset types.BQDatasetID
+			Table   types.BQTableID
+			MD      *bigquery.TableMetadata
+		}) {
+			gt.Equal(t, v.Table, "test-table_errors")
+		})
+
+		gt.A(t, bqMock.OpenedStream).Length(2)
+		gt.Equal(t, bqMock.OpenedStream[0].Table, "test-table")
+		gt.Equal(t, bqMock.OpenedStream[1].Table, "test-table_errors")
+
+		// main table has only valid rows
+		gt.A(t, bqMock.Streams[0].Inserted).Length(1)
+		gt.A(t, bqMock.Streams[0].Inserted[0]).Length(8)
+
+		// error table has rejected rows with reason
+		gt.A(t, bqMock.Streams[1].Inserted).Length(1)
+		gt.A(t, bqMock.Streams[1].Inserted[0]).Length(2).At(0, func(t testing.TB, v any) {
+			errLog := gt.Cast[*model.InsertErrorLogRaw](t, v)
+			gt.Equal(t, errLog.ID, records[3].ID)
+			gt.Equal(t, errLog.Reason, "INVALID: bad row")
+			gt.Equal(t, errLog.Record, `{"num":3}`)
+		})
+	})
+}
+
+func TestLoadTimestampFallback(t *testing.T) {
+	const schemaPolicy = `package schema.no_ts
+
+log[{
+	"dataset": "my_dataset",
+	"table": "no_ts",
+	"id": r.eventID,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+	objCreatedAt := time.Date(2024, 2, 17, 0, 48, 27, 0, time.UTC)
+	objUpdatedAt := time.Date(2024, 3, 1, 12, 0, 0, 500000000, time.UTC)
+
+	testCases := map[string]struct {
+		createdAt *int64
+		fallback  bool
+		noAttrs   bool
+		expect    time.Time
+		isErr     bool
+	}{
+		"use modified time of object rather than created time": {
+			createdAt: toPtr(objCreatedAt.Unix()),
+			fallback:  true,
+			expect:    objUpdatedAt,
+		},
+		"use modified time of object without created time": {
+			fallback: true,
+			expect:   objUpdatedAt,
+		},
+		"reject log if modified time is not available": {
+			fallback: true,
+			noAttrs:  true,
+			isErr:    true,
+		},
+		"reject log without fallback": {
+			createdAt: toPtr(objCreatedAt.Unix()),
+			fallback:  false,
+			isErr:     true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					if tc.noAttrs {
+						return &storage.ObjectAttrs{}, nil
+					}
+					return &storage.ObjectAttrs{Updated: objUpdatedAt}, nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			options := []usecase.Option{}
+			if tc.fallback {
+				options = append(options, usecase.WithObjectTimeFallback())
+			}
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), options...)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "no_ts",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+					CreatedAt: tc.createdAt,
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(4)
+			for _, v := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, v)
+				gt.Equal(t, r.Timestamp, tc.expect.UnixMicro())
+			}
+		})
+	}
+}
+
+func toPtr[T any](v T) *T {
+	return &v
+}
+
+func TestLoadInsertIDField(t *testing.T) {
+	testCases := map[string]struct {
+		field  string
+		expect []types.LogID
+		isErr  bool
+	}{
+		"use value of specified field as insert ID": {
+			field: "requestID",
+			expect: []types.LogID{
+				"D493BF6281B6E1F2",
+				"D8FE34C4642F8011",
+				"F4CF815CC982B266",
+				"0430EF222D27C318",
+			},
+		},
+		"use ID of log if field is not specified": {
+			field: "",
+			expect: []types.LogID{
+				"d4dacb9d-9822-4217-b88d-d334bde89755",
+				"ac3cfd93-435d-41cc-bbd7-aad0340ec668",
+				"dbb28938-5ed4-4774-8bb6-82ea916b21bb",
+				"18e67b09-94a3-4b5c-9b3a-cd549b3341fb",
+			},
+		},
+		"reject log if specified field does not exist": {
+			field: "noSuchField",
+			isErr: true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			schemaPolicy := fmt.Sprintf(`package schema.insert_id
+
+log[{
+	"dataset": "my_dataset",
+	"table": "insert_id",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"insert_id_field": %q,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`, tc.field)
+
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "insert_id",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.True(t, errors.Is(err, types.ErrInvalidPolicyResult))
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(len(tc.expect))
+			// Order of logs is not guaranteed by schema policy
+			var ids []types.LogID
+			for _, v := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, v)
+				ids = append(ids, r.ID)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			expect := append([]types.LogID{}, tc.expect...)
+			sort.Slice(expect, func(i, j int) bool { return expect[i] < expect[j] })
+			gt.Equal(t, ids, expect)
+		})
+	}
+}
+
+func TestLoadDefaultPartition(t *testing.T) {
+	const schemaPolicy = `package schema.partition
+
+log[{
+	"dataset": "my_dataset",
+	"table": "no_partition",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+
+log[{
+	"dataset": "my_dataset",
+	"table": "hour_partition",
+	"partition": "hour",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+
+	testCases := map[string]struct {
+		options []usecase.Option
+		expect  map[types.BQTableID]bigquery.TimePartitioningType
+	}{
+		"apply default partition to table without partition": {
+			options: []usecase.Option{usecase.WithDefaultPartition(types.BQPartitionDay)},
+			expect: map[types.BQTableID]bigquery.TimePartitioningType{
+				"no_partition":   bigquery.DayPartitioningType,
+				"hour_partition": bigquery.HourPartitioningType,
+			},
+		},
+		"no partition without default": {
+			expect: map[types.BQTableID]bigquery.TimePartitioningType{
+				"no_partition":   "",
+				"hour_partition": bigquery.HourPartitioningType,
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), tc.options...)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "partition",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+			gt.A(t, bqClient.CreatedTable).Length(len(tc.expect))
+			for _, v := range bqClient.CreatedTable {
+				expect, ok := tc.expect[v.Table]
+				gt.True(t, ok)
+				if expect == "" {
+					gt.True(t, v.MD.TimePartitioning == nil)
+					continue
+				}
+				gt.Equal(t, v.MD.TimePartitioning.Field, "timestamp")
+				gt.Equal(t, v.MD.TimePartitioning.Type, expect)
+			}
+		})
+	}
+}
+
+func TestLoadWithRecordsPath(t *testing.T) {
+	const schemaPolicy = `package schema.records
+
+log[{
+	"dataset": "my_dataset",
+	"table": "records",
+	"id": input.eventID,
+	"timestamp": time.parse_rfc3339_ns(input.eventTime) / 1000000000,
+	"data": input,
+}]
+`
+	var nested bytes.Buffer
+	gt.NoError(t, json.NewEncoder(&nested).Encode(map[string]any{
+		"detail": json.RawMessage(cloudTrailExampleRaw),
+	}))
+
+	testCases := map[string]struct {
+		data  []byte
+		path  string
+		count int
+		isErr bool
+	}{
+		"extract records from top level field": {
+			data:  cloudTrailExampleRaw,
+			path:  "Records",
+			count: 4,
+		},
+		"extract records from nested field": {
+			data:  nested.Bytes(),
+			path:  "detail.Records",
+			count: 4,
+		},
+		"path not found": {
+			data:  cloudTrailExampleRaw,
+			path:  "detail.Records",
+			isErr: true,
+		},
+		"value of path is not array": {
+			data:  []byte(`{"Records": {"eventID": "x"}}`),
+			path:  "Records",
+			isErr: true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tc.data)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:      types.JSONParser,
+					Schema:      "records",
+					RecordsPath: tc.path,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(tc.count)
+			for _, v := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, v)
+				gt.NotEqual(t, r.ID, "")
+			}
+		})
+	}
+}
+
+func TestLoadMaxConcurrentInserts(t *testing.T) {
+	const schemaPolicy = `package schema.concurrent
+
+log[{
+	"dataset": "my_dataset",
+	"table": r.eventID,
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+	const maxInserts = 2
+
+	var running, peak, count int64
+	bqClient := bq.NewGeneralMock()
+	bqClient.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		atomic.AddInt64(&count, 1)
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	),
+		usecase.WithIngestTableConcurrency(8),
+		usecase.WithIngestRecordConcurrency(8),
+		usecase.WithMaxConcurrentInserts(maxInserts),
+	)
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "concurrent",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   types.CSObjectID(fmt.Sprintf("test%d.log", i)),
+					},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		}(i)
+	}
+	wg.Wait()
+
+	// 4 loads x 4 tables
+	gt.Equal(t, atomic.LoadInt64(&count), 16)
+	gt.True(t, atomic.LoadInt64(&peak) <= maxInserts)
+}
+
+func TestLoadDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return nil, goerr.Wrap(types.ErrUnsupportedCompress, "object is broken")
+		},
+	}
+	dlClient := pubsub.NewMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+	), usecase.WithDeadLetterPubSub(dlClient))
+
+	obj := model.Object{
+		CS: &model.CloudStorageObject{
+			Bucket: "test-bucket",
+			Name:   "test.log",
+		},
+		Size: toPtr(int64(123)),
+	}
+	reqs := []*model.LoadRequest{
+		{
+			Source: model.Source{Parser: types.JSONParser, Schema: "cloudtrail"},
+			Object: obj,
+		},
+		{
+			Source: model.Source{Parser: types.JSONParser, Schema: "another"},
+			Object: obj,
+		},
+	}
+	gt.Error(t, uc.Load(ctx, reqs))
+
+	// Same object must be republished only once
+	gt.A(t, dlClient.Results).Length(1)
+	result := dlClient.Results[0]
+	gt.String(t, result.Attrs[usecase.DeadLetterAttrError]).Contains("object is broken")
+	gt.NotEqual(t, result.Attrs[usecase.DeadLetterAttrRequestID], "")
+
+	var msg model.SwarmMessage
+	gt.NoError(t, json.Unmarshal(result.Data, &msg))
+	gt.A(t, msg.Objects).Length(1)
+	gt.Equal(t, *msg.Objects[0].CS, *obj.CS)
+	gt.Equal(t, *msg.Objects[0].Size, 123)
+}
+
+func TestLoadDeadLetterLongError(t *testing.T) {
+	ctx := context.Background()
+	// 3 bytes character is not split by truncation
+	reason := strings.Repeat("壊", 1000)
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return nil, goerr.Wrap(types.ErrUnsupportedCompress, reason)
+		},
+	}
+	dlClient := pubsub.NewMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+	), usecase.WithDeadLetterPubSub(dlClient))
+
+	reqs := []*model.LoadRequest{
+		{
+			Source: model.Source{Parser: types.JSONParser, Schema: "cloudtrail"},
+			Object: model.Object{CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"}},
+		},
+	}
+	gt.Error(t, uc.Load(ctx, reqs))
+
+	gt.A(t, dlClient.Results).Length(1)
+	attr := dlClient.Results[0].Attrs[usecase.DeadLetterAttrError]
+	gt.N(t, len(attr)).LessOrEqual(1024).Greater(1000)
+	gt.True(t, utf8.ValidString(attr))
+	gt.True(t, strings.HasSuffix(attr, "壊"))
+}
+
+func TestLoadDeadLetterClassifier(t *testing.T) {
+	newUseCase := func(dlClient *pubsub.Mock, decision types.RetryDecision) *usecase.UseCase {
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return nil, errors.New("object is broken")
+			},
+		}
+		return newTestUseCase(t, testClients{BigQuery: bq.NewGeneralMock(), CloudStorage: csClient},
+			usecase.WithDeadLetterPubSub(dlClient),
+			usecase.WithErrorClassifier(func(err error) types.RetryDecision { return decision }),
+		)
+	}
+	reqs := []*model.LoadRequest{
+		{
+			Source: model.Source{Parser: types.JSONParser, Schema: "cloudtrail"},
+			Object: model.Object{CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"}},
+		},
+	}
+
+	t.Run("dead-lettered error is published", func(t *testing.T) {
+		dlClient := pubsub.NewMock()
+		gt.Error(t, newUseCase(dlClient, types.RetryDecisionDeadLetter).Load(context.Background(), reqs))
+		gt.A(t, dlClient.Results).Length(1)
+	})
+
+	t.Run("retried error is not published", func(t *testing.T) {
+		dlClient := pubsub.NewMock()
+		gt.Error(t, newUseCase(dlClient, types.RetryDecisionRetry).Load(context.Background(), reqs))
+		gt.A(t, dlClient.Results).Length(0)
+	})
+
+	t.Run("dropped error is not published", func(t *testing.T) {
+		dlClient := pubsub.NewMock()
+		gt.Error(t, newUseCase(dlClient, types.RetryDecisionDrop).Load(context.Background(), reqs))
+		gt.A(t, dlClient.Results).Length(0)
+	})
+}
+
+func TestLoadNoDeadLetterOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+	dlClient := pubsub.NewMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	), usecase.WithDeadLetterPubSub(dlClient))
+
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "cloudtrail"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+	gt.A(t, dlClient.Results).Length(0)
+}
+
+func TestLoadCSV(t *testing.T) {
+	const schemaPolicy = `package schema.csv
+
+log[{
+	"dataset": "my_dataset",
+	"table": "csv",
+	"timestamp": time.parse_rfc3339_ns(input.time) / 1000000000,
+	"data": input,
+}]
+`
+	const csvData = `time,user,action
+2024-02-17T00:48:27Z,alice,login
+2024-02-17T00:49:01Z,bob,"logout, forced"
+`
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(csvData))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser: types.CSVParser,
+			Schema: "csv",
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "test.csv",
+			},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	gt.A(t, bqClient.Streams).Length(1)
+	gt.A(t, bqClient.Streams[0].Inserted[0]).Length(2)
+	r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][1])
+	data := gt.Cast[map[string]any](t, r.Data)
+	gt.Equal(t, data["user"], "bob")
+	gt.Equal(t, data["action"], "logout, forced")
+	gt.Equal(t, r.Timestamp, time.Date(2024, 2, 17, 0, 49, 1, 0, time.UTC).UnixMicro())
+}
+
+func TestLoadCSVEmpty(t *testing.T) {
+	const schemaPolicy = `package schema.csv
+
+log[{
+	"dataset": "my_dataset",
+	"table": "csv",
+	"timestamp": time.parse_rfc3339_ns(input.time) / 1000000000,
+	"data": input,
+}]
+`
+	const csvData = `time,user,note
+2024-02-17T00:48:27Z,alice,
+2024-02-17T00:49:01Z,bob,hello
+`
+
+	testCases := map[string]struct {
+		emptyPolicy types.CSVEmptyPolicy
+		sentinel    string
+		expect      map[string]any
+	}{
+		"empty string by default": {
+			expect: map[string]any{"time": "2024-02-17T00:48:27Z", "user": "alice", "note": ""},
+		},
+		"empty string": {
+			emptyPolicy: types.CSVEmptyString,
+			expect:      map[string]any{"time": "2024-02-17T00:48:27Z", "user": "alice", "note": ""},
+		},
+		"null is removed from data": {
+			emptyPolicy: types.CSVEmptyNull,
+			expect:      map[string]any{"time": "2024-02-17T00:48:27Z", "user": "alice"},
+		},
+		"sentinel": {
+			emptyPolicy: types.CSVEmptySentinel,
+			sentinel:    "N/A",
+			expect:      map[string]any{"time": "2024-02-17T00:48:27Z", "user": "alice", "note": "N/A"},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader([]byte(csvData))), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:           types.CSVParser,
+					Schema:           "csv",
+					CSVEmpty:         tc.emptyPolicy,
+					CSVEmptySentinel: tc.sentinel,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.csv"},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(2)
+			// Order of logs is not guaranteed by schema policy
+			var first *model.LogRecordRaw
+			for _, v := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, v)
+				if data := gt.Cast[map[string]any](t, r.Data); data["user"] == "alice" {
+					first = r
+				}
+			}
+			gt.V(t, first).NotNil()
+			gt.Equal(t, first.Data, any(tc.expect))
+
+			gt.A(t, bqClient.OpenedStream).Length(1)
+			var dataSchema *bigquery.FieldSchema
+			for _, f := range bqClient.OpenedStream[0].Schema {
+				if f.Name == "data" {
+					dataSchema = f
+				}
+			}
+			gt.V(t, dataSchema).NotNil()
+			var noteField *bigquery.FieldSchema
+			for _, f := range dataSchema.Schema {
+				if f.Name == "note" {
+					noteField = f
+				}
+			}
+			// note field is in schema by the second row even if it is null in the first row
+			gt.V(t, noteField).NotNil()
+			gt.Equal(t, noteField.Type, bigquery.StringFieldType)
+		})
+	}
+
+	t.Run("null field only is not in schema", func(t *testing.T) {
+		ctx := context.Background()
+		bqClient := bq.NewGeneralMock()
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader([]byte("time,user,note\n2024-02-17T00:48:27Z,alice,\n"))), nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		))
+
+		req := &model.LoadRequest{
+			Source: model.Source{
+				Parser:   types.CSVParser,
+				Schema:   "csv",
+				CSVEmpty: types.CSVEmptyNull,
+			},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.csv"},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+		gt.A(t, bqClient.OpenedStream).Length(1)
+		for _, f := range bqClient.OpenedStream[0].Schema {
+			if f.Name != "data" {
+				continue
+			}
+			for _, nested := range f.Schema {
+				gt.NotEqual(t, nested.Name, "note")
+			}
+		}
+	})
+
+	t.Run("sentinel policy requires sentinel", func(t *testing.T) {
+		src := model.Source{Parser: types.CSVParser, Schema: "csv", CSVEmpty: types.CSVEmptySentinel}
+		gt.Error(t, src.Validate())
+		src.CSVEmpty = "unknown"
+		gt.Error(t, src.Validate())
+	})
+}
+
+func TestLoadTimestampFields(t *testing.T) {
+	const schemaPolicy = `package schema.fields
+
+log[{
+	"dataset": "my_dataset",
+	"table": "fields",
+	"timestamp": time.parse_rfc3339_ns(input.time) / 1000000000,
+	"fields": {
+		"time": {"type": "timestamp"},
+		"user.created": {"type": "timestamp", "format": "2006-01-02 15:04:05"},
+		"events.at": {"type": "timestamp", "format": "unix_milli"},
+	},
+	"data": input,
+}]
+`
+	const logData = `{"time":"2024-02-17T00:48:27.5Z","user":{"name":"alice","created":"2023-12-01 09:30:00"},"events":[{"at":1708130907000},{"at":1708130908000}]}`
+
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(logData))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser: types.JSONParser,
+			Schema: "fields",
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "test.log",
+			},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	// Declared fields are created as TIMESTAMP column
+	gt.A(t, bqClient.CreatedTable).Length(1)
+	dataField := findSchemaField(bqClient.CreatedTable[0].MD.Schema, "data")
+	gt.NotEqual(t, dataField, nil)
+	gt.Equal(t, findSchemaField(dataField.Schema, "time").Type, bigquery.TimestampFieldType)
+	gt.Equal(t, findSchemaField(findSchemaField(dataField.Schema, "user").Schema, "created").Type, bigquery.TimestampFieldType)
+	gt.Equal(t, findSchemaField(findSchemaField(dataField.Schema, "user").Schema, "name").Type, bigquery.StringFieldType)
+	gt.Equal(t, findSchemaField(findSchemaField(dataField.Schema, "events").Schema, "at").Type, bigquery.TimestampFieldType)
+
+	// Timestamp values are inserted as Unix time in microsecond
+	gt.A(t, bqClient.Streams).Length(1)
+	r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+	data := gt.Cast[map[string]any](t, r.Data)
+	gt.Equal(t, data["time"], any(time.Date(2024, 2, 17, 0, 48, 27, 500000000, time.UTC).UnixMicro()))
+	user := gt.Cast[map[string]any](t, data["user"])
+	gt.Equal(t, user["created"], any(time.Date(2023, 12, 1, 9, 30, 0, 0, time.UTC).UnixMicro()))
+	events := gt.Cast[[]any](t, data["events"])
+	gt.Equal(t, gt.Cast[map[string]any](t, events[1])["at"], any(int64(1708130908000000)))
+}
+
+func TestLoadStringFields(t *testing.T) {
+	const schemaPolicy = `package schema.fields
+
+log[{
+	"dataset": "my_dataset",
+	"table": "fields",
+	"timestamp": 1708130907,
+	"fields": {
+		"id": {"type": "string"},
+		"user.ids": {"type": "string"},
+	},
+	"data": input,
+}]
+`
+	const logData = `{"id":1234567890123456789,"count":3,"user":{"ids":[9007199254740993,2]}}`
+
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(logData))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "fields"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	// Declared fields are created as STRING column, and others are not changed
+	gt.A(t, bqClient.CreatedTable).Length(1)
+	dataField := findSchemaField(bqClient.CreatedTable[0].MD.Schema, "data")
+	gt.NotEqual(t, dataField, nil)
+	gt.Equal(t, findSchemaField(dataField.Schema, "id").Type, bigquery.StringFieldType)
+	gt.Equal(t, findSchemaField(findSchemaField(dataField.Schema, "user").Schema, "ids").Type, bigquery.StringFieldType)
+	gt.NotEqual(t, findSchemaField(dataField.Schema, "count").Type, bigquery.StringFieldType)
+
+	// Original digits are kept without losing precision of float64
+	gt.A(t, bqClient.Streams).Length(1)
+	r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+	data := gt.Cast[map[string]any](t, r.Data)
+	gt.Equal(t, data["id"], any("1234567890123456789"))
+	gt.Equal(t, data["count"], any(float64(3)))
+	user := gt.Cast[map[string]any](t, data["user"])
+	gt.Equal(t, user["ids"], any([]any{"9007199254740993", "2"}))
+}
+
+func findSchemaField(schema bigquery.Schema, name string) *bigquery.FieldSchema {
+	for _, field := range schema {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+func TestLoadInvalidTimestampField(t *testing.T) {
+	const schemaPolicy = `package schema.fields
+
+log[{
+	"dataset": "my_dataset",
+	"table": "fields",
+	"timestamp": 1708130907,
+	"fields": {"created": {"type": "timestamp"}},
+	"data": input,
+}]
+`
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(`{"created":"yesterday"}`))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "fields"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	gt.Error(t, uc.Load(ctx, []*model.LoadRequest{req}))
+	gt.A(t, bqClient.Streams).Length(0)
+}
+
+func TestLoadMissingObject(t *testing.T) {
+	newRequest := func(name types.CSObjectID) *model.LoadRequest {
+		return &model.LoadRequest{
+			Source: model.Source{
+				Parser: types.JSONParser,
+				Schema: "cloudtrail",
+			},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{
+					Bucket: "test-bucket",
+					Name:   name,
+				},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		failOnMissing bool
+		isErr         bool
+	}{
+		"skip missing object by default": {
+			failOnMissing: false,
+		},
+		"fail whole batch on missing object": {
+			failOnMissing: true,
+			isErr:         true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					if obj.Name == "missing.log" {
+						return nil, goerr.Wrap(storage.ErrObjectNotExist, "failed to create reader")
+					}
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+			sink := &fakeLoadLogSink{}
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			),
+				usecase.WithFailOnMissing(tc.failOnMissing),
+				usecase.WithLoadLogSink(sink),
+			)
+
+			err := uc.Load(ctx, []*model.LoadRequest{
+				newRequest("exists.log"),
+				newRequest("missing.log"),
+			})
+			gt.A(t, sink.written).Length(1)
+			var loadLog model.LoadLog
+			gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.A(t, bqClient.Streams).Length(0)
+				gt.False(t, loadLog.Success)
+				return
+			}
+
+			gt.NoError(t, err)
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(4)
+			gt.True(t, loadLog.Success)
+
+			var missing int
+			for _, src := range loadLog.Sources {
+				if src.Missing {
+					missing++
+					gt.Equal(t, src.CS.Name, "missing.log")
+				}
+			}
+			gt.Equal(t, missing, 1)
+		})
+	}
+}
+
+func TestLoadDataByObjectMissing(t *testing.T) {
+	csClient := &cs.Mock{
+		MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+			return nil, goerr.Wrap(storage.ErrObjectNotExist, "failed to get object attributes")
+		},
+	}
+
+	t.Run("skip missing object by default", func(t *testing.T) {
+		uc := usecase.New(infra.New(infra.WithCloudStorage(csClient)))
+		gt.NoError(t, uc.LoadDataByObject(context.Background(), "gs://test-bucket/missing.log"))
+	})
+
+	t.Run("fail on missing object", func(t *testing.T) {
+		uc := usecase.New(infra.New(infra.WithCloudStorage(csClient)), usecase.WithFailOnMissing(true))
+		err := uc.LoadDataByObject(context.Background(), "gs://test-bucket/missing.log")
+		gt.Error(t, err)
+		gt.True(t, errors.Is(err, storage.ErrObjectNotExist))
+	})
+}
+
+//go:embed testdata/object/pretty_single.json
+var prettySingleRaw []byte
+
+func TestLoadPrettyPrintedJSON(t *testing.T) {
+	const schemaPolicy = `package schema.pretty
+
+log[{
+	"dataset": "my_dataset",
+	"table": "pretty",
+	"id": input.eventID,
+	"timestamp": time.parse_rfc3339_ns(input.eventTime) / 1000000000,
+	"data": input,
+}]
+`
+	testCases := map[string]struct {
+		data   []byte
+		single bool
+		count  int
+		isErr  bool
+	}{
+		"pretty-printed object is parsed without single object mode": {
+			data:  prettySingleRaw,
+			count: 1,
+		},
+		"pretty-printed object is parsed in single object mode": {
+			data:   prettySingleRaw,
+			single: true,
+			count:  1,
+		},
+		"concatenated objects are parsed without single object mode": {
+			data:  append(append([]byte{}, prettySingleRaw...), prettySingleRaw...),
+			count: 2,
+		},
+		"concatenated objects are rejected in single object mode": {
+			data:   append(append([]byte{}, prettySingleRaw...), prettySingleRaw...),
+			single: true,
+			isErr:  true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tc.data)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:       types.JSONParser,
+					Schema:       "pretty",
+					SingleObject: tc.single,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "pretty.json"},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(tc.count)
+			r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+			data := gt.Cast[map[string]any](t, r.Data)
+			gt.Equal(t, data["message"], any("multiline\nvalue"))
+			user := gt.Cast[map[string]any](t, data["user"])
+			gt.A(t, gt.Cast[[]any](t, user["groups"])).Length(2)
+		})
+	}
+}
+
+//go:embed testdata/object/json_seq.log
+var jsonSeqRaw []byte
+
+func TestLoadDelimitedJSON(t *testing.T) {
+	const schemaPolicy = `package schema.seq
+
+log[{
+	"dataset": "my_dataset",
+	"table": "seq",
+	"id": input.id,
+	"timestamp": 1700000000,
+	"data": input,
+}]
+`
+	testCases := map[string]struct {
+		data      []byte
+		delimiter string
+		ids       []string
+		isErr     bool
+	}{
+		"JSON text sequences delimited by RS": {
+			data:      jsonSeqRaw,
+			delimiter: "\x1e",
+			ids:       []string{"seq-1", "seq-2", "seq-3"},
+		},
+		"records delimited by NUL": {
+			data:      []byte(`{"id":"nul-1"}` + "\x00" + `{"id":"nul-2"}` + "\x00"),
+			delimiter: "\x00",
+			ids:       []string{"nul-1", "nul-2"},
+		},
+		"newline is default delimiter": {
+			data:      []byte(`{"id":"ln-1"}` + "\n" + `{"id":"ln-2"}` + "\n"),
+			delimiter: "\n",
+			ids:       []string{"ln-1", "ln-2"},
+		},
+		"RS can not be parsed without delimiter": {
+			data:  jsonSeqRaw,
+			isErr: true,
+		},
+		"record must have one JSON value": {
+			data:      []byte(`{"id":"a"} {"id":"b"}` + "\x1e"),
+			delimiter: "\x1e",
+			isErr:     true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tc.data)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:    types.JSONParser,
+					Schema:    "seq",
+					Delimiter: tc.delimiter,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "records.log"},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			var ids []string
+			for _, row := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, row)
+				data := gt.Cast[map[string]any](t, r.Data)
+				ids = append(ids, gt.Cast[string](t, data["id"]))
+				if data["id"] == "seq-2" {
+					gt.Equal(t, data["message"], any("multiline\nvalue"))
+				}
+			}
+			sort.Strings(ids)
+			gt.Equal(t, ids, tc.ids)
+		})
+	}
+}
+
+func TestLoadLeadingBOM(t *testing.T) {
+	const schemaPolicy = `package schema.bom
+
+log[{
+	"dataset": "my_dataset",
+	"table": "bom",
+	"id": input.id,
+	"timestamp": 1700000000,
+	"data": input,
+}]
+`
+	bom := "\xEF\xBB\xBF"
+	gzipData := func(data string) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		gt.R1(w.Write([]byte(data))).NoError(t)
+		gt.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	testCases := map[string]struct {
+		data     []byte
+		compress types.ObjectCompress
+		parser   types.ObjectParser
+	}{
+		"BOM-prefixed JSON": {
+			data:   []byte(bom + `{"id":"a1","color":"blue"}`),
+			parser: types.JSONParser,
+		},
+		"whitespace around BOM": {
+			data:   []byte(" \n" + bom + "\r\n" + `{"id":"a1","color":"blue"}`),
+			parser: types.JSONParser,
+		},
+		"BOM-prefixed gzip JSON": {
+			data:     gzipData(bom + `{"id":"a1","color":"blue"}`),
+			compress: types.GZIPComp,
+			parser:   types.JSONParser,
+		},
+		"BOM-prefixed CSV": {
+			data:   []byte(bom + "id,color\na1,blue\n"),
+			parser: types.CSVParser,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tc.data)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:   tc.parser,
+					Compress: tc.compress,
+					Schema:   "bom",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "bom.log"},
+				},
+			}
+
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(1)
+			r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+			gt.Equal(t, r.ID, "a1")
+			data := gt.Cast[map[string]any](t, r.Data)
+			gt.Equal(t, data["color"], any("blue"))
+		})
+	}
+}
+
+func TestLoadMaxObjectSize(t *testing.T) {
+	// 16 MiB of repeated records is compressed into a few dozen KiB
+	var bomb bytes.Buffer
+	gw := gzip.NewWriter(&bomb)
+	line := []byte(`{"eventID":"x","eventTime":"2024-02-17T00:48:27Z"}` + "\n")
+	for written := 0; written < 16*1024*1024; written += len(line) {
+		_, err := gw.Write(line)
+		gt.NoError(t, err)
+	}
+	gt.NoError(t, gw.Close())
+	gt.True(t, bomb.Len() < 1024*1024)
+
+	testCases := map[string]struct {
+		data     []byte
+		compress types.ObjectCompress
+		isErr    bool
+	}{
+		"highly compressed object exceeds the limit": {
+			data:     bomb.Bytes(),
+			compress: types.GZIPComp,
+			isErr:    true,
+		},
+		"object within the limit": {
+			data:     cloudTrailExampleGzip,
+			compress: types.GZIPComp,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tc.data)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), usecase.WithMaxObjectSize(1024*1024))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:   types.JSONParser,
+					Schema:   "cloudtrail",
+					Compress: tc.compress,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log.gz"},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.True(t, errors.Is(err, types.ErrObjectTooLarge))
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+			gt.A(t, bqClient.Streams).Length(1)
+		})
+	}
+}
+
+func TestLoadPolicyTags(t *testing.T) {
+	const (
+		emailTag = "projects/my-project/locations/us/taxonomies/123/policyTags/456"
+		ipTag    = "projects/my-project/locations/us/taxonomies/123/policyTags/789"
+	)
+	schemaPolicy := fmt.Sprintf(`package schema.tags
+
+log[{
+	"dataset": "my_dataset",
+	"table": "tags",
+	"timestamp": 1708130907,
+	"fields": {
+		"user.email": {"policy_tags": [%q]},
+		"ip": {"policy_tags": [%q]},
+		"not_found": {"policy_tags": [%q]},
+	},
+	"data": input,
+}]
+`, emailTag, ipTag, ipTag)
+	const logData = `{"user":{"email":"alice@example.com","name":"alice"},"ip":"192.0.2.1"}`
+
+	newUseCase := func(t *testing.T, bqClient *bq.GeneralMock, policyData string) *usecase.UseCase {
+		return newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   []byte(logData),
+			Policy:   []policy.Option{policy.WithPolicyData("schema.rego", policyData)},
+		})
+	}
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "tags"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	assertTags := func(t *testing.T, schema bigquery.Schema) {
+		data := findSchemaField(schema, "data")
+		user := findSchemaField(data.Schema, "user")
+		gt.Equal(t, findSchemaField(user.Schema, "email").PolicyTags, &bigquery.PolicyTagList{Names: []string{emailTag}})
+		gt.True(t, findSchemaField(user.Schema, "name").PolicyTags == nil)
+		gt.Equal(t, findSchemaField(data.Schema, "ip").PolicyTags, &bigquery.PolicyTagList{Names: []string{ipTag}})
+		gt.True(t, findSchemaField(schema, "id").PolicyTags == nil)
+	}
+
+	var created bigquery.Schema
+	t.Run("set policy tags when creating table", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		uc := newUseCase(t, bqClient, schemaPolicy)
+		gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+
+		gt.A(t, bqClient.CreatedTable).Length(1)
+		created = bqClient.CreatedTable[0].MD.Schema
+		assertTags(t, created)
+	})
+
+	t.Run("set policy tags to existing columns when updating table", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: cloneSchemaWithoutPolicyTags(created)}}
+		uc := newUseCase(t, bqClient, schemaPolicy)
+		gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+
+		gt.A(t, bqClient.CreatedTable).Length(0)
+		gt.A(t, bqClient.UpdatedTable).Length(1)
+		assertTags(t, bqClient.UpdatedTable[0].MD.Schema)
+	})
+
+	t.Run("reject invalid policy tag", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		invalidPolicy := `package schema.tags
+
+log[{
+	"dataset": "my_dataset",
+	"table": "tags",
+	"timestamp": 1708130907,
+	"fields": {"ip": {"policy_tags": ["my-tag"]}},
+	"data": input,
+}]
+`
+		uc := newUseCase(t, bqClient, invalidPolicy)
+		err := uc.Load(context.Background(), []*model.LoadRequest{req})
+		gt.Error(t, err)
+		gt.True(t, errors.Is(err, types.ErrInvalidPolicyResult))
+		gt.A(t, bqClient.CreatedTable).Length(0)
+	})
+}
+
+func cloneSchemaWithoutPolicyTags(schema bigquery.Schema) bigquery.Schema {
+	cloned := make(bigquery.Schema, len(schema))
+	for i, field := range schema {
+		f := *field
+		f.PolicyTags = nil
+		f.Schema = cloneSchemaWithoutPolicyTags(field.Schema)
+		cloned[i] = &f
+	}
+	return cloned
+}
+
+func TestLoadLogIDGenerator(t *testing.T) {
+	const schemaPolicy = `package schema.id_gen
+
+log[{
+	"dataset": "my_dataset",
+	"table": "id_gen",
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithLogIDGenerator(func(obj model.Object, idx int) types.LogID {
+			return types.LogID(fmt.Sprintf("%s/%s#%d", obj.CS.Bucket, obj.CS.Name, idx))
+		}),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser: types.JSONParser,
+			Schema: "id_gen",
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "test.log",
+			},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	gt.A(t, bqClient.Streams).Length(1)
+	gt.A(t, bqClient.Streams[0].Inserted[0]).Length(4)
+	ids := map[types.LogID]bool{}
+	for _, v := range bqClient.Streams[0].Inserted[0] {
+		r := gt.Cast[*model.LogRecordRaw](t, v)
+		ids[r.ID] = true
+	}
+	gt.Equal(t, ids, map[types.LogID]bool{
+		"test-bucket/test.log#0": true,
+		"test-bucket/test.log#1": true,
+		"test-bucket/test.log#2": true,
+		"test-bucket/test.log#3": true,
+	})
+}
+
+func TestLoadDataByObjectMinObjectSize(t *testing.T) {
+	testCases := map[string]struct {
+		size    int64
+		opened  bool
+		ingests int
+	}{
+		"skip object smaller than threshold": {
+			size:    16,
+			opened:  false,
+			ingests: 0,
+		},
+		"load object of threshold size": {
+			size:    1024,
+			opened:  true,
+			ingests: 1,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			var opened bool
+			csClient := &cs.Mock{
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					return &storage.ObjectAttrs{
+						Bucket: obj.Bucket.String(),
+						Name:   obj.Name.String(),
+						Size:   tc.size,
+					}, nil
+				},
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					opened = true
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(
+				policy.WithFile("testdata/policy/schema.rego"),
+				policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+			)).NoError(t)
+			bqClient := bq.NewGeneralMock()
+
+			uc := usecase.New(
+				infra.New(
+					infra.WithBigQuery(bqClient),
+					infra.WithCloudStorage(csClient),
+					infra.WithPolicy(pClient),
+				),
+				usecase.WithMinObjectSize(1024),
+			)
+
+			gt.NoError(t, uc.LoadDataByObject(context.Background(), "gs://cloudtrail-logs/partial.log"))
+			gt.Equal(t, opened, tc.opened)
+			gt.A(t, bqClient.Streams).Length(tc.ingests)
+		})
+	}
+}
+
+func TestLoadDataByObjectMaxAge(t *testing.T) {
+	// Objects under "dlq/" are dead-lettered if expired, others are skipped
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"parser": "json",
+	"max_age": 86400,
+	"expired_action": expired_action,
+}] {
+	input.cs.bucket == "cloudtrail-logs"
+}
+
+expired_action := "dead_letter" {
+	startswith(input.cs.name, "dlq/")
+} else := "skip"
+`
+
+	testCases := map[string]struct {
+		name    string
+		age     time.Duration
+		ingests int
+		isErr   bool
+	}{
+		"load object within max age": {
+			name:    "recent.log",
+			age:     time.Hour,
+			ingests: 1,
+		},
+		"skip object older than max age": {
+			name:    "old.log",
+			age:     48 * time.Hour,
+			ingests: 0,
+		},
+		"dead letter object older than max age": {
+			name:    "dlq/old.log",
+			age:     48 * time.Hour,
+			ingests: 0,
+			isErr:   true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			var opened bool
+			csClient := &cs.Mock{
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					return &storage.ObjectAttrs{
+						Bucket:  obj.Bucket.String(),
+						Name:    obj.Name.String(),
+						Size:    int64(len(cloudTrailExampleRaw)),
+						Updated: time.Now().Add(-tc.age),
+					}, nil
+				},
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					opened = true
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(
+				policy.WithFile("testdata/policy/schema.rego"),
+				policy.WithPolicyData("event.rego", eventPolicy),
+			)).NoError(t)
+			bqClient := bq.NewGeneralMock()
+			dlClient := pubsub.NewMock()
+
+			uc := usecase.New(
+				infra.New(
+					infra.WithBigQuery(bqClient),
+					infra.WithCloudStorage(csClient),
+					infra.WithPolicy(pClient),
+				),
+				usecase.WithDeadLetterPubSub(dlClient),
+			)
+
+			err := uc.LoadDataByObject(context.Background(), types.CSUrl("gs://cloudtrail-logs/"+tc.name))
+			gt.A(t, bqClient.Streams).Length(tc.ingests)
+			gt.Equal(t, opened, tc.ingests > 0)
+
+			if !tc.isErr {
+				gt.NoError(t, err)
+				gt.A(t, dlClient.Results).Length(0)
+				return
+			}
+
+			gt.True(t, errors.Is(err, types.ErrObjectExpired))
+			gt.Equal(t, uc.ClassifyError(err), types.RetryDecisionDeadLetter)
+			gt.A(t, dlClient.Results).Length(1).At(0, func(t testing.TB, v *pubsub.MockResult) {
+				gt.String(t, v.Attrs[usecase.DeadLetterAttrError]).Contains("object is expired")
+			})
+		})
+	}
+}
+
+func TestLoadLowerCaseDest(t *testing.T) {
+	const schemaPolicy = `package schema.casing
+
+log[{
+	"dataset": "My_Dataset",
+	"table": "MyTable",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+
+log[{
+	"dataset": "my_dataset",
+	"table": "mytable",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+
+	testCases := map[string]struct {
+		options []usecase.Option
+		tables  []string
+	}{
+		"route both casings to one table with normalization": {
+			options: []usecase.Option{usecase.WithLowerCaseDest()},
+			tables:  []string{"my_dataset.mytable"},
+		},
+		"create separate tables without normalization": {
+			tables: []string{"My_Dataset.MyTable", "my_dataset.mytable"},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), tc.options...)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "casing",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+				},
+			}
+			gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+
+			tables := map[string]bool{}
+			for _, created := range bqClient.CreatedTable {
+				tables[created.Dataset.String()+"."+created.Table.String()] = true
+			}
+			gt.A(t, bqClient.CreatedTable).Length(len(tc.tables))
+			for _, table := range tc.tables {
+				gt.True(t, tables[table])
+			}
+
+			var inserted int
+			for _, s := range bqClient.Streams {
+				for _, data := range s.Inserted {
+					inserted += len(data)
+				}
+			}
+			gt.Equal(t, inserted, 8)
+		})
+	}
+}
+
+func TestLoadFailOnEmpty(t *testing.T) {
+	// Only the first record produces a log
+	const schemaPolicy = `package schema.partial
+
+log[{
+	"dataset": "my_dataset",
+	"table": "partial",
+	"id": input.eventID,
+	"timestamp": time.parse_rfc3339_ns(input.eventTime) / 1000000000,
+	"data": input,
+}] {
+	input.eventID == "d4dacb9d-9822-4217-b88d-d334bde89755"
+}
+`
+
+	testCases := map[string]struct {
+		failOnEmpty bool
+		isErr       bool
+	}{
+		"best-effort source skips record without log": {
+			failOnEmpty: false,
+		},
+		"critical source fails on record without log": {
+			failOnEmpty: true,
+			isErr:       true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:      types.JSONParser,
+					Schema:      "partial",
+					RecordsPath: "Records",
+					FailOnEmpty: tc.failOnEmpty,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+				},
+			}
+
+			err := uc.Load(context.Background(), []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.True(t, errors.Is(err, types.ErrNoPolicyResult))
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+
+			gt.NoError(t, err)
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(1)
+		})
+	}
+}
+
+func TestLoadPolicyBatch(t *testing.T) {
+	const schemaPolicy = `package schema.batch
+
+log[{
+	"dataset": "my_dataset",
+	"table": "batch",
+	"id": input.eventID,
+	"timestamp": time.parse_rfc3339_ns(input.eventTime) / 1000000000,
+	"data": input,
+}]
+`
+
+	load := func(t *testing.T, options ...usecase.Option) []*model.LogRecordRaw {
+		bqClient := bq.NewGeneralMock()
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), options...)
+
+		req := &model.LoadRequest{
+			Source: model.Source{
+				Parser:      types.JSONParser,
+				Schema:      "batch",
+				RecordsPath: "Records",
+			},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{
+					Bucket: "test-bucket",
+					Name:   "test.log",
+				},
+			},
+		}
+		gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+
+		gt.A(t, bqClient.Streams).Length(1)
+		var records []*model.LogRecordRaw
+		for _, v := range bqClient.Streams[0].Inserted[0] {
+			records = append(records, gt.Cast[*model.LogRecordRaw](t, v))
+		}
+		return records
+	}
+
+	perRow := load(t)
+	gt.A(t, perRow).Length(4)
+
+	// 3 does not divide number of records (4) to test the last partial batch
+	for _, size := range []int{3, 4, 10} {
+		t.Run(fmt.Sprintf("batch size %d", size), func(t *testing.T) {
+			batched := load(t, usecase.WithPolicyBatchSize(size))
+			gt.A(t, batched).Length(len(perRow))
+			for i := range perRow {
+				gt.Equal(t, batched[i].ID, perRow[i].ID)
+				gt.Equal(t, batched[i].Timestamp, perRow[i].Timestamp)
+				gt.Equal(t, batched[i].Data, perRow[i].Data)
+			}
+		})
+	}
+}
+
+func TestLoadTableDescription(t *testing.T) {
+	const schemaPolicy = `package schema.desc
+
+log[{
+	"dataset": "my_dataset",
+	"table": "described",
+	"description": "CloudTrail logs of S3 data events",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+
+	newUseCase := func(t *testing.T, bqClient *bq.GeneralMock, schemaPolicy string) *usecase.UseCase {
+		return newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   cloudTrailExampleRaw,
+			Policy:   []policy.Option{policy.WithPolicyData("schema.rego", schemaPolicy)},
+		})
+	}
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "desc"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+
+	bqClient := bq.NewGeneralMock()
+	gt.NoError(t, newUseCase(t, bqClient, schemaPolicy).Load(context.Background(), []*model.LoadRequest{req}))
+	gt.A(t, bqClient.CreatedTable).Length(1)
+	gt.Equal(t, bqClient.CreatedTable[0].MD.Description, "CloudTrail logs of S3 data events")
+	created := bqClient.CreatedTable[0].MD
+
+	t.Run("update description of existing table", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: created.Schema, Description: "old description"}}
+		gt.NoError(t, newUseCase(t, bqClient, schemaPolicy).Load(context.Background(), []*model.LoadRequest{req}))
+		gt.A(t, bqClient.UpdatedTable).Length(1)
+		gt.Equal(t, bqClient.UpdatedTable[0].MD.Description, "CloudTrail logs of S3 data events")
+	})
+
+	t.Run("do not update if description is not changed", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: created.Schema, Description: created.Description}}
+		gt.NoError(t, newUseCase(t, bqClient, schemaPolicy).Load(context.Background(), []*model.LoadRequest{req}))
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+	})
+
+	t.Run("ingest logs with different descriptions together", func(t *testing.T) {
+		const perLogPolicy = `package schema.desc
+
+log[{
+	"dataset": "my_dataset",
+	"table": "described",
+	"description": sprintf("event %s", [r.eventID]),
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, newUseCase(t, bqClient, perLogPolicy).Load(context.Background(), []*model.LoadRequest{req}))
+		gt.A(t, bqClient.CreatedTable).Length(1)
+		gt.String(t, bqClient.CreatedTable[0].MD.Description).HasPrefix("event ")
+		gt.A(t, bqClient.Streams).Length(1)
+		gt.A(t, bqClient.Streams[0].Inserted).Length(1)
+		gt.A(t, bqClient.Streams[0].Inserted[0]).Length(4)
+	})
+}
+
+func TestLoadRangePartition(t *testing.T) {
+	const schemaPolicy = `package schema.range
+
+log[{
+	"dataset": "my_dataset",
+	"table": "ranged",
+	"id": input.id,
+	"timestamp": 1700000000,
+	"range_partition": {"field": "customer.id", "start": 0, "end": 10000, "interval": 1000},
+	"data": input,
+}]
+`
+	const conflictPolicy = `package schema.conflict
+
+log[{
+	"dataset": "my_dataset",
+	"table": "ranged",
+	"partition": "day",
+	"id": input.id,
+	"timestamp": 1700000000,
+	"range_partition": {"field": "customer.id", "start": 0, "end": 10000, "interval": 1000},
+	"data": input,
+}]
+`
+	raw := []byte(`{"id":"a1","customer":{"id":42}}
+{"id":"a2","customer":{"id":1500}}
+{"id":"a3","name":"no customer"}
+`)
+
+	load := func(t *testing.T, bqClient *bq.GeneralMock, schema types.ObjectSchema, options ...usecase.Option) error {
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(raw)), nil
+			},
+		}
+		pClient := gt.R1(policy.New(
+			policy.WithPolicyData("range.rego", schemaPolicy),
+			policy.WithPolicyData("conflict.rego", conflictPolicy),
+		)).NoError(t)
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), options...)
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: schema},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		return uc.Load(context.Background(), []*model.LoadRequest{req})
+	}
+
+	t.Run("create table with range partitioning", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		// Default partition is not applied to range partitioned table
+		gt.NoError(t, load(t, bqClient, "range", usecase.WithDefaultPartition(types.BQPartitionDay)))
+
+		gt.A(t, bqClient.CreatedTable).Length(1)
+		md := bqClient.CreatedTable[0].MD
+		gt.Equal(t, md.TimePartitioning, nil)
+		gt.Equal(t, md.RangePartitioning, &bigquery.RangePartitioning{
+			Field: model.RangePartitionColumn,
+			Range: &bigquery.RangePartitioningRange{Start: 0, End: 10000, Interval: 1000},
+		})
+		column := findSchemaField(md.Schema, model.RangePartitionColumn)
+		gt.NotEqual(t, column, nil)
+		gt.Equal(t, column.Type, bigquery.IntegerFieldType)
+
+		gt.A(t, bqClient.Streams).Length(1)
+		keys := map[types.LogID]*int64{}
+		for _, v := range bqClient.Streams[0].Inserted[0] {
+			r := gt.Cast[*model.LogRecordRaw](t, v)
+			keys[r.ID] = r.PartitionKey
+		}
+		gt.Equal(t, *keys["a1"], 42)
+		gt.Equal(t, *keys["a2"], 1500)
+		// Missing field goes to NULL partition
+		gt.Equal(t, keys["a3"], nil)
+	})
+
+	t.Run("record without partition key omits the column", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, load(t, bqClient, "range"))
+		raw := gt.R1(json.Marshal(bqClient.Streams[0].Inserted[0][2])).NoError(t)
+		gt.String(t, string(raw)).NotContains(model.RangePartitionColumn)
+	})
+
+	t.Run("fail if time partitioning is also declared", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		err := load(t, bqClient, "conflict")
+		gt.True(t, errors.Is(err, types.ErrInvalidPolicyResult))
+		gt.A(t, bqClient.CreatedTable).Length(0)
+	})
+}
+
+func TestLoadShardTable(t *testing.T) {
+	const schemaPolicy = `package schema.shard
+
+log[{
+	"dataset": "my_dataset",
+	"table": "events",
+	"id": input.id,
+	"timestamp": input.ts,
+	"shard": "day",
+	"data": input,
+}]
+`
+	const objectPolicy = `package schema.shard_object
+
+log[{
+	"dataset": "my_dataset",
+	"table": "events",
+	"id": input.id,
+	"timestamp": input.ts,
+	"shard": "month",
+	"shard_by": "object",
+	"data": input,
+}]
+`
+	const conflictPolicy = `package schema.shard_conflict
+
+log[{
+	"dataset": "my_dataset",
+	"table": "events",
+	"partition": "day",
+	"id": input.id,
+	"timestamp": input.ts,
+	"shard": "day",
+	"data": input,
+}]
+`
+	// 2024-05-01T23:00:00Z, 2024-05-02T01:00:00Z and 2024-05-02T02:00:00Z
+	raw := []byte(`{"id":"a1","ts":1714604400}
+{"id":"a2","ts":1714611600}
+{"id":"a3","ts":1714615200}
+`)
+
+	load := func(t *testing.T, bqClient *bq.GeneralMock, schema types.ObjectSchema, options ...usecase.Option) error {
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(raw)), nil
+			},
+			MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+				return &storage.ObjectAttrs{Updated: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, nil
+			},
+		}
+		pClient := gt.R1(policy.New(
+			policy.WithPolicyData("shard.rego", schemaPolicy),
+			policy.WithPolicyData("object.rego", objectPolicy),
+			policy.WithPolicyData("conflict.rego", conflictPolicy),
+		)).NoError(t)
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), options...)
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: schema},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		return uc.Load(context.Background(), []*model.LoadRequest{req})
+	}
+
+	insertedIDs := func(t *testing.T, bqClient *bq.GeneralMock) map[types.BQTableID][]types.LogID {
+		ids := map[types.BQTableID][]types.LogID{}
+		for i, stream := range bqClient.Streams {
+			table := bqClient.OpenedStream[i].Table
+			for _, inserted := range stream.Inserted {
+				for _, v := range inserted {
+					r := gt.Cast[*model.LogRecordRaw](t, v)
+					ids[table] = append(ids[table], r.ID)
+				}
+			}
+		}
+		return ids
+	}
+
+	t.Run("route records to date-suffixed tables by timestamp", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		// Default partition is not applied to sharded tables
+		gt.NoError(t, load(t, bqClient, "shard", usecase.WithDefaultPartition(types.BQPartitionDay)))
+
+		gt.Equal(t, insertedIDs(t, bqClient), map[types.BQTableID][]types.LogID{
+			"events_20240501": {"a1"},
+			"events_20240502": {"a2", "a3"},
+		})
+		gt.A(t, bqClient.CreatedTable).Length(2)
+		for _, created := range bqClient.CreatedTable {
+			gt.Equal(t, created.MD.TimePartitioning, nil)
+		}
+	})
+
+	t.Run("route records by created time of object", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, load(t, bqClient, "shard_object"))
+
+		gt.Equal(t, insertedIDs(t, bqClient), map[types.BQTableID][]types.LogID{
+			"events_202406": {"a1", "a2", "a3"},
+		})
+	})
+
+	t.Run("fail if partitioning is also declared", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		err := load(t, bqClient, "shard_conflict")
+		gt.True(t, errors.Is(err, types.ErrInvalidPolicyResult))
+		gt.A(t, bqClient.CreatedTable).Length(0)
+	})
+}
+
+func TestLoadMaxFields(t *testing.T) {
+	const schemaPolicy = `package schema.fields
+
+log[{
+	"dataset": "my_dataset",
+	"table": "fields",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+
+	testCases := map[string]struct {
+		data      string
+		maxFields int
+		isErr     bool
+	}{
+		"record having too many keys": {
+			data:      `{"k1":1,"k2":2,"k3":3,"k4":4,"k5":5,"k6":6}`,
+			maxFields: 5,
+			isErr:     true,
+		},
+		"unique keys leaked across records": {
+			data:      `{"a":1,"b":1,"c":1}` + "\n" + `{"a":1,"b":1,"d":1}` + "\n" + `{"a":1,"b":1,"e":1}`,
+			maxFields: 4,
+			isErr:     true,
+		},
+		"keys within limit": {
+			data:      `{"a":1,"b":1,"c":1}` + "\n" + `{"a":1,"b":1,"d":1}`,
+			maxFields: 4,
+		},
+		"no limit": {
+			data: `{"k1":1,"k2":2,"k3":3,"k4":4,"k5":5,"k6":6}`,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader([]byte(tc.data))), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:    types.JSONParser,
+					Schema:    "fields",
+					MaxFields: tc.maxFields,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+				},
+			}
+
+			err := uc.Load(context.Background(), []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				gt.True(t, errors.Is(err, types.ErrTooManyFields))
+				gt.String(t, err.Error()).Contains(fmt.Sprintf("exceeding max_fields %d", tc.maxFields))
+				gt.A(t, bqClient.CreatedTable).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+			gt.A(t, bqClient.CreatedTable).Length(1)
+		})
+	}
+}
+
+func TestLoadDataByObjects(t *testing.T) {
+	var (
+		mutex   sync.Mutex
+		opened  []types.CSObjectID
+		running int
+		peak    int
+	)
+	csClient := &cs.Mock{
+		MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+			if obj.Name == "broken.log" {
+				return nil, errors.New("permission denied")
+			}
+			return &storage.ObjectAttrs{Bucket: obj.Bucket.String(), Name: obj.Name.String(), Size: 1024}, nil
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			mutex.Lock()
+			opened = append(opened, obj.Name)
+			running++
+			peak = max(peak, running)
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithFile("testdata/policy/schema.rego"),
+		policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+	)).NoError(t)
+	bqClient := bq.NewGeneralMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	urls := []types.CSUrl{
+		"gs://cloudtrail-logs/1.log",
+		"gs://cloudtrail-logs/2.log",
+		"gs://cloudtrail-logs/broken.log",
+		"gs://cloudtrail-logs/3.log",
+		"gs://cloudtrail-logs/4.log",
+		"gs://cloudtrail-logs/5.log",
+	}
+
+	// A failed url does not stop loading other urls
+	err := uc.LoadDataByObjects(context.Background(), urls, 3)
+	gt.Error(t, err)
+	gt.String(t, err.Error()).Contains("permission denied")
+
+	sort.Slice(opened, func(i, j int) bool { return opened[i] < opened[j] })
+	gt.Equal(t, opened, []types.CSObjectID{"1.log", "2.log", "3.log", "4.log", "5.log"})
+	gt.True(t, peak > 1)
+	gt.True(t, peak <= 3)
+	gt.A(t, bqClient.Streams).Length(5)
+}
+
+func TestLoadDataByObjectsFailureWriter(t *testing.T) {
+	csClient := &cs.Mock{
+		MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+			if obj.Name == "broken.log" {
+				return nil, errors.New("permission\ndenied")
+			}
+			return &storage.ObjectAttrs{Bucket: obj.Bucket.String(), Name: obj.Name.String(), Size: 1024}, nil
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithFile("testdata/policy/schema.rego"),
+		policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+	)).NoError(t)
+
+	var buf bytes.Buffer
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	), usecase.WithFailureWriter(&buf))
+
+	urls := []types.CSUrl{
+		"gs://cloudtrail-logs/1.log",
+		"gs://cloudtrail-logs/broken.log",
+		"gs://cloudtrail-logs/2.log",
+	}
+	gt.Error(t, uc.LoadDataByObjects(context.Background(), urls, 2))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	gt.A(t, lines).Length(1)
+	cols := strings.Split(lines[0], "\t")
+	gt.A(t, cols).Length(2)
+	gt.Equal(t, cols[0], "gs://cloudtrail-logs/broken.log")
+	// Error message is written in a single line
+	gt.String(t, cols[1]).Contains("permission denied")
+}
+
+func TestLoadPolicyErrorInSourceLog(t *testing.T) {
+	const brokenPolicy = `package schema.broken
+
+table = "a" { input.kind == "x" }
+
+table = "b" { input.kind == "x" }
+
+log[{"dataset": "my_dataset", "table": table, "timestamp": 1708130907, "data": input}]
+`
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(`{"kind":"x"}`))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("broken.rego", brokenPolicy))).NoError(t)
+	sink := &fakeLoadLogSink{}
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bq.NewGeneralMock()),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithLoadLogSink(sink),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "broken"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	err := uc.Load(ctx, []*model.LoadRequest{req})
+	gt.Error(t, err)
+	gt.String(t, err.Error()).Contains("broken.rego:")
+
+	gt.A(t, sink.written).Length(1)
+	var loadLog model.LoadLog
+	gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+	gt.False(t, loadLog.Success)
+	gt.A(t, loadLog.Sources).Length(1)
+
+	src := loadLog.Sources[0]
+	gt.NotEqual(t, src.Error, "")
+	gt.NotEqual(t, src.PolicyError, nil)
+	gt.Equal(t, src.PolicyError.Code, "eval_conflict_error")
+	gt.String(t, src.PolicyError.Location).Contains("broken.rego:")
+	gt.String(t, src.PolicyError.Rule).Contains("data.schema.broken.")
+}
+
+func TestLoadSortByTimestamp(t *testing.T) {
+	const schemaPolicy = `package schema.sorted
+
+log[{
+	"dataset": "my_dataset",
+	"table": "sorted",
+	"timestamp": input.ts,
+	"data": input,
+}]
+`
+	// 600 records in random order are split into 3 chunks
+	var logData bytes.Buffer
+	for i, n := range rand.New(rand.NewSource(1)).Perm(600) {
+		logData.WriteString(fmt.Sprintf(`{"ts":%d,"seq":%d}`+"\n", 1708130907+n, i))
+	}
+
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(logData.Bytes())), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithSortByTimestamp(),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "sorted"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	gt.A(t, bqClient.Streams).Length(1)
+	chunks := bqClient.Streams[0].Inserted
+	gt.A(t, chunks).Length(3)
+
+	// Chunks may be inserted concurrently, but each chunk has time-ordered records and chunks do not overlap
+	sort.Slice(chunks, func(i, j int) bool {
+		return gt.Cast[*model.LogRecordRaw](t, chunks[i][0]).Timestamp < gt.Cast[*model.LogRecordRaw](t, chunks[j][0]).Timestamp
+	})
+	var last int64
+	var count int
+	for _, chunk := range chunks {
+		for _, v := range chunk {
+			r := gt.Cast[*model.LogRecordRaw](t, v)
+			gt.True(t, last <= r.Timestamp)
+			last = r.Timestamp
+			count++
+		}
+	}
+	gt.Equal(t, count, 600)
+}
+
+func TestLoadIngestTimeout(t *testing.T) {
+	const schemaPolicy = `package schema.routing
+
+log[{
+	"dataset": "my_dataset",
+	"table": input.kind,
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const logData = `{"kind":"slow","seq":1}
+{"kind":"fast","seq":2}
+`
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	bqClient.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+		if tableID == "slow" {
+			// Hung insert that returns only when the context is done
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(logData))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithIngestTimeout(50*time.Millisecond),
+		usecase.WithIngestTableConcurrency(1),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "routing"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+
+	startedAt := time.Now()
+	err := uc.Load(ctx, []*model.LoadRequest{req})
+	gt.True(t, time.Since(startedAt) < 5*time.Second)
+	gt.True(t, errors.Is(err, context.DeadlineExceeded))
+	gt.String(t, err.Error()).Contains("ingest timeout exceeded")
+
+	// The fast destination is ingested even if it is processed after the hung one
+	gt.A(t, bqClient.Streams).Length(2)
+	for i, s := range bqClient.OpenedStream {
+		if s.Table == "fast" {
+			gt.A(t, bqClient.Streams[i].Inserted).Length(1)
+		} else {
+			gt.A(t, bqClient.Streams[i].Inserted).Length(0)
+		}
+	}
+}
+
+func TestLoadIngestTimeFallback(t *testing.T) {
+	const schemaPolicy = `package schema.no_ts
+
+log[{
+	"dataset": "my_dataset",
+	"table": "no_ts",
+	"data": input,
+}]
+`
+	ctx := context.Background()
+	newUseCase := func(bqClient *bq.GeneralMock, options ...usecase.Option) *usecase.UseCase {
+		return newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   []byte(`{"user":"alice"}`),
+			Policy:   []policy.Option{policy.WithPolicyData("schema.rego", schemaPolicy)},
+		}, options...)
+	}
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "no_ts"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+
+	t.Run("log without timestamp is rejected by default", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.Error(t, newUseCase(bqClient).Load(ctx, []*model.LoadRequest{req}))
+		gt.A(t, bqClient.Streams).Length(0)
+	})
+
+	t.Run("ingested time is used as timestamp", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		startedAt := time.Now()
+		gt.NoError(t, newUseCase(bqClient, usecase.WithIngestTimeFallback()).Load(ctx, []*model.LoadRequest{req}))
+
+		gt.A(t, bqClient.CreatedTable).Length(1)
+		tsField := findSchemaField(bqClient.CreatedTable[0].MD.Schema, "timestamp")
+		gt.NotEqual(t, tsField, nil)
+		gt.True(t, tsField.Required)
+
+		gt.A(t, bqClient.Streams).Length(1)
+		r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+		gt.Equal(t, r.Timestamp, r.IngestedAt)
+		gt.True(t, r.Timestamp >= startedAt.UnixMicro())
+	})
+
+	t.Run("mode of existing column is not changed", func(t *testing.T) {
+		first := bq.NewGeneralMock()
+		gt.NoError(t, newUseCase(first, usecase.WithIngestTimeFallback()).Load(ctx, []*model.LoadRequest{req}))
+		schema := first.CreatedTable[0].MD.Schema
+		setNullable(schema)
+
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: schema}}
+		gt.NoError(t, newUseCase(bqClient, usecase.WithIngestTimeFallback()).Load(ctx, []*model.LoadRequest{req}))
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+	})
+}
+
+func setNullable(schema bigquery.Schema) {
+	for _, field := range schema {
+		field.Required = false
+		setNullable(field.Schema)
+	}
+}
+
+func TestLoadObjectVersion(t *testing.T) {
+	const schemaPolicy = `package schema.version
+
+log[{
+	"dataset": "my_dataset",
+	"table": "version",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	// openedGeneration is generation of the object passed to Open
+	var openedGeneration int64
+	run := func(t *testing.T, options ...usecase.Option) model.SourceLog {
+		ctx := context.Background()
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				openedGeneration = obj.Generation
+				return io.NopCloser(bytes.NewReader([]byte(`{"kind":"x"}`))), nil
+			},
+			MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+				return &storage.ObjectAttrs{
+					Bucket:     obj.Bucket.String(),
+					Name:       obj.Name.String(),
+					Generation: 1708130907123456,
+					CRC32C:     0x12345678,
+				}, nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("version.rego", schemaPolicy))).NoError(t)
+		sink := &fakeLoadLogSink{}
+
+		uc := usecase.New(
+			infra.New(
+				infra.WithBigQuery(bq.NewGeneralMock()),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			),
+			append(options, usecase.WithLoadLogSink(sink))...,
+		)
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "version"},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+		gt.A(t, sink.written).Length(1)
+		var loadLog model.LoadLog
+		gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+		gt.A(t, loadLog.Sources).Length(1)
+		return *loadLog.Sources[0]
+	}
+
+	t.Run("record generation and CRC32C", func(t *testing.T) {
+		src := run(t, usecase.WithObjectVersion())
+		gt.Equal(t, src.Generation, int64(1708130907123456))
+		gt.Equal(t, src.CRC32C, "EjRWeA==")
+		// Recorded generation is downloaded even if the object is overwritten after getting attributes
+		gt.Equal(t, openedGeneration, int64(1708130907123456))
+		gt.Equal(t, src.CS.Generation, int64(0))
+	})
+
+	t.Run("not recorded by default", func(t *testing.T) {
+		src := run(t)
+		gt.Equal(t, src.Generation, int64(0))
+		gt.Equal(t, src.CRC32C, "")
+		gt.Equal(t, openedGeneration, int64(0))
+	})
+}
+
+func TestLoadDropRecords(t *testing.T) {
+	const schemaPolicy = `package schema.drop
+
+log[{
+	"dataset": "my_dataset",
+	"table": "drop",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const data = `{"path":"/healthz","status":200}
+{"path":"/api/users","status":200}
+{"path":"/api/users","status":500,"internal":true}
+{"request":{"path":"/readyz"}}
+{"path":"/api/items","status":404}`
+
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(data))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithPolicyData("drop.rego", schemaPolicy))).NoError(t)
+	sink := &fakeLoadLogSink{}
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithLoadLogSink(sink),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser: types.JSONParser,
+			Schema: "drop",
+			Drop: model.RecordFilters{
+				{Field: "path", Value: "/healthz"},
+				{Field: "request.path", Value: "/readyz"},
+				{Field: "internal", Value: "true"},
+				{Field: "status", Value: "404"},
+			},
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	gt.A(t, bqClient.Streams).Length(1)
+	gt.A(t, bqClient.Streams[0].Inserted).Length(1)
+	gt.A(t, bqClient.Streams[0].Inserted[0]).Length(1)
+	r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+	inserted := gt.Cast[map[string]any](t, r.Data)
+	gt.Equal(t, inserted["path"], any("/api/users"))
+
+	gt.A(t, sink.written).Length(1)
+	var loadLog model.LoadLog
+	gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+	gt.A(t, loadLog.Sources).Length(1)
+	gt.Equal(t, loadLog.Sources[0].DropCount, 4)
+	gt.Equal(t, loadLog.Sources[0].RowCount, 1)
+}
+
+func TestLoadProjectFields(t *testing.T) {
+	const data = `{"id":"a1","user":{"name":"alice","email":"alice@example.com","role":"admin"},"action":"login","debug":{"trace":"xxx"},"extra":1}`
+
+	testCases := map[string]struct {
+		projection string
+		expect     map[string]any
+	}{
+		"include three fields": {
+			projection: `"include": ["id", "user.name", "action"]`,
+			expect: map[string]any{
+				"id":     "a1",
+				"user":   map[string]any{"name": "alice"},
+				"action": "login",
+			},
+		},
+		"exclude fields": {
+			projection: `"exclude": ["debug", "extra", "user.email", "user.role"]`,
+			expect: map[string]any{
+				"id":     "a1",
+				"user":   map[string]any{"name": "alice"},
+				"action": "login",
+			},
+		},
+		"include and exclude": {
+			projection: `"include": ["id", "user", "action", "missing.field"], "exclude": ["user.email", "user.role"]`,
+			expect: map[string]any{
+				"id":     "a1",
+				"user":   map[string]any{"name": "alice"},
+				"action": "login",
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			schemaPolicy := `package schema.projection
+
+log[{
+	"dataset": "my_dataset",
+	"table": "projection",
+	"timestamp": 1708130907,
+	` + tc.projection + `,
+	"data": input,
+}]
+`
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader([]byte(data))), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("projection.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{Parser: types.JSONParser, Schema: "projection"},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+			gt.A(t, bqClient.CreatedTable).Length(1)
+			dataField := findSchemaField(bqClient.CreatedTable[0].MD.Schema, "data")
+			gt.NotEqual(t, dataField, nil)
+			gt.A(t, dataField.Schema).Length(3)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+			gt.Equal(t, r.Data, any(tc.expect))
+		})
+	}
+}
+
+func TestLoadRenameFields(t *testing.T) {
+	const schemaPolicy = `package schema.rename
+
+log[{
+	"dataset": "my_dataset",
+	"table": "rename",
+	"timestamp": 1708130907,
+	"rename_fields": {
+		"userName": "user_name",
+		"detail.srcIP": "src_ip",
+	},
+	"fields": {"user_name": {"type": "string"}},
+	"data": input,
+}]
+`
+	newUseCase := func(t *testing.T, bqClient *bq.GeneralMock, data string) *usecase.UseCase {
+		return newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   []byte(data),
+			Policy:   []policy.Option{policy.WithPolicyData("rename.rego", schemaPolicy)},
+		})
+	}
+	req := &model.LoadRequest{
+		Source: model.Source{Parser: types.JSONParser, Schema: "rename"},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+		},
+	}
+
+	t.Run("renamed field appears under new name", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		uc := newUseCase(t, bqClient, `{"userName":123,"detail":{"srcIP":"10.0.0.1"},"action":"login"}`)
+		gt.NoError(t, uc.Load(context.Background(), []*model.LoadRequest{req}))
+
+		gt.A(t, bqClient.Streams).Length(1)
+		r := gt.Cast[*model.LogRecordRaw](t, bqClient.Streams[0].Inserted[0][0])
+		gt.Equal(t, r.Data, any(map[string]any{
+			// fields refers to the new name
+			"user_name": "123",
+			"detail":    map[string]any{"src_ip": "10.0.0.1"},
+			"action":    "login",
+		}))
+
+		gt.A(t, bqClient.CreatedTable).Length(1)
+		dataField := findSchemaField(bqClient.CreatedTable[0].MD.Schema, "data")
+		gt.NotEqual(t, dataField, nil)
+		gt.NotEqual(t, findSchemaField(dataField.Schema, "user_name"), nil)
+		gt.Equal(t, findSchemaField(dataField.Schema, "userName"), nil)
+	})
+
+	t.Run("new name collides with existing field", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		uc := newUseCase(t, bqClient, `{"userName":"alice","user_name":"bob"}`)
+		err := uc.Load(context.Background(), []*model.LoadRequest{req})
+		gt.True(t, errors.Is(err, types.ErrInvalidPolicyResult))
+		gt.A(t, bqClient.Streams).Length(0)
+	})
+}
+
+func TestIngestRecordsRetryUnknownField(t *testing.T) {
+	ctx := context.Background()
+	dst := model.BigQueryDest{
+		Dataset: "test-dataset",
+		Table:   "test-table",
+	}
+	records := []*model.LogRecord{
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"name": "blue"},
+			IngestedAt: time.Now(),
+		},
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"name": "orange", "extra": "x"},
+			IngestedAt: time.Now(),
+		},
+	}
+
+	newMock := func(code model.InsertRowErrorCode) *bq.GeneralMock {
+		bqMock := bq.NewGeneralMock()
+		// Schema is inferred by the first record only, then the table lacks "extra" field
+		narrow := gt.R1(usecase.InferSchema(records[:1])).NoError(t)
+		bqMock.Metadata = []*bigquery.TableMetadata{nil, {Schema: narrow}}
+
+		var called int32
+		bqMock.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+			if atomic.AddInt32(&called, 1) == 1 {
+				return &model.InsertRowErrors{Errors: []*model.InsertRowError{
+					{Index: 1, Code: code, Reason: "INVALID: no such field: data.extra."},
+				}}
+			}
+			return nil
+		}
+		return bqMock
+	}
+
+	t.Run("fail without option", func(t *testing.T) {
+		bqMock := newMock(model.InsertRowErrorUnknownField)
+		resp, err := usecase.IngestRecords(ctx, bqMock, dst, records, 1, usecase.WithSchemaSample(1, 0))
+		gt.Error(t, err)
+		gt.False(t, resp.Success)
+		gt.A(t, bqMock.UpdatedTable).Length(0)
+	})
+
+	t.Run("fail by other field error", func(t *testing.T) {
+		// Rejection is classified by the code, not by the reason message
+		bqMock := newMock(model.InsertRowErrorFields)
+		resp, err := usecase.IngestRecords(ctx, bqMock, dst, records, 1,
+			usecase.WithSchemaSample(1, 0),
+			usecase.WithRetryUnknownField(),
+		)
+		gt.Error(t, err)
+		gt.False(t, resp.Success)
+		gt.A(t, bqMock.UpdatedTable).Length(0)
+	})
+
+	t.Run("widen schema and retry", func(t *testing.T) {
+		bqMock := newMock(model.InsertRowErrorUnknownField)
+		resp := gt.R1(usecase.IngestRecords(ctx, bqMock, dst, records, 1,
+			usecase.WithSchemaSample(1, 0),
+			usecase.WithRetryUnknownField(),
+		)).NoError(t)
+		gt.True(t, resp.Success)
+
+		gt.A(t, bqMock.UpdatedTable).Length(1)
+		data := findSchemaField(bqMock.UpdatedTable[0].MD.Schema, "data")
+		gt.NotEqual(t, data, nil)
+		gt.NotEqual(t, findSchemaField(data.Schema, "extra"), nil)
+
+		gt.A(t, bqMock.Streams).Length(2)
+		gt.A(t, bqMock.Streams[0].Inserted).Length(0)
+		gt.A(t, bqMock.Streams[1].Inserted).Length(1)
+		gt.A(t, bqMock.Streams[1].Inserted[0]).Length(2)
+	})
+}
+
+func TestLoadWithEnvelopePath(t *testing.T) {
+	const schemaPolicy = `package schema.envelope
+
+log[{
+	"dataset": "my_dataset",
+	"table": "envelope",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const data = `{"metadata": {"account": "123", "region": "us-east-1"}, "records": [{"id": "a"}, {"id": "b", "region": "ap-northeast-1"}]}`
+
+	testCases := map[string]struct {
+		path   string
+		expect []map[string]any
+		isErr  bool
+	}{
+		"merge envelope into each record": {
+			path: "metadata",
+			expect: []map[string]any{
+				{"id": "a", "account": "123", "region": "us-east-1"},
+				// Field of the record takes precedence
+				{"id": "b", "account": "123", "region": "ap-northeast-1"},
+			},
+		},
+		"envelope path not found": {
+			path:  "header",
+			isErr: true,
+		},
+		"envelope is not object": {
+			path:  "metadata.account",
+			isErr: true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader([]byte(data))), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("envelope.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:       types.JSONParser,
+					Schema:       "envelope",
+					RecordsPath:  "records",
+					EnvelopePath: tc.path,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+				},
+			}
+
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.Error(t, err)
+				return
+			}
+			gt.NoError(t, err)
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(len(tc.expect))
+			var actual []map[string]any
+			for _, v := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, v)
+				actual = append(actual, gt.Cast[map[string]any](t, r.Data))
+			}
+			sort.Slice(actual, func(i, j int) bool {
+				return actual[i]["id"].(string) < actual[j]["id"].(string)
+			})
+			gt.Equal(t, actual, tc.expect)
+		})
+	}
+}
+
+func TestIngestRecordsDedupWindow(t *testing.T) {
+	dst := model.BigQueryDest{
+		Dataset: "test-dataset",
+		Table:   "test-table",
+	}
+	records := []*model.LogRecord{
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now().Add(-48 * time.Hour),
+			Data:       map[string]any{"name": "blue"},
+			IngestedAt: time.Now(),
+		},
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"name": "orange"},
+			IngestedAt: time.Now(),
+		},
+	}
+
+	t.Run("warn old records", func(t *testing.T) {
+		var buf bytes.Buffer
+		ctx := utils.CtxWithLogger(context.Background(), slog.New(slog.NewJSONHandler(&buf, nil)))
+		reg := metrics.New()
+
+		resp := gt.R1(usecase.IngestRecords(ctx, bq.NewGeneralMock(), dst, records, 1,
+			usecase.WithDedupWindow(time.Hour),
+			usecase.WithMetrics(reg),
+		)).NoError(t)
+		gt.True(t, resp.Success)
+
+		gt.String(t, buf.String()).Contains("records are outside of dedup window")
+		gt.String(t, buf.String()).Contains(`"count":1`)
+
+		var out bytes.Buffer
+		gt.R1(reg.WriteTo(&out)).NoError(t)
+		gt.String(t, out.String()).Contains("swarm_rows_outside_dedup_window_total 1\n")
+	})
+
+	t.Run("no warning without option", func(t *testing.T) {
+		var buf bytes.Buffer
+		ctx := utils.CtxWithLogger(context.Background(), slog.New(slog.NewJSONHandler(&buf, nil)))
+
+		gt.R1(usecase.IngestRecords(ctx, bq.NewGeneralMock(), dst, records, 1)).NoError(t)
+		gt.False(t, bytes.Contains(buf.Bytes(), []byte("outside of dedup window")))
+	})
+}
+
+func TestLoadWithPathFields(t *testing.T) {
+	const schemaPolicy = `package schema.path_fields
+
+log[{
+	"dataset": "my_dataset",
+	"table": "path_fields",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const data = `{"id": "a"}
+{"id": "b", "service": "s3"}`
+
+	testCases := map[string]struct {
+		name   types.CSObjectID
+		expect []map[string]any
+	}{
+		"extract region and service": {
+			name: "logs/region=us/service=ec2/dt=2024-01-01%3A00/1.log",
+			expect: []map[string]any{
+				{"id": "a", "region": "us", "service": "ec2"},
+				// Field of the record takes precedence
+				{"id": "b", "region": "us", "service": "s3"},
+			},
+		},
+		"missing segment is ignored": {
+			name: "logs/region=ap/1.log",
+			expect: []map[string]any{
+				{"id": "a", "region": "ap"},
+				{"id": "b", "region": "ap", "service": "s3"},
+			},
+		},
+		"no hive-style segment": {
+			name: "logs/us/ec2/1.log",
+			expect: []map[string]any{
+				{"id": "a"},
+				{"id": "b", "service": "s3"},
+			},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader([]byte(data))), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("path_fields.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:     types.JSONParser,
+					Schema:     "path_fields",
+					PathFields: []string{"region", "service"},
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: tc.name},
+				},
+			}
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(len(tc.expect))
+			var actual []map[string]any
+			for _, v := range bqClient.Streams[0].Inserted[0] {
+				r := gt.Cast[*model.LogRecordRaw](t, v)
+				actual = append(actual, gt.Cast[map[string]any](t, r.Data))
+			}
+			sort.Slice(actual, func(i, j int) bool {
+				return actual[i]["id"].(string) < actual[j]["id"].(string)
+			})
+			gt.Equal(t, actual, tc.expect)
+		})
+	}
+}
+
+func TestIngestRecordsTableMetrics(t *testing.T) {
+	ctx := context.Background()
+	records := []*model.LogRecord{
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"name": "blue"},
+			IngestedAt: time.Now(),
+		},
+	}
+	okDst := model.BigQueryDest{Dataset: "test_dataset", Table: "ok_table"}
+	ngDst := model.BigQueryDest{Dataset: "test_dataset", Table: "ng_table"}
+
+	bqMock := bq.NewGeneralMock()
+	bqMock.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+		if tableID == ngDst.Table {
+			return errors.New("insert failed")
+		}
+		return nil
+	}
+	reg := metrics.New()
+
+	resp := gt.R1(usecase.IngestRecords(ctx, bqMock, okDst, records, 1, usecase.WithMetrics(reg))).NoError(t)
+	gt.True(t, resp.Success)
+	resp, err := usecase.IngestRecords(ctx, bqMock, ngDst, records, 1, usecase.WithMetrics(reg))
+	gt.Error(t, err)
+	gt.False(t, resp.Success)
+	gt.R1(usecase.IngestRecords(ctx, bqMock, okDst, records, 1, usecase.WithMetrics(reg))).NoError(t)
+
+	var out bytes.Buffer
+	gt.R1(reg.WriteTo(&out)).NoError(t)
+	gt.String(t, out.String()).Contains(`swarm_table_ingests_total{table="test_dataset.ok_table",result="success"} 2` + "\n")
+	gt.String(t, out.String()).Contains(`swarm_table_ingests_total{table="test_dataset.ng_table",result="failure"} 1` + "\n")
+	gt.False(t, strings.Contains(out.String(), `table="test_dataset.ok_table",result="failure"`))
+}
+
+func TestIngestRecordsOTelMetrics(t *testing.T) {
+	ctx := context.Background()
+	records := []*model.LogRecord{
+		{
+			ID:         "log-1",
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"key": "value"},
+			IngestedAt: time.Now(),
+		},
+		{
+			ID:         "log-2",
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"key": "value"},
+			IngestedAt: time.Now(),
+		},
+	}
+	dst := model.BigQueryDest{Dataset: "test_dataset", Table: "ok_table"}
+
+	reader := sdkmetric.NewManualReader()
+	emitter := metrics.NewOTel(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	resp := gt.R1(usecase.IngestRecords(ctx, bq.NewGeneralMock(), dst, records, 1, usecase.WithMetricsEmitter(emitter))).NoError(t)
+	gt.True(t, resp.Success)
+
+	var rm metricdata.ResourceMetrics
+	gt.NoError(t, reader.Collect(ctx, &rm))
+	data := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			data[m.Name] = m.Data
+		}
+	}
+
+	rows := gt.Cast[metricdata.Sum[int64]](t, data["swarm_rows_ingested_total"])
+	gt.A(t, rows.DataPoints).Length(1)
+	gt.Equal(t, rows.DataPoints[0].Value, 2)
+
+	ingests := gt.Cast[metricdata.Sum[int64]](t, data["swarm_table_ingests_total"])
+	gt.A(t, ingests.DataPoints).Length(1)
+	table, _ := ingests.DataPoints[0].Attributes.Value("table")
+	result, _ := ingests.DataPoints[0].Attributes.Value("result")
+	gt.Equal(t, table.AsString(), "test_dataset.ok_table")
+	gt.Equal(t, result.AsString(), "success")
+	gt.Equal(t, ingests.DataPoints[0].Value, 1)
+
+	// Gauges are calculated from totals kept in swarm because OpenTelemetry counter can not be read back
+	rate := gt.Cast[metricdata.Gauge[float64]](t, data["swarm_rows_ingested_per_second"])
+	gt.A(t, rate.DataPoints).Length(1)
+	gt.True(t, rate.DataPoints[0].Value > 0)
+}
+
+func TestLoadVerifyChecksum(t *testing.T) {
+	const schemaPolicy = `package schema.checksum
+
+log[{
+	"dataset": "my_dataset",
+	"table": "checksum",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const data = `{"kind":"x"}`
+
+	testCases := map[string]struct {
+		content  string
+		encoding string
+		options  []usecase.Option
+		isErr    bool
+	}{
+		"match": {
+			content: data,
+			options: []usecase.Option{usecase.WithVerifyChecksum()},
+		},
+		"truncated": {
+			content: data[:6],
+			options: []usecase.Option{usecase.WithVerifyChecksum()},
+			isErr:   true,
+		},
+		"corrupted": {
+			content: `{"kind":"y"}`,
+			options: []usecase.Option{usecase.WithVerifyChecksum()},
+			isErr:   true,
+		},
+		"not verified without option": {
+			content: `{"kind":"y"}`,
+		},
+		"skip gzip content encoding": {
+			content:  `{"kind":"y"}`,
+			encoding: "gzip",
+			options:  []usecase.Option{usecase.WithVerifyChecksum()},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader([]byte(tc.content))), nil
+				},
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					return &storage.ObjectAttrs{
+						Bucket:          obj.Bucket.String(),
+						Name:            obj.Name.String(),
+						ContentEncoding: tc.encoding,
+						// Checksum of the original data, not the downloaded content
+						CRC32C: crc32.Checksum([]byte(data), crc32.MakeTable(crc32.Castagnoli)),
+					}, nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("checksum.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), tc.options...)
+
+			req := &model.LoadRequest{
+				Source: model.Source{Parser: types.JSONParser, Schema: "checksum"},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+				},
+			}
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrCorruptObject))
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+			gt.A(t, bqClient.Streams).Length(1)
+		})
+	}
+}
+
+func TestLoadSampleRate(t *testing.T) {
+	const schemaPolicy = `package schema.sample
+
+log[{
+	"dataset": "my_dataset",
+	"table": "sample",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const total = 10000
+	var buf bytes.Buffer
+	for i := 0; i < total; i++ {
+		fmt.Fprintf(&buf, `{"seq":%d}`+"\n", i)
+	}
+
+	run := func(t *testing.T, rate float64) ([]types.LogID, model.SourceLog) {
+		ctx := context.Background()
+		bqClient := bq.NewGeneralMock()
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("sample.rego", schemaPolicy))).NoError(t)
+		sink := &fakeLoadLogSink{}
+
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), usecase.WithLoadLogSink(sink))
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "sample", SampleRate: rate},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+		var ids []types.LogID
+		for _, stream := range bqClient.Streams {
+			for _, chunk := range stream.Inserted {
+				for _, v := range chunk {
+					ids = append(ids, gt.Cast[*model.LogRecordRaw](t, v).ID)
+				}
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		gt.A(t, sink.written).Length(1)
+		var loadLog model.LoadLog
+		gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+		gt.A(t, loadLog.Sources).Length(1)
+		return ids, *loadLog.Sources[0]
+	}
+
+	t.Run("keep approximately the rate", func(t *testing.T) {
+		ids, src := run(t, 0.3)
+		gt.True(t, len(ids) > total*0.27)
+		gt.True(t, len(ids) < total*0.33)
+		gt.Equal(t, src.SampledOutCount, total-len(ids))
+		gt.Equal(t, src.RowCount, total)
+
+		// Same logs are kept in reprocessing
+		again, _ := run(t, 0.3)
+		gt.Equal(t, again, ids)
+	})
+
+	t.Run("no sampling by default", func(t *testing.T) {
+		ids, src := run(t, 0)
+		gt.A(t, ids).Length(total)
+		gt.Equal(t, src.SampledOutCount, 0)
+	})
+}
+
+func TestIngestRecordsLoadLabels(t *testing.T) {
+	reqID, ctx := utils.CtxRequestID(context.Background())
+	dst := model.BigQueryDest{Dataset: "test_dataset", Table: "test_table"}
+	records := []*model.LogRecord{
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"name": "blue"},
+			IngestedAt: time.Now(),
+		},
+	}
+
+	labelsAt := func(at int64) bigquery.TableMetadataToUpdate {
+		var md bigquery.TableMetadataToUpdate
+		md.SetLabel("swarm_last_load_id", reqID.String())
+		md.SetLabel("swarm_last_load_at", fmt.Sprintf("%d", at))
+		return md
+	}
+
+	t.Run("labels are updated after ingestion", func(t *testing.T) {
+		bqMock := bq.NewGeneralMock()
+		startedAt := time.Now().Unix()
+		gt.R1(usecase.IngestRecords(ctx, bqMock, dst, records, 1, usecase.WithLoadLabels())).NoError(t)
+		finishedAt := time.Now().Unix()
+
+		gt.A(t, bqMock.UpdatedTable).Length(1)
+		updated := bqMock.UpdatedTable[0]
+		gt.Equal(t, updated.Dataset, dst.Dataset)
+		gt.Equal(t, updated.Table, dst.Table)
+		gt.Equal(t, updated.ETag, "")
+		// Load time is between start and finish of ingestion
+		var matched bool
+		for at := startedAt; at <= finishedAt; at++ {
+			if reflect.DeepEqual(updated.MD, labelsAt(at)) {
+				matched = true
+			}
+		}
+		gt.True(t, matched)
+	})
+
+	t.Run("not updated by failed ingestion", func(t *testing.T) {
+		bqMock := bq.NewGeneralMock()
+		bqMock.MockInsert = func(ctx context.Context, datasetID types.BQDatasetID, tableID types.BQTableID, data []any) error {
+			return errors.New("insert failed")
+		}
+		_, err := usecase.IngestRecords(ctx, bqMock, dst, records, 1, usecase.WithLoadLabels())
+		gt.Error(t, err)
+		gt.A(t, bqMock.UpdatedTable).Length(0)
+	})
+
+	t.Run("not updated by default", func(t *testing.T) {
+		bqMock := bq.NewGeneralMock()
+		gt.R1(usecase.IngestRecords(ctx, bqMock, dst, records, 1)).NoError(t)
+		gt.A(t, bqMock.UpdatedTable).Length(0)
+	})
+}
+
+func TestLoadObjectPolicy(t *testing.T) {
+	const recordPolicy = `package schema.object
+
+log[d] {
+	d := {
+		"dataset": "my_dataset",
+		"table": "object",
+		"id": input.id,
+		"timestamp": input.ts,
+		"data": input,
+	}
+}
+`
+	const objectPolicy = recordPolicy + `
+batch_log[d] {
+	r := input[_]
+	d := {
+		"dataset": "my_dataset",
+		"table": "object",
+		"id": r.id,
+		"timestamp": r.ts,
+		"data": r,
+	}
+}
+`
+	const data = `{"id": "a", "ts": 1708130907, "user": "alice"}
+{"id": "b", "ts": 1708130908, "user": "bob"}
+{"id": "c", "ts": 1708130909, "user": "carol"}`
+
+	run := func(t *testing.T, policyData string, objectMode bool) ([]map[string]any, model.SourceLog) {
+		ctx := context.Background()
+		bqClient := bq.NewGeneralMock()
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader([]byte(data))), nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("object.rego", policyData))).NoError(t)
+		sink := &fakeLoadLogSink{}
+
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), usecase.WithLoadLogSink(sink))
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "object", ObjectPolicy: objectMode},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+		// Compare values not depending on time of ingestion
+		var records []map[string]any
+		for _, stream := range bqClient.Streams {
+			for _, chunk := range stream.Inserted {
+				for _, v := range chunk {
+					r := gt.Cast[*model.LogRecordRaw](t, v)
+					records = append(records, map[string]any{
+						"id":        r.ID,
+						"timestamp": r.Timestamp,
+						"data":      r.Data,
+					})
+				}
+			}
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i]["id"].(types.LogID) < records[j]["id"].(types.LogID) })
+
+		gt.A(t, sink.written).Length(1)
+		var loadLog model.LoadLog
+		gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+		gt.A(t, loadLog.Sources).Length(1)
+		return records, *loadLog.Sources[0]
+	}
+
+	expected, _ := run(t, recordPolicy, false)
+	gt.A(t, expected).Length(3)
+
+	t.Run("same output as per-record evaluation", func(t *testing.T) {
+		actual, src := run(t, objectPolicy, true)
+		gt.Equal(t, actual, expected)
+		gt.Equal(t, src.RowCount, 3)
+	})
+
+	t.Run("fall back to per-record evaluation without batch_log", func(t *testing.T) {
+		actual, src := run(t, recordPolicy, true)
+		gt.Equal(t, actual, expected)
+		gt.Equal(t, src.RowCount, 3)
+	})
+}
+
+func TestIngestRecordsNumericField(t *testing.T) {
+	ctx := context.Background()
+	dst := model.BigQueryDest{Dataset: "test_dataset", Table: "test_table"}
+	records := []*model.LogRecord{
+		{
+			ID:         types.LogID(uuid.NewString()),
+			Timestamp:  time.Now(),
+			Data:       map[string]any{"price": "1.5", "name": "blue"},
+			IngestedAt: time.Now(),
+			Fields: map[string]model.FieldSpec{
+				"price": {Type: types.FieldNumeric},
+			},
+		},
+	}
+
+	bqMock := bq.NewGeneralMock()
+	gt.R1(usecase.IngestRecords(ctx, bqMock, dst, records, 1)).NoError(t)
+
+	gt.A(t, bqMock.OpenedStream).Length(1)
+	data := findSchemaField(bqMock.OpenedStream[0].Schema, "data")
+	gt.NotEqual(t, data, nil)
+	gt.Equal(t, findSchemaField(data.Schema, "price").Type, bigquery.NumericFieldType)
+	gt.Equal(t, findSchemaField(data.Schema, "name").Type, bigquery.StringFieldType)
+
+	gt.A(t, bqMock.Streams).Length(1)
+	raw := gt.Cast[*model.LogRecordRaw](t, bqMock.Streams[0].Inserted[0][0])
+	inserted := gt.Cast[map[string]any](t, raw.Data)
+	gt.Equal(t, inserted["price"], any([]byte{0x00, 0x2F, 0x68, 0x59, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+}
+
+func TestLoadGeographyField(t *testing.T) {
+	const schemaPolicy = `package schema.geo
+
+log[{
+	"dataset": "my_dataset",
+	"table": "geo",
+	"id": input.id,
+	"timestamp": 1708130907,
+	"fields": {
+		"location": {"type": "geography"},
+		"areas": {"type": "geography"},
+	},
+	"data": input,
+}]
+`
+
+	load := func(t *testing.T, data string) (*bq.GeneralMock, error) {
+		bqClient := bq.NewGeneralMock()
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(data)), nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		))
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "geo"},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		return bqClient, uc.Load(context.Background(), []*model.LoadRequest{req})
+	}
+
+	t.Run("declared field becomes GEOGRAPHY column", func(t *testing.T) {
+		const data = `{"id":"1","location":"POINT(139.69 35.68)","areas":[{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}],"name":"tokyo"}
+{"id":"2","location":"{\"type\":\"Point\",\"coordinates\":[-122.41,37.77]}","name":"sf"}
+`
+		bqClient := gt.R1(load(t, data)).NoError(t)
+
+		gt.A(t, bqClient.OpenedStream).Length(1)
+		schema := findSchemaField(bqClient.OpenedStream[0].Schema, "data")
+		gt.NotEqual(t, schema, nil)
+		gt.Equal(t, findSchemaField(schema.Schema, "location").Type, bigquery.GeographyFieldType)
+		gt.Equal(t, findSchemaField(schema.Schema, "areas").Type, bigquery.GeographyFieldType)
+		gt.Equal(t, findSchemaField(schema.Schema, "areas").Repeated, true)
+		gt.Equal(t, findSchemaField(schema.Schema, "name").Type, bigquery.StringFieldType)
+
+		// Values are inserted as WKT or GeoJSON string
+		inserted := map[types.LogID]map[string]any{}
+		for _, v := range bqClient.Streams[0].Inserted[0] {
+			r := gt.Cast[*model.LogRecordRaw](t, v)
+			inserted[r.ID] = gt.Cast[map[string]any](t, r.Data)
+		}
+		gt.Equal(t, inserted["1"]["location"], any("POINT(139.69 35.68)"))
+		areas := gt.Cast[[]any](t, inserted["1"]["areas"])
+		gt.A(t, areas).Length(1)
+		var polygon map[string]any
+		gt.NoError(t, json.Unmarshal([]byte(gt.Cast[string](t, areas[0])), &polygon))
+		gt.Equal(t, polygon["type"], any("Polygon"))
+		gt.Equal(t, inserted["2"]["location"], any(`{"type":"Point","coordinates":[-122.41,37.77]}`))
+	})
+
+	t.Run("reject invalid geography", func(t *testing.T) {
+		for _, value := range []string{
+			`"POINT(1 2"`,
+			`"CIRCLE(1 2)"`,
+			`"POINT(a b)"`,
+			`{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]}}`,
+			`{"type":"Polygon","coordinates":[[0,0],[1,1]]}`,
+			`123`,
+		} {
+			bqClient, err := load(t, `{"id":"1","location":`+value+`}`)
+			gt.Error(t, err)
+			gt.A(t, bqClient.Streams).Length(0)
+		}
+	})
+}
+
+func TestLoadFixedSchema(t *testing.T) {
+	const schemaPolicy = `package schema.fixed
+
+log[{
+	"dataset": "my_dataset",
+	"table": "events",
+	"id": input.id,
+	"timestamp": input.ts,
+	"data": input,
+}]
+`
+	fixed := gt.R1(usecase.ReadFixedSchema(strings.NewReader(`[
+	{"name": "id", "type": "STRING", "mode": "REQUIRED"},
+	{"name": "ingest_id", "type": "STRING"},
+	{"name": "timestamp", "type": "TIMESTAMP", "mode": "REQUIRED"},
+	{"name": "ingested_at", "type": "TIMESTAMP"},
+	{"name": "data", "type": "RECORD", "fields": [
+		{"name": "id", "type": "STRING"},
+		{"name": "ts", "type": "INTEGER"},
+		{"name": "user", "type": "RECORD", "fields": [
+			{"name": "name", "type": "STRING"}
+		]}
+	]}
+]`))).NoError(t)
+
+	load := func(t *testing.T, bqClient *bq.GeneralMock, raw string, mode types.FixedSchemaMode) error {
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(raw)), nil
+			},
+		}
+		pClient := gt.R1(policy.New(
+			policy.WithPolicyData("fixed.rego", schemaPolicy),
+		)).NoError(t)
+		uc := usecase.New(infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		), usecase.WithFixedSchema("my_dataset", "events", fixed, mode))
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "fixed"},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		return uc.Load(context.Background(), []*model.LoadRequest{req})
+	}
+
+	insertedData := func(t *testing.T, bqClient *bq.GeneralMock) []map[string]any {
+		var data []map[string]any
+		for _, stream := range bqClient.Streams {
+			for _, inserted := range stream.Inserted {
+				for _, v := range inserted {
+					r := gt.Cast[*model.LogRecordRaw](t, v)
+					data = append(data, gt.Cast[map[string]any](t, r.Data))
+				}
+			}
+		}
+		return data
+	}
+
+	const conforming = `{"id":"a1","ts":1714604400,"user":{"name":"alice"}}
+{"id":"a2","ts":1714611600}
+`
+	const nonConforming = `{"id":"a1","ts":1714604400,"user":{"name":"alice"}}
+{"id":"a2","ts":1714611600,"user":{"name":"bob","age":30},"extra":true}
+`
+
+	t.Run("conforming records are inserted with the fixed schema", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, load(t, bqClient, conforming, types.FixedSchemaStrict))
+
+		gt.A(t, bqClient.CreatedTable).Length(1).At(0, func(t testing.TB, v struct {
+			Dataset types.BQDatasetID
+			Table   types.BQTableID
+			MD      *bigquery.TableMetadata
+		}) {
+			gt.Equal(t, bqs.Equal(v.MD.Schema, fixed), true)
+		})
+		gt.A(t, insertedData(t, bqClient)).Length(2)
+	})
+
+	t.Run("strict mode rejects records with undeclared fields", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		err := load(t, bqClient, nonConforming, types.FixedSchemaStrict)
+		gt.Error(t, err)
+		gt.True(t, errors.Is(err, types.ErrUnexpectedField))
+		gt.Equal(t, usecase.DefaultErrorClassifier(err), types.RetryDecisionDeadLetter)
+
+		gt.A(t, bqClient.CreatedTable).Length(0)
+		gt.A(t, insertedData(t, bqClient)).Length(0)
+	})
+
+	t.Run("loose mode removes undeclared fields", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, load(t, bqClient, nonConforming, types.FixedSchemaLoose))
+
+		data := insertedData(t, bqClient)
+		gt.A(t, data).Length(2)
+		sort.Slice(data, func(i, j int) bool { return fmt.Sprint(data[i]["id"]) < fmt.Sprint(data[j]["id"]) })
+		gt.Equal(t, data[0]["user"], any(map[string]any{"name": "alice"}))
+		gt.Equal(t, data[1]["user"], any(map[string]any{"name": "bob"}))
+		_, ok := data[1]["extra"]
+		gt.False(t, ok)
+	})
+
+	const mismatched = `{"id":"a1","ts":1714604400,"user":{"name":"alice"}}
+{"id":"a2","ts":1714611600,"user":{"name":200}}
+`
+
+	t.Run("strict mode rejects values not matching column types", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		err := load(t, bqClient, mismatched, types.FixedSchemaStrict)
+		gt.Error(t, err)
+		gt.True(t, errors.Is(err, types.ErrFieldTypeMismatch))
+		gt.Equal(t, usecase.DefaultErrorClassifier(err), types.RetryDecisionDeadLetter)
+		var goErr *goerr.Error
+		gt.True(t, errors.As(err, &goErr))
+		gt.Equal(t, goErr.Values()["field"], any("user.name"))
+
+		gt.A(t, insertedData(t, bqClient)).Length(0)
+	})
+
+	t.Run("loose mode converts values to column types", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		gt.NoError(t, load(t, bqClient, mismatched, types.FixedSchemaLoose))
+
+		data := insertedData(t, bqClient)
+		gt.A(t, data).Length(2)
+		sort.Slice(data, func(i, j int) bool { return fmt.Sprint(data[i]["id"]) < fmt.Sprint(data[j]["id"]) })
+		gt.Equal(t, data[1]["user"], any(map[string]any{"name": "200"}))
+	})
+
+	t.Run("loose mode rejects values that can not be converted", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		err := load(t, bqClient, `{"id":"a1","ts":1714604400,"user":"alice"}`, types.FixedSchemaLoose)
+		gt.True(t, errors.Is(err, types.ErrFieldTypeMismatch))
+		gt.A(t, insertedData(t, bqClient)).Length(0)
+	})
+}
+
+func TestLoadRecordLimits(t *testing.T) {
+	const schemaPolicy = `package schema.limit
+
+log[{
+	"dataset": "my_dataset",
+	"table": "limit",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	const normal = `{"user":{"name":"alice","tags":["a","b"]},"msg":"{[{[ not nested ]}]}"}`
+	deep := strings.Repeat(`{"a":`, 10) + `1` + strings.Repeat(`}`, 10)
+	large := `{"msg":"` + strings.Repeat("x", 1024) + `"}`
+
+	testCases := map[string]struct {
+		data        string
+		recordsPath string
+		err         error
+		records     int
+	}{
+		"records within limits": {
+			data:    normal + "\n" + normal,
+			records: 2,
+		},
+		"limits are applied to each record of records_path": {
+			// Whole JSON value is larger than max_record_size
+			data:        `{"Records":[` + strings.Join([]string{normal, normal, normal, normal}, ",") + `]}`,
+			recordsPath: "Records",
+			records:     4,
+		},
+		"over-deep record of records_path": {
+			data:        `{"Records":[` + normal + `,` + deep + `]}`,
+			recordsPath: "Records",
+			err:         types.ErrRecordTooDeep,
+		},
+		"record of records_path slightly deeper than limit": {
+			// Depth of the whole JSON value is within the limit of envelope
+			data:        `{"Records":[` + normal + `,` + strings.Repeat(`{"a":`, 5) + `1` + strings.Repeat(`}`, 5) + `]}`,
+			recordsPath: "Records",
+			err:         types.ErrRecordTooDeep,
+		},
+		"over-large record of records_path": {
+			data:        `{"Records":[` + normal + `,` + large + `]}`,
+			recordsPath: "Records",
+			err:         types.ErrRecordTooLarge,
+		},
+		"over-deep record": {
+			data: normal + "\n" + deep,
+			err:  types.ErrRecordTooDeep,
+		},
+		"over-large record": {
+			data: normal + "\n" + large,
+			err:  types.ErrRecordTooLarge,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(tc.data)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			))
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser:         types.JSONParser,
+					Schema:         "limit",
+					MaxRecordDepth: 4,
+					MaxRecordSize:  256,
+					RecordsPath:    tc.recordsPath,
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.json"},
+				},
+			}
+			err := uc.Load(ctx, []*model.LoadRequest{req})
+			if tc.err != nil {
+				gt.True(t, errors.Is(err, tc.err))
+				// Offending object is dead-lettered because retry does not help
+				gt.Equal(t, usecase.DefaultErrorClassifier(err), types.RetryDecisionDeadLetter)
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+
+			gt.NoError(t, err)
+			gt.A(t, bqClient.Streams).Length(1)
+			gt.A(t, bqClient.Streams[0].Inserted[0]).Length(tc.records)
+		})
+	}
+}
+
+func TestLoadSchemaOverride(t *testing.T) {
+	primaryPolicy := `package schema.primary
+
+log[{
+	"dataset": "my_dataset",
+	"table": "primary",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+	emergencyPolicy := `package schema.emergency
+
+log[{
+	"dataset": "my_dataset",
+	"table": "emergency",
+	"id": r.eventID,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
+	"data": r,
+}] {
+	r := input.Records[_]
+}
+`
+
+	testCases := map[string]struct {
+		options  []usecase.Option
+		metadata map[string]string
+		expect   types.BQTableID
+	}{
+		"redirect to schema of object metadata": {
+			options:  []usecase.Option{usecase.WithSchemaOverride("emergency")},
+			metadata: map[string]string{"swarm-schema": "emergency"},
+			expect:   "emergency",
+		},
+		"ignore schema not allowed": {
+			options:  []usecase.Option{usecase.WithSchemaOverride("other")},
+			metadata: map[string]string{"swarm-schema": "emergency"},
+			expect:   "primary",
+		},
+		"ignore object metadata without option": {
+			metadata: map[string]string{"swarm-schema": "emergency"},
+			expect:   "primary",
+		},
+		"use source schema without object metadata": {
+			options: []usecase.Option{usecase.WithSchemaOverride("emergency")},
+			expect:  "primary",
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			ctx := context.Background()
+			bqClient := bq.NewGeneralMock()
+			csClient := &cs.Mock{
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(
+				policy.WithPolicyData("primary.rego", primaryPolicy),
+				policy.WithPolicyData("emergency.rego", emergencyPolicy),
+			)).NoError(t)
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), tc.options...)
+
+			req := &model.LoadRequest{
+				Source: model.Source{
+					Parser: types.JSONParser,
+					Schema: "primary",
+				},
+				Object: model.Object{
+					CS: &model.CloudStorageObject{
+						Bucket: "test-bucket",
+						Name:   "test.log",
+					},
+					Metadata: tc.metadata,
+				},
+			}
+
+			gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+			gt.A(t, bqClient.OpenedStream).Length(1)
+			gt.Equal(t, bqClient.OpenedStream[0].Table, tc.expect)
+			// Source of the request is not modified
+			gt.Equal(t, req.Source.Schema, "primary")
+		})
+	}
+}
+
+func TestLoadCompressMetadata(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"parser": "json",
+}] {
+	endswith(input.cs.name, ".log")
+}
+`
+	var zstdRaw bytes.Buffer
+	w := gt.R1(zstd.NewWriter(&zstdRaw)).NoError(t)
+	gt.R1(w.Write(cloudTrailExampleRaw)).NoError(t)
+	gt.NoError(t, w.Close())
+
+	testCases := map[string]struct {
+		metadata map[string]string
+		body     []byte
+		isErr    bool
+	}{
+		"metadata selects zstd": {
+			metadata: map[string]string{"content-codec": "zstd"},
+			body:     zstdRaw.Bytes(),
+		},
+		"metadata selects gzip": {
+			metadata: map[string]string{"content-codec": "GZIP"},
+			body:     cloudTrailExampleGzip,
+		},
+		"no metadata falls back to source": {
+			body: cloudTrailExampleRaw,
+		},
+		"unsupported value": {
+			metadata: map[string]string{"content-codec": "brotli"},
+			body:     cloudTrailExampleRaw,
+			isErr:    true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			csClient := &cs.Mock{
+				MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+					return &storage.ObjectAttrs{
+						Bucket:   obj.Bucket.String(),
+						Name:     obj.Name.String(),
+						Size:     int64(len(tc.body)),
+						Metadata: tc.metadata,
+					}, nil
+				},
+				MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tc.body)), nil
+				},
+			}
+			pClient := gt.R1(policy.New(
+				policy.WithPolicyData("event.rego", eventPolicy),
+				policy.WithFile("testdata/policy/schema.rego"),
+			)).NoError(t)
+			bqClient := bq.NewGeneralMock()
+
+			uc := usecase.New(infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			), usecase.WithCompressMetadataKey("content-codec"))
+
+			err := uc.LoadDataByObject(context.Background(), "gs://test-bucket/logs/data.log")
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrUnsupportedCompress))
+				gt.A(t, bqClient.Streams).Length(0)
+				return
+			}
+			gt.NoError(t, err)
+			gt.A(t, bqClient.Streams).Length(1).At(0, func(t testing.TB, v *bq.MockStream) {
+				gt.A(t, v.Inserted).Length(1)
+				gt.A(t, v.Inserted[0]).Length(4)
+			})
+		})
+	}
+}
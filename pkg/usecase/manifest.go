@@ -0,0 +1,78 @@
+package usecase
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// expandManifests replaces requests of manifest source with requests of data objects listed in the manifest. The data objects are loaded with the same source except Manifest flag, then a manifest in a manifest is not expanded. Other requests are kept as they are.
+func (x *UseCase) expandManifests(ctx context.Context, requests []*model.LoadRequest) ([]*model.LoadRequest, error) {
+	expanded := make([]*model.LoadRequest, 0, len(requests))
+	for _, req := range requests {
+		if !req.Source.Manifest {
+			expanded = append(expanded, req)
+			continue
+		}
+		if req.Object.CS == nil {
+			return nil, goerr.Wrap(types.ErrInvalidRequest, "manifest must be a Cloud Storage object").With("req", req)
+		}
+
+		objects, err := x.readManifest(ctx, *req.Object.CS)
+		if err != nil {
+			return nil, err
+		}
+		utils.CtxLogger(ctx).Info("manifest expanded", "manifest", req.Object.CS, "count", len(objects))
+
+		src := req.Source
+		src.Manifest = false
+		for _, obj := range objects {
+			expanded = append(expanded, &model.LoadRequest{
+				Source:   src,
+				Object:   model.Object{CS: obj},
+				Manifest: req.Object.CS,
+			})
+		}
+	}
+
+	return expanded, nil
+}
+
+// readManifest returns data objects listed in the manifest object. Empty lines and lines starting with "#" are ignored. A line without "gs://" prefix is an object name in the bucket of the manifest.
+func (x *UseCase) readManifest(ctx context.Context, manifest model.CloudStorageObject) ([]*model.CloudStorageObject, error) {
+	r, err := x.clients.CloudStorage().Open(ctx, manifest)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to open manifest").With("manifest", manifest)
+	}
+	defer r.Close()
+
+	var objects []*model.CloudStorageObject
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "gs://") {
+			objects = append(objects, &model.CloudStorageObject{Bucket: manifest.Bucket, Name: types.CSObjectID(line)})
+			continue
+		}
+
+		bucket, name, err := types.CSUrl(line).Parse()
+		if err != nil {
+			return nil, goerr.Wrap(err, "invalid object URL in manifest").With("manifest", manifest).With("line", line)
+		}
+		objects = append(objects, &model.CloudStorageObject{Bucket: bucket, Name: name})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, goerr.Wrap(err, "failed to read manifest").With("manifest", manifest)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,125 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestLoadManifest(t *testing.T) {
+	ctx := context.Background()
+	manifest := "# exported logs\n1.log\n\ngs://other-bucket/2.log\n"
+
+	var mutex sync.Mutex
+	var opened []string
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			opened = append(opened, obj.Bucket.String()+"/"+obj.Name.String())
+
+			if obj.Name == "manifest.txt" {
+				return io.NopCloser(bytes.NewReader([]byte(manifest))), nil
+			}
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+	bqClient := bq.NewGeneralMock()
+	sink := &fakeLoadLogSink{}
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bqClient),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+		usecase.WithLoadLogSink(sink),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser:   types.JSONParser,
+			Schema:   "cloudtrail",
+			Compress: types.NoCompress,
+			Manifest: true,
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "manifest.txt",
+			},
+		},
+	}
+	gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+
+	gt.A(t, opened).Length(3)
+	gt.Equal(t, opened[0], "test-bucket/manifest.txt")
+
+	// Both objects are loaded as one batch
+	gt.A(t, sink.written).Length(1)
+	var loadLog model.LoadLog
+	gt.NoError(t, json.Unmarshal(sink.written[0], &loadLog))
+	gt.True(t, loadLog.Success)
+
+	var objects []string
+	for _, src := range loadLog.Sources {
+		gt.Equal(t, src.Manifest, &model.CloudStorageObject{Bucket: "test-bucket", Name: "manifest.txt"})
+		gt.False(t, src.Source.Manifest)
+		objects = append(objects, src.CS.Bucket.String()+"/"+src.CS.Name.String())
+	}
+	gt.A(t, objects).Length(2)
+	gt.Equal(t, objects[0], "test-bucket/1.log")
+	gt.Equal(t, objects[1], "other-bucket/2.log")
+
+	var inserted int
+	for _, s := range bqClient.Streams {
+		for _, rows := range s.Inserted {
+			inserted += len(rows)
+		}
+	}
+	gt.Equal(t, inserted, 8)
+}
+
+func TestLoadManifestInvalidURL(t *testing.T) {
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte("gs://\n"))), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+
+	uc := usecase.New(
+		infra.New(
+			infra.WithBigQuery(bq.NewGeneralMock()),
+			infra.WithCloudStorage(csClient),
+			infra.WithPolicy(pClient),
+		),
+	)
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser:   types.JSONParser,
+			Schema:   "cloudtrail",
+			Compress: types.NoCompress,
+			Manifest: true,
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "manifest.txt"},
+		},
+	}
+	gt.Error(t, uc.Load(ctx, []*model.LoadRequest{req}))
+}
@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+)
+
+// progressMetrics is a set of metrics to watch progress of long running process, such as enqueue. All metrics are no-op if metrics is not configured, and metrics of ingestion are no-op if ingest is false.
+type progressMetrics struct {
+	objectsFound     *progressCounter
+	objectsProcessed *progressCounter
+	rowsIngested     *progressCounter
+	errors           *progressCounter
+
+	rowsOutsideDedupWindow *progressCounter
+
+	// tableIngests counts ingestions of each destination table labeled by "table" (dataset.table) and "result" (success or failure)
+	tableIngests *progressCounter
+}
+
+func newProgressMetrics(m interfaces.Metrics, ingest bool) *progressMetrics {
+	if m == nil {
+		return &progressMetrics{}
+	}
+
+	pm := &progressMetrics{
+		objectsFound:     newProgressCounter(m.Counter("swarm_objects_found_total", "Number of objects found to be processed")),
+		objectsProcessed: newProgressCounter(m.Counter("swarm_objects_processed_total", "Number of processed objects")),
+		errors:           newProgressCounter(m.Counter("swarm_errors_total", "Number of errors")),
+	}
+
+	startedAt := time.Now()
+	m.GaugeFunc("swarm_objects_remaining", "Number of objects found but not processed yet", func() float64 {
+		return float64(pm.objectsFound.Value() - pm.objectsProcessed.Value())
+	})
+	m.GaugeFunc("swarm_objects_processed_per_second", "Average rate of processed objects since started", func() float64 {
+		return float64(pm.objectsProcessed.Value()) / time.Since(startedAt).Seconds()
+	})
+
+	if ingest {
+		pm.rowsIngested = newProgressCounter(m.Counter("swarm_rows_ingested_total", "Number of rows ingested into BigQuery"))
+		pm.rowsOutsideDedupWindow = newProgressCounter(m.Counter("swarm_rows_outside_dedup_window_total", "Number of rows whose timestamp is older than dedup window of insert ID"))
+		pm.tableIngests = newProgressCounter(m.Counter("swarm_table_ingests_total", "Number of ingestions into each destination table by result", "table", "result"))
+		m.GaugeFunc("swarm_rows_ingested_per_second", "Average rate of ingested rows since started", func() float64 {
+			return float64(pm.rowsIngested.Value()) / time.Since(startedAt).Seconds()
+		})
+	}
+
+	return pm
+}
+
+// countTableIngest increments tableIngests of dst by the result of ingestion.
+func (x *progressMetrics) countTableIngest(dst model.BigQueryDest, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	x.tableIngests.Add(1, dst.Dataset.String()+"."+dst.Table.String(), result)
+}
+
+// progressCounter emits a counter and keeps its total over all label values locally, because some emitters such as OpenTelemetry can not read back the value for gauges. nil is no-op counter.
+type progressCounter struct {
+	counter interfaces.MetricCounter
+	total   atomic.Int64
+}
+
+func newProgressCounter(counter interfaces.MetricCounter) *progressCounter {
+	return &progressCounter{counter: counter}
+}
+
+func (x *progressCounter) Add(n int64, values ...string) {
+	if x == nil || n < 0 {
+		return
+	}
+	x.total.Add(n)
+	x.counter.Add(n, values...)
+}
+
+func (x *progressCounter) Inc(values ...string) { x.Add(1, values...) }
+
+func (x *progressCounter) Value() int64 {
+	if x == nil {
+		return 0
+	}
+	return x.total.Load()
+}
@@ -16,6 +16,7 @@ type Mock struct {
 	MockGetOrCreateState func(ctx context.Context, msgType types.MsgType, id string) (*model.State, bool, error)
 	MockUpdateState      func(ctx context.Context, msgType types.MsgType, id string, state types.MsgState) error
 	MockWaitState        func(ctx context.Context, msgType types.MsgType, id string, expiresAt time.Time) error
+	MockClassifyError    func(err error) types.RetryDecision
 }
 
 func (x *Mock) Load(ctx context.Context, req []*model.LoadRequest) error {
@@ -63,3 +64,10 @@ func (x Mock) WaitState(ctx context.Context, msgType types.MsgType, id string, e
 	}
 	return x.MockWaitState(ctx, msgType, id, expiresAt)
 }
+
+func (x Mock) ClassifyError(err error) types.RetryDecision {
+	if x.MockClassifyError == nil {
+		return DefaultErrorClassifier(err)
+	}
+	return x.MockClassifyError(err)
+}
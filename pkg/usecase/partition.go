@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// MaxPartitions is the maximum number of partitions per table in BigQuery.
+const MaxPartitions = 4000
+
+// EstimatePartitions computes number of time partitions that records between start and end (both inclusive) are ingested into with pt. If the number exceeds MaxPartitions, it returns the estimate with ErrTooManyPartitions, and Suggested of the estimate has a coarser granularity within the limit.
+func EstimatePartitions(start, end time.Time, pt types.BQPartition) (*model.PartitionEstimate, error) {
+	if pt.Type() == "" {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "partition type must be hour, day, month or year").With("partition", pt)
+	}
+	if end.Before(start) {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "end must not be before start").With("start", start).With("end", end)
+	}
+
+	estimate := &model.PartitionEstimate{
+		Partition: pt,
+		Count:     countPartitions(start, end, pt),
+	}
+	if estimate.Count <= MaxPartitions {
+		return estimate, nil
+	}
+
+	coarser := []types.BQPartition{
+		types.BQPartitionHour,
+		types.BQPartitionDay,
+		types.BQPartitionMonth,
+		types.BQPartitionYear,
+	}
+	for i, p := range coarser {
+		if p != pt {
+			continue
+		}
+		for _, candidate := range coarser[i+1:] {
+			if countPartitions(start, end, candidate) <= MaxPartitions {
+				estimate.Suggested = candidate
+				break
+			}
+		}
+		break
+	}
+
+	return estimate, goerr.Wrap(types.ErrTooManyPartitions, "number of partitions exceeds the limit").
+		With("count", estimate.Count).
+		With("limit", MaxPartitions).
+		With("suggested", estimate.Suggested)
+}
+
+func countPartitions(start, end time.Time, pt types.BQPartition) int {
+	s, e := truncateTime(start, pt), truncateTime(end, pt)
+
+	switch pt {
+	case types.BQPartitionHour:
+		return int(e.Sub(s)/time.Hour) + 1
+	case types.BQPartitionDay:
+		return int(e.Sub(s)/(24*time.Hour)) + 1
+	case types.BQPartitionMonth:
+		return (e.Year()-s.Year())*12 + int(e.Month()-s.Month()) + 1
+	case types.BQPartitionYear:
+		return e.Year() - s.Year() + 1
+	default:
+		return 1
+	}
+}
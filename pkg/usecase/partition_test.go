@@ -0,0 +1,68 @@
+package usecase_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestEstimatePartitions(t *testing.T) {
+	testCases := map[string]struct {
+		start, end time.Time
+		partition  types.BQPartition
+		count      int
+		suggested  types.BQPartition
+		isErr      bool
+	}{
+		"multi-year daily range exceeds limit": {
+			start:     time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:       time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			partition: types.BQPartitionDay,
+			count:     5479,
+			suggested: types.BQPartitionMonth,
+			isErr:     true,
+		},
+		"multi-year daily range within limit": {
+			start:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:       time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC),
+			partition: types.BQPartitionDay,
+			count:     1827,
+		},
+		"hourly range suggests day": {
+			start:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:       time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC),
+			partition: types.BQPartitionHour,
+			count:     8784,
+			suggested: types.BQPartitionDay,
+			isErr:     true,
+		},
+		"monthly range across years": {
+			start:     time.Date(2023, 11, 15, 0, 0, 0, 0, time.UTC),
+			end:       time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			partition: types.BQPartitionMonth,
+			count:     4,
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			estimate, err := usecase.EstimatePartitions(tc.start, tc.end, tc.partition)
+			if tc.isErr {
+				gt.True(t, errors.Is(err, types.ErrTooManyPartitions))
+			} else {
+				gt.NoError(t, err)
+			}
+			gt.V(t, estimate.Count).Equal(tc.count)
+			gt.V(t, estimate.Suggested).Equal(tc.suggested)
+		})
+	}
+
+	t.Run("invalid partition type", func(t *testing.T) {
+		_, err := usecase.EstimatePartitions(time.Now(), time.Now(), types.BQPartitionNone)
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+}
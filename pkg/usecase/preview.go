@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// PreviewRecords imports the object of req and returns the first n records per destination without ingesting them into BigQuery. Records are ordered by dataset and table of destination.
+func (x *UseCase) PreviewRecords(ctx context.Context, req *model.LoadRequest, n int) ([]*model.LogRecord, error) {
+	if n <= 0 {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "number of preview records must be positive").With("n", n)
+	}
+
+	recordSet, _, _, mErr := x.importLogRecords(ctx, []*model.LoadRequest{req})
+	if mErr != nil {
+		return nil, goerr.Wrap(mErr, "failed to import records for preview").With("req", req)
+	}
+
+	var results []*model.LogRecord
+	for _, dst := range recordSet.Dests() {
+		records := recordSet[dst]
+		if len(records) > n {
+			records = records[:n]
+		}
+		results = append(results, records...)
+	}
+
+	return results, nil
+}
+
+// EvalSchemaPolicy evaluates schema policy of schema with a JSON record read from r, and returns the output as it is. It requires only policy client, no Cloud Storage or BigQuery, for iterating on a policy with a sample record. Numbers in the record are decoded as json.Number as in ingestion. r must have exactly one JSON value.
+func (x *UseCase) EvalSchemaPolicy(ctx context.Context, schema types.ObjectSchema, r io.Reader) (*model.SchemaPolicyOutput, error) {
+	if x.clients.Policy() == nil {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "policy client is required to evaluate schema policy")
+	}
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var record any
+	if err := decoder.Decode(&record); err != nil {
+		return nil, goerr.Wrap(err, "failed to decode input record")
+	}
+	if decoder.More() {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "input must have exactly one JSON record")
+	}
+
+	var output model.SchemaPolicyOutput
+	if err := x.clients.Policy().Query(ctx, schema.Query(), record, &output); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+// RecordsFromReader parses data read from r by source, evaluates schema policy of the source, and returns log records per destination. It runs the same pipeline as loading an object except download, and requires only policy client, e.g. to embed the transformation in other tools. Options of the source depending on the object, such as path_fields and shard_by "object", have no effect. r is not closed.
+func (x *UseCase) RecordsFromReader(ctx context.Context, source model.Source, r io.Reader) (model.LogRecordSet, error) {
+	if x.clients.Policy() == nil {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "policy client is required to build records")
+	}
+	if err := source.Validate(); err != nil {
+		return nil, err
+	}
+
+	req := &model.LoadRequest{Source: source}
+	rows, err := parseObject(io.NopCloser(r), req, x.maxObjectSize)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &importSourceResponse{
+		dstMap: model.LogRecordSet{},
+		log:    &model.SourceLog{Source: source},
+	}
+	if _, err := x.importRows(ctx, req, rows, result); err != nil {
+		return nil, err
+	}
+
+	return result.dstMap, nil
+}
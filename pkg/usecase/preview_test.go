@@ -0,0 +1,155 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestPreviewRecords(t *testing.T) {
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+	bqClient := bq.NewGeneralMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser: types.JSONParser,
+			Schema: "cloudtrail",
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "test.log",
+			},
+		},
+	}
+
+	t.Run("return first n records", func(t *testing.T) {
+		records := gt.R1(uc.PreviewRecords(ctx, req, 2)).NoError(t)
+		gt.A(t, records).Length(2)
+		for _, record := range records {
+			data := gt.Cast[map[string]any](t, record.Data)
+			gt.Equal(t, data["eventSource"], "s3.amazonaws.com")
+			gt.NotEqual(t, record.ID, "")
+		}
+	})
+
+	t.Run("return all records if n is larger than records", func(t *testing.T) {
+		records := gt.R1(uc.PreviewRecords(ctx, req, 10)).NoError(t)
+		gt.A(t, records).Length(4)
+	})
+
+	t.Run("invalid number of records", func(t *testing.T) {
+		_, err := uc.PreviewRecords(ctx, req, 0)
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+
+	// Preview must not touch BigQuery
+	gt.A(t, bqClient.CreatedTable).Length(0)
+	gt.A(t, bqClient.Streams).Length(0)
+}
+
+func TestEvalSchemaPolicy(t *testing.T) {
+	ctx := context.Background()
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+	uc := usecase.New(infra.New(infra.WithPolicy(pClient)))
+
+	t.Run("evaluate schema policy with a record", func(t *testing.T) {
+		input := `{"Records":[{"eventID":"e1","eventTime":"2024-02-17T00:48:27Z","bytes":12345678901234567890}]}`
+		output := gt.R1(uc.EvalSchemaPolicy(ctx, "cloudtrail", strings.NewReader(input))).NoError(t)
+
+		gt.A(t, output.Logs).Length(1).At(0, func(t testing.TB, log *model.Log) {
+			gt.Equal(t, log.Dataset, "my_dataset")
+			gt.Equal(t, log.Table, "cloudtrail")
+			gt.Equal(t, log.ID, "e1")
+			gt.Equal(t, log.Timestamp, float64(1708130907))
+		})
+
+		// Output is printed as JSON, and numbers keep original digits
+		raw := gt.R1(json.Marshal(output)).NoError(t)
+		gt.String(t, string(raw)).Contains(`"bytes":12345678901234567890`)
+	})
+
+	t.Run("fail with invalid JSON", func(t *testing.T) {
+		_, err := uc.EvalSchemaPolicy(ctx, "cloudtrail", strings.NewReader(`{"Records":`))
+		gt.Error(t, err)
+	})
+
+	t.Run("fail with multiple records", func(t *testing.T) {
+		_, err := uc.EvalSchemaPolicy(ctx, "cloudtrail", strings.NewReader(`{"Records":[]} {"Records":[]}`))
+		gt.Error(t, err)
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+
+	t.Run("fail without policy client", func(t *testing.T) {
+		_, err := usecase.New(infra.New()).EvalSchemaPolicy(ctx, "cloudtrail", strings.NewReader(`{}`))
+		gt.Error(t, err)
+	})
+}
+
+func TestRecordsFromReader(t *testing.T) {
+	ctx := context.Background()
+	pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+	uc := usecase.New(infra.New(infra.WithPolicy(pClient)))
+	source := model.Source{
+		Parser: types.JSONParser,
+		Schema: "cloudtrail",
+	}
+
+	t.Run("build records from reader", func(t *testing.T) {
+		recordSet := gt.R1(uc.RecordsFromReader(ctx, source, bytes.NewReader(cloudTrailExampleRaw))).NoError(t)
+
+		gt.A(t, recordSet.Dests()).Length(1).At(0, func(t testing.TB, v model.BigQueryDest) {
+			gt.Equal(t, v.Dataset, "my_dataset")
+			gt.Equal(t, v.Table, "cloudtrail")
+		})
+		records := recordSet[recordSet.Dests()[0]]
+		gt.A(t, records).Length(4)
+		for _, record := range records {
+			data := gt.Cast[map[string]any](t, record.Data)
+			gt.Equal(t, data["eventSource"], "s3.amazonaws.com")
+			gt.Equal(t, record.ID, types.LogID(data["eventID"].(string)))
+		}
+	})
+
+	t.Run("decompress by source", func(t *testing.T) {
+		gzipSource := source
+		gzipSource.Compress = types.GZIPComp
+		recordSet := gt.R1(uc.RecordsFromReader(ctx, gzipSource, bytes.NewReader(cloudTrailExampleGzip))).NoError(t)
+		gt.A(t, recordSet[recordSet.Dests()[0]]).Length(4)
+	})
+
+	t.Run("fail with invalid source", func(t *testing.T) {
+		_, err := uc.RecordsFromReader(ctx, model.Source{Parser: types.JSONParser}, bytes.NewReader(cloudTrailExampleRaw))
+		gt.True(t, errors.Is(err, types.ErrInvalidPolicyResult))
+	})
+
+	t.Run("fail without policy client", func(t *testing.T) {
+		_, err := usecase.New(infra.New()).RecordsFromReader(ctx, source, bytes.NewReader(cloudTrailExampleRaw))
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+}
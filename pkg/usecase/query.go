@@ -0,0 +1,53 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"google.golang.org/api/iterator"
+)
+
+// QueryURLColumn is a column name of object URL in query result for LoadObjectsByQuery.
+const QueryURLColumn = "url"
+
+// LoadObjectsByQuery runs query in BigQuery and loads objects of which URLs (e.g. "gs://my-bucket/path/to/object.log") are returned in QueryURLColumn. Objects are loaded by LoadDataByObjects with concurrency.
+func (x *UseCase) LoadObjectsByQuery(ctx context.Context, query string, concurrency int) error {
+	urls, err := x.queryObjectURLs(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	utils.CtxLogger(ctx).Info("object URLs queried", "count", len(urls))
+	return x.LoadDataByObjects(ctx, urls, concurrency)
+}
+
+// queryObjectURLs runs query with params in BigQuery and returns object URLs in QueryURLColumn of the result.
+func (x *UseCase) queryObjectURLs(ctx context.Context, query string, params ...bigquery.QueryParameter) ([]types.CSUrl, error) {
+	it, err := x.clients.BigQuery().Query(ctx, query, params...)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to query object URLs").With("query", query)
+	}
+
+	var urls []types.CSUrl
+	for {
+		var row map[string]bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			return nil, goerr.Wrap(err, "failed to read query result").With("query", query)
+		}
+
+		url, ok := row[QueryURLColumn].(string)
+		if !ok || url == "" {
+			return nil, goerr.Wrap(types.ErrInvalidOption, "query result must have url column as string").With("row", row)
+		}
+		urls = append(urls, types.CSUrl(url))
+	}
+
+	return urls, nil
+}
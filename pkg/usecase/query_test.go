@@ -0,0 +1,101 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestLoadObjectsByQuery(t *testing.T) {
+	const query = "SELECT url FROM `my-project.swarm.pending_objects`"
+
+	newUseCase := func(bqClient *bq.GeneralMock, opened *[]types.CSObjectID) *usecase.UseCase {
+		var mutex sync.Mutex
+		csClient := &cs.Mock{
+			MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+				return &storage.ObjectAttrs{Bucket: obj.Bucket.String(), Name: obj.Name.String(), Size: 1024}, nil
+			},
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				*opened = append(*opened, obj.Name)
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+		}
+		return newTestUseCase(t, testClients{
+			BigQuery:     bqClient,
+			CloudStorage: csClient,
+			Policy: []policy.Option{
+				policy.WithFile("testdata/policy/schema.rego"),
+				policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+			},
+		})
+	}
+
+	t.Run("load objects of URLs in query result", func(t *testing.T) {
+		var opened []types.CSObjectID
+		bqClient := bq.NewGeneralMock()
+		bqClient.QueryResult = &bq.MockIterator{
+			Rows: []map[string]bigquery.Value{
+				{"url": "gs://cloudtrail-logs/1.log"},
+				{"url": "gs://cloudtrail-logs/2.log"},
+				{"url": "gs://cloudtrail-logs/3.log"},
+			},
+		}
+		uc := newUseCase(bqClient, &opened)
+
+		gt.NoError(t, uc.LoadObjectsByQuery(context.Background(), query, 2))
+		gt.A(t, bqClient.Queries).Length(1).At(0, func(t testing.TB, v string) {
+			gt.Equal(t, v, query)
+		})
+
+		sort.Slice(opened, func(i, j int) bool { return opened[i] < opened[j] })
+		gt.Equal(t, opened, []types.CSObjectID{"1.log", "2.log", "3.log"})
+		gt.A(t, bqClient.Streams).Length(3)
+	})
+
+	t.Run("fail if url column is missing", func(t *testing.T) {
+		var opened []types.CSObjectID
+		bqClient := bq.NewGeneralMock()
+		bqClient.QueryResult = &bq.MockIterator{
+			Rows: []map[string]bigquery.Value{
+				{"name": "gs://cloudtrail-logs/1.log"},
+			},
+		}
+		uc := newUseCase(bqClient, &opened)
+
+		err := uc.LoadObjectsByQuery(context.Background(), query, 2)
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+		gt.A(t, opened).Length(0)
+	})
+
+	t.Run("fail if reading query result fails", func(t *testing.T) {
+		var opened []types.CSObjectID
+		bqClient := bq.NewGeneralMock()
+		bqClient.QueryResult = &bq.MockIterator{
+			MockNext: func(dst interface{}) error {
+				return errors.New("quota exceeded")
+			},
+		}
+		uc := newUseCase(bqClient, &opened)
+
+		err := uc.LoadObjectsByQuery(context.Background(), query, 2)
+		gt.Error(t, err)
+		gt.String(t, err.Error()).Contains("quota exceeded")
+		gt.A(t, opened).Length(0)
+	})
+}
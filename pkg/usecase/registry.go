@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/bqs"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// schemaRegistry inserts schema of a table into the registry table of BigQuery as a new version.
+type schemaRegistry struct {
+	dataset types.BQDatasetID
+	table   types.BQTableID
+}
+
+// Write inserts schema of dst table with ID of the load in ctx. The registry table is created or updated before insertion. It does nothing if x is nil (registry is not configured).
+func (x *schemaRegistry) Write(ctx context.Context, bq interfaces.BigQuery, dst model.BigQueryDest, schema bigquery.Schema) error {
+	if x == nil {
+		return nil
+	}
+
+	jsonSchema, err := schemaToJSON(schema)
+	if err != nil {
+		return err
+	}
+
+	registrySchema, err := bqs.Infer(&model.SchemaRegistryLog{})
+	if err != nil {
+		return goerr.Wrap(err, "failed to infer schema of registry table")
+	}
+	finalized, err := createOrUpdateTable(ctx, bq, x.dataset, x.table, &bigquery.TableMetadata{Schema: registrySchema})
+	if err != nil {
+		return goerr.Wrap(err, "failed to create schema registry table").With("dataset", x.dataset).With("table", x.table)
+	}
+
+	stream, err := bq.NewStream(ctx, x.dataset, x.table, finalized)
+	if err != nil {
+		return err
+	}
+	defer utils.SafeClose(stream)
+
+	reqID, _ := utils.CtxRequestID(ctx)
+	row := &model.SchemaRegistryLog{
+		Dataset:    dst.Dataset,
+		Table:      dst.Table,
+		SchemaJSON: jsonSchema,
+		ChangedAt:  time.Now(),
+		LoadID:     reqID,
+	}
+	if _, err := stream.Insert(ctx, []any{row.Raw()}, model.InsertOptions{}); err != nil {
+		return goerr.Wrap(err, "failed to insert schema into registry table").With("dataset", x.dataset).With("table", x.table)
+	}
+
+	utils.CtxLogger(ctx).Info("schema registry written", "dst", dst, "registry", x.table)
+	return nil
+}
+
+// recordSchemaChange writes changed schema of dst table into schema sidecar and schema registry if they are configured. Failure is only reported because it should not stop ingestion.
+func (x *UseCase) recordSchemaChange(ctx context.Context, dst model.BigQueryDest, schema bigquery.Schema) {
+	x.writeSchemaSidecar(ctx, dst, schema)
+
+	if err := x.schemaRegistry.Write(ctx, x.clients.BigQuery(), dst, schema); err != nil {
+		utils.HandleError(ctx, "failed to write schema registry", err)
+	}
+}
@@ -0,0 +1,123 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestLoadSchemaRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	load := func(t *testing.T, bqClient *bq.GeneralMock) {
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+
+		uc := usecase.New(
+			infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			),
+			usecase.WithSchemaRegistry("swarm_meta", "schema_registry"),
+		)
+
+		req := &model.LoadRequest{
+			Source: model.Source{
+				Parser: types.JSONParser,
+				Schema: "cloudtrail",
+			},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{
+					Bucket: "test-bucket",
+					Name:   "test.log",
+				},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+	}
+
+	// registryRows returns rows inserted into the registry table
+	registryRows := func(t *testing.T, bqClient *bq.GeneralMock) []*model.SchemaRegistryLogRaw {
+		var rows []*model.SchemaRegistryLogRaw
+		for i, s := range bqClient.OpenedStream {
+			if s.Dataset != "swarm_meta" || s.Table != "schema_registry" {
+				continue
+			}
+			for _, data := range bqClient.Streams[i].Inserted {
+				for _, v := range data {
+					rows = append(rows, gt.Cast[*model.SchemaRegistryLogRaw](t, v))
+				}
+			}
+		}
+		return rows
+	}
+
+	created := bq.NewGeneralMock()
+	load(t, created)
+	gt.A(t, registryRows(t, created)).Length(1)
+	schema := created.CreatedTable[0].MD.Schema
+
+	t.Run("registry row is written on schema-growing ingest", func(t *testing.T) {
+		// Existing table does not have "data" column yet
+		var old bigquery.Schema
+		for _, field := range schema {
+			if field.Name != "data" {
+				old = append(old, field)
+			}
+		}
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: old}}
+		load(t, bqClient)
+
+		gt.A(t, bqClient.UpdatedTable).Length(1)
+		// Registry table is created because it does not exist
+		gt.A(t, bqClient.CreatedTable).Length(1).At(0, func(t testing.TB, v struct {
+			Dataset types.BQDatasetID
+			Table   types.BQTableID
+			MD      *bigquery.TableMetadata
+		}) {
+			gt.Equal(t, v.Dataset, "swarm_meta")
+			gt.Equal(t, v.Table, "schema_registry")
+		})
+
+		gt.A(t, registryRows(t, bqClient)).Length(1).At(0, func(t testing.TB, v *model.SchemaRegistryLogRaw) {
+			gt.Equal(t, v.Dataset, "my_dataset")
+			gt.Equal(t, v.Table, "cloudtrail")
+			gt.NotEqual(t, v.LoadID, "")
+			gt.NotEqual(t, v.ChangedAt, 0)
+
+			changed := gt.R1(bigquery.SchemaFromJSON([]byte(v.SchemaJSON))).NoError(t)
+			names := map[string]bool{}
+			for _, field := range changed {
+				names[field.Name] = true
+			}
+			gt.Equal(t, names["data"], true)
+			gt.Equal(t, names["id"], true)
+		})
+	})
+
+	t.Run("registry row is not written if schema is not changed", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: schema}}
+		load(t, bqClient)
+
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+		gt.A(t, registryRows(t, bqClient)).Length(0)
+	})
+}
@@ -0,0 +1,346 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+	"google.golang.org/api/iterator"
+)
+
+// ListFailedObjectsByTable returns URLs of objects of failed loads recorded in the load log table (metadata table) that match the filter. An object is returned only once even if it failed multiple times, and an object loaded successfully after the failure (e.g. already replayed) is not returned. Missing objects are not included because they can not be replayed.
+func (x *UseCase) ListFailedObjectsByTable(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, filter model.ReplayFilter) ([]types.CSUrl, error) {
+	query, params := failedObjectsQuery(dataset, table, filter)
+	utils.CtxLogger(ctx).Debug("query failed objects", "query", query, "params", params)
+
+	return x.queryObjectURLs(ctx, query, params...)
+}
+
+func failedObjectsQuery(dataset types.BQDatasetID, table types.BQTableID, filter model.ReplayFilter) (string, []bigquery.QueryParameter) {
+	conds := []string{
+		"NOT f.success",
+		"NOT f.missing",
+		// Exclude failure of which object was loaded successfully later
+		"NOT EXISTS (SELECT 1 FROM sources AS s WHERE s.success AND s.bucket = f.bucket AND s.name = f.name AND s.started_at > f.started_at)",
+	}
+	var params []bigquery.QueryParameter
+	if !filter.Start.IsZero() {
+		conds = append(conds, "f.started_at >= @start")
+		params = append(params, bigquery.QueryParameter{Name: "start", Value: filter.Start.UTC()})
+	}
+	if !filter.End.IsZero() {
+		conds = append(conds, "f.started_at < @end")
+		params = append(params, bigquery.QueryParameter{Name: "end", Value: filter.End.UTC()})
+	}
+	if filter.Error != "" {
+		conds = append(conds, "(STRPOS(f.load_error, @error) > 0 OR STRPOS(f.src_error, @error) > 0)")
+		params = append(params, bigquery.QueryParameter{Name: "error", Value: filter.Error})
+	}
+
+	query := fmt.Sprintf("WITH sources AS ("+
+		"SELECT log.started_at, (log.success AND src.success) AS success, IFNULL(src.missing, FALSE) AS missing, src.cs.bucket AS bucket, src.cs.name AS name, IFNULL(log.error, '') AS load_error, IFNULL(src.error, '') AS src_error "+
+		"FROM `%s.%s` AS log, UNNEST(log.sources) AS src WHERE src.cs IS NOT NULL) "+
+		"SELECT DISTINCT CONCAT('gs://', f.bucket, '/', f.name) AS %s FROM sources AS f WHERE %s",
+		dataset, table, QueryURLColumn, strings.Join(conds, " AND "))
+	return query, params
+}
+
+// ListFailedObjectsByCloudStorage returns URLs of objects of failed loads that match the filter from LoadLog JSON objects written under the prefix of the bucket by metadata sink. An object is returned only once even if it failed multiple times, and an object loaded successfully after the failure (e.g. already replayed) is not returned. Missing objects are not included because they can not be replayed.
+func (x *UseCase) ListFailedObjectsByCloudStorage(ctx context.Context, bucket types.CSBucket, prefix string, filter model.ReplayFilter) ([]types.CSUrl, error) {
+	type failure struct {
+		url       types.CSUrl
+		startedAt time.Time
+	}
+	var failures []failure
+	lastLoaded := map[types.CSUrl]time.Time{}
+
+	// All load logs are read before deciding failed objects because order of the logs is not guaranteed
+	it := x.clients.CloudStorage().List(ctx, bucket, &storage.Query{Prefix: prefix})
+	err := x.iterateObjects(it, func(attrs *storage.ObjectAttrs) error {
+		if !strings.HasSuffix(attrs.Name, ".json") {
+			return nil
+		}
+
+		log, err := x.readLoadLog(ctx, model.CloudStorageObject{Bucket: bucket, Name: types.CSObjectID(attrs.Name)})
+		if err != nil {
+			return err
+		}
+
+		for _, url := range failedObjects(log, filter) {
+			failures = append(failures, failure{url: url, startedAt: log.StartedAt})
+		}
+		for _, url := range loadedObjects(log) {
+			if log.StartedAt.After(lastLoaded[url]) {
+				lastLoaded[url] = log.StartedAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []types.CSUrl
+	seen := map[types.CSUrl]struct{}{}
+	for _, f := range failures {
+		if _, ok := seen[f.url]; ok {
+			continue
+		}
+		if lastLoaded[f.url].After(f.startedAt) {
+			continue
+		}
+		seen[f.url] = struct{}{}
+		urls = append(urls, f.url)
+	}
+
+	return urls, nil
+}
+
+func (x *UseCase) readLoadLog(ctx context.Context, obj model.CloudStorageObject) (*model.LoadLog, error) {
+	r, err := x.clients.CloudStorage().Open(ctx, obj)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to open load log").With("obj", obj)
+	}
+	defer r.Close()
+
+	var log model.LoadLog
+	if err := json.NewDecoder(r).Decode(&log); err != nil {
+		return nil, goerr.Wrap(err, "failed to decode load log").With("obj", obj)
+	}
+	return &log, nil
+}
+
+// failedObjects returns URLs of objects in the LoadLog that failed and match the filter. If the whole load failed, all objects of the load are returned because they are not ingested.
+func failedObjects(log *model.LoadLog, filter model.ReplayFilter) []types.CSUrl {
+	var urls []types.CSUrl
+	for _, src := range log.Sources {
+		if src.CS == nil || src.Missing || (log.Success && src.Success) {
+			continue
+		}
+		if !filter.Match(log.StartedAt, log.Error, src.Error) {
+			continue
+		}
+		urls = append(urls, types.CSUrl(fmt.Sprintf("gs://%s/%s", src.CS.Bucket, src.CS.Name)))
+	}
+	return urls
+}
+
+// loadedObjects returns URLs of objects in the LoadLog that are loaded successfully.
+func loadedObjects(log *model.LoadLog) []types.CSUrl {
+	if !log.Success {
+		return nil
+	}
+
+	var urls []types.CSUrl
+	for _, src := range log.Sources {
+		if src.CS == nil || !src.Success {
+			continue
+		}
+		urls = append(urls, types.CSUrl(fmt.Sprintf("gs://%s/%s", src.CS.Bucket, src.CS.Name)))
+	}
+	return urls
+}
+
+// ListInsertErrors returns rows of the insert error table of dst ("<table>_errors" written by WithInsertErrorTable) that match the filter as records of dst. Time range of the filter is applied to failed_at and error to reason of the rows. A row is returned only once even if it was rejected multiple times, and a row of which ID already exists in dst (e.g. replayed) is not returned.
+func (x *UseCase) ListInsertErrors(ctx context.Context, dst model.BigQueryDest, filter model.ReplayFilter) ([]*model.LogRecord, error) {
+	query, params := insertErrorsQuery(dst, filter)
+	utils.CtxLogger(ctx).Debug("query insert errors", "query", query, "params", params)
+
+	it, err := x.clients.BigQuery().Query(ctx, query, params...)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to query insert errors").With("dst", dst)
+	}
+
+	var records []*model.LogRecord
+	for {
+		var row map[string]bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			return nil, goerr.Wrap(err, "failed to read insert errors").With("dst", dst)
+		}
+
+		record, err := insertErrorToRecord(row)
+		if err != nil {
+			return nil, goerr.Wrap(err).With("dst", dst)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func insertErrorsQuery(dst model.BigQueryDest, filter model.ReplayFilter) (string, []bigquery.QueryParameter) {
+	conds := []string{
+		fmt.Sprintf("NOT EXISTS (SELECT 1 FROM `%s.%s` AS t WHERE t.id = e.id)", dst.Dataset, dst.Table),
+	}
+	var params []bigquery.QueryParameter
+	if !filter.Start.IsZero() {
+		conds = append(conds, "e.failed_at >= @start")
+		params = append(params, bigquery.QueryParameter{Name: "start", Value: filter.Start.UTC()})
+	}
+	if !filter.End.IsZero() {
+		conds = append(conds, "e.failed_at < @end")
+		params = append(params, bigquery.QueryParameter{Name: "end", Value: filter.End.UTC()})
+	}
+	if filter.Error != "" {
+		conds = append(conds, "STRPOS(IFNULL(e.reason, ''), @error) > 0")
+		params = append(params, bigquery.QueryParameter{Name: "error", Value: filter.Error})
+	}
+
+	query := fmt.Sprintf("SELECT e.id, e.ingest_id, e.timestamp, e.record FROM `%s.%s%s` AS e WHERE %s "+
+		"QUALIFY ROW_NUMBER() OVER (PARTITION BY e.id ORDER BY e.failed_at DESC) = 1",
+		dst.Dataset, dst.Table, insertErrorTableSuffix, strings.Join(conds, " AND "))
+	return query, params
+}
+
+// insertErrorToRecord restores a record from a row of insert error table.
+func insertErrorToRecord(row map[string]bigquery.Value) (*model.LogRecord, error) {
+	id, _ := row["id"].(string)
+	ingestID, _ := row["ingest_id"].(string)
+	timestamp, _ := row["timestamp"].(time.Time)
+	raw, _ := row["record"].(string)
+	if id == "" || raw == "" {
+		return nil, goerr.Wrap(types.ErrInvalidOption, "row of insert error table must have id and record").With("row", row)
+	}
+
+	var data any
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, goerr.Wrap(err, "failed to decode record of insert error").With("id", id)
+	}
+
+	return &model.LogRecord{
+		ID:         types.LogID(id),
+		IngestID:   types.IngestID(ingestID),
+		Timestamp:  timestamp,
+		IngestedAt: time.Now(),
+		Data:       data,
+	}, nil
+}
+
+// ReplayInsertErrors ingests records restored by ListInsertErrors into dst again. Schema of dst is updated by the records as ingestion of objects.
+func (x *UseCase) ReplayInsertErrors(ctx context.Context, dst model.BigQueryDest, records []*model.LogRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	log, err := x.ingestRecords(ctx, dst, records)
+	if err != nil {
+		return goerr.Wrap(err, "failed to replay insert errors").With("dst", dst)
+	}
+	utils.CtxLogger(ctx).Info("insert errors replayed", "dst", dst, "count", log.LogCount)
+	return nil
+}
+
+// ReplayDeadLetterSubscription pulls messages of dead letter topic published by WithDeadLetterPubSub from the Pub/Sub subscription, and loads objects in the messages again. The time range of filter is applied to the failed time and error to the error attribute of the messages. A message that does not match filter or fails again is nacked and kept in the subscription. Pulling stops when no message is replayed successfully for idleTimeout, and the number of replayed messages is returned.
+func (x *UseCase) ReplayDeadLetterSubscription(ctx context.Context, filter model.ReplayFilter, idleTimeout time.Duration) (int, error) {
+	sub := x.clients.PubSubSubscription()
+	if sub == nil {
+		return 0, goerr.Wrap(types.ErrInvalidOption, "Pub/Sub subscription of dead letter topic is not configured")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Idle timer is stopped while messages are handled not to cancel loading objects
+	var (
+		mutex        sync.Mutex
+		inFlight     int
+		replayed     int
+		lastReplayed = time.Now()
+	)
+	idle := time.AfterFunc(idleTimeout, cancel)
+	defer idle.Stop()
+
+	handler := func(ctx context.Context, msgID types.PubSubMessageID, data []byte, attrs map[string]string) error {
+		mutex.Lock()
+		inFlight++
+		idle.Stop()
+		mutex.Unlock()
+
+		ok, err := x.replayDeadLetterMessage(ctx, msgID, data, attrs, filter)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		inFlight--
+		if ok {
+			replayed++
+			lastReplayed = time.Now()
+		}
+		if inFlight == 0 {
+			idle.Reset(max(idleTimeout-time.Since(lastReplayed), 0))
+		}
+		return err
+	}
+
+	if err := sub.Receive(ctx, handler); err != nil && !errors.Is(err, context.Canceled) {
+		return replayed, err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return replayed, nil
+}
+
+// replayDeadLetterMessage loads objects in the dead letter message and returns true if they are loaded. Error means the message should be nacked.
+func (x *UseCase) replayDeadLetterMessage(ctx context.Context, msgID types.PubSubMessageID, data []byte, attrs map[string]string, filter model.ReplayFilter) (bool, error) {
+	var msg model.SwarmMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		// Broken message never succeeds, then it should be acked
+		utils.HandleError(ctx, "failed to unmarshal dead letter message", goerr.Wrap(err).With("msgID", msgID).With("data", string(data)))
+		return false, nil
+	}
+
+	// Messages published before failed_at attribute was added have zero time, and do not match time range
+	failedAt, _ := time.Parse(time.RFC3339Nano, attrs[DeadLetterAttrFailedAt])
+	if !filter.Match(failedAt, attrs[DeadLetterAttrError]) {
+		utils.CtxLogger(ctx).Debug("skip dead letter message not matching filter", "msgID", msgID, "attrs", attrs)
+		return false, goerr.New("dead letter message does not match filter").With("msgID", msgID)
+	}
+
+	var urls []types.CSUrl
+	for _, obj := range msg.Objects {
+		if obj.CS == nil {
+			continue
+		}
+		urls = append(urls, types.CSUrl(fmt.Sprintf("gs://%s/%s", obj.CS.Bucket, obj.CS.Name)))
+	}
+
+	if err := x.LoadDataByObjects(ctx, urls, 1); err != nil {
+		return false, goerr.Wrap(err, "failed to replay dead letter message").With("msgID", msgID)
+	}
+	utils.CtxLogger(ctx).Info("dead letter message replayed", "msgID", msgID, "count", len(urls))
+	return true, nil
+}
+
+// ListDumpedObjects returns URLs of objects in swarm messages dumped by enqueue with output directory, e.g. read by pubsub.ReadDump. Unlike dead letter messages, a broken message is an error because the dump is written by swarm itself.
+func (x *UseCase) ListDumpedObjects(ctx context.Context, messages [][]byte) ([]types.CSUrl, error) {
+	var urls []types.CSUrl
+	for i, data := range messages {
+		var msg model.SwarmMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, goerr.Wrap(err, "failed to unmarshal dumped message").With("index", i)
+		}
+
+		for _, obj := range msg.Objects {
+			if obj.CS == nil {
+				continue
+			}
+			urls = append(urls, types.CSUrl(fmt.Sprintf("gs://%s/%s", obj.CS.Bucket, obj.CS.Name)))
+		}
+	}
+
+	utils.CtxLogger(ctx).Info("dumped objects listed", "messages", len(messages), "objects", len(urls))
+	return urls, nil
+}
@@ -0,0 +1,407 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestReplayDeadLetter(t *testing.T) {
+	baseTime := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	loadLogs := map[string]*model.LoadLog{
+		"meta/2024/03/01/req-1.json": {
+			ID:        "req-1",
+			StartedAt: baseTime.Add(time.Hour),
+			Success:   false,
+			Error:     "failed to import records",
+			Sources: []*model.SourceLog{
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "1.log"},
+					Success: false,
+					Error:   "schema policy: no dataset",
+				},
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "2.log"},
+					Success: true,
+				},
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "deleted.log"},
+					Success: true,
+					Missing: true,
+				},
+			},
+		},
+		"meta/2024/03/01/req-2.json": {
+			ID:        "req-2",
+			StartedAt: baseTime.Add(2 * time.Hour),
+			Success:   true,
+			Sources: []*model.SourceLog{
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "3.log"},
+					Success: true,
+				},
+			},
+		},
+		// 5.log failed and was replayed successfully, then it is not replayed again
+		"meta/2024/03/01/req-4.json": {
+			ID:        "req-4",
+			StartedAt: baseTime.Add(3 * time.Hour),
+			Success:   false,
+			Error:     "schema policy: no dataset",
+			Sources: []*model.SourceLog{
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "5.log"},
+					Success: false,
+				},
+			},
+		},
+		"meta/2024/03/01/req-5.json": {
+			ID:        "req-5",
+			StartedAt: baseTime.Add(4 * time.Hour),
+			Success:   true,
+			Sources: []*model.SourceLog{
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "5.log"},
+					Success: true,
+				},
+			},
+		},
+		"meta/2024/03/02/req-3.json": {
+			ID:        "req-3",
+			StartedAt: baseTime.Add(25 * time.Hour),
+			Success:   false,
+			Error:     "schema policy: no dataset",
+			Sources: []*model.SourceLog{
+				{
+					CS:      &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: "4.log"},
+					Success: false,
+				},
+			},
+		},
+	}
+
+	newUseCase := func(bqClient *bq.GeneralMock, opened *[]types.CSObjectID) *usecase.UseCase {
+		var mutex sync.Mutex
+		csClient := &cs.Mock{
+			MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+				gt.Equal(t, bucket, "swarm-meta")
+				gt.Equal(t, query.Prefix, "meta/")
+				var names []string
+				for name := range loadLogs {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				it := &cs.MockObjectIterator{}
+				for _, name := range names {
+					it.Attrs = append(it.Attrs, &storage.ObjectAttrs{Bucket: string(bucket), Name: name})
+				}
+				return it
+			},
+			MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+				return &storage.ObjectAttrs{Bucket: obj.Bucket.String(), Name: obj.Name.String(), Size: 1024}, nil
+			},
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				if log, ok := loadLogs[obj.Name.String()]; ok {
+					raw := gt.R1(json.Marshal(log)).NoError(t)
+					return io.NopCloser(bytes.NewReader(raw)), nil
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				*opened = append(*opened, obj.Name)
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+		}
+		return newTestUseCase(t, testClients{
+			BigQuery:     bqClient,
+			CloudStorage: csClient,
+			Policy: []policy.Option{
+				policy.WithFile("testdata/policy/schema.rego"),
+				policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+			},
+		})
+	}
+
+	t.Run("replay failed objects in load logs of Cloud Storage", func(t *testing.T) {
+		ctx := context.Background()
+		var opened []types.CSObjectID
+		bqClient := bq.NewGeneralMock()
+		uc := newUseCase(bqClient, &opened)
+
+		urls := gt.R1(uc.ListFailedObjectsByCloudStorage(ctx, "swarm-meta", "meta/", model.ReplayFilter{})).NoError(t)
+		// 2.log is replayed because the whole load failed, but missing object is not
+		gt.Equal(t, urls, []types.CSUrl{
+			"gs://cloudtrail-logs/1.log",
+			"gs://cloudtrail-logs/2.log",
+			"gs://cloudtrail-logs/4.log",
+		})
+
+		gt.NoError(t, uc.LoadDataByObjects(ctx, urls, 2))
+		sort.Slice(opened, func(i, j int) bool { return opened[i] < opened[j] })
+		gt.Equal(t, opened, []types.CSObjectID{"1.log", "2.log", "4.log"})
+		gt.A(t, bqClient.Streams).Length(3)
+	})
+
+	t.Run("filter by time range and error", func(t *testing.T) {
+		ctx := context.Background()
+		var opened []types.CSObjectID
+		uc := newUseCase(bq.NewGeneralMock(), &opened)
+
+		urls := gt.R1(uc.ListFailedObjectsByCloudStorage(ctx, "swarm-meta", "meta/", model.ReplayFilter{
+			Start: baseTime,
+			End:   baseTime.Add(24 * time.Hour),
+		})).NoError(t)
+		gt.Equal(t, urls, []types.CSUrl{
+			"gs://cloudtrail-logs/1.log",
+			"gs://cloudtrail-logs/2.log",
+		})
+
+		// Error of source or load is matched
+		urls = gt.R1(uc.ListFailedObjectsByCloudStorage(ctx, "swarm-meta", "meta/", model.ReplayFilter{
+			Error: "schema policy",
+		})).NoError(t)
+		gt.Equal(t, urls, []types.CSUrl{
+			"gs://cloudtrail-logs/1.log",
+			"gs://cloudtrail-logs/4.log",
+		})
+	})
+
+	t.Run("query failed objects in load log table", func(t *testing.T) {
+		ctx := context.Background()
+		var opened []types.CSObjectID
+		bqClient := bq.NewGeneralMock()
+		bqClient.QueryResult = &bq.MockIterator{
+			Rows: []map[string]bigquery.Value{
+				{"url": "gs://cloudtrail-logs/1.log"},
+				{"url": "gs://cloudtrail-logs/4.log"},
+			},
+		}
+		uc := newUseCase(bqClient, &opened)
+
+		urls := gt.R1(uc.ListFailedObjectsByTable(ctx, "swarm", "load_logs", model.ReplayFilter{
+			Start: baseTime,
+			Error: `schema "policy"`,
+		})).NoError(t)
+		gt.Equal(t, urls, []types.CSUrl{
+			"gs://cloudtrail-logs/1.log",
+			"gs://cloudtrail-logs/4.log",
+		})
+
+		gt.A(t, bqClient.Queries).Length(1).At(0, func(t testing.TB, v string) {
+			gt.String(t, v).Contains("FROM `swarm.load_logs` AS log, UNNEST(log.sources) AS src")
+			gt.String(t, v).Contains("f.started_at >= @start")
+			gt.String(t, v).Contains("STRPOS(f.src_error, @error) > 0")
+			gt.String(t, v).Contains("NOT EXISTS (SELECT 1 FROM sources AS s WHERE s.success")
+			gt.String(t, v).NotContains("f.started_at < @end")
+			gt.String(t, v).NotContains("2024-03-01")
+			gt.String(t, v).NotContains("policy")
+		})
+		// Filter values are given as parameters, not embedded in the query
+		gt.Equal(t, bqClient.QueryParameters[0], []bigquery.QueryParameter{
+			{Name: "start", Value: baseTime},
+			{Name: "error", Value: `schema "policy"`},
+		})
+	})
+}
+
+func TestReplayInsertErrors(t *testing.T) {
+	ctx := context.Background()
+	failedAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	bqClient := bq.NewGeneralMock()
+	bqClient.QueryResult = &bq.MockIterator{
+		Rows: []map[string]bigquery.Value{
+			{"id": "log-1", "ingest_id": "ingest-1", "timestamp": failedAt.Add(-time.Hour), "record": `{"user":"alice","count":1}`},
+			{"id": "log-2", "ingest_id": "ingest-1", "timestamp": failedAt.Add(-time.Hour), "record": `{"user":"bob"}`},
+		},
+	}
+	uc := usecase.New(infra.New(infra.WithBigQuery(bqClient)))
+	dst := model.BigQueryDest{Dataset: "swarm", Table: "access_log"}
+
+	records := gt.R1(uc.ListInsertErrors(ctx, dst, model.ReplayFilter{
+		Start: failedAt,
+		Error: "no such field",
+	})).NoError(t)
+	gt.A(t, records).Length(2).At(0, func(t testing.TB, v *model.LogRecord) {
+		gt.Equal(t, v.ID, "log-1")
+		gt.Equal(t, v.IngestID, "ingest-1")
+		gt.Equal(t, v.Timestamp, failedAt.Add(-time.Hour))
+		gt.Equal(t, v.Data, any(map[string]any{"user": "alice", "count": 1.0}))
+	})
+
+	gt.A(t, bqClient.Queries).Length(1).At(0, func(t testing.TB, v string) {
+		gt.String(t, v).Contains("FROM `swarm.access_log_errors` AS e")
+		// Rows already in the table are not replayed again
+		gt.String(t, v).Contains("NOT EXISTS (SELECT 1 FROM `swarm.access_log` AS t WHERE t.id = e.id)")
+		gt.String(t, v).Contains("e.failed_at >= @start")
+		gt.String(t, v).NotContains("no such field")
+	})
+	gt.Equal(t, bqClient.QueryParameters[0], []bigquery.QueryParameter{
+		{Name: "start", Value: failedAt},
+		{Name: "error", Value: "no such field"},
+	})
+
+	gt.NoError(t, uc.ReplayInsertErrors(ctx, dst, records))
+	gt.A(t, bqClient.OpenedStream).Length(1).At(0, func(t testing.TB, v struct {
+		Dataset types.BQDatasetID
+		Table   types.BQTableID
+		Schema  bigquery.Schema
+	}) {
+		gt.Equal(t, v.Table, "access_log")
+	})
+	gt.A(t, bqClient.Streams[0].Inserted).Length(1).At(0, func(t testing.TB, v []any) {
+		gt.A(t, v).Length(2)
+		gt.Equal(t, gt.Cast[*model.LogRecordRaw](t, v[1]).ID, "log-2")
+	})
+}
+
+func TestReplayDeadLetterSubscription(t *testing.T) {
+	ctx := context.Background()
+	failedAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	message := func(name, errMsg string, at time.Time) (*pubsub.MockMessage, []byte) {
+		raw := gt.R1(json.Marshal(model.SwarmMessage{Objects: []*model.Object{
+			{CS: &model.CloudStorageObject{Bucket: "cloudtrail-logs", Name: types.CSObjectID(name)}},
+		}})).NoError(t)
+		return &pubsub.MockMessage{
+			ID:   types.PubSubMessageID(name),
+			Data: raw,
+			Attrs: map[string]string{
+				usecase.DeadLetterAttrError:    errMsg,
+				usecase.DeadLetterAttrFailedAt: at.Format(time.RFC3339Nano),
+			},
+		}, raw
+	}
+	replayed, _ := message("1.log", "schema policy: no dataset", failedAt.Add(time.Hour))
+	otherError, _ := message("2.log", "service unavailable", failedAt.Add(time.Hour))
+	outOfRange, _ := message("3.log", "schema policy: no dataset", failedAt.Add(-time.Hour))
+	sub := &pubsub.MockSubscription{
+		Messages: []*pubsub.MockMessage{
+			replayed,
+			otherError,
+			outOfRange,
+			{ID: "broken", Data: []byte(`{"objects":`)},
+		},
+	}
+
+	var opened []types.CSObjectID
+	csClient := &cs.Mock{
+		MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+			return &storage.ObjectAttrs{Bucket: obj.Bucket.String(), Name: obj.Name.String(), Size: 1024}, nil
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			opened = append(opened, obj.Name)
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithFile("testdata/policy/schema.rego"),
+		policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+	)).NoError(t)
+	bqClient := bq.NewGeneralMock()
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+		infra.WithPubSubSubscription(sub),
+	))
+
+	count := gt.R1(uc.ReplayDeadLetterSubscription(ctx, model.ReplayFilter{
+		Start: failedAt,
+		Error: "schema policy",
+	}, time.Second)).NoError(t)
+	gt.Equal(t, count, 1)
+	gt.Equal(t, opened, []types.CSObjectID{"1.log"})
+	gt.A(t, bqClient.Streams).Length(1)
+
+	// Messages not matching the filter are kept in the subscription, and broken message is dropped
+	gt.Equal(t, sub.Acked, []types.PubSubMessageID{"1.log", "broken"})
+	gt.Equal(t, sub.Nacked, []types.PubSubMessageID{"2.log", "3.log"})
+}
+
+func TestReplayDeadLetterSubscriptionIdleTimeout(t *testing.T) {
+	sub := &blockingSubscription{}
+	uc := usecase.New(infra.New(infra.WithPubSubSubscription(sub)))
+
+	// Receiving stops when no message is replayed
+	count := gt.R1(uc.ReplayDeadLetterSubscription(context.Background(), model.ReplayFilter{}, 10*time.Millisecond)).NoError(t)
+	gt.Equal(t, count, 0)
+}
+
+// blockingSubscription receives no message until ctx is canceled like Pub/Sub subscription without message.
+type blockingSubscription struct{}
+
+func (x *blockingSubscription) Receive(ctx context.Context, handler interfaces.PubSubHandler) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReplayDumpedMessages(t *testing.T) {
+	testCases := map[string][]pubsub.DumperOption{
+		"plain": nil,
+		"gzip":  {pubsub.WithDumpGzip()},
+	}
+
+	for title, options := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			// Dump messages in the same way as enqueue with --output
+			csMock := &cs.Mock{
+				MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+					return &cs.MockObjectIterator{
+						Attrs: []*storage.ObjectAttrs{
+							{Bucket: "bucket", Name: "logs/a.json", Size: 100},
+							{Bucket: "bucket", Name: "logs/b.json", Size: 100},
+							{Bucket: "bucket", Name: "logs/c.json", Size: 100},
+						},
+					}
+				},
+			}
+			enqueuer := usecase.New(infra.New(
+				infra.WithCloudStorage(csMock),
+				infra.WithPubSub(pubsub.NewDumper(dir, options...)),
+			), usecase.WithEnqueueCountLimit(2))
+			gt.R1(enqueuer.Enqueue(ctx, &model.EnqueueRequest{
+				URLs: []types.ObjectURL{"gs://bucket/logs/"},
+			})).NoError(t)
+
+			messages := gt.R1(pubsub.ReadDump(dir)).NoError(t)
+			gt.A(t, messages).Length(2)
+
+			uc := usecase.New(infra.New())
+			urls := gt.R1(uc.ListDumpedObjects(ctx, messages)).NoError(t)
+			sort.Slice(urls, func(i, j int) bool { return urls[i] < urls[j] })
+			gt.Equal(t, urls, []types.CSUrl{
+				"gs://bucket/logs/a.json",
+				"gs://bucket/logs/b.json",
+				"gs://bucket/logs/c.json",
+			})
+		})
+	}
+
+	t.Run("broken message is error", func(t *testing.T) {
+		uc := usecase.New(infra.New())
+		_, err := uc.ListDumpedObjects(context.Background(), [][]byte{[]byte("{")})
+		gt.Error(t, err)
+	})
+}
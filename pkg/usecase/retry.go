@@ -0,0 +1,44 @@
+package usecase
+
+import (
+	"errors"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+)
+
+// ClassifyError decides handling of a message failed by err with the classifier set by WithErrorClassifier, or DefaultErrorClassifier.
+func (x *UseCase) ClassifyError(err error) types.RetryDecision {
+	return x.errorClassifier(err)
+}
+
+// DefaultErrorClassifier retries errors by default because they may be transient. An error caused by configuration or data can not be recovered by retry, then it is dead-lettered. A missing object is dropped because it can not be replayed.
+func DefaultErrorClassifier(err error) types.RetryDecision {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return types.RetryDecisionDrop
+	}
+
+	nonRetryable := []error{
+		types.ErrInvalidOption,
+		types.ErrInvalidPolicyResult,
+		types.ErrNoPolicyResult,
+		types.ErrNoSourceMatched,
+		types.ErrSchemaConflict,
+		types.ErrObjectTooLarge,
+		types.ErrRecordTooLarge,
+		types.ErrRecordTooDeep,
+		types.ErrTooManyFields,
+		types.ErrUnexpectedField,
+		types.ErrFieldTypeMismatch,
+		types.ErrObjectExpired,
+		types.ErrUnsupportedArray,
+		types.ErrUnsupportedCompress,
+	}
+	for _, target := range nonRetryable {
+		if errors.Is(err, target) {
+			return types.RetryDecisionDeadLetter
+		}
+	}
+
+	return types.RetryDecisionRetry
+}
@@ -2,7 +2,9 @@ package usecase
 
 import (
 	"context"
+	"sort"
 
+	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
@@ -11,6 +13,56 @@ import (
 )
 
 func (x *UseCase) ApplyInferredSchema(ctx context.Context, urls []types.CSUrl) error {
+	objects, err := x.listObjects(ctx, urls)
+	if err != nil {
+		return err
+	}
+
+	return x.applyInferredSchema(ctx, objects)
+}
+
+// DiffInferredSchema infers schema from objects specified by urls and compares it with schema of live BigQuery table. It does not change any table.
+func (x *UseCase) DiffInferredSchema(ctx context.Context, urls []types.CSUrl) ([]*model.SchemaDiff, error) {
+	objects, err := x.listObjects(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas, _, err := x.inferSchemaByDest(ctx, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*model.SchemaDiff
+	for dst, schema := range schemas {
+		md, err := x.clients.BigQuery().GetMetadata(ctx, dst.Dataset, dst.Table)
+		if err != nil {
+			return nil, err
+		}
+
+		var current bigquery.Schema
+		if md != nil {
+			current = md.Schema
+			if current == nil {
+				current = bigquery.Schema{}
+			}
+		}
+
+		diffs = append(diffs, model.DiffSchema(dst, current, schema))
+	}
+
+	// schemas is a map, then diffs are sorted to be output in the same order every time
+	sort.Slice(diffs, func(i, j int) bool {
+		a, b := diffs[i].Dest, diffs[j].Dest
+		if a.Dataset != b.Dataset {
+			return a.Dataset < b.Dataset
+		}
+		return a.Table < b.Table
+	})
+	return diffs, nil
+}
+
+func (x *UseCase) listObjects(ctx context.Context, urls []types.CSUrl) ([]model.Object, error) {
 	var objects []model.Object
 	logger := utils.CtxLogger(ctx)
 
@@ -18,7 +70,7 @@ func (x *UseCase) ApplyInferredSchema(ctx context.Context, urls []types.CSUrl) e
 		var tmp []model.Object
 		bucket, objPrefix, err := url.Parse()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		query := &storage.Query{
@@ -32,7 +84,7 @@ func (x *UseCase) ApplyInferredSchema(ctx context.Context, urls []types.CSUrl) e
 				break
 			}
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			obj := model.NewObjectFromCloudStorageAttrs(attrs)
@@ -43,16 +95,43 @@ func (x *UseCase) ApplyInferredSchema(ctx context.Context, urls []types.CSUrl) e
 		objects = append(objects, tmp...)
 	}
 
-	return x.applyInferredSchema(ctx, objects)
+	return objects, nil
 }
 
 func (x *UseCase) applyInferredSchema(ctx context.Context, objects []model.Object) error {
+	schemas, descriptions, err := x.inferSchemaByDest(ctx, objects)
+	if err != nil {
+		return err
+	}
+
+	for dst, schema := range schemas {
+		md, err := buildBQMetadata(schema, dst.Partition)
+		if err != nil {
+			return err
+		}
+		setRangePartitioning(md, dst.RangePartition)
+		md.Description = descriptions[dst]
+
+		finalized, changed, err := applyTableSchema(ctx, x.clients.BigQuery(), dst.Dataset, dst.Table, md)
+		if err != nil {
+			return err
+		}
+		if changed {
+			x.recordSchemaChange(ctx, dst, finalized)
+		}
+	}
+
+	return nil
+}
+
+// inferSchemaByDest infers schema of each destination from logs of objects. It also returns table description of each destination declared by schema policy.
+func (x *UseCase) inferSchemaByDest(ctx context.Context, objects []model.Object) (map[model.BigQueryDest]bigquery.Schema, map[model.BigQueryDest]string, error) {
 	var requests []*model.LoadRequest
 
 	for _, obj := range objects {
 		sources, err := x.ObjectToSources(ctx, obj)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		for _, src := range sources {
@@ -65,26 +144,24 @@ func (x *UseCase) applyInferredSchema(ctx context.Context, objects []model.Objec
 
 	logger := utils.CtxLogger(ctx)
 	logger.Info("importing objects", "source.size", len(requests))
-	records, _, err := importLogRecords(ctx, x.clients, requests, x.readObjectConcurrency)
+	records, _, _, err := x.importLogRecords(ctx, requests)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
+	schemas := make(map[model.BigQueryDest]bigquery.Schema, len(records))
+	descriptions := make(map[model.BigQueryDest]string, len(records))
 	for dst, records := range records {
 		schema, err := inferSchema(records)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-
-		md, err := buildBQMetadata(schema, dst.Partition)
-		if err != nil {
-			return err
-		}
-
-		if _, err := createOrUpdateTable(ctx, x.clients.BigQuery(), dst.Dataset, dst.Table, md); err != nil {
-			return err
+		if schema, err = x.pinnedSchema(ctx, dst, schema); err != nil {
+			return nil, nil, err
 		}
+		schemas[dst] = schema
+		descriptions[dst] = model.TableDescription(records)
 	}
 
-	return nil
+	return schemas, descriptions, nil
 }
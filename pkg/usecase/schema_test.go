@@ -0,0 +1,161 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+const schemaDiffEventPolicy = `package event
+
+src[{
+	"schema": "cloudtrail",
+	"parser": "json",
+}] {
+	input.cs.bucket == "cloudtrail-logs"
+	endswith(input.cs.name, ".log")
+}
+`
+
+func TestDiffInferredSchema(t *testing.T) {
+	ctx := context.Background()
+
+	newUseCase := func(t *testing.T, bqClient interfaces.BigQuery) *usecase.UseCase {
+		csClient := &cs.Mock{
+			MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+				gt.Equal(t, bucket, "cloudtrail-logs")
+				return &cs.MockObjectIterator{
+					Attrs: []*storage.ObjectAttrs{
+						{Bucket: "cloudtrail-logs", Name: "logs/cloudtrail_example.log"},
+					},
+				}
+			},
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+		}
+		return newTestUseCase(t, testClients{
+			BigQuery:     bqClient,
+			CloudStorage: csClient,
+			Policy: []policy.Option{
+				policy.WithFile("testdata/policy/schema.rego"),
+				policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+			},
+		})
+	}
+	urls := []types.CSUrl{"gs://cloudtrail-logs/logs/"}
+
+	t.Run("table does not exist", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		diffs := gt.R1(newUseCase(t, bqClient).DiffInferredSchema(ctx, urls)).NoError(t)
+		gt.A(t, diffs).Length(1).At(0, func(t testing.TB, v *model.SchemaDiff) {
+			gt.Equal(t, v.Dest.Table, "cloudtrail")
+			gt.Equal(t, v.TableExists, false)
+			gt.Equal(t, v.Incompatible(), false)
+			gt.Equal(t, len(v.Added) > 0, true)
+		})
+		gt.A(t, bqClient.CreatedTable).Length(0)
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+	})
+
+	t.Run("retyped column", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{
+			{
+				Schema: bigquery.Schema{
+					{Name: "id", Type: bigquery.IntegerFieldType},
+					{Name: "legacy", Type: bigquery.StringFieldType},
+				},
+			},
+		}
+
+		diffs := gt.R1(newUseCase(t, bqClient).DiffInferredSchema(ctx, urls)).NoError(t)
+		gt.A(t, diffs).Length(1).At(0, func(t testing.TB, v *model.SchemaDiff) {
+			gt.Equal(t, v.TableExists, true)
+			gt.Equal(t, v.Incompatible(), true)
+			gt.A(t, v.Retyped).Length(1).At(0, func(t testing.TB, v *model.SchemaFieldChange) {
+				gt.Equal(t, v.Name, "id")
+				gt.Equal(t, v.Old.Type, bigquery.IntegerFieldType)
+				gt.Equal(t, v.New.Type, bigquery.StringFieldType)
+			})
+			gt.A(t, v.Removed).Length(1).At(0, func(t testing.TB, v *model.SchemaField) {
+				gt.Equal(t, v.Name, "legacy")
+			})
+		})
+	})
+}
+
+func TestDiffInferredSchemaOrder(t *testing.T) {
+	const eventPolicy = `package event
+
+src[{
+	"schema": "multi",
+	"parser": "json",
+}] {
+	true
+}
+`
+	// Logs are routed to multiple tables and the diffs must be sorted by the destination
+	const schemaPolicy = `package schema.multi
+
+log[{
+	"dataset": dst[0],
+	"table": dst[1],
+	"timestamp": 1708130907,
+	"data": input,
+}] {
+	dst := [["ds_b", "t1"], ["ds_a", "t2"], ["ds_a", "t1"], ["ds_c", "t0"]][_]
+}
+`
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			return &cs.MockObjectIterator{
+				Attrs: []*storage.ObjectAttrs{{Bucket: "logs", Name: "test.json"}},
+			}
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(`{"b":1,"a":"x","c":{"z":true,"y":1}}`)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithPolicyData("event.rego", eventPolicy),
+		policy.WithPolicyData("schema.rego", schemaPolicy),
+	)).NoError(t)
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+	))
+
+	for i := 0; i < 10; i++ {
+		diffs := gt.R1(uc.DiffInferredSchema(ctx, []types.CSUrl{"gs://logs/"})).NoError(t)
+
+		var dests []string
+		for _, diff := range diffs {
+			dests = append(dests, diff.Dest.Dataset.String()+"."+diff.Dest.Table.String())
+
+			var names []string
+			for _, f := range diff.Added {
+				names = append(names, f.Name)
+			}
+			gt.True(t, sort.StringsAreSorted(names))
+		}
+		gt.Equal(t, dests, []string{"ds_a.t1", "ds_a.t2", "ds_b.t1", "ds_c.t0"})
+	}
+}
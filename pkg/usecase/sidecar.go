@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/bqs"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// schemaSidecar writes schema of a table as JSON object into Cloud Storage. The object name is "{prefix}{dataset}.{table}.json".
+type schemaSidecar struct {
+	client interfaces.CloudStorage
+	bucket types.CSBucket
+	prefix string
+}
+
+// Write puts schema of dst table into Cloud Storage. It does nothing if x is nil (sidecar is not configured).
+func (x *schemaSidecar) Write(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID, schema bigquery.Schema) error {
+	if x == nil {
+		return nil
+	}
+
+	jsonSchema, err := schemaToJSON(schema)
+	if err != nil {
+		return err
+	}
+
+	obj := x.object(dataset, table)
+	w := x.client.NewWriter(ctx, obj)
+	if _, err := io.Copy(w, strings.NewReader(jsonSchema)); err != nil {
+		_ = w.Close()
+		return goerr.Wrap(err, "failed to write schema sidecar").With("obj", obj)
+	}
+	if err := w.Close(); err != nil {
+		return goerr.Wrap(err, "failed to close schema sidecar writer").With("obj", obj)
+	}
+
+	utils.CtxLogger(ctx).Info("schema sidecar written", "obj", obj)
+	return nil
+}
+
+// Read returns schema of the table stored by Write. It returns nil if x is nil or the schema has not been stored yet.
+func (x *schemaSidecar) Read(ctx context.Context, dataset types.BQDatasetID, table types.BQTableID) (bigquery.Schema, error) {
+	if x == nil {
+		return nil, nil
+	}
+
+	obj := x.object(dataset, table)
+	r, err := x.client.Open(ctx, obj)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, nil
+		}
+		return nil, goerr.Wrap(err, "failed to open schema sidecar").With("obj", obj)
+	}
+	defer r.Close()
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to read schema sidecar").With("obj", obj)
+	}
+	schema, err := bigquery.SchemaFromJSON(raw)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to parse schema sidecar").With("obj", obj)
+	}
+	return schema, nil
+}
+
+func (x *schemaSidecar) object(dataset types.BQDatasetID, table types.BQTableID) model.CloudStorageObject {
+	return model.CloudStorageObject{
+		Bucket: x.bucket,
+		Name:   types.CSObjectID(x.prefix + dataset.String() + "." + table.String() + ".json"),
+	}
+}
+
+// writeSchemaSidecar writes schema of dst table by schemaSidecar. Failure of writing sidecar is only reported because it should not stop ingestion.
+func (x *UseCase) writeSchemaSidecar(ctx context.Context, dst model.BigQueryDest, schema bigquery.Schema) {
+	if err := x.schemaSidecar.Write(ctx, dst.Dataset, dst.Table, schema); err != nil {
+		utils.HandleError(ctx, "failed to write schema sidecar", err)
+	}
+}
+
+// pinnedSchema returns schema based on the schema of dst stored by schemaSidecar if schemaPin is enabled. Types of fields in the stored schema are kept as they are, and only fields that do not exist in it are added from inferred. It returns inferred as it is if schemaPin is disabled or no schema is stored.
+func (x *UseCase) pinnedSchema(ctx context.Context, dst model.BigQueryDest, inferred bigquery.Schema) (bigquery.Schema, error) {
+	if !x.schemaPin {
+		return inferred, nil
+	}
+
+	pinned, err := x.schemaSidecar.Read(ctx, dst.Dataset, dst.Table)
+	if err != nil {
+		return nil, err
+	}
+	if pinned == nil {
+		return inferred, nil
+	}
+
+	schema := pinFields(pinned, inferred)
+	if !bqs.Equal(schema, inferred) {
+		utils.CtxLogger(ctx).Debug("inferred schema is pinned", "dst", dst)
+	}
+	return schema, nil
+}
+
+// pinFields returns fields of pinned and fields of inferred that do not exist in pinned. Nested fields of RECORD in both are pinned recursively. Policy tags of inferred are kept because they are declared by schema policy.
+func pinFields(pinned, inferred bigquery.Schema) bigquery.Schema {
+	inferredMap := make(map[string]*bigquery.FieldSchema, len(inferred))
+	for _, field := range inferred {
+		inferredMap[field.Name] = field
+	}
+
+	result := make(bigquery.Schema, 0, len(pinned)+len(inferred))
+	pinnedNames := make(map[string]struct{}, len(pinned))
+	for _, field := range pinned {
+		pinnedNames[field.Name] = struct{}{}
+
+		copied := *field
+		if src, ok := inferredMap[field.Name]; ok {
+			if field.Type == bigquery.RecordFieldType && src.Type == bigquery.RecordFieldType {
+				copied.Schema = pinFields(field.Schema, src.Schema)
+			}
+			if src.PolicyTags != nil {
+				copied.PolicyTags = src.PolicyTags
+			}
+		}
+		result = append(result, &copied)
+	}
+
+	for _, field := range inferred {
+		if _, ok := pinnedNames[field.Name]; !ok {
+			result = append(result, field)
+		}
+	}
+
+	return result
+}
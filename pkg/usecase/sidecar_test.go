@@ -0,0 +1,241 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type bufferWriteCloser struct {
+	bytes.Buffer
+}
+
+func (x *bufferWriteCloser) Close() error { return nil }
+
+func TestLoadSchemaSidecar(t *testing.T) {
+	ctx := context.Background()
+
+	type sidecar struct {
+		obj  model.CloudStorageObject
+		data *bufferWriteCloser
+	}
+
+	load := func(t *testing.T, bqClient *bq.GeneralMock) []*sidecar {
+		var written []*sidecar
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+			},
+			MockNewWriter: func(ctx context.Context, obj model.CloudStorageObject) io.WriteCloser {
+				s := &sidecar{obj: obj, data: &bufferWriteCloser{}}
+				written = append(written, s)
+				return s.data
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithDir("testdata/policy"))).NoError(t)
+
+		uc := usecase.New(
+			infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			),
+			usecase.WithSchemaSidecar("schema-bucket", "schemas/"),
+		)
+
+		req := &model.LoadRequest{
+			Source: model.Source{
+				Parser: types.JSONParser,
+				Schema: "cloudtrail",
+			},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{
+					Bucket: "test-bucket",
+					Name:   "test.log",
+				},
+			},
+		}
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		return written
+	}
+
+	bqClient := bq.NewGeneralMock()
+	written := load(t, bqClient)
+	gt.A(t, bqClient.CreatedTable).Length(1)
+	gt.A(t, written).Length(1)
+	gt.Equal(t, written[0].obj, model.CloudStorageObject{
+		Bucket: "schema-bucket",
+		Name:   "schemas/my_dataset.cloudtrail.json",
+	})
+
+	var fields []map[string]any
+	gt.NoError(t, json.Unmarshal(written[0].data.Bytes(), &fields))
+	names := map[string]bool{}
+	for _, f := range fields {
+		names[f["name"].(string)] = true
+	}
+	gt.True(t, names["id"])
+	gt.True(t, names["data"])
+
+	t.Run("not written if schema is not changed", func(t *testing.T) {
+		existing := bq.NewGeneralMock()
+		existing.Metadata = []*bigquery.TableMetadata{{Schema: bqClient.CreatedTable[0].MD.Schema}}
+		written := load(t, existing)
+		gt.A(t, existing.CreatedTable).Length(0)
+		gt.A(t, existing.UpdatedTable).Length(0)
+		gt.A(t, written).Length(0)
+	})
+}
+
+func TestLoadSchemaPin(t *testing.T) {
+	const schemaPolicy = `package schema.pin
+
+log[{
+	"dataset": "my_dataset",
+	"table": "pin",
+	"timestamp": 1708130907,
+	"data": input,
+}]
+`
+	ctx := context.Background()
+
+	// load returns stored sidecar after loading logData. Sidecar stored previously is read from sidecar argument.
+	load := func(t *testing.T, bqClient *bq.GeneralMock, logData string, sidecar []byte, options ...usecase.Option) ([]byte, error) {
+		var written *bufferWriteCloser
+		csClient := &cs.Mock{
+			MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+				if obj.Bucket != "schema-bucket" {
+					return io.NopCloser(bytes.NewReader([]byte(logData))), nil
+				}
+				gt.Equal(t, obj.Name, "schemas/my_dataset.pin.json")
+				if sidecar == nil {
+					return nil, storage.ErrObjectNotExist
+				}
+				return io.NopCloser(bytes.NewReader(sidecar)), nil
+			},
+			MockNewWriter: func(ctx context.Context, obj model.CloudStorageObject) io.WriteCloser {
+				written = &bufferWriteCloser{}
+				return written
+			},
+		}
+		pClient := gt.R1(policy.New(policy.WithPolicyData("schema.rego", schemaPolicy))).NoError(t)
+
+		uc := usecase.New(
+			infra.New(
+				infra.WithBigQuery(bqClient),
+				infra.WithCloudStorage(csClient),
+				infra.WithPolicy(pClient),
+			),
+			append([]usecase.Option{usecase.WithSchemaSidecar("schema-bucket", "schemas/")}, options...)...,
+		)
+
+		req := &model.LoadRequest{
+			Source: model.Source{Parser: types.JSONParser, Schema: "pin"},
+			Object: model.Object{
+				CS: &model.CloudStorageObject{Bucket: "test-bucket", Name: "test.log"},
+			},
+		}
+		if err := uc.Load(ctx, []*model.LoadRequest{req}); err != nil {
+			return nil, err
+		}
+		if written == nil {
+			return nil, nil
+		}
+		return written.Bytes(), nil
+	}
+
+	// First load creates the table and stores the schema. Pinning without stored schema uses inferred schema as it is.
+	first := bq.NewGeneralMock()
+	stored := gt.R1(load(t, first, `{"status":"ok","user":{"name":"alice"}}`, nil, usecase.WithSchemaPin())).NoError(t)
+	gt.A(t, first.CreatedTable).Length(1)
+	gt.NotEqual(t, stored, nil)
+	tableSchema := first.CreatedTable[0].MD.Schema
+
+	// status becomes number in the next batch
+	const nextData = `{"status":200,"user":{"name":"bob","age":20},"region":"us"}`
+
+	t.Run("type change fails without pin", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: tableSchema}}
+		_, err := load(t, bqClient, nextData, stored)
+		gt.True(t, errors.Is(err, types.ErrSchemaConflict))
+		gt.A(t, bqClient.UpdatedTable).Length(0)
+	})
+
+	t.Run("stored schema prevents type change and only new fields are added", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: tableSchema}}
+		updated := gt.R1(load(t, bqClient, nextData, stored, usecase.WithSchemaPin())).NoError(t)
+		gt.NotEqual(t, updated, nil)
+
+		gt.A(t, bqClient.UpdatedTable).Length(1)
+		dataField := findSchemaField(bqClient.UpdatedTable[0].MD.Schema, "data")
+		gt.NotEqual(t, dataField, nil)
+		gt.Equal(t, findSchemaField(dataField.Schema, "status").Type, bigquery.StringFieldType)
+		gt.Equal(t, findSchemaField(dataField.Schema, "region").Type, bigquery.StringFieldType)
+		user := findSchemaField(dataField.Schema, "user")
+		gt.NotEqual(t, findSchemaField(user.Schema, "name"), nil)
+		gt.NotEqual(t, findSchemaField(user.Schema, "age"), nil)
+	})
+
+	t.Run("values are converted to pinned types for Storage Write API", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: tableSchema}}
+		gt.R1(load(t, bqClient, nextData, stored, usecase.WithSchemaPin())).NoError(t)
+
+		var inserted int
+		for i, opened := range bqClient.OpenedStream {
+			if opened.Table != "pin" {
+				continue
+			}
+			for _, rows := range bqClient.Streams[i].Inserted {
+				for _, row := range rows {
+					msg := unmarshalProtoJSON(t, opened.Schema, row)
+					data := msg.Get(msg.Descriptor().Fields().ByName("data")).Message()
+					gt.Equal(t, data.Get(data.Descriptor().Fields().ByName("status")).String(), "200")
+					inserted++
+				}
+			}
+		}
+		gt.Equal(t, inserted, 1)
+	})
+
+	t.Run("value that can not be converted to pinned type is rejected", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		bqClient.Metadata = []*bigquery.TableMetadata{{Schema: tableSchema}}
+		_, err := load(t, bqClient, `{"status":{"code":200},"user":{"name":"bob"}}`, stored, usecase.WithSchemaPin())
+		gt.True(t, errors.Is(err, types.ErrFieldTypeMismatch))
+		gt.A(t, bqClient.Streams).Length(0)
+	})
+}
+
+// unmarshalProtoJSON converts row into protobuf message of schema in the same way as Storage Write API client, to test that row can be inserted actually.
+func unmarshalProtoJSON(t *testing.T, schema bigquery.Schema, row any) protoreflect.Message {
+	t.Helper()
+	storageSchema := gt.R1(adapt.BQSchemaToStorageTableSchema(schema)).NoError(t)
+	descriptor := gt.R1(adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")).NoError(t)
+	msgDescriptor := gt.Cast[protoreflect.MessageDescriptor](t, descriptor)
+
+	msg := dynamicpb.NewMessage(msgDescriptor)
+	raw := gt.R1(json.Marshal(row)).NoError(t)
+	gt.NoError(t, protojson.Unmarshal(raw, msg))
+	return msg
+}
@@ -29,7 +29,16 @@ func (x *UseCase) GetOrCreateState(ctx context.Context, msgType types.MsgType, i
 		return state, true, nil
 	}
 
-	return db.GetOrCreateState(ctx, msgType, state)
+	current, acquired, err := db.GetOrCreateState(ctx, msgType, state)
+	if err != nil {
+		return nil, false, err
+	}
+	if !acquired && x.force {
+		utils.CtxLogger(ctx).Warn("force to acquire state", "msgType", msgType, "id", id, "current", current)
+		return state, true, nil
+	}
+
+	return current, acquired, nil
 }
 
 func (x *UseCase) UpdateState(ctx context.Context, msgType types.MsgType, id string, state types.MsgState) error {
@@ -11,6 +11,8 @@ import (
 	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
 	"github.com/m-mizutani/swarm/pkg/usecase"
 	"github.com/m-mizutani/swarm/pkg/utils"
 )
@@ -95,3 +97,78 @@ func TestStateWaitCompleted(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	gt.True(t, done)
 }
+
+type mockCompletedDatabase struct {
+	interfaces.Database
+	state   model.State
+	updated []types.MsgState
+}
+
+func (m *mockCompletedDatabase) GetOrCreateState(ctx context.Context, msgType types.MsgType, input *model.State) (*model.State, bool, error) {
+	return &m.state, false, nil
+}
+
+func (m *mockCompletedDatabase) UpdateState(ctx context.Context, msgType types.MsgType, id string, state types.MsgState, now time.Time) error {
+	m.updated = append(m.updated, state)
+	return nil
+}
+
+func TestForceReload(t *testing.T) {
+	db := &mockCompletedDatabase{
+		state: model.State{
+			ID:        "test-msg",
+			State:     types.MsgCompleted,
+			RequestID: types.NewRequestID(),
+		},
+	}
+	ctx := context.Background()
+
+	req := &model.LoadRequest{
+		Source: model.Source{
+			Parser:   types.JSONParser,
+			Schema:   "cloudtrail",
+			Compress: types.NoCompress,
+		},
+		Object: model.Object{
+			CS: &model.CloudStorageObject{
+				Bucket: "test-bucket",
+				Name:   "cloudtrail_example.log",
+			},
+		},
+	}
+
+	newUseCase := func(bqClient *bq.GeneralMock, options ...usecase.Option) *usecase.UseCase {
+		return newTestUseCase(t, testClients{
+			BigQuery: bqClient,
+			Object:   cloudTrailExampleRaw,
+			Policy:   []policy.Option{policy.WithDir("testdata/policy")},
+			Database: db,
+		}, options...)
+	}
+
+	t.Run("not forced", func(t *testing.T) {
+		uc := newUseCase(bq.NewGeneralMock())
+		state, acquired := gt.R2(uc.GetOrCreateState(ctx, types.MsgPubSub, "test-msg")).NoError(t)
+		gt.False(t, acquired)
+		gt.Equal(t, state.State, types.MsgCompleted)
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		bqClient := bq.NewGeneralMock()
+		uc := newUseCase(bqClient, usecase.WithForce())
+		state, acquired := gt.R2(uc.GetOrCreateState(ctx, types.MsgPubSub, "test-msg")).NoError(t)
+		gt.True(t, acquired)
+		gt.Equal(t, state.State, types.MsgRunning)
+
+		gt.NoError(t, uc.Load(ctx, []*model.LoadRequest{req}))
+		gt.NoError(t, uc.UpdateState(ctx, types.MsgPubSub, "test-msg", types.MsgCompleted))
+
+		gt.A(t, bqClient.Streams).Length(1).At(0, func(t testing.TB, v *bq.MockStream) {
+			gt.A(t, v.Inserted).Length(1)
+			gt.A(t, v.Inserted[0]).Length(4)
+		})
+		gt.A(t, db.updated).Length(1).At(0, func(t testing.TB, v types.MsgState) {
+			gt.Equal(t, v, types.MsgCompleted)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// Subscribe pulls Cloud Storage notifications from Pub/Sub subscription and loads the notified objects until ctx is canceled. A message is acked when the object is loaded or the error is classified as dead-letter or drop by ClassifyError, and nacked otherwise.
+func (x *UseCase) Subscribe(ctx context.Context) error {
+	sub := x.clients.PubSubSubscription()
+	if sub == nil {
+		return goerr.Wrap(types.ErrInvalidOption, "Pub/Sub subscription is not configured")
+	}
+
+	return sub.Receive(ctx, x.handleSubscribedMessage)
+}
+
+func (x *UseCase) handleSubscribedMessage(ctx context.Context, msgID types.PubSubMessageID, data []byte, _ map[string]string) error {
+	_, ctx = utils.CtxRequestID(ctx)
+	utils.CtxLogger(ctx).Info("Received pubsub message by pull", "msgID", msgID)
+
+	var event model.CloudStorageEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		// Broken message never succeeds, then it should be acked
+		utils.HandleError(ctx, "failed to unmarshal pulled message", goerr.Wrap(err).With("msgID", msgID).With("data", string(data)))
+		return nil
+	}
+
+	state, acquired, err := x.GetOrCreateState(ctx, types.MsgPubSub, string(msgID))
+	if err != nil {
+		return goerr.Wrap(err, "failed to get or create state for pubsub").With("msgID", msgID)
+	}
+	if !acquired {
+		if state.State == types.MsgCompleted {
+			utils.CtxLogger(ctx).Info("skip pubsub message because it's already completed", "msgID", msgID)
+			return nil
+		}
+		// Another process is working on the message. Redeliver it later.
+		return goerr.Wrap(types.ErrBlockingPubSub, "pubsub message is already acquired").With("msgID", msgID)
+	}
+
+	msgState := types.MsgFailed
+	defer func() {
+		if err := x.UpdateState(ctx, types.MsgPubSub, string(msgID), msgState); err != nil {
+			utils.HandleError(ctx, "failed to update state", err)
+		}
+	}()
+
+	url := types.CSUrl(fmt.Sprintf("gs://%s/%s", event.Bucket, event.Name))
+	if err := x.LoadDataByObject(ctx, url); err != nil {
+		if decision := x.ClassifyError(err); decision != types.RetryDecisionRetry {
+			utils.HandleError(ctx, "failed to load pulled object, not retried", goerr.Wrap(err).With("decision", decision))
+			return nil
+		}
+		return goerr.Wrap(err, "failed to load pulled object").With("msgID", msgID).With("url", url)
+	}
+	msgState = types.MsgCompleted
+
+	return nil
+}
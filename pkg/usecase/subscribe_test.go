@@ -0,0 +1,107 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/infra/pubsub"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	bqClient := bq.NewGeneralMock()
+	csClient := &cs.Mock{
+		MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+			switch obj.Name {
+			case "logs/missing.log":
+				return nil, storage.ErrObjectNotExist
+			case "logs/unavailable.log":
+				return nil, errors.New("service unavailable")
+			}
+			return &storage.ObjectAttrs{Bucket: string(obj.Bucket), Name: string(obj.Name)}, nil
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithFile("testdata/policy/schema.rego"),
+		policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+	)).NoError(t)
+
+	sub := &pubsub.MockSubscription{
+		Messages: []*pubsub.MockMessage{
+			{ID: "loaded", Data: []byte(`{"bucket":"cloudtrail-logs","name":"logs/cloudtrail_example.log"}`)},
+			{ID: "broken", Data: []byte(`{"bucket":`)},
+			{ID: "missing", Data: []byte(`{"bucket":"cloudtrail-logs","name":"logs/missing.log"}`)},
+			{ID: "unavailable", Data: []byte(`{"bucket":"cloudtrail-logs","name":"logs/unavailable.log"}`)},
+		},
+	}
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bqClient),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+		infra.WithPubSubSubscription(sub),
+	))
+	gt.NoError(t, uc.Subscribe(ctx))
+
+	// Messages that can not be recovered by retry are acked as well as loaded one
+	gt.A(t, sub.Acked).Length(3)
+	gt.Equal(t, sub.Acked, []types.PubSubMessageID{"loaded", "broken", "missing"})
+	gt.Equal(t, sub.Nacked, []types.PubSubMessageID{"unavailable"})
+
+	gt.A(t, bqClient.Streams).Length(1)
+	gt.A(t, bqClient.Streams[0].Inserted[0]).Length(4)
+}
+
+func TestSubscribeErrorClassifier(t *testing.T) {
+	ctx := context.Background()
+	csClient := &cs.Mock{
+		MockAttrs: func(ctx context.Context, obj model.CloudStorageObject) (*storage.ObjectAttrs, error) {
+			return nil, errors.New("service unavailable")
+		},
+	}
+	sub := &pubsub.MockSubscription{
+		Messages: []*pubsub.MockMessage{
+			{ID: "unavailable", Data: []byte(`{"bucket":"cloudtrail-logs","name":"logs/unavailable.log"}`)},
+		},
+	}
+	clients := infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPubSubSubscription(sub),
+	)
+
+	// Retryable error by default
+	gt.Equal(t, usecase.DefaultErrorClassifier(errors.New("service unavailable")), types.RetryDecisionRetry)
+
+	var classified []error
+	uc := usecase.New(clients, usecase.WithErrorClassifier(func(err error) types.RetryDecision {
+		classified = append(classified, err)
+		return types.RetryDecisionDeadLetter
+	}))
+	gt.NoError(t, uc.Subscribe(ctx))
+
+	gt.A(t, classified).Length(1)
+	gt.String(t, classified[0].Error()).Contains("service unavailable")
+	gt.Equal(t, sub.Acked, []types.PubSubMessageID{"unavailable"})
+	gt.A(t, sub.Nacked).Length(0)
+}
+
+func TestSubscribeWithoutSubscription(t *testing.T) {
+	uc := usecase.New(infra.New())
+	gt.Error(t, uc.Subscribe(context.Background()))
+}
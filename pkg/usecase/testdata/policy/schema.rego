@@ -5,7 +5,7 @@ log[{
 	"table": "cloudtrail",
 	"timeunit": "month",
 	"id": r.eventID,
-	"timestamp": ((time.parse_rfc3339_ns(r.eventTime) / 1000) * 1000) * 1000,
+	"timestamp": time.parse_rfc3339_ns(r.eventTime) / 1000000000,
 	"data": r,
 }] {
 	r := input.Records[_]
@@ -1,21 +1,153 @@
 package usecase
 
 import (
+	"io"
+	"sync"
 	"time"
 
+	"cloud.google.com/go/bigquery"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
 	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
 	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/metrics"
 )
 
 type UseCase struct {
 	clients  *infra.Clients
 	metadata *model.MetadataConfig
 
+	loadLogSinks []interfaces.LoadLogSink
+
+	// lineageSinks are destinations of lineage from source objects to a destination table for each ingestion.
+	lineageSinks []interfaces.LineageSink
+
+	// deadLetter is a Pub/Sub topic to republish objects of failed load for reprocessing.
+	deadLetter interfaces.PubSub
+
+	// errorClassifier decides whether a failed message is retried, dead-lettered or dropped.
+	errorClassifier func(err error) types.RetryDecision
+
+	// schemaSidecar writes table schema into Cloud Storage when the schema is changed. nil means disabled.
+	schemaSidecar *schemaSidecar
+
+	// failures records URLs of objects failed in LoadDataByObjects. nil means disabled.
+	failures *failureWriter
+
+	// schemaRegistry inserts table schema into a BigQuery table when the schema is changed. nil means disabled.
+	schemaRegistry *schemaRegistry
+
+	// dimensions are dimension tables to enrich records by WithEnrichment.
+	dimensions []*dimension
+
+	// schemaPin is a flag to use schema stored by schemaSidecar as the authoritative base of inferred schema. It stabilizes types of existing fields across loads.
+	schemaPin bool
+
+	// fixedSchemas are schemas of destination tables supplied by WithFixedSchema. Key is "dataset.table". Schema of the tables is not inferred from records.
+	fixedSchemas map[string]*fixedSchema
+
+	// metricsEmitter emits progress metrics. nil means disabled.
+	metricsEmitter interfaces.Metrics
+	metrics        *progressMetrics
+	// noIngestMetrics is a flag not to register metrics of ingested rows and tables for commands that do not ingest records.
+	noIngestMetrics bool
+
 	readObjectConcurrency   int
 	ingestTableConcurrency  int
 	ingestRecordConcurrency int
 	enqueueCountLimit       int
 	enqueueSizeLimit        int
+	enqueueListConcurrency  int
+
+	// insertSlots is a semaphore to limit number of concurrent inserts to BigQuery in the whole process. It is shared by all ingestRecords calls. nil means no limit.
+	insertSlots chan struct{}
+	maxInserts  int
+
+	// ingestTimeout is a deadline of ingesting records into a destination table. It prevents a hung insert of a destination from consuming the deadline of the whole load. 0 means no timeout.
+	ingestTimeout time.Duration
+
+	// dedupWindow is a period in which BigQuery deduplicates rows by insert ID on a best-effort basis. Records older than the window are reported because dedup does not protect them. 0 means no report.
+	dedupWindow time.Duration
+
+	// insertedRecords tracks records inserted successfully to insert only the rest when the load is retried. nil means disabled.
+	insertedRecords *insertedRecords
+
+	// sortByTimestamp is a flag to sort records by timestamp before splitting them into insert chunks.
+	sortByTimestamp bool
+
+	// retryUnknownField is a flag to widen the table schema and retry the insert once when BigQuery rejects rows because the table lacks a field of them.
+	retryUnknownField bool
+
+	// insertErrorTable is a flag to write rows rejected by BigQuery into "<table>_errors" table instead of failing the ingestion.
+	insertErrorTable bool
+
+	// ingestRequestID is a flag to record IDs of insert requests to BigQuery API in IngestLog.
+	ingestRequestID bool
+
+	// insertOptions are applied to insertion of log records, not of metadata.
+	insertOptions model.InsertOptions
+
+	// policyBatchSize is number of rows evaluated by schema policy in a batch. 1 or less means evaluating row by row.
+	policyBatchSize int
+
+	// lowerCaseDest is a flag to normalize dataset and table name of destination to lower case.
+	lowerCaseDest bool
+
+	// defaultPartition is applied to destination of which partition is not specified by schema policy.
+	defaultPartition types.BQPartition
+
+	// minObjectSize is a threshold of stored object size in bytes. Object smaller than it is skipped in LoadDataByObject. 0 means no threshold.
+	minObjectSize int64
+
+	// maxObjectSize is a limit of object size in bytes after decompression. 0 means no limit.
+	maxObjectSize int64
+
+	// metadataStrict is a flag to fail the load if LoadLog can not be written to metadata table or other LoadLog sinks.
+	metadataStrict bool
+
+	// objectVersion is a flag to record generation and CRC32C of the object in SourceLog. It requires additional request to get object attributes.
+	objectVersion bool
+
+	// schemaOverrides is an allowlist of schema names that can be forced by "swarm-schema" custom metadata of an object. Override is disabled if empty.
+	schemaOverrides map[types.ObjectSchema]struct{}
+
+	// compressMetadataKey is a key of custom metadata of an object to specify its compression type instead of Source.Compress. Disabled if empty.
+	compressMetadataKey string
+
+	// loadLabels is a flag to set ID and time of the last successful load as labels of the destination table after ingestion.
+	loadLabels bool
+
+	// verifyChecksum is a flag to verify CRC32C of downloaded object with the checksum of Cloud Storage before parsing.
+	verifyChecksum bool
+
+	// failOnMissing is a flag to fail the whole load if any object does not exist. By default, missing object is skipped with log.
+	failOnMissing bool
+
+	// skipUnroutable is a flag to skip an object for which event policy selects no source, instead of returning ErrNoSourceMatched.
+	skipUnroutable bool
+
+	// logIDGenerator generates ID of a log record when schema policy does not set it. nil means using hash of the record data.
+	logIDGenerator LogIDGenerator
+
+	// ingestTimeFallback is a flag to use ingested time of a log as its timestamp when schema policy returns no timestamp. The timestamp column is created as REQUIRED with it.
+	ingestTimeFallback bool
+
+	// objectTimeFallback is a flag to use last modified time of the object as log timestamp when schema policy does not return timestamp.
+	objectTimeFallback bool
+
+	// schemaSampleHead and schemaSampleRandom are numbers of records to infer schema. First schemaSampleHead records and schemaSampleRandom records picked randomly from the rest are used. If both are 0, all records are used.
+	schemaSampleHead   int
+	schemaSampleRandom int
+
+	// force is a flag to bypass state checks and re-ingest data even if the message is already completed.
+	force bool
+
+	// truncatedPartitions deletes existing rows in partitions that are affected by ingested records before inserting them, only once for each partition in the process. nil means disabled.
+	truncatedPartitions *truncatedPartitions
+
+	// watermarks keeps watermark of Watch when Database is not configured.
+	watermarks     map[string]*model.Watermark
+	watermarkMutex sync.Mutex
 
 	// stateTimeout is a duration to wait for state transition. Even if the state is not changed, other process can acquire the state after this duration.
 	stateTimeout time.Duration
@@ -49,11 +181,16 @@ func New(clients *infra.Clients, options ...Option) *UseCase {
 		stateTimeout:            defaultStateTimeout,
 		stateTTL:                defaultStateTTL,
 		stateCheckInterval:      defaultStateCheckInterval,
+		errorClassifier:         DefaultErrorClassifier,
 	}
 
 	for _, option := range options {
 		option(uc)
 	}
+	uc.metrics = newProgressMetrics(uc.metricsEmitter, !uc.noIngestMetrics)
+	if uc.maxInserts > 0 {
+		uc.insertSlots = make(chan struct{}, uc.maxInserts)
+	}
 
 	return uc
 }
@@ -66,6 +203,111 @@ func WithMetadata(metadata *model.MetadataConfig) Option {
 	}
 }
 
+// WithMetadataStrict makes a load fail if LoadLog can not be written to the metadata table (WithMetadata) or other LoadLog sinks (WithLoadLogSink), for deployments where the audit record of every load is required. By default, the failure is only logged and the load succeeds.
+func WithMetadataStrict(strict bool) Option {
+	return func(uc *UseCase) {
+		uc.metadataStrict = strict
+	}
+}
+
+// WithLoadLogSink adds destinations of LoadLog in addition to BigQuery metadata table configured by WithMetadata.
+func WithLoadLogSink(sinks ...interfaces.LoadLogSink) Option {
+	return func(uc *UseCase) {
+		uc.loadLogSinks = append(uc.loadLogSinks, sinks...)
+	}
+}
+
+// WithLineageSink adds destinations of lineage (source objects to destination table) recorded for each ingestion, such as Data Catalog.
+func WithLineageSink(sinks ...interfaces.LineageSink) Option {
+	return func(uc *UseCase) {
+		uc.lineageSinks = append(uc.lineageSinks, sinks...)
+	}
+}
+
+// WithDeadLetterPubSub republishes objects of failed load to the Pub/Sub topic with failure reason attributes. Only objects of which error is classified as dead-letter by the error classifier are published. The message format is same as Enqueue.
+func WithDeadLetterPubSub(client interfaces.PubSub) Option {
+	return func(uc *UseCase) {
+		uc.deadLetter = client
+	}
+}
+
+// WithErrorClassifier replaces DefaultErrorClassifier to decide handling of a failed message by its error, e.g. to dead-letter a transient error without retry in a deployment with low retry tolerance.
+func WithErrorClassifier(fn func(err error) types.RetryDecision) Option {
+	return func(uc *UseCase) {
+		uc.errorClassifier = fn
+	}
+}
+
+// WithSchemaSidecar writes schema of a table as JSON into Cloud Storage object "{prefix}{dataset}.{table}.json" in the bucket whenever the table is created or its schema is changed.
+func WithSchemaSidecar(bucket types.CSBucket, prefix string) Option {
+	return func(uc *UseCase) {
+		uc.schemaSidecar = &schemaSidecar{
+			client: uc.clients.CloudStorage(),
+			bucket: bucket,
+			prefix: prefix,
+		}
+	}
+}
+
+// WithSchemaRegistry inserts schema of a table as a row (dataset, table, schema_json, changed_at, load_id) into the registry table whenever the table is created or its schema is changed. The registry table is created if it does not exist. It keeps all versions of schemas of tables for organization-wide visibility.
+func WithSchemaRegistry(dataset types.BQDatasetID, table types.BQTableID) Option {
+	return func(uc *UseCase) {
+		uc.schemaRegistry = &schemaRegistry{
+			dataset: dataset,
+			table:   table,
+		}
+	}
+}
+
+// WithSchemaPin uses schema of a table stored by WithSchemaSidecar as the authoritative base of inferred schema. Types of fields in the stored schema are never re-inferred, and only new fields are added. Values of records are converted to the stored types, e.g. number to string, and a record of which value can not be converted fails with types.ErrFieldTypeMismatch. It requires WithSchemaSidecar.
+func WithSchemaPin() Option {
+	return func(uc *UseCase) {
+		uc.schemaPin = true
+	}
+}
+
+// WithEnrichment adds columns of a dimension table in BigQuery to records at ingestion, e.g. account name looked up by account ID. The dimension table is loaded into memory by LoadDimensions or at first use, and reloaded every cfg.Refresh. A record of which lookup key is not found in the dimension table is ingested without the columns. It can be specified multiple times.
+func WithEnrichment(cfg model.EnrichmentConfig) Option {
+	return func(uc *UseCase) {
+		uc.dimensions = append(uc.dimensions, &dimension{cfg: cfg})
+	}
+}
+
+// WithFixedSchema uses the schema for the destination table instead of inferring it from records. The schema must include all columns of the table, such as id, timestamp and data. Records are validated against the schema before insertion: if a record has a field not declared in the schema or a value of which type does not match the column, the ingestion is rejected by types.FixedSchemaStrict mode. types.FixedSchemaLoose mode removes the field and converts the value to the column type if possible, e.g. 200 to "200" for STRING column. Empty mode means strict.
+func WithFixedSchema(dataset types.BQDatasetID, table types.BQTableID, schema bigquery.Schema, mode types.FixedSchemaMode) Option {
+	return func(uc *UseCase) {
+		if uc.fixedSchemas == nil {
+			uc.fixedSchemas = make(map[string]*fixedSchema)
+		}
+		if mode == "" {
+			mode = types.FixedSchemaStrict
+		}
+		uc.fixedSchemas[fixedSchemaKey(dataset, table)] = &fixedSchema{schema: schema, mode: mode}
+	}
+}
+
+// WithMetrics enables progress metrics, such as number of processed objects and ingested rows, in the registry exposed in Prometheus text format.
+func WithMetrics(reg *metrics.Registry) Option {
+	if reg == nil {
+		return WithMetricsEmitter(nil)
+	}
+	return WithMetricsEmitter(metrics.NewPrometheus(reg))
+}
+
+// WithMetricsEmitter enables progress metrics emitted by m, e.g. metrics.NewOTel to emit them via OpenTelemetry SDK. The metrics are same as WithMetrics.
+func WithMetricsEmitter(m interfaces.Metrics) Option {
+	return func(uc *UseCase) {
+		uc.metricsEmitter = m
+	}
+}
+
+// WithoutIngestMetrics excludes metrics of ingested rows and destination tables from WithMetrics and WithMetricsEmitter, for commands that do not ingest records such as enqueue. Otherwise they are exposed as always 0.
+func WithoutIngestMetrics() Option {
+	return func(uc *UseCase) {
+		uc.noIngestMetrics = true
+	}
+}
+
 func WithReadObjectConcurrency(n int) Option {
 	if n < 1 {
 		n = 1
@@ -93,6 +335,20 @@ func WithEnqueueSizeLimit(n int) Option {
 	}
 }
 
+// WithEnqueueListConcurrency lists objects in Enqueue with n workers. The prefix is split into sub-prefixes by "/" delimiter and each sub-prefix is listed in parallel. It speeds up enqueue over a prefix that has a huge number of objects. 1 or less means listing sequentially (default).
+func WithEnqueueListConcurrency(n int) Option {
+	return func(uc *UseCase) {
+		uc.enqueueListConcurrency = n
+	}
+}
+
+// WithMaxConcurrentInserts limits number of concurrent inserts to BigQuery across all ingestion in the process, independent of ingest table and record concurrency. Zero or negative value means no limit.
+func WithMaxConcurrentInserts(n int) Option {
+	return func(uc *UseCase) {
+		uc.maxInserts = n
+	}
+}
+
 func WithIngestTableConcurrency(n int) Option {
 	if n < 1 {
 		n = 1
@@ -111,6 +367,215 @@ func WithIngestRecordConcurrency(n int) Option {
 	}
 }
 
+// WithForce makes GetOrCreateState always acquire the state even if it is already completed or running by other process, and inserts records even if they are tracked as inserted by WithInsertTracking. It's used to reprocess data that has been ingested.
+func WithForce() Option {
+	return func(uc *UseCase) {
+		uc.force = true
+	}
+}
+
+// WithTruncatePartition enables deleting existing rows in partitions covered by ingested records before inserting them, to reprocess objects without duplicated records. Each partition is truncated only once in the process, then rows inserted by other objects of the same run are kept.
+func WithTruncatePartition() Option {
+	return func(uc *UseCase) {
+		uc.truncatedPartitions = newTruncatedPartitions()
+	}
+}
+
+// WithSchemaSample makes schema inference use only sampled records: first head records and random records picked from the rest. All records are still inserted, and the table schema is widened if a record that does not match the inferred schema is found.
+func WithSchemaSample(head, random int) Option {
+	return func(uc *UseCase) {
+		uc.schemaSampleHead = max(head, 0)
+		uc.schemaSampleRandom = max(random, 0)
+	}
+}
+
+// WithIngestTimeout sets a deadline of ingesting records into each destination table. Ingestion exceeding the deadline fails only the destination, and ingestion into other destinations continues. 0 means no timeout.
+func WithIngestTimeout(d time.Duration) Option {
+	return func(uc *UseCase) {
+		uc.ingestTimeout = d
+	}
+}
+
+// WithDedupWindow reports records whose timestamp is older than d by a warning log and swarm_rows_outside_dedup_window_total metric when ingesting. BigQuery deduplicates rows with same insert ID only within a short period (about one minute), so re-ingested old records may be duplicated. 0 disables the report.
+func WithDedupWindow(d time.Duration) Option {
+	return func(uc *UseCase) {
+		uc.dedupWindow = d
+	}
+}
+
+// WithInsertTracking tracks IDs of records inserted successfully into each destination table in memory for ttl. When a load fails after some insert chunks succeed and is retried in the process, e.g. by redelivery of Pub/Sub message, the retry inserts only records that have not been inserted. It prevents duplication of records older than dedup window of insert ID by BigQuery. Records are identified by ID, so records with same ID are also skipped in other loads within ttl. 0 disables tracking.
+func WithInsertTracking(ttl time.Duration) Option {
+	return func(uc *UseCase) {
+		if ttl > 0 {
+			uc.insertedRecords = newInsertedRecords(ttl)
+		} else {
+			uc.insertedRecords = nil
+		}
+	}
+}
+
+// WithFailureWriter writes URL and error of each object failed in LoadDataByObjects into w as a tab separated line while loading. Successfully loaded objects are not written.
+func WithFailureWriter(w io.Writer) Option {
+	return func(uc *UseCase) {
+		uc.failures = &failureWriter{w: w}
+	}
+}
+
+// WithSortByTimestamp sorts records by timestamp before splitting them into insert chunks, so that each chunk contains time-ordered records. BigQuery does not guarantee the order of streaming inserts, but inserting time-sorted records helps clustering by time.
+func WithSortByTimestamp() Option {
+	return func(uc *UseCase) {
+		uc.sortByTimestamp = true
+	}
+}
+
+// WithIngestRequestID records IDs of insert requests to BigQuery Storage Write API in IngestLog for traceability. The ID consists of name of the write stream, trace ID sent to BigQuery by the writer and offset of the append if available.
+func WithIngestRequestID() Option {
+	return func(uc *UseCase) {
+		uc.ingestRequestID = true
+	}
+}
+
+// WithSkipInvalidRows inserts valid rows and drops invalid rows of a chunk instead of failing the whole chunk, like skipInvalidRows of insertAll API. It helps to onboard logs of which schema is not stable, but the dropped rows are lost with only warning logs: they are not routed to the error table by WithInsertErrorTable and the ingestion is reported as success.
+func WithSkipInvalidRows() Option {
+	return func(uc *UseCase) {
+		uc.insertOptions.SkipInvalidRows = true
+	}
+}
+
+// WithIgnoreUnknownValues drops values of fields that are not in the table schema instead of rejecting the rows, like ignoreUnknownValues of insertAll API. The dropped values are lost silently, and the table schema is no longer widened by fields missed in inferring the schema (e.g. by WithSchemaSample).
+func WithIgnoreUnknownValues() Option {
+	return func(uc *UseCase) {
+		uc.insertOptions.IgnoreUnknownValues = true
+	}
+}
+
+// WithInsertErrorTable enables routing rows rejected by BigQuery to "<table>_errors" table with the reason. Other rows in the same request are inserted into the original table.
+func WithInsertErrorTable() Option {
+	return func(uc *UseCase) {
+		uc.insertErrorTable = true
+	}
+}
+
+// WithMaxObjectSize limits object size in bytes after decompression to protect from a highly compressed object (zip bomb). Loading the object exceeding the limit fails with types.ErrObjectTooLarge. 0 means no limit.
+func WithMaxObjectSize(n int64) Option {
+	return func(uc *UseCase) {
+		uc.maxObjectSize = n
+	}
+}
+
+// WithMinObjectSize skips objects of which stored size is smaller than n bytes in LoadDataByObject. It is useful to ignore incomplete or partial writes and wait for a later complete write. 0 means no threshold.
+func WithMinObjectSize(n int64) Option {
+	return func(uc *UseCase) {
+		uc.minObjectSize = n
+	}
+}
+
+// WithFailOnMissing specifies behavior for an object that does not exist (e.g. deleted before processing). If fail is true, the whole load fails. Otherwise, the object is skipped with log (default).
+func WithFailOnMissing(fail bool) Option {
+	return func(uc *UseCase) {
+		uc.failOnMissing = fail
+	}
+}
+
+// WithSkipUnroutable specifies behavior for an unroutable object, for which event policy selects no source or a source with unsupported parser. If skip is true, the object is skipped with log. Otherwise, ErrNoSourceMatched is returned (default).
+func WithSkipUnroutable(skip bool) Option {
+	return func(uc *UseCase) {
+		uc.skipUnroutable = skip
+	}
+}
+
+// LogIDGenerator generates ID of a log record. obj is the source object and idx is sequence number of the record in the object, starting from 0.
+type LogIDGenerator func(obj model.Object, idx int) types.LogID
+
+// WithLogIDGenerator replaces the default ID scheme (hash of the record data) for records of which ID is not set by schema policy.
+func WithLogIDGenerator(gen LogIDGenerator) Option {
+	return func(uc *UseCase) {
+		uc.logIDGenerator = gen
+	}
+}
+
+// WithRetryUnknownField makes insertion widen the table schema with the inserted records and retry once when BigQuery rejects rows because the table lacks a field of them. Without it, such rows fail the chunk (or are routed to the error table by WithInsertErrorTable).
+func WithRetryUnknownField() Option {
+	return func(uc *UseCase) {
+		uc.retryUnknownField = true
+	}
+}
+
+// WithObjectVersion records generation and CRC32C of the object in SourceLog of LoadLog to identify which version of the object is processed.
+func WithObjectVersion() Option {
+	return func(uc *UseCase) {
+		uc.objectVersion = true
+	}
+}
+
+// WithCompressMetadataKey makes compression type of an object be read from custom metadata of the object with key, e.g. "content-codec", before Source.Compress of event policy. The value must be "gzip", "zstd" or "none", otherwise loading the object fails with ErrUnsupportedCompress. Source.Compress is used for an object without the metadata.
+func WithCompressMetadataKey(key string) Option {
+	return func(uc *UseCase) {
+		uc.compressMetadataKey = key
+	}
+}
+
+// WithSchemaOverride allows an object to override Source.Schema by "swarm-schema" custom metadata of the object, e.g. to force a fixed schema policy for an object in an emergency without redeploying the policy. Only schemas in allowed can be used; metadata with other schema is ignored with a warning.
+func WithSchemaOverride(allowed ...types.ObjectSchema) Option {
+	return func(uc *UseCase) {
+		if uc.schemaOverrides == nil {
+			uc.schemaOverrides = map[types.ObjectSchema]struct{}{}
+		}
+		for _, schema := range allowed {
+			uc.schemaOverrides[schema] = struct{}{}
+		}
+	}
+}
+
+// WithLoadLabels sets ID and time (Unix seconds) of the last successful load as "swarm_last_load_id" and "swarm_last_load_at" labels of the destination table after each successful ingestion for traceability. It is opt-in because it updates table metadata every ingestion.
+func WithLoadLabels() Option {
+	return func(uc *UseCase) {
+		uc.loadLabels = true
+	}
+}
+
+// WithVerifyChecksum verifies CRC32C of downloaded bytes with the checksum in object attributes before parsing, and fails the load by types.ErrCorruptObject if they do not match. It guards against truncated or corrupted download at the cost of an additional request of object attributes and buffering the object in memory.
+func WithVerifyChecksum() Option {
+	return func(uc *UseCase) {
+		uc.verifyChecksum = true
+	}
+}
+
+// WithIngestTimeFallback makes log timestamp fall back to ingested time of the log (ingested_at column) when schema policy returns no timestamp, and creates the timestamp column as REQUIRED to guarantee that no row lacks timestamp. Mode of the column in an existing table is not changed. WithObjectTimeFallback takes precedence if both are enabled.
+func WithIngestTimeFallback() Option {
+	return func(uc *UseCase) {
+		uc.ingestTimeFallback = true
+	}
+}
+
+// WithObjectTimeFallback makes log timestamp fall back to last modified time (Updated attribute) of the source object in Cloud Storage when schema policy returns no timestamp. Without this option, such log is rejected as invalid policy result.
+func WithObjectTimeFallback() Option {
+	return func(uc *UseCase) {
+		uc.objectTimeFallback = true
+	}
+}
+
+// WithPolicyBatchSize makes schema policy evaluate n rows in a batch with a prepared query instead of preparing the query for each row. 1 or less means evaluating row by row (default).
+func WithPolicyBatchSize(n int) Option {
+	return func(uc *UseCase) {
+		uc.policyBatchSize = n
+	}
+}
+
+// WithLowerCaseDest normalizes dataset and table name returned by schema policy to lower case. It avoids creating separate tables by inconsistent casing, such as "MyTable" and "mytable", because BigQuery table name is case-sensitive.
+func WithLowerCaseDest() Option {
+	return func(uc *UseCase) {
+		uc.lowerCaseDest = true
+	}
+}
+
+// WithDefaultPartition specifies time partitioning of destination table when schema policy does not specify partition. Partition specified by schema policy is always prioritized.
+func WithDefaultPartition(pt types.BQPartition) Option {
+	return func(uc *UseCase) {
+		uc.defaultPartition = pt
+	}
+}
+
 func WithStateTimeout(d time.Duration) Option {
 	return func(uc *UseCase) {
 		uc.stateTimeout = d
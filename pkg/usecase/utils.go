@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"reflect"
+	"slices"
 	"unsafe"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
 	"github.com/m-mizutani/swarm/pkg/domain/types"
 )
 
@@ -192,3 +194,25 @@ func buildBQMetadata(schema bigquery.Schema, pt types.BQPartition) (*bigquery.Ta
 
 	return md, nil
 }
+
+// setRangePartitioning sets integer range partitioning of rp to md, and adds model.RangePartitionColumn to the schema if it does not exist. It does nothing if rp is not enabled.
+func setRangePartitioning(md *bigquery.TableMetadata, rp model.RangePartition) {
+	if !rp.Enabled() {
+		return
+	}
+
+	if !slices.ContainsFunc(md.Schema, func(f *bigquery.FieldSchema) bool { return f.Name == model.RangePartitionColumn }) {
+		md.Schema = append(slices.Clone(md.Schema), &bigquery.FieldSchema{
+			Name: model.RangePartitionColumn,
+			Type: bigquery.IntegerFieldType,
+		})
+	}
+	md.RangePartitioning = &bigquery.RangePartitioning{
+		Field: model.RangePartitionColumn,
+		Range: &bigquery.RangePartitioningRange{
+			Start:    rp.Start,
+			End:      rp.End,
+			Interval: rp.Interval,
+		},
+	}
+}
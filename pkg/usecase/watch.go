@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/go-multierror"
+	"github.com/m-mizutani/goerr"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/utils"
+)
+
+// Watch polls objects under the prefix of req.URL every req.Interval and loads objects created after the watermark until ctx is canceled. The watermark is saved into Database to resume watching after restart. If Database is not configured, the watermark is kept only in memory.
+func (x *UseCase) Watch(ctx context.Context, req *model.WatchRequest) error {
+	if req.Interval <= 0 {
+		return goerr.Wrap(types.ErrInvalidOption, "watch interval must be positive").With("interval", req.Interval)
+	}
+
+	ticker := time.NewTicker(req.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := x.watchOnce(ctx, req)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			// Failed objects are retried in next cycle because the watermark does not cover them
+			utils.HandleError(ctx, "failed to load watched objects", err)
+		}
+		utils.CtxLogger(ctx).Info("watch cycle completed", "url", req.URL, "loaded", n)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchOnce lists objects under the prefix and loads objects that are not covered by the watermark. It returns number of loaded objects. The watermark is advanced up to the first failed object in order of created time, and objects loaded after it are kept in Names of the watermark, so that only failed objects are loaded again in next cycle.
+func (x *UseCase) watchOnce(ctx context.Context, req *model.WatchRequest) (int, error) {
+	bucket, prefix, err := req.URL.ParseAsCloudStorage()
+	if err != nil {
+		return 0, err
+	}
+
+	wmID := string(req.URL)
+	wm, err := x.getWatermark(ctx, wmID)
+	if err != nil {
+		return 0, err
+	}
+
+	var listed, newObjects []*storage.ObjectAttrs
+	query := &storage.Query{Prefix: prefix.String()}
+	err = x.iterateObjects(x.clients.CloudStorage().List(ctx, bucket, query), func(attrs *storage.ObjectAttrs) error {
+		listed = append(listed, attrs)
+		if !wm.Loaded(attrs.Name, attrs.Created) {
+			newObjects = append(newObjects, attrs)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(newObjects) == 0 {
+		return 0, nil
+	}
+
+	sortObjectsByCreated(newObjects)
+
+	errs := make([]error, len(newObjects))
+	sem := make(chan struct{}, max(req.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, attrs := range newObjects {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = x.loadObjectAttrs(ctx, attrs)
+		}()
+	}
+	wg.Wait()
+
+	next := &model.Watermark{ID: wmID}
+	if wm != nil {
+		next.Timestamp = wm.Timestamp
+	}
+
+	succeeded := map[string]struct{}{}
+	var mErr *multierror.Error
+	for i, attrs := range newObjects {
+		if errs[i] != nil {
+			mErr = multierror.Append(mErr, goerr.Wrap(errs[i], "failed to load watched object").With("name", attrs.Name))
+			continue
+		}
+		succeeded[attrs.Name] = struct{}{}
+	}
+	loaded := len(succeeded)
+
+	loadedObject := func(attrs *storage.ObjectAttrs) bool {
+		_, ok := succeeded[attrs.Name]
+		return ok || wm.Loaded(attrs.Name, attrs.Created)
+	}
+
+	// The timestamp is advanced up to the first object that is not loaded yet
+	sortObjectsByCreated(listed)
+	for _, attrs := range listed {
+		if attrs.Created.After(next.Timestamp) {
+			next.Timestamp = attrs.Created
+		}
+		if !loadedObject(attrs) {
+			break
+		}
+	}
+
+	// Objects created at or after the timestamp are covered by names of loaded objects
+	for _, attrs := range listed {
+		if !attrs.Created.Before(next.Timestamp) && loadedObject(attrs) {
+			next.Names = append(next.Names, attrs.Name)
+		}
+	}
+	sort.Strings(next.Names)
+
+	next.UpdatedAt = utils.CtxTime(ctx)
+	if err := x.putWatermark(ctx, next); err != nil {
+		return loaded, err
+	}
+
+	if mErr != nil {
+		return loaded, mErr
+	}
+	return loaded, nil
+}
+
+// sortObjectsByCreated sorts objects by created time, and by name for objects created at the same time.
+func sortObjectsByCreated(objects []*storage.ObjectAttrs) {
+	sort.Slice(objects, func(i, j int) bool {
+		if !objects[i].Created.Equal(objects[j].Created) {
+			return objects[i].Created.Before(objects[j].Created)
+		}
+		return objects[i].Name < objects[j].Name
+	})
+}
+
+func (x *UseCase) getWatermark(ctx context.Context, id string) (*model.Watermark, error) {
+	if db := x.clients.Database(); db != nil {
+		return db.GetWatermark(ctx, id)
+	}
+
+	x.watermarkMutex.Lock()
+	defer x.watermarkMutex.Unlock()
+	return x.watermarks[id], nil
+}
+
+func (x *UseCase) putWatermark(ctx context.Context, wm *model.Watermark) error {
+	if db := x.clients.Database(); db != nil {
+		return db.PutWatermark(ctx, wm)
+	}
+
+	x.watermarkMutex.Lock()
+	defer x.watermarkMutex.Unlock()
+	if x.watermarks == nil {
+		x.watermarks = make(map[string]*model.Watermark)
+	}
+	x.watermarks[wm.ID] = wm
+	return nil
+}
@@ -0,0 +1,157 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-mizutani/gt"
+	"github.com/m-mizutani/swarm/pkg/domain/interfaces"
+	"github.com/m-mizutani/swarm/pkg/domain/model"
+	"github.com/m-mizutani/swarm/pkg/domain/types"
+	"github.com/m-mizutani/swarm/pkg/infra"
+	"github.com/m-mizutani/swarm/pkg/infra/bq"
+	"github.com/m-mizutani/swarm/pkg/infra/cs"
+	"github.com/m-mizutani/swarm/pkg/infra/policy"
+	"github.com/m-mizutani/swarm/pkg/usecase"
+)
+
+type mockWatermarkDatabase struct {
+	interfaces.Database
+	mutex      sync.Mutex
+	watermarks map[string]*model.Watermark
+}
+
+func (m *mockWatermarkDatabase) GetWatermark(ctx context.Context, id string) (*model.Watermark, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.watermarks[id], nil
+}
+
+func (m *mockWatermarkDatabase) PutWatermark(ctx context.Context, wm *model.Watermark) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.watermarks[wm.ID] = wm
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var (
+		mutex   sync.Mutex
+		objects []*storage.ObjectAttrs
+		opened  []string
+		broken  = map[string]bool{}
+	)
+	addObject := func(name string, created time.Time) {
+		objects = append(objects, &storage.ObjectAttrs{
+			Bucket:  "cloudtrail-logs",
+			Name:    name,
+			Size:    int64(len(cloudTrailExampleRaw)),
+			Created: created,
+		})
+	}
+
+	csClient := &cs.Mock{
+		MockList: func(ctx context.Context, bucket types.CSBucket, query *storage.Query) interfaces.CSObjectIterator {
+			gt.Equal(t, bucket, "cloudtrail-logs")
+			gt.Equal(t, query.Prefix, "logs/")
+			return &cs.MockObjectIterator{Attrs: append([]*storage.ObjectAttrs{}, objects...)}
+		},
+		MockOpen: func(ctx context.Context, obj model.CloudStorageObject) (io.ReadCloser, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			opened = append(opened, obj.Name.String())
+			if broken[obj.Name.String()] {
+				return io.NopCloser(bytes.NewReader([]byte("{broken"))), nil
+			}
+			return io.NopCloser(bytes.NewReader(cloudTrailExampleRaw)), nil
+		},
+	}
+	pClient := gt.R1(policy.New(
+		policy.WithFile("testdata/policy/schema.rego"),
+		policy.WithPolicyData("event.rego", schemaDiffEventPolicy),
+	)).NoError(t)
+	db := &mockWatermarkDatabase{watermarks: map[string]*model.Watermark{}}
+
+	uc := usecase.New(infra.New(
+		infra.WithBigQuery(bq.NewGeneralMock()),
+		infra.WithCloudStorage(csClient),
+		infra.WithPolicy(pClient),
+		infra.WithDatabase(db),
+	))
+	req := &model.WatchRequest{
+		URL:         "gs://cloudtrail-logs/logs/",
+		Interval:    time.Minute,
+		Concurrency: 2,
+	}
+
+	popOpened := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result := opened
+		opened = nil
+		sort.Strings(result)
+		return result
+	}
+
+	// 1st cycle: all objects are new
+	addObject("logs/a.log", base.Add(1*time.Second))
+	addObject("logs/b.log", base.Add(2*time.Second))
+	gt.V(t, gt.R1(usecase.WatchOnce(ctx, uc, req)).NoError(t)).Equal(2)
+	gt.Equal(t, popOpened(), []string{"logs/a.log", "logs/b.log"})
+
+	wm := db.watermarks["gs://cloudtrail-logs/logs/"]
+	gt.True(t, wm != nil)
+	gt.Equal(t, wm.Timestamp, base.Add(2*time.Second))
+	gt.Equal(t, wm.Names, []string{"logs/b.log"})
+
+	// 2nd cycle: only objects appeared after the 1st cycle are loaded, including an object created at the same time as the watermark
+	addObject("logs/c.log", base.Add(3*time.Second))
+	addObject("logs/d.log", base.Add(2*time.Second))
+	gt.V(t, gt.R1(usecase.WatchOnce(ctx, uc, req)).NoError(t)).Equal(2)
+	gt.Equal(t, popOpened(), []string{"logs/c.log", "logs/d.log"})
+	gt.Equal(t, db.watermarks["gs://cloudtrail-logs/logs/"].Timestamp, base.Add(3*time.Second))
+
+	// No new object
+	gt.V(t, gt.R1(usecase.WatchOnce(ctx, uc, req)).NoError(t)).Equal(0)
+	gt.A(t, popOpened()).Length(0)
+
+	t.Run("watermark does not pass failed object", func(t *testing.T) {
+		broken["logs/e.log"] = true
+		addObject("logs/e.log", base.Add(4*time.Second))
+		addObject("logs/f.log", base.Add(5*time.Second))
+
+		_, err := usecase.WatchOnce(ctx, uc, req)
+		gt.Error(t, err)
+		gt.Equal(t, popOpened(), []string{"logs/e.log", "logs/f.log"})
+		wm := db.watermarks["gs://cloudtrail-logs/logs/"]
+		gt.Equal(t, wm.Timestamp, base.Add(4*time.Second))
+		gt.Equal(t, wm.Names, []string{"logs/f.log"})
+
+		// Only failed object is retried while it keeps failing, and loaded object is not loaded again
+		_, err = usecase.WatchOnce(ctx, uc, req)
+		gt.Error(t, err)
+		gt.Equal(t, popOpened(), []string{"logs/e.log"})
+
+		delete(broken, "logs/e.log")
+		gt.V(t, gt.R1(usecase.WatchOnce(ctx, uc, req)).NoError(t)).Equal(1)
+		gt.Equal(t, popOpened(), []string{"logs/e.log"})
+		wm = db.watermarks["gs://cloudtrail-logs/logs/"]
+		gt.Equal(t, wm.Timestamp, base.Add(5*time.Second))
+		gt.Equal(t, wm.Names, []string{"logs/f.log"})
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		err := uc.Watch(ctx, &model.WatchRequest{URL: req.URL})
+		gt.True(t, errors.Is(err, types.ErrInvalidOption))
+	})
+}